@@ -0,0 +1,400 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"github.com/calvarado2004/go-movies-backend/internal/mailer"
+	"github.com/calvarado2004/go-movies-backend/internal/models"
+	"net/http"
+	"net/mail"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// Lifetimes of the single-use tokens sent by email.
+const (
+	emailVerificationExpiry = 24 * time.Hour
+	passwordResetExpiry     = time.Hour
+)
+
+// minPasswordLength is the minimum length of a user password.
+const minPasswordLength = 8
+
+// register creates a new, unverified viewer account and emails a verification link to it. Registering an
+// address that already has an account gets the same response, and its owner is notified by email instead.
+func (app *application) register(w http.ResponseWriter, r *http.Request) {
+
+	var requestPayload struct {
+		FirstName string `json:"first_name"`
+		LastName  string `json:"last_name"`
+		Email     string `json:"email"`
+		Password  string `json:"password"`
+	}
+
+	err := app.readJSON(w, r, &requestPayload)
+	if err != nil {
+		err := app.errorJSON(w, r, err, http.StatusBadRequest)
+		if err != nil {
+			return
+		}
+		return
+	}
+
+	email, err := validateEmail(requestPayload.Email)
+	if err != nil {
+		err := app.errorJSON(w, r, err, http.StatusBadRequest)
+		if err != nil {
+			return
+		}
+		return
+	}
+
+	if strings.TrimSpace(requestPayload.FirstName) == "" || strings.TrimSpace(requestPayload.LastName) == "" {
+		err := app.errorJSON(w, r, errors.New("first and last name are required"), http.StatusBadRequest)
+		if err != nil {
+			return
+		}
+		return
+	}
+
+	err = validatePassword(requestPayload.Password)
+	if err != nil {
+		err := app.errorJSON(w, r, err, http.StatusBadRequest)
+		if err != nil {
+			return
+		}
+		return
+	}
+
+	user := models.User{
+		FirstName: strings.TrimSpace(requestPayload.FirstName),
+		LastName:  strings.TrimSpace(requestPayload.LastName),
+		Email:     email,
+		Role:      models.RoleViewer,
+		CreatedAt: time.Now(),
+		UpdatedAt: time.Now(),
+	}
+
+	// the password is hashed for registered addresses too, so the response time doesn't tell them apart
+	err = user.SetPassword(requestPayload.Password)
+	if err != nil {
+		err := app.errorJSON(w, r, err, http.StatusInternalServerError)
+		if err != nil {
+			return
+		}
+		return
+	}
+
+	// the response doesn't tell whether the address is registered
+	response := JSONResponse{
+		Error:   false,
+		Message: "check your email to verify your address",
+	}
+
+	existing, err := app.DB.GetUserByEmail(r.Context(), email)
+	switch {
+	case err == nil:
+		app.notifyRegisteredAddress(r.Context(), existing)
+	case errors.Is(err, sql.ErrNoRows):
+		user.ID, err = app.DB.InsertUser(r.Context(), user)
+		if err != nil {
+			err := app.errorJSON(w, r, err, http.StatusInternalServerError)
+			if err != nil {
+				return
+			}
+			return
+		}
+
+		err = app.sendVerificationEmail(r.Context(), user)
+		if err != nil {
+			err := app.errorJSON(w, r, err, http.StatusInternalServerError)
+			if err != nil {
+				return
+			}
+			return
+		}
+	default:
+		err := app.errorJSON(w, r, err, http.StatusInternalServerError)
+		if err != nil {
+			return
+		}
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusCreated, response, nil)
+	if err != nil {
+		app.logger(r.Context()).Error("writing the response failed", "error", err)
+		return
+	}
+}
+
+// sendVerificationEmail emails a new email verification link to the user.
+func (app *application) sendVerificationEmail(ctx context.Context, user models.User) error {
+
+	token, err := app.newUserToken(ctx, user.ID, models.TokenScopeEmailVerification, emailVerificationExpiry)
+	if err != nil {
+		return err
+	}
+
+	app.sendEmail(mailer.Message{
+		To:      user.Email,
+		Subject: "Confirm your Go Movies account",
+		Body: fmt.Sprintf("Hi %s,\n\nplease confirm your email address by opening the link below within %s:\n\n%s\n",
+			user.FirstName, emailVerificationExpiry, app.frontendLink("/verify-email", token)),
+	})
+
+	return nil
+}
+
+// notifyRegisteredAddress tells the owner of an account that someone tried to register its address again. An
+// unverified owner gets a new verification link, as they likely lost the first one.
+func (app *application) notifyRegisteredAddress(ctx context.Context, user models.User) {
+
+	if !user.IsEmailVerified() {
+		err := app.sendVerificationEmail(ctx, user)
+		if err != nil {
+			app.logger(ctx).Error("sending a new verification link failed", "user_id", user.ID, "error", err)
+		}
+		return
+	}
+
+	app.sendEmail(mailer.Message{
+		To:      user.Email,
+		Subject: "Your Go Movies account",
+		Body: fmt.Sprintf("Hi %s,\n\nsomeone tried to register a new Go Movies account with your email address, which already has one. If it was you, log in instead, or ask for a password reset if you forgot your password.\n\nIf it wasn't you, you can ignore this email, your account is unchanged.\n",
+			user.FirstName),
+	})
+}
+
+// verifyEmail consumes an email verification token and marks the address of its user as verified.
+func (app *application) verifyEmail(w http.ResponseWriter, r *http.Request) {
+
+	var requestPayload struct {
+		Token string `json:"token"`
+	}
+
+	err := app.readJSON(w, r, &requestPayload)
+	if err != nil {
+		err := app.errorJSON(w, r, err, http.StatusBadRequest)
+		if err != nil {
+			return
+		}
+		return
+	}
+
+	token, err := app.DB.ConsumeUserToken(r.Context(), hashToken(requestPayload.Token), models.TokenScopeEmailVerification)
+	if err != nil {
+		err := app.errorJSON(w, r, errors.New("invalid or expired token"), http.StatusBadRequest)
+		if err != nil {
+			return
+		}
+		return
+	}
+
+	err = app.DB.SetUserEmailVerified(r.Context(), token.UserID)
+	if err != nil {
+		err := app.errorJSON(w, r, err, http.StatusInternalServerError)
+		if err != nil {
+			return
+		}
+		return
+	}
+
+	response := JSONResponse{
+		Error:   false,
+		Message: "email address verified",
+	}
+
+	err = app.writeJSON(w, http.StatusOK, response, nil)
+	if err != nil {
+		app.logger(r.Context()).Error("writing the response failed", "error", err)
+		return
+	}
+}
+
+// forgotPassword emails a password reset link to a verified user. The response is the same whether the
+// address is registered or not, so it cannot be used to discover accounts.
+func (app *application) forgotPassword(w http.ResponseWriter, r *http.Request) {
+
+	var requestPayload struct {
+		Email string `json:"email"`
+	}
+
+	err := app.readJSON(w, r, &requestPayload)
+	if err != nil {
+		err := app.errorJSON(w, r, err, http.StatusBadRequest)
+		if err != nil {
+			return
+		}
+		return
+	}
+
+	response := JSONResponse{
+		Error:   false,
+		Message: "if the address is registered, a password reset link has been sent to it",
+	}
+
+	user, err := app.DB.GetUserByEmail(r.Context(), strings.TrimSpace(requestPayload.Email))
+	if err == nil && user.IsEmailVerified() {
+		token, err := app.newUserToken(r.Context(), user.ID, models.TokenScopePasswordReset, passwordResetExpiry)
+		if err != nil {
+			app.logger(r.Context()).Error("creating the password reset token failed", "user_id", user.ID, "error", err)
+		} else {
+			app.sendEmail(mailer.Message{
+				To:      user.Email,
+				Subject: "Reset your Go Movies password",
+				Body: fmt.Sprintf("Hi %s,\n\nyou can choose a new password by opening the link below within %s:\n\n%s\n\nIf you did not ask for a password reset, you can ignore this email.\n",
+					user.FirstName, passwordResetExpiry, app.frontendLink("/reset-password", token)),
+			})
+		}
+	}
+
+	err = app.writeJSON(w, http.StatusAccepted, response, nil)
+	if err != nil {
+		app.logger(r.Context()).Error("writing the response failed", "error", err)
+		return
+	}
+}
+
+// resetPassword consumes a password reset token, sets the new password and ends every session of the user.
+func (app *application) resetPassword(w http.ResponseWriter, r *http.Request) {
+
+	var requestPayload struct {
+		Token    string `json:"token"`
+		Password string `json:"password"`
+	}
+
+	err := app.readJSON(w, r, &requestPayload)
+	if err != nil {
+		err := app.errorJSON(w, r, err, http.StatusBadRequest)
+		if err != nil {
+			return
+		}
+		return
+	}
+
+	err = validatePassword(requestPayload.Password)
+	if err != nil {
+		err := app.errorJSON(w, r, err, http.StatusBadRequest)
+		if err != nil {
+			return
+		}
+		return
+	}
+
+	token, err := app.DB.ConsumeUserToken(r.Context(), hashToken(requestPayload.Token), models.TokenScopePasswordReset)
+	if err != nil {
+		err := app.errorJSON(w, r, errors.New("invalid or expired token"), http.StatusBadRequest)
+		if err != nil {
+			return
+		}
+		return
+	}
+
+	var user models.User
+
+	err = user.SetPassword(requestPayload.Password)
+	if err != nil {
+		err := app.errorJSON(w, r, err, http.StatusInternalServerError)
+		if err != nil {
+			return
+		}
+		return
+	}
+
+	err = app.DB.UpdateUserPassword(r.Context(), token.UserID, user.Password)
+	if err != nil {
+		err := app.errorJSON(w, r, err, http.StatusInternalServerError)
+		if err != nil {
+			return
+		}
+		return
+	}
+
+	err = app.DB.RevokeUserRefreshTokens(r.Context(), token.UserID)
+	if err != nil {
+		app.logger(r.Context()).Error("revoking refresh tokens after a password reset failed", "user_id", token.UserID, "error", err)
+	}
+
+	response := JSONResponse{
+		Error:   false,
+		Message: "password updated",
+	}
+
+	err = app.writeJSON(w, http.StatusOK, response, nil)
+	if err != nil {
+		app.logger(r.Context()).Error("writing the response failed", "error", err)
+		return
+	}
+}
+
+// newUserToken creates a single-use token for the user, stores its hash and returns the plain text token.
+func (app *application) newUserToken(ctx context.Context, userID int, scope string, ttl time.Duration) (string, error) {
+
+	b := make([]byte, 32)
+
+	_, err := rand.Read(b)
+	if err != nil {
+		return "", err
+	}
+
+	token := base64.RawURLEncoding.EncodeToString(b)
+
+	err = app.DB.InsertUserToken(ctx, models.UserToken{
+		UserID:    userID,
+		TokenHash: hashToken(token),
+		Scope:     scope,
+		ExpiresAt: time.Now().UTC().Add(ttl),
+		CreatedAt: time.Now().UTC(),
+	})
+	if err != nil {
+		return "", err
+	}
+
+	return token, nil
+}
+
+// sendEmail sends the message in the background, so slow mail servers don't delay responses.
+func (app *application) sendEmail(msg mailer.Message) {
+	go func() {
+		err := app.Mailer.Send(msg)
+		if err != nil {
+			app.logger(context.Background()).Error("sending the email failed", "subject", msg.Subject, "error", err)
+		}
+	}()
+}
+
+// frontendLink returns the link to a page of the frontend carrying the given token.
+func (app *application) frontendLink(path, token string) string {
+	return strings.TrimSuffix(app.FrontendURL, "/") + path + "?token=" + url.QueryEscape(token)
+}
+
+// validateEmail checks that the address is a plain email address and returns it normalized.
+func validateEmail(email string) (string, error) {
+
+	address, err := mail.ParseAddress(strings.TrimSpace(email))
+	if err != nil || address.Name != "" || address.Address != strings.TrimSpace(email) {
+		return "", errors.New("invalid email address")
+	}
+
+	return strings.ToLower(address.Address), nil
+}
+
+// validatePassword checks that a new password is acceptable.
+func validatePassword(password string) error {
+	if len(password) < minPasswordLength {
+		return fmt.Errorf("password must be at least %d characters long", minPasswordLength)
+	}
+
+	// bcrypt ignores everything after the first 72 bytes
+	if len(password) > 72 {
+		return errors.New("password must be at most 72 bytes long")
+	}
+
+	return nil
+}
@@ -0,0 +1,235 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/calvarado2004/go-movies-backend/internal/mailer"
+	"github.com/calvarado2004/go-movies-backend/internal/models"
+	"github.com/calvarado2004/go-movies-backend/internal/repository/dbrepo"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+)
+
+// recordingSender collects the messages sent by the application.
+type recordingSender struct {
+	messages chan mailer.Message
+}
+
+// Send records the message.
+func (s *recordingSender) Send(msg mailer.Message) error {
+	s.messages <- msg
+	return nil
+}
+
+// tokenLink matches the token of the links in emails.
+var tokenLink = regexp.MustCompile(`\?token=(\S+)`)
+
+// newAccountsApp returns an application with a seeded memory repository, recording the email it sends.
+func newAccountsApp(t *testing.T) (*application, *recordingSender) {
+	t.Helper()
+
+	sender := &recordingSender{messages: make(chan mailer.Message, 10)}
+
+	app := &application{
+		DB:          dbrepo.NewSeededMemoryDBRepo(),
+		auth:        testAuth(t),
+		Mailer:      sender,
+		FrontendURL: "https://movies.example.com/",
+	}
+
+	return app, sender
+}
+
+// nextEmail waits for the next email sent, and returns it with the token of its link, if any.
+func nextEmail(t *testing.T, sender *recordingSender) (mailer.Message, string) {
+	t.Helper()
+
+	select {
+	case msg := <-sender.messages:
+		match := tokenLink.FindStringSubmatch(msg.Body)
+		if match == nil {
+			return msg, ""
+		}
+
+		token, err := url.QueryUnescape(match[1])
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		return msg, token
+	case <-time.After(5 * time.Second):
+		t.Fatal("no email was sent")
+		return mailer.Message{}, ""
+	}
+}
+
+// postJSON posts a JSON body to the application and returns the recorded response.
+func postJSON(app *application, target, body string) *httptest.ResponseRecorder {
+	rr := httptest.NewRecorder()
+	app.routes().ServeHTTP(rr, httptest.NewRequest(http.MethodPost, target, strings.NewReader(body)))
+	return rr
+}
+
+func TestRegister(t *testing.T) {
+
+	app, sender := newAccountsApp(t)
+
+	register := func(email string) *httptest.ResponseRecorder {
+		return postJSON(app, "/register", `{"first_name":"Ada","last_name":"Lovelace","email":"`+email+`","password":"analytical engine"}`)
+	}
+
+	rr := register("Ada@Example.com")
+	if rr.Code != http.StatusCreated {
+		t.Fatalf("registering = %d %s", rr.Code, rr.Body)
+	}
+
+	msg, token := nextEmail(t, sender)
+	if msg.To != "ada@example.com" || !strings.HasPrefix(msg.Body, "Hi Ada,") || !strings.Contains(msg.Body, "https://movies.example.com/verify-email?token=") {
+		t.Errorf("verification email = %+v", msg)
+	}
+
+	// an unverified account can't log in
+	login := postJSON(app, "/authenticate", `{"email":"ada@example.com","password":"analytical engine"}`)
+	if login.Code != http.StatusForbidden {
+		t.Errorf("login before verifying = %d, want %d", login.Code, http.StatusForbidden)
+	}
+
+	// registering the address again gets the same response, the owner gets a new verification link
+	again := register("ada@example.com")
+	if again.Code != rr.Code || again.Body.String() != rr.Body.String() {
+		t.Errorf("registering again = %d %s, want %d %s", again.Code, again.Body, rr.Code, rr.Body)
+	}
+
+	msg, newToken := nextEmail(t, sender)
+	if msg.To != "ada@example.com" || newToken == "" || newToken == token {
+		t.Errorf("email of registering an unverified address again = %+v, want a new verification link", msg)
+	}
+
+	if rr := postJSON(app, "/verify-email", `{"token":"`+token+`"}`); rr.Code != http.StatusOK {
+		t.Fatalf("verifying = %d %s", rr.Code, rr.Body)
+	}
+
+	// tokens are single-use
+	if rr := postJSON(app, "/verify-email", `{"token":"`+token+`"}`); rr.Code != http.StatusBadRequest {
+		t.Errorf("verifying with a used token = %d, want %d", rr.Code, http.StatusBadRequest)
+	}
+
+	user, err := app.DB.GetUserByEmail(context.Background(), "ada@example.com")
+	if err != nil || !user.IsEmailVerified() || user.Role != models.RoleViewer {
+		t.Fatalf("user = %+v, %v, want a verified viewer", user, err)
+	}
+
+	// registering a verified address only notifies its owner, without a link nor a second account
+	again = register("ADA@example.com")
+	if again.Code != rr.Code || again.Body.String() != rr.Body.String() {
+		t.Errorf("registering a verified address = %d %s, want %d %s", again.Code, again.Body, rr.Code, rr.Body)
+	}
+
+	msg, token = nextEmail(t, sender)
+	if msg.To != "ada@example.com" || token != "" || !strings.Contains(msg.Body, "someone tried to register") {
+		t.Errorf("email of registering a verified address = %+v, want a notice", msg)
+	}
+
+	if rr := postJSON(app, "/authenticate", `{"email":"ada@example.com","password":"analytical engine"}`); rr.Code != http.StatusAccepted {
+		t.Errorf("login after registering again = %d %s, want the first password kept", rr.Code, rr.Body)
+	}
+}
+
+func TestRegisterInvalid(t *testing.T) {
+
+	app, _ := newAccountsApp(t)
+
+	tests := []struct {
+		body    string
+		message string
+	}{
+		{`{"first_name":"Ada","last_name":"Lovelace","email":"Ada <ada@example.com>","password":"analytical engine"}`, "invalid email address"},
+		{`{"first_name":"Ada","last_name":"Lovelace","email":"ada","password":"analytical engine"}`, "invalid email address"},
+		{`{"first_name":" ","last_name":"Lovelace","email":"ada@example.com","password":"analytical engine"}`, "first and last name are required"},
+		{`{"first_name":"Ada","last_name":"Lovelace","email":"ada@example.com","password":"short"}`, "password must be at least 8 characters long"},
+		{`{"first_name":"Ada","last_name":"Lovelace","email":"ada@example.com","password":"` + strings.Repeat("x", 73) + `"}`, "password must be at most 72 bytes long"},
+		{`{"first_name":"Ada","last_name":"Lovelace","email":"ada@example.com","password":"analytical engine","role":"admin"}`, `json: unknown field "role"`},
+	}
+
+	for _, tt := range tests {
+		rr := postJSON(app, "/register", tt.body)
+
+		var response JSONResponse
+
+		err := json.Unmarshal(rr.Body.Bytes(), &response)
+		if rr.Code != http.StatusBadRequest || err != nil || response.Message != tt.message {
+			t.Errorf("registering %s = %d %s, want 400 %q", tt.body, rr.Code, rr.Body, tt.message)
+		}
+	}
+}
+
+func TestResetPassword(t *testing.T) {
+
+	app, sender := newAccountsApp(t)
+
+	admin, err := app.DB.GetUserByEmail(context.Background(), "admin@example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	session, err := app.issueTokenPair(context.Background(), &jwtUser{ID: admin.ID, Role: admin.Role}, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// unknown addresses get the same response, and no email
+	unknown := postJSON(app, "/forgot-password", `{"email":"nobody@example.com"}`)
+
+	rr := postJSON(app, "/forgot-password", `{"email":"admin@example.com"}`)
+	if rr.Code != http.StatusAccepted || unknown.Code != rr.Code || unknown.Body.String() != rr.Body.String() {
+		t.Errorf("forgot password = %d %s, for an unknown address %d %s, want the same", rr.Code, rr.Body, unknown.Code, unknown.Body)
+	}
+
+	msg, token := nextEmail(t, sender)
+	if msg.To != "admin@example.com" || !strings.Contains(msg.Body, "https://movies.example.com/reset-password?token=") {
+		t.Fatalf("reset email = %+v", msg)
+	}
+
+	if rr := postJSON(app, "/reset-password", `{"token":"`+token+`","password":"short"}`); rr.Code != http.StatusBadRequest {
+		t.Errorf("resetting to a short password = %d, want %d", rr.Code, http.StatusBadRequest)
+	}
+
+	// a verification token isn't a reset token
+	verification, err := app.newUserToken(context.Background(), admin.ID, models.TokenScopeEmailVerification, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if rr := postJSON(app, "/reset-password", `{"token":"`+verification+`","password":"new password"}`); rr.Code != http.StatusBadRequest {
+		t.Errorf("resetting with a verification token = %d, want %d", rr.Code, http.StatusBadRequest)
+	}
+
+	if rr := postJSON(app, "/reset-password", `{"token":"`+token+`","password":"new password"}`); rr.Code != http.StatusOK {
+		t.Fatalf("resetting = %d %s", rr.Code, rr.Body)
+	}
+
+	if rr := postJSON(app, "/reset-password", `{"token":"`+token+`","password":"other password"}`); rr.Code != http.StatusBadRequest {
+		t.Errorf("resetting with a used token = %d, want %d", rr.Code, http.StatusBadRequest)
+	}
+
+	if rr := postJSON(app, "/authenticate", `{"email":"admin@example.com","password":"new password"}`); rr.Code != http.StatusAccepted {
+		t.Errorf("login with the new password = %d %s", rr.Code, rr.Body)
+	}
+
+	// the reset ends the sessions started with the old password
+	stored, err := app.DB.GetRefreshTokenByHash(context.Background(), hashToken(session.RefreshToken))
+	if err != nil || !stored.IsRevoked() {
+		t.Errorf("refresh token after the reset = %+v, %v, want it revoked", stored, err)
+	}
+
+	select {
+	case msg := <-sender.messages:
+		t.Errorf("unexpected email %+v", msg)
+	default:
+	}
+}
@@ -1,17 +1,51 @@
 package main
 
 import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
 	"fmt"
+	"github.com/calvarado2004/go-movies-backend/internal/keyring"
+	"github.com/calvarado2004/go-movies-backend/internal/models"
 	"github.com/golang-jwt/jwt/v4"
 	"net/http"
 	"time"
 )
 
+// Token types, set as the typ claim so that a refresh token is never accepted as an access token.
+const (
+	accessTokenType       = "access"
+	refreshTokenType      = "refresh"
+	mfaChallengeTokenType = "mfa_challenge"
+)
+
+// mfaChallengeExpiry is how long a user who logged in with their password has to give their second factor.
+const mfaChallengeExpiry = 5 * time.Minute
+
+// Errors of token verification, telling why a request isn't authenticated.
+var (
+	errMissingToken     = errors.New("missing authorization header")
+	errMalformedHeader  = errors.New("invalid authorization header")
+	errInvalidToken     = errors.New("invalid token")
+	errTokenExpired     = errors.New("token is expired")
+	errTokenNotValidYet = errors.New("token is not valid yet")
+	errInvalidIssuer    = errors.New("invalid issuer")
+	errInvalidAudience  = errors.New("invalid audience")
+	errWrongTokenType   = errors.New("wrong token type")
+)
+
+// errRefreshTokenReused is returned when rotating a refresh token that was already rotated.
+var errRefreshTokenReused = errors.New("refresh token reused")
+
 // Auth is a struct that holds the authentication configuration.
 type Auth struct {
-	Issuer        string
-	Audience      string
-	Secret        string
+	Issuer   string
+	Audience string
+	Keys     *keyring.Keyring
+	// Leeway tolerates clock skew between the servers issuing and verifying tokens.
+	Leeway        time.Duration
 	TokenExpiry   time.Duration
 	RefreshExpiry time.Duration
 	CookieDomain  string
@@ -24,6 +58,7 @@ type jwtUser struct {
 	ID        int    `json:"id"`
 	FirstName string `json:"first_name"`
 	LastName  string `json:"last_name"`
+	Role      string `json:"role"`
 }
 
 // tokenPairs is a struct that holds the access and refresh tokens.
@@ -34,42 +69,50 @@ type tokenPairs struct {
 
 // tokenClaims is a struct that holds the claims for the access token.
 type tokenClaims struct {
+	Name string `json:"name"`
+	Role string `json:"role"`
+	Type string `json:"typ"`
 	jwt.RegisteredClaims
 }
 
 // generateTokenPair generates a new access and refresh token pair.
 func (j *Auth) generateTokenPair(user *jwtUser) (tokenPairs, error) {
 
-	// Create a token
-	token := jwt.New(jwt.SigningMethodHS256)
-
 	// Set the claims
-	claims := token.Claims.(jwt.MapClaims)
+	claims := jwt.MapClaims{}
 	claims["name"] = fmt.Sprintf("%s %s", user.FirstName, user.LastName)
 	claims["sub"] = fmt.Sprint(user.ID)
+	claims["role"] = user.Role
 	claims["aud"] = j.Audience
 	claims["iss"] = j.Issuer
 	claims["iat"] = time.Now().UTC().Unix()
-	claims["typ"] = "JWT"
+	claims["typ"] = accessTokenType
 	claims["exp"] = time.Now().UTC().Add(j.TokenExpiry).Unix()
 
-	// Sign the token
-	signedAccessToken, err := token.SignedString([]byte(j.Secret))
+	// Sign the token with the current key
+	signedAccessToken, err := j.Keys.Sign(claims, time.Now())
 	if err != nil {
 		return tokenPairs{}, err
 	}
 
-	// Create a refresh token
-	refreshToken := jwt.New(jwt.SigningMethodHS256)
+	// Every refresh token gets a unique ID, so its hash identifies it in the database
+	tokenID, err := newRandomID()
+	if err != nil {
+		return tokenPairs{}, err
+	}
 
 	// Set the claims
-	claimsRefresh := refreshToken.Claims.(jwt.MapClaims)
+	claimsRefresh := jwt.MapClaims{}
 	claimsRefresh["sub"] = fmt.Sprint(user.ID)
+	claimsRefresh["jti"] = tokenID
+	claimsRefresh["typ"] = refreshTokenType
+	claimsRefresh["iss"] = j.Issuer
+	claimsRefresh["aud"] = j.Issuer
 	claimsRefresh["iat"] = time.Now().UTC().Unix()
-	claimsRefresh["exp"] = time.Now().UTC().Add(j.TokenExpiry).Unix()
+	claimsRefresh["exp"] = time.Now().UTC().Add(j.RefreshExpiry).Unix()
 
 	// Sign the refresh token
-	signedRefreshToken, err := refreshToken.SignedString([]byte(j.Secret))
+	signedRefreshToken, err := j.Keys.Sign(claimsRefresh, time.Now())
 	if err != nil {
 		return tokenPairs{}, err
 	}
@@ -85,6 +128,28 @@ func (j *Auth) generateTokenPair(user *jwtUser) (tokenPairs, error) {
 
 }
 
+// generateChallengeToken generates the token a user with two-factor authentication gets for their password,
+// which they exchange along with a code for a token pair.
+func (j *Auth) generateChallengeToken(userID int) (string, error) {
+
+	claims := jwt.MapClaims{}
+	claims["sub"] = fmt.Sprint(userID)
+	claims["typ"] = mfaChallengeTokenType
+	claims["iss"] = j.Issuer
+	claims["aud"] = j.Issuer
+	claims["iat"] = time.Now().UTC().Unix()
+	claims["exp"] = time.Now().UTC().Add(mfaChallengeExpiry).Unix()
+
+	return j.Keys.Sign(claims, time.Now())
+}
+
+// parseChallengeToken verifies the signature of a two-factor challenge token and returns its claims.
+func (j *Auth) parseChallengeToken(challengeToken string) (*tokenClaims, error) {
+
+	// like refresh tokens, challenge tokens are only ever sent back to the API
+	return j.verifyToken(challengeToken, mfaChallengeTokenType, j.Issuer)
+}
+
 // getRefreshCookie generates a new refresh cookie.
 func (j *Auth) getRefreshCookie(refreshToken string) *http.Cookie {
 	return &http.Cookie{
@@ -114,3 +179,157 @@ func (j *Auth) getExpiredRefreshCookie() *http.Cookie {
 		SameSite: http.SameSiteStrictMode,
 	}
 }
+
+// newRandomID returns a random, hex encoded identifier suitable for token IDs and token families.
+func newRandomID() (string, error) {
+	b := make([]byte, 16)
+
+	_, err := rand.Read(b)
+	if err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(b), nil
+}
+
+// hashToken returns the hex encoded SHA-256 hash of a token, which is what gets stored in the database.
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// issueTokenPair generates a new token pair for the user and stores its refresh token as part of the given
+// token family. An empty familyID starts a new family, i.e. a new login session.
+func (app *application) issueTokenPair(ctx context.Context, user *jwtUser, familyID string) (tokenPairs, error) {
+
+	tokens, refreshToken, err := app.newTokenPair(user, familyID)
+	if err != nil {
+		return tokenPairs{}, err
+	}
+
+	err = app.DB.InsertRefreshToken(ctx, refreshToken)
+	if err != nil {
+		return tokenPairs{}, err
+	}
+
+	return tokens, nil
+}
+
+// newTokenPair generates a new token pair for the user, and the record of its refresh token in the given token
+// family, for the caller to store. An empty familyID starts a new family.
+func (app *application) newTokenPair(user *jwtUser, familyID string) (tokenPairs, models.RefreshToken, error) {
+
+	var err error
+
+	if familyID == "" {
+		familyID, err = newRandomID()
+		if err != nil {
+			return tokenPairs{}, models.RefreshToken{}, err
+		}
+	}
+
+	tokens, err := app.auth.generateTokenPair(user)
+	if err != nil {
+		return tokenPairs{}, models.RefreshToken{}, err
+	}
+
+	refreshToken := models.RefreshToken{
+		UserID:    user.ID,
+		TokenHash: hashToken(tokens.RefreshToken),
+		FamilyID:  familyID,
+		ExpiresAt: time.Now().UTC().Add(app.auth.RefreshExpiry),
+		CreatedAt: time.Now().UTC(),
+	}
+
+	return tokens, refreshToken, nil
+}
+
+// parseRefreshToken verifies the signature of a refresh token and returns its claims.
+func (j *Auth) parseRefreshToken(refreshToken string) (*tokenClaims, error) {
+
+	// refresh tokens are only ever sent back to the API, which is their audience
+	return j.verifyToken(refreshToken, refreshTokenType, j.Issuer)
+}
+
+// verifyToken verifies the signature of a token of the given type and its claims: expiry, not before, issued
+// at, issuer and audience. Times are compared with Leeway of tolerance.
+func (j *Auth) verifyToken(signed, tokenType, audience string) (*tokenClaims, error) {
+
+	claims := &tokenClaims{}
+
+	// the claims are checked below, with the leeway
+	parser := jwt.NewParser(jwt.WithoutClaimsValidation())
+
+	_, err := parser.ParseWithClaims(signed, claims, j.Keys.Keyfunc(time.Now))
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", errInvalidToken, err)
+	}
+
+	now := time.Now()
+
+	switch {
+	case !claims.VerifyExpiresAt(now.Add(-j.Leeway), true):
+		return nil, errTokenExpired
+	case !claims.VerifyNotBefore(now.Add(j.Leeway), false), !claims.VerifyIssuedAt(now.Add(j.Leeway), false):
+		return nil, errTokenNotValidYet
+	case !claims.VerifyIssuer(j.Issuer, true):
+		return nil, errInvalidIssuer
+	case !claims.VerifyAudience(audience, true):
+		return nil, errInvalidAudience
+	case claims.Type != tokenType:
+		return nil, errWrongTokenType
+	}
+
+	return claims, nil
+}
+
+// authUser is the user a request is authenticated as.
+type authUser struct {
+	ID   int
+	Name string
+	Role string
+}
+
+// can reports whether the role of the user grants the given one.
+func (u authUser) can(role string) bool {
+	return models.RoleSatisfies(u.Role, role)
+}
+
+// authUserKey is the context key of the authUser of a request.
+type authUserKey struct{}
+
+// contextWithUser returns a copy of ctx carrying the user the request is authenticated as.
+func contextWithUser(ctx context.Context, user authUser) context.Context {
+	return context.WithValue(ctx, authUserKey{}, user)
+}
+
+// userFromContext returns the user the request of ctx is authenticated as, set by the authRequired and
+// requireRole middleware.
+func userFromContext(ctx context.Context) (authUser, bool) {
+	user, ok := ctx.Value(authUserKey{}).(authUser)
+	return user, ok
+}
+
+// cleanupRefreshTokens periodically deletes expired refresh tokens until the done channel is closed.
+func (app *application) cleanupRefreshTokens(interval time.Duration, done <-chan struct{}) {
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			deleted, err := app.DB.DeleteExpiredRefreshTokens(context.Background())
+			if err != nil {
+				app.logger(context.Background()).Error("deleting expired refresh tokens failed", "error", err)
+				continue
+			}
+
+			if deleted > 0 {
+				app.logger(context.Background()).Info("deleted expired refresh tokens", "count", deleted)
+			}
+		case <-done:
+			return
+		}
+	}
+}
@@ -0,0 +1,371 @@
+package main
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"github.com/calvarado2004/go-movies-backend/internal/keyring"
+	"github.com/calvarado2004/go-movies-backend/internal/models"
+	"github.com/calvarado2004/go-movies-backend/internal/repository"
+	"github.com/calvarado2004/go-movies-backend/internal/repository/dbrepo"
+	"github.com/golang-jwt/jwt/v4"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// testAuth returns an Auth signing tokens with a new Ed25519 key.
+func testAuth(t *testing.T) Auth {
+	t.Helper()
+
+	_, private, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	key, err := keyring.NewKey("test", private)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	keys, err := keyring.New(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return Auth{
+		Issuer:        "example.com",
+		Audience:      "example.com",
+		Keys:          keys,
+		Leeway:        30 * time.Second,
+		TokenExpiry:   time.Minute,
+		RefreshExpiry: time.Hour,
+		CookieName:    "refresh_token",
+	}
+}
+
+func TestTokenTypes(t *testing.T) {
+
+	auth := testAuth(t)
+
+	tokens, err := auth.generateTokenPair(&jwtUser{ID: 1, Role: models.RoleViewer})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	verify := func(token string) error {
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		r.Header.Set("Authorization", "Bearer "+token)
+		_, _, err := auth.getTokenFromHeaderAndVerify(httptest.NewRecorder(), r)
+		return err
+	}
+
+	if err := verify(tokens.AccessToken); err != nil {
+		t.Errorf("the access token is refused: %v", err)
+	}
+
+	if err := verify(tokens.RefreshToken); err == nil {
+		t.Error("the refresh token is accepted as an access token")
+	}
+
+	if _, err := auth.parseRefreshToken(tokens.RefreshToken); err != nil {
+		t.Errorf("the refresh token is refused: %v", err)
+	}
+
+	if _, err := auth.parseRefreshToken(tokens.AccessToken); err == nil {
+		t.Error("the access token is accepted as a refresh token")
+	}
+
+	// a token signed by a key of another keyring is refused, even with the same kid
+	other := testAuth(t)
+
+	forged, err := other.generateTokenPair(&jwtUser{ID: 1, Role: models.RoleAdmin})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := verify(forged.AccessToken); err == nil {
+		t.Error("a token signed by an unknown key is accepted")
+	}
+}
+
+func TestJWKS(t *testing.T) {
+
+	app := application{DB: dbrepo.NewMemoryDBRepo(), auth: testAuth(t)}
+
+	tokens, err := app.auth.generateTokenPair(&jwtUser{ID: 1, Role: models.RoleViewer})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rr := httptest.NewRecorder()
+	app.routes().ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/.well-known/jwks.json", nil))
+
+	if rr.Code != http.StatusOK {
+		t.Fatalf("GET /.well-known/jwks.json = %d, want %d", rr.Code, http.StatusOK)
+	}
+
+	var set keyring.JWKS
+
+	err = json.Unmarshal(rr.Body.Bytes(), &set)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(set.Keys) != 1 || set.Keys[0].ID != "test" || set.Keys[0].Curve != "Ed25519" {
+		t.Fatalf("JWKS = %+v, want the test key", set)
+	}
+
+	// another service verifies the access token with the published key only
+	x, err := base64.RawURLEncoding.DecodeString(set.Keys[0].X)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = jwt.Parse(tokens.AccessToken, func(token *jwt.Token) (any, error) {
+		if token.Header["kid"] != set.Keys[0].ID {
+			t.Errorf("kid = %v, want %s", token.Header["kid"], set.Keys[0].ID)
+		}
+		return ed25519.PublicKey(x), nil
+	})
+	if err != nil {
+		t.Errorf("the token can't be verified with the JWKS: %v", err)
+	}
+}
+
+func TestVerifyTokenClaims(t *testing.T) {
+
+	auth := testAuth(t)
+	now := time.Now()
+
+	// sign returns a token of the claims, which are valid access token claims unless overridden
+	sign := func(overrides jwt.MapClaims) string {
+		claims := jwt.MapClaims{
+			"sub":  "1",
+			"typ":  accessTokenType,
+			"iss":  auth.Issuer,
+			"aud":  auth.Audience,
+			"iat":  now.Unix(),
+			"exp":  now.Add(time.Minute).Unix(),
+			"role": models.RoleViewer,
+		}
+		for key, value := range overrides {
+			if value == nil {
+				delete(claims, key)
+				continue
+			}
+			claims[key] = value
+		}
+
+		signed, err := auth.Keys.Sign(claims, now)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return signed
+	}
+
+	tests := []struct {
+		name   string
+		claims jwt.MapClaims
+		want   error
+	}{
+		{"valid", nil, nil},
+		{"expired within the leeway", jwt.MapClaims{"exp": now.Add(-10 * time.Second).Unix()}, nil},
+		{"expired", jwt.MapClaims{"exp": now.Add(-time.Minute).Unix()}, errTokenExpired},
+		{"without expiry", jwt.MapClaims{"exp": nil}, errTokenExpired},
+		{"not before within the leeway", jwt.MapClaims{"nbf": now.Add(10 * time.Second).Unix()}, nil},
+		{"not valid yet", jwt.MapClaims{"nbf": now.Add(time.Minute).Unix()}, errTokenNotValidYet},
+		{"issued in the future", jwt.MapClaims{"iat": now.Add(time.Minute).Unix()}, errTokenNotValidYet},
+		{"other issuer", jwt.MapClaims{"iss": "other.example.com"}, errInvalidIssuer},
+		{"other audience", jwt.MapClaims{"aud": "other.example.com"}, errInvalidAudience},
+		{"without audience", jwt.MapClaims{"aud": nil}, errInvalidAudience},
+		{"one of the audiences", jwt.MapClaims{"aud": []string{"other.example.com", auth.Audience}}, nil},
+		{"refresh token", jwt.MapClaims{"typ": refreshTokenType}, errWrongTokenType},
+	}
+
+	for _, tt := range tests {
+		_, err := auth.verifyToken(sign(tt.claims), accessTokenType, auth.Audience)
+		if !errors.Is(err, tt.want) || (tt.want == nil && err != nil) {
+			t.Errorf("%s: verifyToken = %v, want %v", tt.name, err, tt.want)
+		}
+	}
+
+	_, err := auth.verifyToken(sign(nil)+"x", accessTokenType, auth.Audience)
+	if !errors.Is(err, errInvalidToken) {
+		t.Errorf("bad signature: verifyToken = %v, want %v", err, errInvalidToken)
+	}
+}
+
+func TestAuthContext(t *testing.T) {
+
+	app := application{DB: dbrepo.NewMemoryDBRepo(), auth: testAuth(t)}
+
+	tokens, err := app.auth.generateTokenPair(&jwtUser{ID: 7, FirstName: "Ada", LastName: "Lovelace", Role: models.RoleEditor})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var got authUser
+	var found bool
+
+	handler := app.authRequired(app.requireRole(models.RoleEditor)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got, found = userFromContext(r.Context())
+	})))
+
+	serve := func(authorization string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		if authorization != "" {
+			r.Header.Set("Authorization", authorization)
+		}
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, r)
+		return rr
+	}
+
+	rr := serve("Bearer " + tokens.AccessToken)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("status = %d, want %d", rr.Code, http.StatusOK)
+	}
+
+	if challenge := rr.Header().Get("WWW-Authenticate"); challenge != "" {
+		t.Errorf("a valid token got the challenge %s", challenge)
+	}
+
+	want := authUser{ID: 7, Name: "Ada Lovelace", Role: models.RoleEditor}
+	if !found || got != want {
+		t.Errorf("user = %+v, %t, want %+v", got, found, want)
+	}
+
+	challenges := []struct {
+		authorization string
+		want          string
+	}{
+		{"", "Bearer"},
+		{"Basic abc", `Bearer error="invalid_request"`},
+		{"Bearer " + tokens.RefreshToken, `Bearer error="invalid_token", error_description="wrong token type"`},
+		{"Bearer nonsense", `Bearer error="invalid_token"`},
+	}
+
+	for _, c := range challenges {
+		rr := serve(c.authorization)
+		if rr.Code != http.StatusUnauthorized {
+			t.Errorf("%q: status = %d, want %d", c.authorization, rr.Code, http.StatusUnauthorized)
+		}
+		if got := rr.Header().Get("WWW-Authenticate"); got != c.want {
+			t.Errorf("%q: WWW-Authenticate = %s, want %s", c.authorization, got, c.want)
+		}
+	}
+
+	// a viewer is authenticated but not allowed
+	viewer, err := app.auth.generateTokenPair(&jwtUser{ID: 8, Role: models.RoleViewer})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if rr := serve("Bearer " + viewer.AccessToken); rr.Code != http.StatusForbidden {
+		t.Errorf("viewer: status = %d, want %d", rr.Code, http.StatusForbidden)
+	}
+}
+
+// failingRefreshRepo is a repository failing to store refresh tokens, in and out of transactions.
+type failingRefreshRepo struct {
+	repository.DatabaseRepo
+}
+
+// InsertRefreshToken fails.
+func (r failingRefreshRepo) InsertRefreshToken(ctx context.Context, token models.RefreshToken) error {
+	return errors.New("disk full")
+}
+
+// WithTx runs fn with a transaction of the repository that also fails to store refresh tokens.
+func (r failingRefreshRepo) WithTx(ctx context.Context, fn func(repo repository.DatabaseRepo) error) error {
+	return r.DatabaseRepo.WithTx(ctx, func(repo repository.DatabaseRepo) error {
+		return fn(failingRefreshRepo{repo})
+	})
+}
+
+func TestRefreshTokenRotation(t *testing.T) {
+
+	repo := dbrepo.NewSeededMemoryDBRepo()
+
+	app := application{DB: repo, auth: testAuth(t)}
+
+	// refresh presents a refresh token and returns the status and the rotated refresh token
+	refresh := func(refreshToken string) (int, string) {
+		t.Helper()
+
+		req := httptest.NewRequest(http.MethodGet, "/refresh", nil)
+		req.AddCookie(&http.Cookie{Name: app.auth.CookieName, Value: refreshToken})
+
+		rr := httptest.NewRecorder()
+		app.routes().ServeHTTP(rr, req)
+
+		if rr.Code != http.StatusOK {
+			return rr.Code, ""
+		}
+
+		var tokens tokenPairs
+
+		err := json.Unmarshal(rr.Body.Bytes(), &tokens)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		cookies := rr.Result().Cookies()
+		if len(cookies) != 1 || cookies[0].Value != tokens.RefreshToken {
+			t.Errorf("cookies = %v, want the rotated refresh token", cookies)
+		}
+
+		return rr.Code, tokens.RefreshToken
+	}
+
+	login, err := app.issueTokenPair(context.Background(), &jwtUser{ID: 1, Role: models.RoleAdmin}, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	code, second := refresh(login.RefreshToken)
+	if code != http.StatusOK {
+		t.Fatalf("refreshing = %d, want %d", code, http.StatusOK)
+	}
+
+	code, third := refresh(second)
+	if code != http.StatusOK || third == second {
+		t.Fatalf("refreshing the rotated token = %d, want %d and a new token", code, http.StatusOK)
+	}
+
+	other, err := app.issueTokenPair(context.Background(), &jwtUser{ID: 1, Role: models.RoleAdmin}, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// replaying a rotated token revokes the whole session, not the other sessions of the user
+	if code, _ := refresh(login.RefreshToken); code != http.StatusUnauthorized {
+		t.Errorf("replaying a rotated token = %d, want %d", code, http.StatusUnauthorized)
+	}
+
+	if code, _ := refresh(third); code != http.StatusUnauthorized {
+		t.Errorf("refreshing after a replay = %d, want %d", code, http.StatusUnauthorized)
+	}
+
+	// a rotation that fails keeps the presented token valid, its retry isn't a replay
+	app.DB = failingRefreshRepo{repo}
+
+	if code, _ := refresh(other.RefreshToken); code != http.StatusInternalServerError {
+		t.Errorf("refreshing without storage = %d, want %d", code, http.StatusInternalServerError)
+	}
+
+	app.DB = repo
+
+	if code, _ := refresh(other.RefreshToken); code != http.StatusOK {
+		t.Errorf("retrying the refresh = %d, want %d", code, http.StatusOK)
+	}
+
+	if code, _ := refresh("nonsense"); code != http.StatusUnauthorized {
+		t.Errorf("refreshing an invalid token = %d, want %d", code, http.StatusUnauthorized)
+	}
+}
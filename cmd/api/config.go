@@ -0,0 +1,23 @@
+package main
+
+import (
+	"errors"
+	"github.com/calvarado2004/go-movies-backend/internal/config"
+	"os"
+)
+
+// runConfig runs the config subcommand: config print writes the effective configuration with the secrets
+// redacted, then reports whether the server would accept it.
+func runConfig(cfg *config.Config, args []string) error {
+
+	if len(args) != 1 || args[0] != "print" {
+		return errors.New("usage: config print")
+	}
+
+	err := cfg.Print(os.Stdout)
+	if err != nil {
+		return err
+	}
+
+	return cfg.Validate()
+}
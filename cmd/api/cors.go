@@ -0,0 +1,137 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// defaultCORSMethods are the methods browsers may use on the API from another origin.
+var defaultCORSMethods = []string{"GET", "POST", "PUT", "PATCH", "DELETE", "OPTIONS"}
+
+// defaultCORSHeaders are the request headers browsers may send to the API from another origin.
+var defaultCORSHeaders = []string{"Accept", "Content-Type", "Content-Length", "Accept-Encoding", "X-CSRF-Token", "Authorization", requestIDHeader}
+
+// CORS is the cross-origin resource sharing policy of the API.
+type CORS struct {
+	// AllowedOrigins are the origins allowed to call the API, such as https://movies.example.com. A * in place
+	// of the first labels of the host allows every subdomain, https://*.example.com, a lone * any origin.
+	AllowedOrigins []string
+	AllowedMethods []string
+	AllowedHeaders []string
+	// ExposedHeaders are the response headers scripts of other origins may read.
+	ExposedHeaders []string
+	// MaxAge is how long browsers may cache a preflight response, no Access-Control-Max-Age is sent when 0.
+	MaxAge time.Duration
+}
+
+// validate checks that every allowed origin is a scheme and host, with an optional port and wildcard.
+func (c CORS) validate() error {
+
+	for _, origin := range c.AllowedOrigins {
+		if origin == "*" {
+			continue
+		}
+
+		host := strings.TrimPrefix(origin, "https://")
+		host = strings.TrimPrefix(host, "http://")
+
+		if strings.Contains(host, "*") && (!strings.HasPrefix(host, "*.") || strings.Count(host, "*") > 1) {
+			return fmt.Errorf("invalid CORS origin %q, a wildcard can only replace the first labels of the host", origin)
+		}
+
+		u, err := url.Parse(strings.Replace(origin, "*", "wildcard", 1))
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" || u.Path != "" || u.RawQuery != "" || u.User != nil {
+			return fmt.Errorf("invalid CORS origin %q, want a scheme and host such as https://movies.example.com", origin)
+		}
+	}
+
+	return nil
+}
+
+// allowsOrigin reports whether the policy allows requests from origin.
+func (c CORS) allowsOrigin(origin string) bool {
+
+	origin = strings.ToLower(origin)
+
+	for _, allowed := range c.AllowedOrigins {
+		allowed = strings.ToLower(allowed)
+
+		prefix, suffix, wildcard := strings.Cut(allowed, "*")
+		if !wildcard {
+			if origin == allowed {
+				return true
+			}
+			continue
+		}
+
+		if allowed == "*" {
+			return true
+		}
+
+		// the wildcard stands for one or more labels of the host, nothing else
+		if len(origin) > len(prefix)+len(suffix) && strings.HasPrefix(origin, prefix) && strings.HasSuffix(origin, suffix) {
+			labels := origin[len(prefix) : len(origin)-len(suffix)]
+			if !strings.ContainsAny(labels, "/:@?#") && !strings.HasPrefix(labels, ".") && !strings.HasSuffix(labels, ".") {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// enableCORS is a middleware function that adds the CORS headers of the policy to the responses to allowed
+// origins, and answers their preflight requests.
+func (app *application) enableCORS(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+
+		// the response depends on the origin, caches must not serve it to another one
+		w.Header().Add("Vary", "Origin")
+
+		origin := r.Header.Get("Origin")
+		preflight := r.Method == http.MethodOptions && r.Header.Get("Access-Control-Request-Method") != ""
+
+		if preflight {
+			w.Header().Add("Vary", "Access-Control-Request-Method")
+			w.Header().Add("Vary", "Access-Control-Request-Headers")
+		}
+
+		if origin == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		if !app.cors.allowsOrigin(origin) {
+			if preflight {
+				w.WriteHeader(http.StatusForbidden)
+				return
+			}
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		w.Header().Set("Access-Control-Allow-Origin", origin)
+		w.Header().Set("Access-Control-Allow-Credentials", "true")
+
+		if !preflight {
+			if len(app.cors.ExposedHeaders) > 0 {
+				w.Header().Set("Access-Control-Expose-Headers", strings.Join(app.cors.ExposedHeaders, ", "))
+			}
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		w.Header().Set("Access-Control-Allow-Methods", strings.Join(app.cors.AllowedMethods, ", "))
+		w.Header().Set("Access-Control-Allow-Headers", strings.Join(app.cors.AllowedHeaders, ", "))
+
+		if app.cors.MaxAge > 0 {
+			w.Header().Set("Access-Control-Max-Age", strconv.Itoa(int(app.cors.MaxAge.Seconds())))
+		}
+
+		w.WriteHeader(http.StatusNoContent)
+	})
+}
@@ -0,0 +1,191 @@
+package main
+
+import (
+	"github.com/calvarado2004/go-movies-backend/internal/repository/dbrepo"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCORSAllowsOrigin(t *testing.T) {
+
+	cors := CORS{AllowedOrigins: []string{"https://movies.example.com", "https://*.staging.example.com", "http://localhost:3000"}}
+
+	tests := []struct {
+		origin string
+		want   bool
+	}{
+		{"https://movies.example.com", true},
+		{"https://MOVIES.example.com", true},
+		{"http://movies.example.com", false},
+		{"https://movies.example.com:8443", false},
+		{"https://pr-12.staging.example.com", true},
+		{"https://a.b.staging.example.com", true},
+		{"https://staging.example.com", false},
+		{"https://.staging.example.com", false},
+		{"https://evil.com/.staging.example.com", false},
+		{"https://user@x.staging.example.com", false},
+		{"https://x.staging.example.com.evil.com", false},
+		{"http://localhost:3000", true},
+		{"http://localhost:3001", false},
+		{"null", false},
+	}
+
+	for _, tt := range tests {
+		if got := cors.allowsOrigin(tt.origin); got != tt.want {
+			t.Errorf("allowsOrigin(%q) = %t, want %t", tt.origin, got, tt.want)
+		}
+	}
+
+	if !(CORS{AllowedOrigins: []string{"*"}}).allowsOrigin("https://anything.example.org") {
+		t.Error("* does not allow every origin")
+	}
+}
+
+func TestCORSValidate(t *testing.T) {
+
+	valid := []string{"*", "https://movies.example.com", "http://localhost:3000", "https://*.example.com"}
+
+	for _, origin := range valid {
+		err := CORS{AllowedOrigins: []string{origin}}.validate()
+		if err != nil {
+			t.Errorf("validate(%q) = %v, want nil", origin, err)
+		}
+	}
+
+	invalid := []string{"movies.example.com", "ftp://movies.example.com", "https://movies.example.com/", "https://movies.*.com", "https://*.*.example.com", "https://*example.com"}
+
+	for _, origin := range invalid {
+		err := CORS{AllowedOrigins: []string{origin}}.validate()
+		if err == nil {
+			t.Errorf("validate(%q) = nil, want an error", origin)
+		}
+	}
+}
+
+func TestEnableCORS(t *testing.T) {
+
+	app := application{
+		DB: dbrepo.NewSeededMemoryDBRepo(),
+		cors: CORS{
+			AllowedOrigins: []string{"https://movies.example.com", "https://*.staging.example.com"},
+			AllowedMethods: defaultCORSMethods,
+			AllowedHeaders: defaultCORSHeaders,
+			ExposedHeaders: []string{requestIDHeader},
+			MaxAge:         10 * time.Minute,
+		},
+	}
+
+	serve := func(method, origin string, header http.Header) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, "/movies", nil)
+		if origin != "" {
+			req.Header.Set("Origin", origin)
+		}
+		for key, values := range header {
+			req.Header[key] = values
+		}
+
+		rr := httptest.NewRecorder()
+		app.routes().ServeHTTP(rr, req)
+		return rr
+	}
+
+	preflight := http.Header{
+		"Access-Control-Request-Method":  {"POST"},
+		"Access-Control-Request-Headers": {"authorization, content-type"},
+	}
+
+	varies := func(t *testing.T, rr *httptest.ResponseRecorder, header string) {
+		t.Helper()
+		for _, vary := range rr.Header().Values("Vary") {
+			if strings.EqualFold(vary, header) {
+				return
+			}
+		}
+		t.Errorf("Vary = %v, want %s", rr.Header().Values("Vary"), header)
+	}
+
+	t.Run("allowed request", func(t *testing.T) {
+		rr := serve(http.MethodGet, "https://pr-1.staging.example.com", nil)
+
+		if rr.Code != http.StatusOK {
+			t.Fatalf("status = %d, want %d", rr.Code, http.StatusOK)
+		}
+		if got := rr.Header().Get("Access-Control-Allow-Origin"); got != "https://pr-1.staging.example.com" {
+			t.Errorf("Access-Control-Allow-Origin = %q, want the origin", got)
+		}
+		if got := rr.Header().Get("Access-Control-Allow-Credentials"); got != "true" {
+			t.Errorf("Access-Control-Allow-Credentials = %q, want true", got)
+		}
+		if got := rr.Header().Get("Access-Control-Expose-Headers"); got != requestIDHeader {
+			t.Errorf("Access-Control-Expose-Headers = %q, want %s", got, requestIDHeader)
+		}
+		varies(t, rr, "Origin")
+	})
+
+	t.Run("disallowed request", func(t *testing.T) {
+		rr := serve(http.MethodGet, "https://evil.example.org", nil)
+
+		if rr.Code != http.StatusOK {
+			t.Fatalf("status = %d, want %d", rr.Code, http.StatusOK)
+		}
+		if got := rr.Header().Get("Access-Control-Allow-Origin"); got != "" {
+			t.Errorf("Access-Control-Allow-Origin = %q, want none", got)
+		}
+		varies(t, rr, "Origin")
+	})
+
+	t.Run("same origin request", func(t *testing.T) {
+		rr := serve(http.MethodGet, "", nil)
+
+		if got := rr.Header().Get("Access-Control-Allow-Origin"); got != "" {
+			t.Errorf("Access-Control-Allow-Origin = %q, want none", got)
+		}
+		varies(t, rr, "Origin")
+	})
+
+	t.Run("allowed preflight", func(t *testing.T) {
+		rr := serve(http.MethodOptions, "https://movies.example.com", preflight)
+
+		if rr.Code != http.StatusNoContent {
+			t.Fatalf("status = %d, want %d", rr.Code, http.StatusNoContent)
+		}
+
+		want := map[string]string{
+			"Access-Control-Allow-Origin":  "https://movies.example.com",
+			"Access-Control-Allow-Methods": strings.Join(defaultCORSMethods, ", "),
+			"Access-Control-Allow-Headers": strings.Join(defaultCORSHeaders, ", "),
+			"Access-Control-Max-Age":       "600",
+		}
+		for key, value := range want {
+			if got := rr.Header().Get(key); got != value {
+				t.Errorf("%s = %q, want %q", key, got, value)
+			}
+		}
+
+		varies(t, rr, "Origin")
+		varies(t, rr, "Access-Control-Request-Method")
+		varies(t, rr, "Access-Control-Request-Headers")
+	})
+
+	t.Run("disallowed preflight", func(t *testing.T) {
+		rr := serve(http.MethodOptions, "https://evil.example.org", preflight)
+
+		if rr.Code != http.StatusForbidden {
+			t.Fatalf("status = %d, want %d", rr.Code, http.StatusForbidden)
+		}
+		if got := rr.Header().Get("Access-Control-Allow-Origin"); got != "" {
+			t.Errorf("Access-Control-Allow-Origin = %q, want none", got)
+		}
+	})
+
+	t.Run("options without preflight", func(t *testing.T) {
+		rr := serve(http.MethodOptions, "https://movies.example.com", nil)
+
+		if rr.Code == http.StatusNoContent {
+			t.Errorf("status = %d, want the request to reach the router", rr.Code)
+		}
+	})
+}
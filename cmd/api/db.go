@@ -1,11 +1,11 @@
 package main
 
 import (
+	"context"
 	"database/sql"
 	_ "github.com/jackc/pgconn"
 	_ "github.com/jackc/pgx/v4"
 	_ "github.com/jackc/pgx/v4/stdlib"
-	"log"
 )
 
 // openDB opens a connection to the database and returns a sql.DB instance for use by the application.
@@ -31,7 +31,7 @@ func (app *application) connectToDB() (*sql.DB, error) {
 		return nil, err
 	}
 
-	log.Println("Connected to database")
+	app.logger(context.Background()).Info("connected to the database")
 
 	return connection, nil
 
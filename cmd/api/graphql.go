@@ -0,0 +1,203 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/calvarado2004/go-movies-backend/internal/graph"
+	"io"
+	"mime"
+	"net/http"
+	"strings"
+)
+
+// Media types of GraphQL-over-HTTP.
+const (
+	graphQLMediaType         = "application/graphql"
+	graphQLResponseMediaType = "application/graphql-response+json"
+)
+
+// maxGraphQLBytes is the largest GraphQL request body accepted.
+const maxGraphQLBytes = 1024 * 1024 // 1MB
+
+// newGraph builds the GraphQL schema of the repository, with mutations doing the same work outside the
+// repository as the REST handlers.
+func (app *application) newGraph() (*graph.Graph, error) {
+
+	g, err := graph.NewGraph(app.DB)
+	if err != nil {
+		return nil, err
+	}
+
+	g.MovieCreated = app.movieCreated
+	g.DeleteMovie = app.deleteMovieAndPoster
+
+	return g, nil
+}
+
+// moviesGraphQL handler executes GraphQL requests against the movies catalog. It accepts GET requests with the
+// query in the query string, POST requests with a JSON body, and POST requests with the bare query as the body.
+func (app *application) moviesGraphQL(w http.ResponseWriter, r *http.Request) {
+
+	req, err := app.readGraphQLRequest(w, r)
+	if err != nil {
+		app.writeGraphQLError(w, r, http.StatusBadRequest, err)
+		return
+	}
+
+	// GET must not have side effects
+	if r.Method == http.MethodGet && req.Operation() == "mutation" {
+		w.Header().Set("Allow", http.MethodPost)
+		app.writeGraphQLError(w, r, http.StatusMethodNotAllowed, errors.New("mutations must be sent with POST"))
+		return
+	}
+
+	// mutations are executed for the user of a valid access token, if any
+	ctx := r.Context()
+	if r.Header.Get("Authorization") != "" {
+		user, err := app.authenticateRequest(w, r)
+		if err == nil {
+			ctx = contextWithUser(ctx, user)
+			ctx = graph.WithViewer(ctx, graph.Viewer{UserID: user.ID, Role: user.Role})
+		}
+	}
+
+	result := app.Graph.Execute(ctx, req)
+
+	// clients only get a fixed message for internal errors, their causes are logged
+	for _, err := range graph.InternalErrors(result) {
+		app.logError(r, http.StatusInternalServerError, err)
+	}
+
+	// with the GraphQL response media type, a request that failed before execution is a client error
+	status := http.StatusOK
+	if acceptsGraphQLResponse(r) && result.Data == nil && len(result.Errors) > 0 {
+		status = http.StatusBadRequest
+	}
+
+	app.writeGraphQL(w, r, status, result)
+}
+
+// readGraphQLRequest reads a GraphQL request from the query string of a GET request or the body of a POST request.
+func (app *application) readGraphQLRequest(w http.ResponseWriter, r *http.Request) (graph.Request, error) {
+
+	var req graph.Request
+
+	if r.Method == http.MethodGet {
+		qs := r.URL.Query()
+
+		req.Query = qs.Get("query")
+		req.OperationName = qs.Get("operationName")
+
+		if variables := qs.Get("variables"); variables != "" {
+			err := json.Unmarshal([]byte(variables), &req.Variables)
+			if err != nil {
+				return req, errors.New("variables must be a JSON object")
+			}
+		}
+	} else {
+		body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxGraphQLBytes))
+		if err != nil {
+			return req, err
+		}
+
+		mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+
+		switch mediaType {
+		case "application/json":
+			err = json.Unmarshal(body, &req)
+			if err != nil {
+				return req, fmt.Errorf("body must be a JSON GraphQL request: %w", err)
+			}
+		case graphQLMediaType:
+			req.Query = string(body)
+		default:
+			// older clients send the bare query without a GraphQL media type
+			req.Query = string(body)
+		}
+	}
+
+	if strings.TrimSpace(req.Query) == "" {
+		return req, errors.New("the request has no query")
+	}
+
+	return req, nil
+}
+
+// acceptsGraphQLResponse reports whether the client accepts the GraphQL response media type.
+func acceptsGraphQLResponse(r *http.Request) bool {
+	return strings.Contains(r.Header.Get("Accept"), graphQLResponseMediaType)
+}
+
+// graphQLRequestError is the response to a request that could not be read, which has no data.
+type graphQLRequestError struct {
+	Errors []graphQLErrorMessage `json:"errors"`
+}
+
+// graphQLErrorMessage is an error of a graphQLRequestError.
+type graphQLErrorMessage struct {
+	Message string `json:"message"`
+}
+
+// writeGraphQL writes a GraphQL result, in the GraphQL response media type when the client accepts it.
+func (app *application) writeGraphQL(w http.ResponseWriter, r *http.Request, status int, result any) {
+
+	out, err := json.Marshal(result)
+	if err != nil {
+		app.logError(r, http.StatusInternalServerError, err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	contentType := "application/json"
+	if acceptsGraphQLResponse(r) {
+		contentType = graphQLResponseMediaType
+	}
+
+	w.Header().Set("Content-Type", contentType+"; charset=utf-8")
+	w.WriteHeader(status)
+	_, err = w.Write(out)
+	if err != nil {
+		app.logger(r.Context()).Error("writing the response failed", "error", err)
+		return
+	}
+}
+
+// writeGraphQLError writes a GraphQL result holding a single request error.
+func (app *application) writeGraphQLError(w http.ResponseWriter, r *http.Request, status int, err error) {
+	app.logError(r, status, err)
+
+	result := graphQLRequestError{Errors: []graphQLErrorMessage{{Message: err.Error()}}}
+	app.writeGraphQL(w, r, status, result)
+}
+
+// graphiQL serves the GraphiQL playground, only routed in development.
+func (app *application) graphiQL(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	_, err := io.WriteString(w, graphiQLPage)
+	if err != nil {
+		return
+	}
+}
+
+// graphiQLPage is the GraphiQL playground, querying the /graph endpoint.
+const graphiQLPage = `<!DOCTYPE html>
+<html lang="en">
+<head>
+    <meta charset="utf-8">
+    <title>Go Movies GraphiQL</title>
+    <style>body { height: 100vh; margin: 0; } #graphiql { height: 100vh; }</style>
+    <link rel="stylesheet" href="https://unpkg.com/graphiql@3/graphiql.min.css">
+</head>
+<body>
+<div id="graphiql">Loading...</div>
+<script crossorigin src="https://unpkg.com/react@18/umd/react.production.min.js"></script>
+<script crossorigin src="https://unpkg.com/react-dom@18/umd/react-dom.production.min.js"></script>
+<script crossorigin src="https://unpkg.com/graphiql@3/graphiql.min.js"></script>
+<script>
+    const fetcher = GraphiQL.createFetcher({ url: window.location.origin + '/graph' });
+    ReactDOM.createRoot(document.getElementById('graphiql')).render(React.createElement(GraphiQL, { fetcher }));
+</script>
+</body>
+</html>
+`
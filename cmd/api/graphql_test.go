@@ -0,0 +1,330 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/calvarado2004/go-movies-backend/internal/graph"
+	"github.com/calvarado2004/go-movies-backend/internal/images"
+	"github.com/calvarado2004/go-movies-backend/internal/models"
+	"github.com/calvarado2004/go-movies-backend/internal/posters"
+	"github.com/calvarado2004/go-movies-backend/internal/posters/posterstest"
+	"github.com/calvarado2004/go-movies-backend/internal/repository/dbrepo"
+	"io/fs"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// graphQLResponse is a GraphQL response as seen by clients.
+type graphQLResponse struct {
+	Data   map[string]any `json:"data"`
+	Errors []struct {
+		Message    string         `json:"message"`
+		Path       []any          `json:"path"`
+		Extensions map[string]any `json:"extensions"`
+	} `json:"errors"`
+}
+
+// newGraphQLApp returns an application serving the GraphQL schema of a seeded memory repository.
+func newGraphQLApp(t *testing.T) *application {
+	t.Helper()
+
+	app := &application{DB: dbrepo.NewSeededMemoryDBRepo(), auth: testAuth(t)}
+
+	g, err := app.newGraph()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	app.Graph = g
+
+	return app
+}
+
+// authorizedGraphQL returns a POST request of the GraphQL request, authenticated for a user of the role.
+func authorizedGraphQL(t *testing.T, app *application, role string, request graph.Request) *http.Request {
+	t.Helper()
+
+	tokens, err := app.auth.generateTokenPair(&jwtUser{ID: 1, Role: role})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	body, _ := json.Marshal(request)
+
+	req := postGraphQL("application/json", string(body))
+	req.Header.Set("Authorization", "Bearer "+tokens.AccessToken)
+
+	return req
+}
+
+// serveGraphQL serves a request and decodes its GraphQL response.
+func serveGraphQL(t *testing.T, app *application, req *http.Request) (*httptest.ResponseRecorder, graphQLResponse) {
+	t.Helper()
+
+	rr := httptest.NewRecorder()
+	app.routes().ServeHTTP(rr, req)
+
+	var response graphQLResponse
+
+	err := json.Unmarshal(rr.Body.Bytes(), &response)
+	if err != nil {
+		t.Fatalf("%s %s = %d %s", req.Method, req.URL, rr.Code, rr.Body)
+	}
+
+	return rr, response
+}
+
+// postGraphQL returns a POST request of the body with the given content type.
+func postGraphQL(contentType, body string) *http.Request {
+	req := httptest.NewRequest(http.MethodPost, "/graph", strings.NewReader(body))
+	req.Header.Set("Content-Type", contentType)
+	return req
+}
+
+// title returns the title of the movie in a field of the data.
+func title(response graphQLResponse, field string) any {
+	movie, _ := response.Data[field].(map[string]any)
+	return movie["title"]
+}
+
+func TestGraphQLRequests(t *testing.T) {
+
+	app := newGraphQLApp(t)
+
+	// POST with a JSON body and variables
+	rr, response := serveGraphQL(t, app, postGraphQL("application/json",
+		`{"query":"query Movie($id: Int) { get(id: $id) { title } }","variables":{"id":1}}`))
+
+	if rr.Code != http.StatusOK || rr.Header().Get("Content-Type") != "application/json; charset=utf-8" || title(response, "get") != "Highlander" {
+		t.Errorf("POST JSON = %d %s %s", rr.Code, rr.Header().Get("Content-Type"), rr.Body)
+	}
+
+	// GET with the query and variables in the query string
+	qs := url.Values{}
+	qs.Set("query", "query Movie($id: Int) { get(id: $id) { title } }")
+	qs.Set("variables", `{"id":2}`)
+
+	rr, response = serveGraphQL(t, app, httptest.NewRequest(http.MethodGet, "/graph?"+qs.Encode(), nil))
+	if rr.Code != http.StatusOK || title(response, "get") != "Raiders of the Lost Ark" {
+		t.Errorf("GET = %d %s", rr.Code, rr.Body)
+	}
+
+	// POST with the bare query
+	rr, response = serveGraphQL(t, app, postGraphQL("application/graphql", `{ get(id: 3) { title } }`))
+	if rr.Code != http.StatusOK || title(response, "get") != "The Godfather" {
+		t.Errorf("POST application/graphql = %d %s", rr.Code, rr.Body)
+	}
+
+	// the operation name selects one of the operations of the document
+	const operations = `query First { get(id: 1) { title } } query Second { get(id: 2) { title } }`
+
+	body, _ := json.Marshal(graph.Request{Query: operations, OperationName: "Second"})
+
+	rr, response = serveGraphQL(t, app, postGraphQL("application/json", string(body)))
+	if rr.Code != http.StatusOK || title(response, "get") != "Raiders of the Lost Ark" {
+		t.Errorf("operation Second = %d %s", rr.Code, rr.Body)
+	}
+
+	// without one the document is ambiguous, which clients of the GraphQL response media type get as a 400
+	body, _ = json.Marshal(graph.Request{Query: operations})
+
+	req := postGraphQL("application/json", string(body))
+	req.Header.Set("Accept", "application/graphql-response+json")
+
+	rr, response = serveGraphQL(t, app, req)
+	if rr.Code != http.StatusBadRequest || response.Data != nil || len(response.Errors) != 1 || rr.Header().Get("Content-Type") != "application/graphql-response+json; charset=utf-8" {
+		t.Errorf("ambiguous operation = %d %s %s", rr.Code, rr.Header().Get("Content-Type"), rr.Body)
+	}
+
+	qs.Set("query", operations)
+	qs.Set("operationName", "First")
+	qs.Del("variables")
+
+	rr, response = serveGraphQL(t, app, httptest.NewRequest(http.MethodGet, "/graph?"+qs.Encode(), nil))
+	if rr.Code != http.StatusOK || title(response, "get") != "Highlander" {
+		t.Errorf("GET operation First = %d %s", rr.Code, rr.Body)
+	}
+}
+
+func TestGraphQLPartialData(t *testing.T) {
+
+	app := newGraphQLApp(t)
+
+	req := postGraphQL("application/json", `{"query":"{ movie: get(id: 1) { title } results: searchMovies(query: \" \") { rank } }"}`)
+	req.Header.Set("Accept", "application/graphql-response+json")
+
+	// the field that failed is null and has an error, the others still have their data
+	rr, response := serveGraphQL(t, app, req)
+
+	if rr.Code != http.StatusOK {
+		t.Errorf("status = %d, want %d with partial data", rr.Code, http.StatusOK)
+	}
+
+	if title(response, "movie") != "Highlander" {
+		t.Errorf("data = %v, want the movie", response.Data)
+	}
+
+	if results, ok := response.Data["results"]; !ok || results != nil {
+		t.Errorf("results = %v, want null", response.Data["results"])
+	}
+
+	if len(response.Errors) != 1 || response.Errors[0].Message != "search query must not be empty" || len(response.Errors[0].Path) != 1 || response.Errors[0].Path[0] != "results" {
+		t.Errorf("errors = %+v, want the error of results", response.Errors)
+	}
+}
+
+func TestGraphQLMutations(t *testing.T) {
+
+	app := newGraphQLApp(t)
+
+	const mutation = `mutation { createGenre(genre: "Western") { genre } }`
+
+	// mutations are only executed for POST requests
+	rr := httptest.NewRecorder()
+	app.routes().ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/graph?query="+url.QueryEscape(mutation), nil))
+
+	if rr.Code != http.StatusMethodNotAllowed || rr.Header().Get("Allow") != http.MethodPost {
+		t.Errorf("GET mutation = %d, Allow %q, want %d", rr.Code, rr.Header().Get("Allow"), http.StatusMethodNotAllowed)
+	}
+
+	body, _ := json.Marshal(graph.Request{Query: mutation})
+
+	_, response := serveGraphQL(t, app, postGraphQL("application/json", string(body)))
+	if len(response.Errors) != 1 || response.Errors[0].Extensions["code"] != graph.CodeUnauthenticated {
+		t.Errorf("anonymous mutation = %+v, want %s", response.Errors, graph.CodeUnauthenticated)
+	}
+
+	_, response = serveGraphQL(t, app, authorizedGraphQL(t, app, models.RoleEditor, graph.Request{Query: mutation}))
+
+	genre, _ := response.Data["createGenre"].(map[string]any)
+	if len(response.Errors) != 0 || genre["genre"] != "Western" {
+		t.Errorf("editor mutation = %+v", response)
+	}
+}
+
+func TestGraphQLInvalidRequests(t *testing.T) {
+
+	app := newGraphQLApp(t)
+
+	tests := []struct {
+		name string
+		req  *http.Request
+	}{
+		{"invalid JSON", postGraphQL("application/json", `{"query":`)},
+		{"no query", postGraphQL("application/json", `{"variables":{"id":1}}`)},
+		{"invalid variables", httptest.NewRequest(http.MethodGet, "/graph?query=%7B+list+%7B+id+%7D+%7D&variables=%5B1%5D", nil)},
+		{"no query string", httptest.NewRequest(http.MethodGet, "/graph", nil)},
+	}
+
+	for _, tt := range tests {
+		rr, response := serveGraphQL(t, app, tt.req)
+		if rr.Code != http.StatusBadRequest || len(response.Errors) != 1 || response.Data != nil {
+			t.Errorf("%s = %d %s, want 400 and an error", tt.name, rr.Code, rr.Body)
+		}
+	}
+
+	// a document that doesn't validate is executed, and reported in the errors without data
+	rr, response := serveGraphQL(t, app, postGraphQL("application/json", `{"query":"{ get(id: 1) { budget } }"}`))
+	if rr.Code != http.StatusOK || response.Data != nil || len(response.Errors) != 1 || !strings.Contains(response.Errors[0].Message, "budget") {
+		t.Errorf("invalid document = %d %s", rr.Code, rr.Body)
+	}
+}
+
+func TestGraphQLCreateMoviePoster(t *testing.T) {
+
+	fake := posterstest.NewTMDB(t, "key")
+	fake.SetPoster("Die Hard", "/die-hard.jpg")
+	fake.SetImage("/die-hard.jpg", testPNG(t, 500, 750))
+
+	app := newGraphQLApp(t)
+	app.Posters = posters.NewTMDB(posters.TMDBConfig{BaseURL: fake.URL, APIKey: fake.APIKey})
+	app.TMDBImageURL = fake.URL
+	app.Images = &images.FSStore{Dir: t.TempDir()}
+
+	_, response := serveGraphQL(t, app, authorizedGraphQL(t, app, models.RoleEditor, graph.Request{
+		Query: `mutation { createMovie(input: {title: "Die Hard", release_date: "1988-07-15", runtime: 132, mpaa_rating: "R"}) { id } }`,
+	}))
+	if len(response.Errors) != 0 {
+		t.Fatalf("errors = %+v", response.Errors)
+	}
+
+	created, _ := response.Data["createMovie"].(map[string]any)
+	id, _ := created["id"].(float64)
+
+	// the poster is looked up in the background, as for movies created through the REST API
+	deadline := time.Now().Add(5 * time.Second)
+
+	for {
+		movie, err := app.DB.OneMovie(context.Background(), int(id))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if movie.Image == "/die-hard.jpg" && movie.Poster != nil {
+			break
+		}
+
+		if time.Now().After(deadline) {
+			t.Fatalf("movie = %+v, want the poster found on TMDB", movie)
+		}
+
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestGraphQLDeleteMoviePoster(t *testing.T) {
+
+	dir := t.TempDir()
+
+	app := newGraphQLApp(t)
+	app.Images = &images.FSStore{Dir: dir}
+
+	_, err := app.savePoster(context.Background(), 1, "upload", testPNG(t, 400, 600), true)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if stored := storedFiles(t, dir); stored == 0 {
+		t.Fatal("the poster wasn't stored")
+	}
+
+	_, response := serveGraphQL(t, app, authorizedGraphQL(t, app, models.RoleAdmin, graph.Request{Query: `mutation { deleteMovie(id: 1) }`}))
+	if len(response.Errors) != 0 || response.Data["deleteMovie"] != true {
+		t.Fatalf("deleting = %+v", response)
+	}
+
+	// every size of the poster is deleted in the background, as for movies deleted through the REST API
+	deadline := time.Now().Add(5 * time.Second)
+
+	for storedFiles(t, dir) != 0 {
+		if time.Now().After(deadline) {
+			t.Fatalf("%d poster files left in the store", storedFiles(t, dir))
+		}
+
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// storedFiles returns the number of files under dir.
+func storedFiles(t *testing.T, dir string) int {
+	t.Helper()
+
+	var n int
+
+	err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err == nil && !d.IsDir() {
+			n++
+		}
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return n
+}
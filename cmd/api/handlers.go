@@ -1,18 +1,13 @@
 package main
 
 import (
-	"encoding/json"
+	"context"
+	"database/sql"
 	"errors"
-	"fmt"
-	"github.com/calvarado2004/go-movies-backend/internal/graph"
 	"github.com/calvarado2004/go-movies-backend/internal/models"
+	"github.com/calvarado2004/go-movies-backend/internal/repository"
 	"github.com/go-chi/chi/v5"
-	"github.com/golang-jwt/jwt/v4"
-	"io"
-	"io/ioutil"
-	"log"
 	"net/http"
-	"net/url"
 	"strconv"
 	"strings"
 	"time"
@@ -33,33 +28,120 @@ func (app *application) Home(w http.ResponseWriter, r *http.Request) {
 
 	err := app.writeJSON(w, http.StatusOK, payload, nil)
 	if err != nil {
-		fmt.Println(err)
+		app.logger(r.Context()).Error("writing the response failed", "error", err)
 		return
 	}
 
 }
 
-// AllMovies is a simple handler function which writes a response.
+// AllMovies is a simple handler function which writes a page of movies.
 func (app *application) AllMovies(w http.ResponseWriter, r *http.Request) {
+	app.listMovies(w, r)
+}
+
+// listMovies writes the page of movies selected by the query string, restricted to the given genre if any.
+func (app *application) listMovies(w http.ResponseWriter, r *http.Request, genre ...int) {
+
+	query, err := app.readMovieQuery(r)
+	if err != nil {
+		err := app.errorJSON(w, r, err)
+		if err != nil {
+			return
+		}
+		return
+	}
+
+	// a genre taken from the route replaces any genre filter in the query string
+	if len(genre) > 0 {
+		query.Genres = genre
+	}
 
-	// get all movies from the database
-	movies, err := app.DB.AllMovies()
+	// get the requested page of movies from the database
+	movies, total, err := app.DB.ListMovies(r.Context(), query)
 	if err != nil {
-		err := app.errorJSON(w, err)
+		err := app.errorJSON(w, r, err)
 		if err != nil {
 			return
 		}
 		return
 	}
 
-	err = app.writeJSON(w, http.StatusOK, movies, nil)
+	err = app.writeJSON(w, http.StatusOK, newMoviesPage(r, query, movies, total), nil)
 	if err != nil {
-		fmt.Println(err)
+		app.logger(r.Context()).Error("writing the response failed", "error", err)
 		return
 	}
 
 }
 
+// searchMovies is a handler function which writes the movies matching a full-text search.
+func (app *application) searchMovies(w http.ResponseWriter, r *http.Request) {
+
+	qs := r.URL.Query()
+
+	search := repository.MovieSearch{
+		Query: qs.Get("q"),
+	}
+
+	var err error
+
+	search.Genres, err = readGenreIDs(qs)
+	if err != nil {
+		err := app.errorJSON(w, r, err)
+		if err != nil {
+			return
+		}
+		return
+	}
+
+	if rating := qs.Get("rating"); rating != "" {
+		search.MPAARatings = strings.Split(rating, ",")
+	}
+
+	if limit := qs.Get("limit"); limit != "" {
+		search.Limit, err = strconv.Atoi(limit)
+		if err != nil {
+			err := app.errorJSON(w, r, errors.New("limit must be an integer"))
+			if err != nil {
+				return
+			}
+			return
+		}
+	}
+
+	err = search.Normalize()
+	if err != nil {
+		err := app.errorJSON(w, r, err)
+		if err != nil {
+			return
+		}
+		return
+	}
+
+	results, err := app.DB.SearchMovies(r.Context(), search)
+	if err != nil {
+		err := app.errorJSON(w, r, err)
+		if err != nil {
+			return
+		}
+		return
+	}
+
+	var payload = struct {
+		Query   string                      `json:"query"`
+		Results []*models.MovieSearchResult `json:"results"`
+	}{
+		Query:   search.Query,
+		Results: results,
+	}
+
+	err = app.writeJSON(w, http.StatusOK, payload, nil)
+	if err != nil {
+		app.logger(r.Context()).Error("writing the response failed", "error", err)
+		return
+	}
+}
+
 // authenticate is a simple handler function which writes a response.
 func (app *application) authenticate(w http.ResponseWriter, r *http.Request) {
 	// read json payload
@@ -71,43 +153,126 @@ func (app *application) authenticate(w http.ResponseWriter, r *http.Request) {
 
 	err := app.readJSON(w, r, &requestPayload)
 	if err != nil {
-		err := app.errorJSON(w, err, http.StatusBadRequest)
+		err := app.errorJSON(w, r, err, http.StatusBadRequest)
 		if err != nil {
 			return
 		}
 		return
 	}
 
-	// validate payload, user exists, password matches
-	user, err := app.DB.GetUserByEmail(requestPayload.Email)
+	// refuse attempts over the rate limits or locked out after too many failures
+	subjects := app.loginSubjects(r, requestPayload.Email)
+
+	retryAfter, err := app.checkLogin(r.Context(), subjects)
 	if err != nil {
-		err := app.errorJSON(w, errors.New("invalid credentials"), http.StatusBadRequest)
+		err := app.errorJSON(w, r, err, http.StatusInternalServerError)
 		if err != nil {
 			return
 		}
 		return
 	}
 
-	// check password
-	valid, err := user.PasswordMatches(requestPayload.Password)
+	if retryAfter > 0 {
+		app.tooManyLogins(w, r, retryAfter)
+		return
+	}
+
+	// validate payload, user exists, password matches
+	user, err := app.DB.GetUserByEmail(r.Context(), requestPayload.Email)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		err := app.errorJSON(w, r, err, http.StatusInternalServerError)
+		if err != nil {
+			return
+		}
+		return
+	}
+
+	// check password, taking as long for unknown addresses so that they can't be told apart
+	valid := false
+	if err == nil {
+		valid, err = user.PasswordMatches(requestPayload.Password)
+	} else {
+		models.ComparePasswordWithoutUser(requestPayload.Password)
+	}
+
 	if err != nil || !valid {
-		err := app.errorJSON(w, errors.New("invalid credentials"), http.StatusBadRequest)
+		err := app.recordLoginFailure(r.Context(), subjects)
+		if err != nil {
+			app.logger(r.Context()).Error("recording the failed login failed", "error", err)
+		}
+
+		err = app.errorJSON(w, r, errors.New("invalid credentials"), http.StatusBadRequest)
 		if err != nil {
 			return
 		}
 		return
 	}
 
+	// hashes of another cost would make the response time of registered addresses stand out
+	if user.PasswordNeedsRehash() {
+		app.rehashPassword(r.Context(), user, requestPayload.Password)
+	}
+
+	// only verified users can sign in
+	if !user.IsEmailVerified() {
+		err := app.errorJSON(w, r, errors.New("email address is not verified"), http.StatusForbidden)
+		if err != nil {
+			return
+		}
+		return
+	}
+
+	// users with two-factor authentication get a challenge to answer with a code instead of tokens
+	enrollment, err := app.DB.GetUserTOTP(r.Context(), user.ID)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		err := app.errorJSON(w, r, err, http.StatusInternalServerError)
+		if err != nil {
+			return
+		}
+		return
+	}
+
+	if err == nil && enrollment.IsConfirmed() {
+		app.challengeSecondFactor(w, r, user)
+		return
+	}
+
+	app.completeLogin(w, r, user)
+}
+
+// rehashPassword stores a new hash of the password of the user, made with the current cost.
+func (app *application) rehashPassword(ctx context.Context, user models.User, password string) {
+
+	err := user.SetPassword(password)
+	if err == nil {
+		err = app.DB.UpdateUserPassword(ctx, user.ID, user.Password)
+	}
+	if err != nil {
+		app.logger(ctx).Error("rehashing the password failed", "user_id", user.ID, "error", err)
+	}
+}
+
+// completeLogin forgives the failed logins of the account of an authenticated user, starts a new session and
+// writes its token pair, setting the refresh token cookie.
+func (app *application) completeLogin(w http.ResponseWriter, r *http.Request, user models.User) {
+
+	_, err := app.DB.ClearLoginFailures(r.Context(), models.LoginSubjectAccount, accountSubject(user.Email))
+	if err != nil {
+		app.logger(r.Context()).Error("clearing the failed logins failed", "user_id", user.ID, "error", err)
+	}
+
 	// generate token pair
 	u := jwtUser{
 		ID:        user.ID,
 		FirstName: user.FirstName,
 		LastName:  user.LastName,
+		Role:      user.Role,
 	}
 
-	tokens, err := app.auth.generateTokenPair(&u)
+	// a successful login starts a new refresh token family
+	tokens, err := app.issueTokenPair(r.Context(), &u, "")
 	if err != nil {
-		err := app.errorJSON(w, err)
+		err := app.errorJSON(w, r, err)
 		if err != nil {
 			return
 		}
@@ -121,88 +286,188 @@ func (app *application) authenticate(w http.ResponseWriter, r *http.Request) {
 	// write json response
 	err = app.writeJSON(w, http.StatusAccepted, tokens, nil)
 	if err != nil {
+		app.logger(r.Context()).Error("writing the response failed", "error", err)
 		return
 	}
 
 }
 
-// refreshToken is a simple handler function which writes a response.
+// refreshToken rotates the refresh token in the cookie and writes a new token pair. Presenting a refresh token
+// that was already rotated is treated as token theft, and revokes every token of its family.
 func (app *application) refreshToken(w http.ResponseWriter, r *http.Request) {
-	for _, cookie := range r.Cookies() {
-		if cookie.Name == app.auth.CookieName {
 
-			claims := &tokenClaims{}
-			refreshToken := cookie.Value
+	cookie, err := r.Cookie(app.auth.CookieName)
+	if err != nil {
+		err := app.errorJSON(w, r, errors.New("missing refresh token"), http.StatusUnauthorized)
+		if err != nil {
+			return
+		}
+		return
+	}
 
-			_, err := jwt.ParseWithClaims(refreshToken, claims, func(token *jwt.Token) (any, error) {
-				return []byte(app.auth.Secret), nil
-			})
-			if err != nil {
-				err := app.errorJSON(w, errors.New("invalid token"), http.StatusUnauthorized)
-				if err != nil {
-					return
-				}
-				return
-			}
+	claims, err := app.auth.parseRefreshToken(cookie.Value)
+	if err != nil {
+		err := app.errorJSON(w, r, errors.New("invalid token"), http.StatusUnauthorized)
+		if err != nil {
+			return
+		}
+		return
+	}
 
-			// get user id from token claims
-			userID, err := strconv.Atoi(claims.Subject)
-			if err != nil {
-				err := app.errorJSON(w, errors.New("unknown user"), http.StatusUnauthorized)
-				if err != nil {
-					return
-				}
-				return
-			}
+	// look up the stored token
+	stored, err := app.DB.GetRefreshTokenByHash(r.Context(), hashToken(cookie.Value))
+	if err != nil {
+		err := app.errorJSON(w, r, errors.New("invalid token"), http.StatusUnauthorized)
+		if err != nil {
+			return
+		}
+		return
+	}
 
-			// get user from database
-			user, err := app.DB.GetUserByID(userID)
-			if err != nil {
-				err := app.errorJSON(w, errors.New("unknown user"), http.StatusUnauthorized)
-				if err != nil {
-					return
-				}
-				return
-			}
+	// a token that was already rotated is being replayed, revoke the whole session
+	if stored.IsRevoked() {
+		app.revokeRefreshTokenFamily(r.Context(), stored)
 
-			// generate token pair
-			u := jwtUser{
-				ID:        user.ID,
-				FirstName: user.FirstName,
-				LastName:  user.LastName,
-			}
+		err := app.errorJSON(w, r, errors.New("invalid token"), http.StatusUnauthorized)
+		if err != nil {
+			return
+		}
+		return
+	}
 
-			tokenPairs, err := app.auth.generateTokenPair(&u)
-			if err != nil {
-				err := app.errorJSON(w, errors.New("error generating token pair"), http.StatusUnauthorized)
-				if err != nil {
-					return
-				}
-				return
+	if stored.IsExpired() {
+		err := app.errorJSON(w, r, errors.New("token is expired"), http.StatusUnauthorized)
+		if err != nil {
+			return
+		}
+		return
+	}
 
-			}
+	// get user id from token claims
+	userID, err := strconv.Atoi(claims.Subject)
+	if err != nil || userID != stored.UserID {
+		err := app.errorJSON(w, r, errors.New("unknown user"), http.StatusUnauthorized)
+		if err != nil {
+			return
+		}
+		return
+	}
 
-			refreshCookie := app.auth.getRefreshCookie(tokenPairs.RefreshToken)
-			http.SetCookie(w, refreshCookie)
+	// get user from database
+	user, err := app.DB.GetUserByID(r.Context(), userID)
+	if err != nil {
+		err := app.errorJSON(w, r, errors.New("unknown user"), http.StatusUnauthorized)
+		if err != nil {
+			return
+		}
+		return
+	}
 
-			// write json response
-			err = app.writeJSON(w, http.StatusOK, tokenPairs, nil)
-			if err != nil {
-				return
+	// generate token pair
+	u := jwtUser{
+		ID:        user.ID,
+		FirstName: user.FirstName,
+		LastName:  user.LastName,
+		Role:      user.Role,
+	}
 
-			}
+	tokenPairs, refreshToken, err := app.newTokenPair(&u, stored.FamilyID)
+	if err != nil {
+		err := app.errorJSON(w, r, errors.New("error generating token pair"), http.StatusInternalServerError)
+		if err != nil {
+			return
+		}
+		return
+	}
+
+	// rotate the token, so that the presented token stays valid for a retry unless its successor is stored
+	err = app.DB.WithTx(r.Context(), func(repo repository.DatabaseRepo) error {
+		rotated, err := repo.RevokeRefreshToken(r.Context(), stored.ID)
+		if err != nil {
+			return err
+		}
+
+		// losing the race to a concurrent refresh also counts as reuse
+		if !rotated {
+			return errRefreshTokenReused
+		}
+
+		return repo.InsertRefreshToken(r.Context(), refreshToken)
+	})
+	if errors.Is(err, errRefreshTokenReused) {
+		app.revokeRefreshTokenFamily(r.Context(), stored)
 
+		err := app.errorJSON(w, r, errors.New("invalid token"), http.StatusUnauthorized)
+		if err != nil {
+			return
+		}
+		return
+	}
+	if err != nil {
+		err := app.errorJSON(w, r, err, http.StatusInternalServerError)
+		if err != nil {
+			return
 		}
+		return
+	}
+
+	refreshCookie := app.auth.getRefreshCookie(tokenPairs.RefreshToken)
+	http.SetCookie(w, refreshCookie)
+
+	// write json response
+	err = app.writeJSON(w, http.StatusOK, tokenPairs, nil)
+	if err != nil {
+		app.logger(r.Context()).Error("writing the response failed", "error", err)
+		return
+
 	}
+
 }
 
-// logout is a simple handler function which writes a response.
+// revokeRefreshTokenFamily revokes every refresh token of the family the given token belongs to.
+func (app *application) revokeRefreshTokenFamily(ctx context.Context, token models.RefreshToken) {
+	app.logger(ctx).Warn("refresh token reuse detected, revoking the token family",
+		"user_id", token.UserID, "family_id", token.FamilyID)
+
+	err := app.DB.RevokeRefreshTokenFamily(ctx, token.FamilyID)
+	if err != nil {
+		app.logger(ctx).Error("revoking the refresh token family failed", "family_id", token.FamilyID, "error", err)
+	}
+}
+
+// logout revokes the current session and clears the refresh token cookie.
 func (app *application) logout(w http.ResponseWriter, r *http.Request) {
+
+	cookie, err := r.Cookie(app.auth.CookieName)
+	if err == nil {
+		stored, err := app.DB.GetRefreshTokenByHash(r.Context(), hashToken(cookie.Value))
+		if err == nil {
+			err = app.DB.RevokeRefreshTokenFamily(r.Context(), stored.FamilyID)
+			if err != nil {
+				app.logger(r.Context()).Error("revoking the refresh token family failed", "family_id", stored.FamilyID, "error", err)
+			}
+		}
+	}
+
 	http.SetCookie(w, app.auth.getExpiredRefreshCookie())
 	w.WriteHeader(http.StatusAccepted)
 }
 
-// getTokenFromHeaderAndVerify is a simple handler function which writes a response.
+// jwks handler publishes the public keys verifying the JWTs, so that other services can verify them.
+func (app *application) jwks(w http.ResponseWriter, r *http.Request) {
+
+	// verifiers may cache the keys for a while, new keys are published before they start signing
+	w.Header().Set("Cache-Control", "public, max-age=300")
+
+	err := app.writeJSON(w, http.StatusOK, app.auth.Keys.JWKS(time.Now()), nil)
+	if err != nil {
+		app.logger(r.Context()).Error("writing the response failed", "error", err)
+		return
+	}
+}
+
+// getTokenFromHeaderAndVerify reads the access token of the Authorization header and verifies it. The
+// errors are the token errors of auth.go.
 func (j *Auth) getTokenFromHeaderAndVerify(w http.ResponseWriter, r *http.Request) (string, *tokenClaims, error) {
 	w.Header().Add("Vary", "Authorization")
 
@@ -210,62 +475,32 @@ func (j *Auth) getTokenFromHeaderAndVerify(w http.ResponseWriter, r *http.Reques
 	authHeader := r.Header.Get("Authorization")
 
 	if authHeader == "" {
-		return "", nil, errors.New("missing authorization header")
+		return "", nil, errMissingToken
 	}
 
 	token := strings.Split(authHeader, " ")
 
 	if len(token) != 2 {
-		return "", nil, errors.New("invalid authorization header")
+		return "", nil, errMalformedHeader
 	}
 
 	if token[0] != "Bearer" {
-		return "", nil, errors.New("invalid authorization header")
+		return "", nil, errMalformedHeader
 	}
 
 	// verify token
-	claims := &tokenClaims{}
-
-	_, err := jwt.ParseWithClaims(token[1], claims, func(token *jwt.Token) (any, error) {
-		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
-			return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
-		}
-		return []byte(j.Secret), nil
-	})
+	claims, err := j.verifyToken(token[1], accessTokenType, j.Audience)
 	if err != nil {
-		if strings.HasPrefix(err.Error(), "token is expired") {
-			return "", nil, errors.New("token is expired")
-		}
-
 		return "", nil, err
 	}
 
-	if claims.Issuer != j.Issuer {
-		return "", nil, errors.New("invalid issuer")
-	}
-
 	return token[1], claims, nil
 
 }
 
-// movieCatalog is a simple handler function which writes a response.
+// movieCatalog is a simple handler function which writes a page of the movie catalog.
 func (app *application) movieCatalog(w http.ResponseWriter, r *http.Request) {
-
-	// get all movies from the database
-	movies, err := app.DB.AllMovies()
-	if err != nil {
-		err := app.errorJSON(w, err)
-		if err != nil {
-			return
-		}
-		return
-	}
-
-	err = app.writeJSON(w, http.StatusOK, movies, nil)
-	if err != nil {
-		fmt.Println(err)
-		return
-	}
+	app.listMovies(w, r)
 }
 
 // getMovie is a simple handler function which writes a response to retrieve a movie.
@@ -274,16 +509,16 @@ func (app *application) getMovie(w http.ResponseWriter, r *http.Request) {
 
 	movieID, err := strconv.Atoi(id)
 	if err != nil {
-		err := app.errorJSON(w, errors.New("invalid id parameter"), http.StatusBadRequest)
+		err := app.errorJSON(w, r, errors.New("invalid id parameter"), http.StatusBadRequest)
 		if err != nil {
 			return
 		}
 		return
 	}
 
-	movie, err := app.DB.OneMovie(movieID)
+	movie, err := app.DB.OneMovie(r.Context(), movieID)
 	if err != nil {
-		err := app.errorJSON(w, err)
+		err := app.errorJSON(w, r, err)
 		if err != nil {
 			return
 		}
@@ -292,6 +527,7 @@ func (app *application) getMovie(w http.ResponseWriter, r *http.Request) {
 
 	err = app.writeJSON(w, http.StatusOK, movie, nil)
 	if err != nil {
+		app.logger(r.Context()).Error("writing the response failed", "error", err)
 		return
 	}
 
@@ -303,16 +539,16 @@ func (app *application) movieForEdit(w http.ResponseWriter, r *http.Request) {
 
 	movieID, err := strconv.Atoi(id)
 	if err != nil {
-		err := app.errorJSON(w, errors.New("invalid id parameter"), http.StatusBadRequest)
+		err := app.errorJSON(w, r, errors.New("invalid id parameter"), http.StatusBadRequest)
 		if err != nil {
 			return
 		}
 		return
 	}
 
-	movie, genres, err := app.DB.OneMovieForEdit(movieID)
+	movie, genres, err := app.DB.OneMovieForEdit(r.Context(), movieID)
 	if err != nil {
-		err := app.errorJSON(w, err)
+		err := app.errorJSON(w, r, err)
 		if err != nil {
 			return
 		}
@@ -329,6 +565,7 @@ func (app *application) movieForEdit(w http.ResponseWriter, r *http.Request) {
 
 	err = app.writeJSON(w, http.StatusOK, payload, nil)
 	if err != nil {
+		app.logger(r.Context()).Error("writing the response failed", "error", err)
 		return
 	}
 }
@@ -336,9 +573,9 @@ func (app *application) movieForEdit(w http.ResponseWriter, r *http.Request) {
 // allGenres is a simple handler function which writes a response to retrieve all genres.
 func (app *application) allGenres(w http.ResponseWriter, r *http.Request) {
 
-	genres, err := app.DB.AllGenresDB()
+	genres, err := app.DB.AllGenresDB(r.Context())
 	if err != nil {
-		err := app.errorJSON(w, err)
+		err := app.errorJSON(w, r, err)
 		if err != nil {
 			return
 		}
@@ -347,6 +584,7 @@ func (app *application) allGenres(w http.ResponseWriter, r *http.Request) {
 
 	err = app.writeJSON(w, http.StatusOK, genres, nil)
 	if err != nil {
+		app.logger(r.Context()).Error("writing the response failed", "error", err)
 		return
 	}
 }
@@ -358,38 +596,42 @@ func (app *application) insertMovie(w http.ResponseWriter, r *http.Request) {
 
 	err := app.readJSON(w, r, &movie)
 	if err != nil {
-		err := app.errorJSON(w, err)
+		err := app.errorJSON(w, r, err)
 		if err != nil {
 			return
 		}
 		return
 	}
 
-	// try to grab an image
-	movie = app.getPoster(movie)
-
 	movie.CreatedAt = time.Now()
 	movie.UpdatedAt = time.Now()
 
-	newID, err := app.DB.InsertMovie(movie)
-	if err != nil {
-		err := app.errorJSON(w, err)
+	// the movie and its genres are stored together, or not at all
+	var newID int
+
+	err = app.DB.WithTx(r.Context(), func(repo repository.DatabaseRepo) error {
+
+		newID, err = repo.InsertMovie(r.Context(), movie)
 		if err != nil {
-			return
+			return err
 		}
-		return
-	}
 
-	// now handle genres
-	err = app.DB.UpdateMovieGenres(newID, movie.GenresArray)
+		return repo.UpdateMovieGenres(r.Context(), newID, movie.GenresArray)
+	})
 	if err != nil {
-		err := app.errorJSON(w, err)
+		err := app.errorJSON(w, r, err)
 		if err != nil {
 			return
 		}
 		return
 	}
 
+	user, _ := userFromContext(r.Context())
+	app.logger(r.Context()).Info("movie inserted", "movie_id", newID, "user_id", user.ID, "user_name", user.Name)
+
+	movie.ID = newID
+	app.movieCreated(r.Context(), movie)
+
 	response := JSONResponse{
 		Error:   false,
 		Message: "Movie inserted successfully",
@@ -397,71 +639,12 @@ func (app *application) insertMovie(w http.ResponseWriter, r *http.Request) {
 
 	err = app.writeJSON(w, http.StatusAccepted, response, nil)
 	if err != nil {
+		app.logger(r.Context()).Error("writing the response failed", "error", err)
 		return
 	}
 
 }
 
-// getPoster gets the poster from the movie db api and returns the movie
-func (app *application) getPoster(movie models.Movie) models.Movie {
-
-	type TheMovieDB struct {
-		Page    int `json:"page"`
-		Results []struct {
-			PosterPath string `json:"poster_path"`
-		} `json:"results"`
-		TotalPages int `json:"total_pages"`
-	}
-
-	// get poster from the movie db
-	// https://api.themoviedb.org/3/search/movie?api_key=b2225620f919fd84111a706e2dc5d872&query=Die+Hard
-	client := &http.Client{}
-
-	theURL := fmt.Sprintf("https://api.themoviedb.org/3/search/movie?api_key=%s", app.APIKey)
-
-	req, err := http.NewRequest("GET", theURL+"&query="+url.QueryEscape(movie.Title), nil)
-	if err != nil {
-		log.Println("movie not found on the movie db", err)
-		return movie
-	}
-
-	req.Header.Add("Accept", "application/json")
-	req.Header.Add("Content-Type", "application/json")
-
-	resp, err := client.Do(req)
-	if err != nil {
-		log.Println("movie not found on the movie db", err)
-		return movie
-	}
-
-	defer func(Body io.ReadCloser) {
-		err := Body.Close()
-		if err != nil {
-			log.Println("error closing body", err)
-		}
-	}(resp.Body)
-
-	bodyBytes, err := io.ReadAll(resp.Body)
-	if err != nil {
-		log.Println("error reading body", err)
-		return movie
-	}
-
-	var responseMovie TheMovieDB
-
-	err = json.Unmarshal(bodyBytes, &responseMovie)
-	if err != nil {
-		log.Println("error unmarshalling json", err)
-		return movie
-	}
-
-	if len(responseMovie.Results) > 0 {
-		movie.Image = responseMovie.Results[0].PosterPath
-	}
-
-	return movie
-}
-
 // updateMovie handler to update a movie
 func (app *application) updateMovie(w http.ResponseWriter, r *http.Request) {
 
@@ -469,16 +652,16 @@ func (app *application) updateMovie(w http.ResponseWriter, r *http.Request) {
 
 	err := app.readJSON(w, r, &payload)
 	if err != nil {
-		err := app.errorJSON(w, err)
+		err := app.errorJSON(w, r, err)
 		if err != nil {
 			return
 		}
 		return
 	}
 
-	movie, err := app.DB.OneMovie(payload.ID)
+	movie, err := app.DB.OneMovie(r.Context(), payload.ID)
 	if err != nil {
-		err := app.errorJSON(w, err)
+		err := app.errorJSON(w, r, err)
 		if err != nil {
 			return
 		}
@@ -492,24 +675,31 @@ func (app *application) updateMovie(w http.ResponseWriter, r *http.Request) {
 	movie.MPAARating = payload.MPAARating
 	movie.UpdatedAt = time.Now()
 
-	err = app.DB.UpdateMovie(*movie)
-	if err != nil {
-		err := app.errorJSON(w, err)
+	// clients that don't send an image keep the current one
+	if payload.Image != "" {
+		movie.Image = payload.Image
+	}
+
+	err = app.DB.WithTx(r.Context(), func(repo repository.DatabaseRepo) error {
+
+		err := repo.UpdateMovie(r.Context(), *movie)
 		if err != nil {
-			return
+			return err
 		}
-		return
-	}
 
-	err = app.DB.UpdateMovieGenres(movie.ID, payload.GenresArray)
+		return repo.UpdateMovieGenres(r.Context(), movie.ID, payload.GenresArray)
+	})
 	if err != nil {
-		err := app.errorJSON(w, err)
+		err := app.errorJSON(w, r, err)
 		if err != nil {
 			return
 		}
 		return
 	}
 
+	user, _ := userFromContext(r.Context())
+	app.logger(r.Context()).Info("movie updated", "movie_id", movie.ID, "user_id", user.ID, "user_name", user.Name)
+
 	response := JSONResponse{
 		Error:   false,
 		Message: "movie updated successfully",
@@ -517,6 +707,7 @@ func (app *application) updateMovie(w http.ResponseWriter, r *http.Request) {
 
 	err = app.writeJSON(w, http.StatusAccepted, response, nil)
 	if err != nil {
+		app.logger(r.Context()).Error("writing the response failed", "error", err)
 		return
 	}
 
@@ -527,22 +718,25 @@ func (app *application) deleteMovie(w http.ResponseWriter, r *http.Request) {
 
 	id, err := strconv.Atoi(chi.URLParam(r, "id"))
 	if err != nil {
-		err := app.errorJSON(w, err)
+		err := app.errorJSON(w, r, err)
 		if err != nil {
 			return
 		}
 		return
 	}
 
-	err = app.DB.DeleteMovie(id)
+	err = app.deleteMovieAndPoster(r.Context(), id)
 	if err != nil {
-		err := app.errorJSON(w, err)
+		err := app.errorJSON(w, r, err)
 		if err != nil {
 			return
 		}
 		return
 	}
 
+	user, _ := userFromContext(r.Context())
+	app.logger(r.Context()).Info("movie deleted", "movie_id", id, "user_id", user.ID, "user_name", user.Name)
+
 	response := JSONResponse{
 		Error:   false,
 		Message: "movie deleted successfully",
@@ -550,6 +744,7 @@ func (app *application) deleteMovie(w http.ResponseWriter, r *http.Request) {
 
 	err = app.writeJSON(w, http.StatusAccepted, response, nil)
 	if err != nil {
+		app.logger(r.Context()).Error("writing the response failed", "error", err)
 		return
 	}
 
@@ -560,69 +755,13 @@ func (app *application) AllMoviesByGenre(w http.ResponseWriter, r *http.Request)
 
 	id, err := strconv.Atoi(chi.URLParam(r, "id"))
 	if err != nil {
-		err := app.errorJSON(w, err)
-		if err != nil {
-			return
-		}
-		return
-	}
-
-	movies, err := app.DB.AllMovies(id)
-	if err != nil {
-		err := app.errorJSON(w, err)
-		if err != nil {
-			return
-		}
-		return
-	}
-
-	err = app.writeJSON(w, http.StatusOK, movies, nil)
-	if err != nil {
-		return
-	}
-
-}
-
-// moviesGraphQL handler to get all movies by genre
-func (app *application) moviesGraphQL(w http.ResponseWriter, r *http.Request) {
-
-	// populate graphql type with movies
-	movies, err := app.DB.AllMovies()
-	if err != nil {
-		err := app.errorJSON(w, err)
-		if err != nil {
-			return
-		}
-		return
-	}
-
-	// get query from request
-	q, err := ioutil.ReadAll(r.Body)
-	if err != nil {
-		err := app.errorJSON(w, err)
-		if err != nil {
-			return
-		}
-		return
-	}
-
-	query := string(q)
-
-	// create a variable of type *graph.Graph
-	g := graph.NewGraph(movies)
-	g.QueryString = query
-
-	// execute query
-	resp, err := g.Query()
-	if err != nil {
-		err := app.errorJSON(w, err)
+		err := app.errorJSON(w, r, err)
 		if err != nil {
 			return
 		}
 		return
 	}
 
-	// write response
-	err = app.writeJSON(w, http.StatusOK, resp, nil)
+	app.listMovies(w, r, id)
 
 }
@@ -0,0 +1,278 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/calvarado2004/go-movies-backend/internal/images"
+	"github.com/calvarado2004/go-movies-backend/internal/models"
+	"github.com/go-chi/chi/v5"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+// savePoster resizes a poster and stores every size in the image store under a new version, then records it
+// for the movie, pointing the image of the movie to it when setImage is true. The poster it replaces is
+// deleted from the image store once the movie no longer refers to it.
+func (app *application) savePoster(ctx context.Context, movieID int, source string, data []byte, setImage bool) (models.MoviePoster, error) {
+
+	processed, err := images.Process(data)
+	if err != nil {
+		return models.MoviePoster{}, err
+	}
+
+	poster := models.MoviePoster{
+		MovieID:       movieID,
+		Version:       strconv.FormatInt(time.Now().UnixNano(), 36),
+		Source:        source,
+		DominantColor: processed.DominantColor,
+		Placeholder:   processed.Placeholder,
+		Width:         processed.Width,
+		Height:        processed.Height,
+		CreatedAt:     time.Now(),
+		UpdatedAt:     time.Now(),
+	}
+
+	for _, size := range images.Sizes {
+		err := app.Images.Put(ctx, images.PosterKey(movieID, poster.Version, size.Name), processed.Images[size.Name], "image/jpeg")
+		if err != nil {
+			app.deletePosterImages(poster)
+			return models.MoviePoster{}, err
+		}
+	}
+
+	image := ""
+	if setImage {
+		image = posterURL(poster, "full")
+	}
+
+	replaced, err := app.DB.ReplaceMoviePoster(ctx, poster, image)
+	if err != nil {
+		app.deletePosterImages(poster)
+		return models.MoviePoster{}, err
+	}
+
+	if replaced != nil && replaced.Version != poster.Version {
+		go app.deletePosterImages(*replaced)
+	}
+
+	return poster, nil
+}
+
+// posterURL returns the URL a size of a poster is served from.
+func posterURL(poster models.MoviePoster, size string) string {
+	return fmt.Sprintf("/images/%d/%s?v=%s", poster.MovieID, size, url.QueryEscape(poster.Version))
+}
+
+// deletePosterImages removes every size of a poster from the image store.
+func (app *application) deletePosterImages(poster models.MoviePoster) {
+
+	ctx, cancel := context.WithTimeout(context.Background(), posterTimeout)
+	defer cancel()
+
+	for _, size := range images.Sizes {
+		err := app.Images.Delete(ctx, images.PosterKey(poster.MovieID, poster.Version, size.Name))
+		if err != nil {
+			app.logger(ctx).Error("deleting the poster failed", "movie_id", poster.MovieID, "size", size.Name, "error", err)
+		}
+	}
+}
+
+// deleteMovieAndPoster deletes a movie, then the images of its poster in the background.
+func (app *application) deleteMovieAndPoster(ctx context.Context, id int) error {
+
+	poster, posterErr := app.DB.GetMoviePoster(ctx, id)
+
+	err := app.DB.DeleteMovie(ctx, id)
+	if err != nil {
+		return err
+	}
+
+	if posterErr == nil {
+		go app.deletePosterImages(poster)
+	}
+
+	return nil
+}
+
+// maxUploadBytes bounds a poster upload, leaving room for the multipart envelope around the image.
+const maxUploadBytes = images.MaxBytes + 1<<20
+
+// uploadFormats are the content types of the images accepted by uploadMovieImage.
+var uploadFormats = map[string]bool{
+	"image/jpeg": true,
+	"image/png":  true,
+	"image/gif":  true,
+	"image/webp": true,
+}
+
+// uploadMovieImage handler replaces the poster of a movie with the image in the image field of a multipart
+// form. The format is sniffed from the content rather than trusted from the client, and the image is stored
+// as a JPEG in every poster size.
+func (app *application) uploadMovieImage(w http.ResponseWriter, r *http.Request) {
+
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		err := app.errorJSON(w, r, err)
+		if err != nil {
+			return
+		}
+		return
+	}
+
+	_, err = app.DB.OneMovie(r.Context(), id)
+	if err != nil {
+		err := app.errorJSON(w, r, errors.New("movie not found"), http.StatusNotFound)
+		if err != nil {
+			return
+		}
+		return
+	}
+
+	r.Body = http.MaxBytesReader(w, r.Body, maxUploadBytes)
+
+	file, _, err := r.FormFile("image")
+	if err != nil {
+		status := http.StatusBadRequest
+
+		var maxBytesErr *http.MaxBytesError
+		if errors.As(err, &maxBytesErr) {
+			status = http.StatusRequestEntityTooLarge
+		}
+
+		err := app.errorJSON(w, r, fmt.Errorf("the image field of a multipart form is required: %w", err), status)
+		if err != nil {
+			return
+		}
+		return
+	}
+
+	defer func(file io.Closer) {
+		err := file.Close()
+		if err != nil {
+			return
+		}
+	}(file)
+
+	data, err := io.ReadAll(io.LimitReader(file, images.MaxBytes+1))
+	if err != nil {
+		err := app.errorJSON(w, r, err)
+		if err != nil {
+			return
+		}
+		return
+	}
+
+	if len(data) > images.MaxBytes {
+		err := app.errorJSON(w, r, fmt.Errorf("the image must not be larger than %d bytes", images.MaxBytes), http.StatusRequestEntityTooLarge)
+		if err != nil {
+			return
+		}
+		return
+	}
+
+	contentType := http.DetectContentType(data)
+	if !uploadFormats[contentType] {
+		err := app.errorJSON(w, r, fmt.Errorf("unsupported image type %s, use JPEG, PNG, GIF or WebP", contentType), http.StatusUnsupportedMediaType)
+		if err != nil {
+			return
+		}
+		return
+	}
+
+	poster, err := app.savePoster(r.Context(), id, "upload", data, true)
+	if errors.Is(err, images.ErrUnsupported) {
+		err := app.errorJSON(w, r, err, http.StatusUnprocessableEntity)
+		if err != nil {
+			return
+		}
+		return
+	}
+	if err != nil {
+		err := app.errorJSON(w, r, err, http.StatusInternalServerError)
+		if err != nil {
+			return
+		}
+		return
+	}
+
+	var payload = struct {
+		Image  string             `json:"image"`
+		Poster models.MoviePoster `json:"poster"`
+	}{
+		Image:  posterURL(poster, "full"),
+		Poster: poster,
+	}
+
+	err = app.writeJSON(w, http.StatusOK, payload, nil)
+	if err != nil {
+		app.logger(r.Context()).Error("writing the response failed", "error", err)
+		return
+	}
+}
+
+// movieImage handler serves a size of the poster of a movie. Requests carrying a version in the v query
+// parameter, which changes whenever the poster does, are cached for good.
+func (app *application) movieImage(w http.ResponseWriter, r *http.Request) {
+
+	movieID, err := strconv.Atoi(chi.URLParam(r, "movieID"))
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+
+	size, ok := images.SizeByName(chi.URLParam(r, "size"))
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+
+	poster, err := app.DB.GetMoviePoster(r.Context(), movieID)
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+
+	etag := fmt.Sprintf(`"%d-%s-%s-%d"`, movieID, poster.Version, size.Name, poster.UpdatedAt.Unix())
+
+	w.Header().Set("ETag", etag)
+
+	if r.URL.Query().Get("v") != "" {
+		w.Header().Set("Cache-Control", "public, max-age=31536000, immutable")
+	} else {
+		w.Header().Set("Cache-Control", "public, max-age=86400")
+	}
+
+	if r.Header.Get("If-None-Match") == etag {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+
+	blob, contentType, err := app.Images.Get(r.Context(), images.PosterKey(movieID, poster.Version, size.Name))
+	if errors.Is(err, images.ErrNotFound) {
+		http.NotFound(w, r)
+		return
+	}
+	if err != nil {
+		app.logError(r, http.StatusInternalServerError, err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	defer func(blob io.ReadCloser) {
+		err := blob.Close()
+		if err != nil {
+			return
+		}
+	}(blob)
+
+	w.Header().Set("Content-Type", contentType)
+
+	_, err = io.Copy(w, blob)
+	if err != nil {
+		return
+	}
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/calvarado2004/go-movies-backend/internal/images"
+	"github.com/calvarado2004/go-movies-backend/internal/models"
+	"github.com/calvarado2004/go-movies-backend/internal/repository/dbrepo"
+	"github.com/go-chi/chi/v5"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestUploadMovieImage(t *testing.T) {
+
+	app := application{
+		DB:     dbrepo.NewMemoryDBRepo(),
+		Images: &images.FSStore{Dir: t.TempDir()},
+	}
+
+	id, err := app.DB.InsertMovie(context.Background(), models.Movie{Title: "Alien", ReleaseDate: time.Now(), MPAARating: "R"})
+	if err != nil {
+		t.Fatalf("InsertMovie: %v", err)
+	}
+
+	// the admin routes require a token, the handler is mounted on its own to skip authentication
+	mux := chi.NewRouter()
+	mux.Post("/admin/movies/{id}/image", app.uploadMovieImage)
+
+	upload := func(movieID int, field string, data []byte) *httptest.ResponseRecorder {
+		var body bytes.Buffer
+
+		form := multipart.NewWriter(&body)
+		part, err := form.CreateFormFile(field, "poster")
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, _ = part.Write(data)
+		_ = form.Close()
+
+		req := httptest.NewRequest(http.MethodPost, fmt.Sprintf("/admin/movies/%d/image", movieID), &body)
+		req.Header.Set("Content-Type", form.FormDataContentType())
+
+		rr := httptest.NewRecorder()
+		mux.ServeHTTP(rr, req)
+
+		return rr
+	}
+
+	tests := []struct {
+		name       string
+		movieID    int
+		field      string
+		data       []byte
+		wantStatus int
+	}{
+		{"png", id, "image", testPNG(t, 400, 600), http.StatusOK},
+		{"replacement", id, "image", testPNG(t, 200, 300), http.StatusOK},
+		{"missing movie", id + 1, "image", testPNG(t, 200, 300), http.StatusNotFound},
+		{"missing field", id, "file", testPNG(t, 200, 300), http.StatusBadRequest},
+		{"not an image", id, "image", []byte("just some text"), http.StatusUnsupportedMediaType},
+		{"corrupt image", id, "image", testPNG(t, 200, 300)[:100], http.StatusUnprocessableEntity},
+	}
+
+	for _, test := range tests {
+		rr := upload(test.movieID, test.field, test.data)
+
+		if rr.Code != test.wantStatus {
+			t.Errorf("%s: status = %d, want %d: %s", test.name, rr.Code, test.wantStatus, rr.Body)
+		}
+	}
+
+	// the movie points at the last image uploaded
+	movie, err := app.DB.OneMovie(context.Background(), id)
+	if err != nil {
+		t.Fatalf("OneMovie: %v", err)
+	}
+
+	if movie.Poster == nil || movie.Poster.Width != 200 || movie.Image != posterURL(*movie.Poster, "full") {
+		t.Fatalf("movie = %+v, poster = %+v", movie, movie.Poster)
+	}
+
+	rr := httptest.NewRecorder()
+	app.routes().ServeHTTP(rr, httptest.NewRequest(http.MethodGet, movie.Image, nil))
+
+	if rr.Code != http.StatusOK || rr.Header().Get("Content-Type") != "image/jpeg" {
+		t.Errorf("GET %s = %d %v", movie.Image, rr.Code, rr.Header())
+	}
+
+	var payload struct {
+		Image  string             `json:"image"`
+		Poster models.MoviePoster `json:"poster"`
+	}
+
+	rr = upload(id, "image", testPNG(t, 100, 150))
+
+	err = json.NewDecoder(rr.Body).Decode(&payload)
+	if err != nil {
+		t.Fatalf("decoding the response: %v", err)
+	}
+
+	if payload.Poster.Version == movie.Poster.Version || payload.Image != posterURL(payload.Poster, "full") {
+		t.Errorf("response = %+v, previous poster = %+v", payload, movie.Poster)
+	}
+}
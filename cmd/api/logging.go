@@ -0,0 +1,178 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/chi/v5/middleware"
+	"io"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// requestIDHeader carries the id of a request, from the client or a proxy in front of the API and back.
+const requestIDHeader = "X-Request-ID"
+
+// maxRequestIDLength bounds the request ids accepted from clients, longer ones are replaced.
+const maxRequestIDLength = 128
+
+// requestIDKey is the context key of the id of a request.
+type requestIDKey struct{}
+
+// accessLogKey is the context key of the accessLog of a request.
+type accessLogKey struct{}
+
+// accessLog holds what handlers learn about a request that the access log records, such as its user.
+type accessLog struct {
+	userID string
+}
+
+// newLogger returns a logger writing JSON lines of the given level or above to w.
+func newLogger(w io.Writer, level string) (*slog.Logger, error) {
+
+	var l slog.Level
+
+	err := l.UnmarshalText([]byte(level))
+	if err != nil {
+		return nil, fmt.Errorf("invalid log level %q", level)
+	}
+
+	return slog.New(slog.NewJSONHandler(w, &slog.HandlerOptions{Level: l})), nil
+}
+
+// logger returns the logger of the application, with the id of the request ctx belongs to, if any.
+func (app *application) logger(ctx context.Context) *slog.Logger {
+
+	logger := app.Logger
+	if logger == nil {
+		logger = slog.Default()
+	}
+
+	if id := requestID(ctx); id != "" {
+		logger = logger.With("request_id", id)
+	}
+
+	return logger
+}
+
+// requestID returns the id of the request ctx belongs to.
+func requestID(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey{}).(string)
+	return id
+}
+
+// assignRequestID is a middleware function that gives every request an id, sent back in the X-Request-ID
+// header. The id sent by the client is kept when it is a reasonable one.
+func (app *application) assignRequestID(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+
+		id := r.Header.Get(requestIDHeader)
+		if !validRequestID(id) {
+			id = newRequestID()
+		}
+
+		w.Header().Set(requestIDHeader, id)
+
+		ctx := context.WithValue(r.Context(), requestIDKey{}, id)
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// validRequestID reports whether a request id sent by a client can be logged as is.
+func validRequestID(id string) bool {
+
+	if id == "" || len(id) > maxRequestIDLength {
+		return false
+	}
+
+	for _, c := range id {
+		if c < '!' || c > '~' {
+			return false
+		}
+	}
+
+	return true
+}
+
+// newRequestID returns a random request id.
+func newRequestID() string {
+
+	b := make([]byte, 16)
+
+	_, err := rand.Read(b)
+	if err != nil {
+		return fmt.Sprintf("%x", time.Now().UnixNano())
+	}
+
+	return hex.EncodeToString(b)
+}
+
+// logRequests is a middleware function that writes an access log line for every request once it has been
+// served, with the route pattern it matched rather than its path.
+func (app *application) logRequests(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+
+		start := time.Now()
+
+		entry := &accessLog{}
+		ctx := context.WithValue(r.Context(), accessLogKey{}, entry)
+
+		ww := middleware.NewWrapResponseWriter(w, r.ProtoMajor)
+
+		next.ServeHTTP(ww, r.WithContext(ctx))
+
+		route := ""
+		if rctx := chi.RouteContext(r.Context()); rctx != nil {
+			route = rctx.RoutePattern()
+		}
+
+		status := ww.Status()
+		if status == 0 {
+			status = http.StatusOK
+		}
+
+		level := slog.LevelInfo
+		if status >= http.StatusInternalServerError {
+			level = slog.LevelError
+		}
+
+		app.logger(r.Context()).LogAttrs(r.Context(), level, "request",
+			slog.String("method", r.Method),
+			slog.String("route", route),
+			slog.String("path", r.URL.Path),
+			slog.Int("status", status),
+			slog.Int("bytes", ww.BytesWritten()),
+			slog.Duration("latency", time.Since(start)),
+			slog.String("user_id", entry.userID),
+			slog.String("remote_addr", r.RemoteAddr),
+		)
+	})
+}
+
+// setLogUser records the user a request is made for in its access log line.
+func setLogUser(ctx context.Context, userID string) {
+	if entry, ok := ctx.Value(accessLogKey{}).(*accessLog); ok {
+		entry.userID = userID
+	}
+}
+
+// logError logs an error met while serving a request. Server errors are logged as errors, client errors,
+// which only need attention when they pile up, at the info level.
+func (app *application) logError(r *http.Request, status int, err error) {
+
+	level := slog.LevelInfo
+	if status >= http.StatusInternalServerError {
+		level = slog.LevelError
+	}
+
+	app.logger(r.Context()).LogAttrs(r.Context(), level, "request failed",
+		slog.String("method", r.Method),
+		slog.String("path", r.URL.Path),
+		slog.Int("status", status),
+		slog.String("error", strings.TrimSpace(err.Error())),
+	)
+}
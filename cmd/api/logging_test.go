@@ -0,0 +1,127 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"github.com/calvarado2004/go-movies-backend/internal/models"
+	"github.com/calvarado2004/go-movies-backend/internal/repository/dbrepo"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestRequestLogging(t *testing.T) {
+
+	var buf bytes.Buffer
+
+	logger, err := newLogger(&buf, "debug")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	app := application{
+		DB:     dbrepo.NewSeededMemoryDBRepo(),
+		Logger: logger,
+		auth:   testAuth(t),
+	}
+
+	tokens, err := app.auth.generateTokenPair(&jwtUser{ID: 7, FirstName: "Ada", LastName: "Lovelace", Role: models.RoleAdmin})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// lines returns the log lines written while serving req, decoded
+	lines := func(req *http.Request) (*httptest.ResponseRecorder, []map[string]any) {
+		buf.Reset()
+
+		rr := httptest.NewRecorder()
+		app.routes().ServeHTTP(rr, req)
+
+		var entries []map[string]any
+		dec := json.NewDecoder(&buf)
+		for dec.More() {
+			var entry map[string]any
+			err := dec.Decode(&entry)
+			if err != nil {
+				t.Fatalf("log line is not JSON: %v", err)
+			}
+			entries = append(entries, entry)
+		}
+
+		return rr, entries
+	}
+
+	// access returns the access log line
+	access := func(entries []map[string]any) map[string]any {
+		for _, entry := range entries {
+			if entry["msg"] == "request" {
+				return entry
+			}
+		}
+		t.Fatalf("no access log line in %v", entries)
+		return nil
+	}
+
+	t.Run("authenticated", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodGet, "/admin/movies/1", nil)
+		req.Header.Set("Authorization", "Bearer "+tokens.AccessToken)
+		req.Header.Set(requestIDHeader, "client-id-1")
+
+		rr, entries := lines(req)
+
+		if got := rr.Header().Get(requestIDHeader); got != "client-id-1" {
+			t.Errorf("X-Request-ID = %q, want the one sent by the client", got)
+		}
+
+		entry := access(entries)
+
+		want := map[string]any{
+			"method":     http.MethodGet,
+			"route":      "/admin/movies/{id}",
+			"path":       "/admin/movies/1",
+			"status":     float64(http.StatusOK),
+			"user_id":    "7",
+			"request_id": "client-id-1",
+		}
+		for key, value := range want {
+			if entry[key] != value {
+				t.Errorf("%s = %v, want %v", key, entry[key], value)
+			}
+		}
+
+		if entry["bytes"].(float64) == 0 {
+			t.Error("bytes = 0, want the size of the body")
+		}
+	})
+
+	t.Run("error", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodGet, "/movies/x", nil)
+		req.Header.Set(requestIDHeader, "bad id\n")
+
+		rr, entries := lines(req)
+
+		id := rr.Header().Get(requestIDHeader)
+		if len(id) != 32 {
+			t.Fatalf("X-Request-ID = %q, want a generated id", id)
+		}
+
+		// the error and the access log line share the request id
+		var failed bool
+		for _, entry := range entries {
+			if entry["request_id"] != id {
+				t.Errorf("%v logged without the request id %s", entry["msg"], id)
+			}
+			if entry["msg"] == "request failed" {
+				failed = true
+			}
+		}
+
+		if !failed {
+			t.Errorf("the error was not logged: %v", entries)
+		}
+
+		if entry := access(entries); entry["status"] != float64(http.StatusBadRequest) || entry["user_id"] != "" {
+			t.Errorf("access log = %v, want an anonymous 400", entry)
+		}
+	})
+}
@@ -0,0 +1,369 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"github.com/calvarado2004/go-movies-backend/internal/models"
+	"github.com/calvarado2004/go-movies-backend/internal/ratelimit"
+	"github.com/go-chi/chi/v5"
+	"math"
+	"net"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// loginFailureWindow is how long failed logins are remembered without a new failure.
+const loginFailureWindow = 24 * time.Hour
+
+// maxLoginDelay caps the delay failures impose between login attempts before the lockout.
+const maxLoginDelay = time.Minute
+
+// errTooManyLogins is returned to login attempts refused by the rate limits or a lockout.
+var errTooManyLogins = errors.New("too many login attempts, try again later")
+
+// loginPolicy decides how long the logins of a subject are refused after it failed.
+type loginPolicy struct {
+	// MaxFailures is the number of failures locking the subject out for Lockout. Half as many are free, each
+	// one after them doubles the delay before the next attempt, starting at a second.
+	MaxFailures int
+	Lockout     time.Duration
+}
+
+// delay returns how long logins are refused after the given number of failures.
+func (p loginPolicy) delay(failures int) time.Duration {
+
+	free := p.MaxFailures / 2
+
+	switch {
+	case p.MaxFailures <= 0 || failures <= free:
+		return 0
+	case failures >= p.MaxFailures:
+		return p.Lockout
+	}
+
+	// shifting further would overflow long before reaching the cap
+	shift := failures - free - 1
+	if shift > 10 {
+		return min(maxLoginDelay, p.Lockout)
+	}
+
+	return min(time.Second<<shift, maxLoginDelay, p.Lockout)
+}
+
+// loginThrottle protects the logins against password guessing. Attempts are rate limited per account and per
+// client IP address, and their failures, stored in the database, delay then lock out the next attempts.
+type loginThrottle struct {
+	Account     loginPolicy
+	IP          loginPolicy
+	AccountRate *ratelimit.Limiter
+	IPRate      *ratelimit.Limiter
+}
+
+// loginSubject is an account or a client IP address whose login attempts are counted.
+type loginSubject struct {
+	Kind    string
+	Subject string
+}
+
+// policy returns the policy of a kind of subject.
+func (t loginThrottle) policy(kind string) loginPolicy {
+	if kind == models.LoginSubjectIP {
+		return t.IP
+	}
+	return t.Account
+}
+
+// limiter returns the rate limiter of a kind of subject.
+func (t loginThrottle) limiter(kind string) *ratelimit.Limiter {
+	if kind == models.LoginSubjectIP {
+		return t.IPRate
+	}
+	return t.AccountRate
+}
+
+// loginSubjects returns the subjects a login attempt with the given email address counts against. Accounts are
+// identified by their email address whether they exist or not, so that unknown addresses behave the same.
+func (app *application) loginSubjects(r *http.Request, email string) []loginSubject {
+	return []loginSubject{
+		{Kind: models.LoginSubjectIP, Subject: ipSubject(app.clientIP(r))},
+		{Kind: models.LoginSubjectAccount, Subject: accountSubject(email)},
+	}
+}
+
+// accountSubject returns the subject counting the logins of an email address.
+func accountSubject(email string) string {
+	return strings.ToLower(strings.TrimSpace(email))
+}
+
+// checkLogin returns how long a login attempt of the subjects must wait, because of the rate limits or of a
+// lockout, 0 when it may proceed.
+func (app *application) checkLogin(ctx context.Context, subjects []loginSubject) (time.Duration, error) {
+
+	keys := make([]ratelimit.Key, 0, len(subjects))
+	for _, s := range subjects {
+		keys = append(keys, ratelimit.Key{Limiter: app.logins.limiter(s.Kind), Key: s.Subject})
+	}
+
+	// a token is taken from the limit of every subject or from none, so that attempts refused for one subject
+	// don't use up the budget of the others
+	ok, wait := ratelimit.AllowAll(keys...)
+	if !ok {
+		return wait, nil
+	}
+
+	now := time.Now().UTC()
+
+	var retryAfter time.Duration
+
+	for _, s := range subjects {
+		failures, err := app.DB.GetLoginFailures(ctx, s.Kind, s.Subject)
+		if errors.Is(err, sql.ErrNoRows) {
+			continue
+		}
+		if err != nil {
+			return 0, err
+		}
+
+		retryAfter = max(retryAfter, failures.RetryAfter(now))
+	}
+
+	return retryAfter, nil
+}
+
+// recordLoginFailure counts a failed login against the subjects and locks their logins as their policy says.
+func (app *application) recordLoginFailure(ctx context.Context, subjects []loginSubject) error {
+
+	now := time.Now().UTC()
+
+	for _, s := range subjects {
+		failures, err := app.DB.RecordLoginFailure(ctx, s.Kind, s.Subject, now, loginFailureWindow)
+		if err != nil {
+			return err
+		}
+
+		policy := app.logins.policy(s.Kind)
+
+		delay := policy.delay(failures.Failures)
+		if delay <= 0 {
+			continue
+		}
+
+		err = app.DB.LockLogins(ctx, s.Kind, s.Subject, now.Add(delay))
+		if err != nil {
+			return err
+		}
+
+		if failures.Failures >= policy.MaxFailures {
+			app.logger(ctx).Warn("logins locked out",
+				"kind", s.Kind, "subject", s.Subject, "failures", failures.Failures, "locked_until", now.Add(delay))
+		}
+	}
+
+	return nil
+}
+
+// tooManyLogins refuses a login attempt, telling the client when to retry.
+func (app *application) tooManyLogins(w http.ResponseWriter, r *http.Request, retryAfter time.Duration) {
+
+	w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(retryAfter.Seconds()))))
+
+	err := app.errorJSON(w, r, errTooManyLogins, http.StatusTooManyRequests)
+	if err != nil {
+		return
+	}
+}
+
+// clientIP returns the address of the client of the request. Behind trusted proxies, it is the last address of
+// the X-Forwarded-For header that isn't one of them; clients can prepend anything to the header.
+func (app *application) clientIP(r *http.Request) netip.Addr {
+
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+
+	addr, err := netip.ParseAddr(host)
+	if err != nil || !app.trustedProxy(addr) {
+		return addr
+	}
+
+	forwarded := strings.Split(strings.Join(r.Header.Values("X-Forwarded-For"), ","), ",")
+
+	for i := len(forwarded) - 1; i >= 0; i-- {
+		hop, err := netip.ParseAddr(strings.TrimSpace(forwarded[i]))
+		if err != nil {
+			break
+		}
+
+		addr = hop
+		if !app.trustedProxy(hop) {
+			break
+		}
+	}
+
+	return addr
+}
+
+// trustedProxy reports whether addr is one of the trusted proxies.
+func (app *application) trustedProxy(addr netip.Addr) bool {
+
+	addr = addr.Unmap()
+
+	for _, prefix := range app.trustedProxies {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// ipSubject returns the subject counting the logins of a client address. IPv6 clients usually own a whole /64,
+// so it is counted as one.
+func ipSubject(addr netip.Addr) string {
+
+	if !addr.IsValid() {
+		return "unknown"
+	}
+
+	addr = addr.Unmap()
+	if addr.Is4() {
+		return addr.String()
+	}
+
+	prefix, err := addr.Prefix(64)
+	if err != nil {
+		return addr.String()
+	}
+
+	return prefix.String()
+}
+
+// parseTrustedProxies parses the addresses and CIDR ranges of trusted proxies.
+func parseTrustedProxies(proxies []string) ([]netip.Prefix, error) {
+
+	prefixes := make([]netip.Prefix, 0, len(proxies))
+
+	for _, proxy := range proxies {
+		prefix, err := netip.ParsePrefix(proxy)
+		if err != nil {
+			addr, addrErr := netip.ParseAddr(proxy)
+			if addrErr != nil {
+				return nil, fmt.Errorf("invalid trusted proxy %q, want an address or a CIDR range", proxy)
+			}
+			prefix = netip.PrefixFrom(addr.Unmap(), addr.Unmap().BitLen())
+		}
+
+		prefixes = append(prefixes, prefix.Masked())
+	}
+
+	return prefixes, nil
+}
+
+// loginLockouts lists the accounts and client IP addresses whose logins are locked.
+func (app *application) loginLockouts(w http.ResponseWriter, r *http.Request) {
+
+	lockouts, err := app.DB.ListLoginLockouts(r.Context(), time.Now().UTC())
+	if err != nil {
+		err := app.errorJSON(w, r, err, http.StatusInternalServerError)
+		if err != nil {
+			return
+		}
+		return
+	}
+
+	if lockouts == nil {
+		lockouts = []*models.LoginFailures{}
+	}
+
+	err = app.writeJSON(w, http.StatusOK, lockouts, nil)
+	if err != nil {
+		app.logger(r.Context()).Error("writing the response failed", "error", err)
+		return
+	}
+}
+
+// clearLoginLockout forgets the failed logins of an account or a client IP address, lifting its lockout.
+func (app *application) clearLoginLockout(w http.ResponseWriter, r *http.Request) {
+
+	kind := chi.URLParam(r, "kind")
+	if kind != models.LoginSubjectAccount && kind != models.LoginSubjectIP {
+		err := app.errorJSON(w, r, fmt.Errorf("unknown lockout kind %q, want %s or %s", kind, models.LoginSubjectAccount, models.LoginSubjectIP), http.StatusNotFound)
+		if err != nil {
+			return
+		}
+		return
+	}
+
+	// IPv6 ranges contain a slash, which clients escape
+	subject, err := url.PathUnescape(chi.URLParam(r, "subject"))
+	if err != nil {
+		err := app.errorJSON(w, r, err)
+		if err != nil {
+			return
+		}
+		return
+	}
+
+	cleared, err := app.DB.ClearLoginFailures(r.Context(), kind, subject)
+	if err != nil {
+		err := app.errorJSON(w, r, err, http.StatusInternalServerError)
+		if err != nil {
+			return
+		}
+		return
+	}
+
+	if !cleared {
+		err := app.errorJSON(w, r, errors.New("no failed logins for this subject"), http.StatusNotFound)
+		if err != nil {
+			return
+		}
+		return
+	}
+
+	user, _ := userFromContext(r.Context())
+	app.logger(r.Context()).Info("login lockout cleared", "kind", kind, "subject", subject, "user_id", user.ID)
+
+	response := JSONResponse{
+		Error:   false,
+		Message: "login lockout cleared",
+	}
+
+	err = app.writeJSON(w, http.StatusOK, response, nil)
+	if err != nil {
+		app.logger(r.Context()).Error("writing the response failed", "error", err)
+		return
+	}
+}
+
+// cleanupLoginFailures periodically deletes the failed logins that are no longer remembered until the done
+// channel is closed.
+func (app *application) cleanupLoginFailures(interval time.Duration, done <-chan struct{}) {
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			deleted, err := app.DB.DeleteStaleLoginFailures(context.Background(), time.Now().UTC().Add(-loginFailureWindow))
+			if err != nil {
+				app.logger(context.Background()).Error("deleting stale login failures failed", "error", err)
+				continue
+			}
+
+			if deleted > 0 {
+				app.logger(context.Background()).Info("deleted stale login failures", "count", deleted)
+			}
+		case <-done:
+			return
+		}
+	}
+}
@@ -0,0 +1,330 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/calvarado2004/go-movies-backend/internal/models"
+	"github.com/calvarado2004/go-movies-backend/internal/ratelimit"
+	"github.com/calvarado2004/go-movies-backend/internal/repository/dbrepo"
+	"golang.org/x/crypto/bcrypt"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestLoginPolicyDelay(t *testing.T) {
+
+	policy := loginPolicy{MaxFailures: 10, Lockout: 15 * time.Minute}
+
+	tests := []struct {
+		failures int
+		want     time.Duration
+	}{
+		{1, 0},
+		{5, 0},
+		{6, time.Second},
+		{7, 2 * time.Second},
+		{9, 8 * time.Second},
+		{10, 15 * time.Minute},
+		{50, 15 * time.Minute},
+	}
+
+	for _, tt := range tests {
+		if got := policy.delay(tt.failures); got != tt.want {
+			t.Errorf("delay(%d) = %v, want %v", tt.failures, got, tt.want)
+		}
+	}
+
+	// the delays of large policies are capped
+	policy = loginPolicy{MaxFailures: 100, Lockout: 15 * time.Minute}
+
+	if got := policy.delay(99); got != maxLoginDelay {
+		t.Errorf("delay(99) = %v, want %v", got, maxLoginDelay)
+	}
+}
+
+func TestClientIP(t *testing.T) {
+
+	proxies, err := parseTrustedProxies([]string{"10.0.0.0/8", "192.0.2.1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	app := application{trustedProxies: proxies}
+
+	tests := []struct {
+		remote    string
+		forwarded string
+		want      string
+	}{
+		{"198.51.100.7:1234", "", "198.51.100.7"},
+		// untrusted clients can't pretend to be someone else
+		{"198.51.100.7:1234", "203.0.113.9", "198.51.100.7"},
+		{"10.1.2.3:1234", "203.0.113.9", "203.0.113.9"},
+		// the address prepended by the client is ignored, the one appended by the proxy is used
+		{"10.1.2.3:1234", "203.0.113.9, 198.51.100.7, 192.0.2.1", "198.51.100.7"},
+		{"10.1.2.3:1234", "garbage, 198.51.100.7", "198.51.100.7"},
+	}
+
+	for _, tt := range tests {
+		r := httptest.NewRequest(http.MethodPost, "/authenticate", nil)
+		r.RemoteAddr = tt.remote
+		if tt.forwarded != "" {
+			r.Header.Set("X-Forwarded-For", tt.forwarded)
+		}
+
+		if got := app.clientIP(r).String(); got != tt.want {
+			t.Errorf("clientIP(%s, %q) = %s, want %s", tt.remote, tt.forwarded, got, tt.want)
+		}
+	}
+
+	if got := ipSubject(netip.MustParseAddr("2001:db8::1")); got != "2001:db8::/64" {
+		t.Errorf("ipSubject of an IPv6 address = %s, want its /64", got)
+	}
+
+	_, err = parseTrustedProxies([]string{"proxy.example.com"})
+	if err == nil {
+		t.Error("a hostname is accepted as trusted proxy")
+	}
+}
+
+func TestLoginLockout(t *testing.T) {
+
+	repo := dbrepo.NewSeededMemoryDBRepo()
+
+	hash, err := bcrypt.GenerateFromPassword([]byte("correct horse"), bcrypt.MinCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	verified := time.Now().UTC()
+
+	_, err = repo.InsertUser(context.Background(), models.User{
+		FirstName:       "Grace",
+		LastName:        "Hopper",
+		Email:           "grace@example.com",
+		Password:        string(hash),
+		Role:            models.RoleViewer,
+		EmailVerifiedAt: &verified,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	app := application{
+		DB:   repo,
+		auth: testAuth(t),
+		logins: loginThrottle{
+			Account: loginPolicy{MaxFailures: 4, Lockout: 15 * time.Minute},
+			IP:      loginPolicy{MaxFailures: 100, Lockout: 15 * time.Minute},
+		},
+	}
+
+	admin, err := app.auth.generateTokenPair(&jwtUser{ID: 1, Role: models.RoleAdmin})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	serve := func(method, target, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, target, strings.NewReader(body))
+		req.Header.Set("Authorization", "Bearer "+admin.AccessToken)
+		rr := httptest.NewRecorder()
+		app.routes().ServeHTTP(rr, req)
+		return rr
+	}
+
+	login := func(email, password string) *httptest.ResponseRecorder {
+		return serve(http.MethodPost, "/authenticate", `{"email":"`+email+`","password":"`+password+`"}`)
+	}
+
+	// known and unknown addresses fail the same way, and are locked out the same way
+	for _, email := range []string{"grace@example.com", "nobody@example.com"} {
+		for i := 1; i <= 3; i++ {
+			rr := login(email, "wrong")
+			if rr.Code != http.StatusBadRequest || !strings.Contains(rr.Body.String(), "invalid credentials") {
+				t.Fatalf("failure %d of %s = %d %s, want 400 invalid credentials", i, email, rr.Code, rr.Body)
+			}
+		}
+
+		// the third failure delays the next attempt, even with the right password
+		rr := login(strings.ToUpper(email), "correct horse")
+		if rr.Code != http.StatusTooManyRequests || rr.Header().Get("Retry-After") != "1" {
+			t.Errorf("attempt of %s after 3 failures = %d, Retry-After %q, want 429 after 1s", email, rr.Code, rr.Header().Get("Retry-After"))
+		}
+	}
+
+	// the fourth failure, made once the delay is over, locks the account out
+	err = app.recordLoginFailure(context.Background(), []loginSubject{{Kind: models.LoginSubjectAccount, Subject: "grace@example.com"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rr := login("grace@example.com", "correct horse")
+	if retryAfter := rr.Header().Get("Retry-After"); rr.Code != http.StatusTooManyRequests || retryAfter != "900" {
+		t.Fatalf("attempt of a locked account = %d, Retry-After %q, want 429 after 900s", rr.Code, retryAfter)
+	}
+
+	rr = serve(http.MethodGet, "/admin/lockouts", "")
+
+	var lockouts []models.LoginFailures
+
+	err = json.Unmarshal(rr.Body.Bytes(), &lockouts)
+	if err != nil {
+		t.Fatalf("GET /admin/lockouts = %d %s", rr.Code, rr.Body)
+	}
+
+	// the longest lockout is listed first, the delayed unknown address after it
+	if len(lockouts) != 2 || lockouts[0].Subject != "grace@example.com" || lockouts[0].Failures != 4 || lockouts[1].Subject != "nobody@example.com" {
+		t.Errorf("lockouts = %+v, want the accounts of grace and nobody", lockouts)
+	}
+
+	rr = serve(http.MethodDelete, "/admin/lockouts/account/grace@example.com", "")
+	if rr.Code != http.StatusOK {
+		t.Fatalf("clearing the lockout = %d %s", rr.Code, rr.Body)
+	}
+
+	rr = serve(http.MethodDelete, "/admin/lockouts/account/grace@example.com", "")
+	if rr.Code != http.StatusNotFound {
+		t.Errorf("clearing a cleared lockout = %d, want 404", rr.Code)
+	}
+
+	rr = login("grace@example.com", "correct horse")
+	if rr.Code != http.StatusAccepted {
+		t.Fatalf("login after clearing the lockout = %d %s", rr.Code, rr.Body)
+	}
+
+	// the IP address keeps its failures, a successful login only forgives the account
+	failures, err := repo.GetLoginFailures(context.Background(), models.LoginSubjectIP, "192.0.2.1")
+	if err != nil || failures.Failures != 6 {
+		t.Errorf("failures of the IP address = %+v, %v, want 6", failures, err)
+	}
+}
+
+func TestLoginRateLimit(t *testing.T) {
+
+	app := application{
+		DB:   dbrepo.NewSeededMemoryDBRepo(),
+		auth: testAuth(t),
+		logins: loginThrottle{
+			IPRate: ratelimit.New(2, time.Minute),
+		},
+	}
+
+	var codes []int
+
+	for i := 0; i < 3; i++ {
+		req := httptest.NewRequest(http.MethodPost, "/authenticate", strings.NewReader(`{"email":"user`+string(rune('a'+i))+`@example.com","password":"x"}`))
+		rr := httptest.NewRecorder()
+		app.routes().ServeHTTP(rr, req)
+		codes = append(codes, rr.Code)
+	}
+
+	if codes[0] != http.StatusBadRequest || codes[1] != http.StatusBadRequest || codes[2] != http.StatusTooManyRequests {
+		t.Errorf("status codes = %v, want the third attempt from the same address refused", codes)
+	}
+}
+
+func TestLoginRateLimitRefusedAttempts(t *testing.T) {
+
+	app := application{
+		DB:   dbrepo.NewSeededMemoryDBRepo(),
+		auth: testAuth(t),
+		logins: loginThrottle{
+			IPRate:      ratelimit.New(3, time.Minute),
+			AccountRate: ratelimit.New(1, time.Minute),
+		},
+	}
+
+	login := func(email string) int {
+		req := httptest.NewRequest(http.MethodPost, "/authenticate", strings.NewReader(`{"email":"`+email+`","password":"x"}`))
+		rr := httptest.NewRecorder()
+		app.routes().ServeHTTP(rr, req)
+		return rr.Code
+	}
+
+	// the account runs out while its attempts are in flight
+	codes := make([]int, 10)
+
+	var wg sync.WaitGroup
+
+	for i := range codes {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			codes[i] = login("usera@example.com")
+		}(i)
+	}
+
+	wg.Wait()
+
+	var refused int
+	for _, code := range codes {
+		if code == http.StatusTooManyRequests {
+			refused++
+		}
+	}
+
+	if refused != len(codes)-1 {
+		t.Errorf("status codes = %v, want all but one attempt on the same account refused", codes)
+	}
+
+	// the attempts refused for the account didn't use up the tokens of the address
+	if codes := []int{login("userb@example.com"), login("userc@example.com")}; codes[0] != http.StatusBadRequest || codes[1] != http.StatusBadRequest {
+		t.Errorf("status codes of other accounts = %v, want both attempts allowed", codes)
+	}
+
+	if code := login("userd@example.com"); code != http.StatusTooManyRequests {
+		t.Errorf("status code once the address ran out = %d, want %d", code, http.StatusTooManyRequests)
+	}
+}
+
+func TestLoginRehashesPassword(t *testing.T) {
+
+	repo := dbrepo.NewSeededMemoryDBRepo()
+
+	hash, err := bcrypt.GenerateFromPassword([]byte("correct horse"), bcrypt.MinCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	verified := time.Now().UTC()
+
+	id, err := repo.InsertUser(context.Background(), models.User{
+		FirstName:       "Grace",
+		LastName:        "Hopper",
+		Email:           "grace@example.com",
+		Password:        string(hash),
+		Role:            models.RoleViewer,
+		EmailVerifiedAt: &verified,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	app := application{DB: repo, auth: testAuth(t)}
+
+	req := httptest.NewRequest(http.MethodPost, "/authenticate", strings.NewReader(`{"email":"grace@example.com","password":"correct horse"}`))
+	rr := httptest.NewRecorder()
+	app.routes().ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusAccepted {
+		t.Fatalf("login = %d %s", rr.Code, rr.Body)
+	}
+
+	user, err := repo.GetUserByID(context.Background(), id)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if user.PasswordNeedsRehash() {
+		t.Error("the password hash of another cost was kept")
+	}
+
+	if ok, err := user.PasswordMatches("correct horse"); err != nil || !ok {
+		t.Errorf("the new hash doesn't match the password: %v, %v", ok, err)
+	}
+}
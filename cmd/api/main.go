@@ -1,89 +1,319 @@
 package main
 
 import (
+	"context"
 	"database/sql"
+	"errors"
 	"flag"
 	"fmt"
+	"github.com/calvarado2004/go-movies-backend/internal/config"
+	"github.com/calvarado2004/go-movies-backend/internal/graph"
+	"github.com/calvarado2004/go-movies-backend/internal/images"
+	"github.com/calvarado2004/go-movies-backend/internal/keyring"
+	"github.com/calvarado2004/go-movies-backend/internal/mailer"
+	"github.com/calvarado2004/go-movies-backend/internal/metrics"
+	"github.com/calvarado2004/go-movies-backend/internal/posters"
+	"github.com/calvarado2004/go-movies-backend/internal/ratelimit"
 	"github.com/calvarado2004/go-movies-backend/internal/repository"
 	"github.com/calvarado2004/go-movies-backend/internal/repository/dbrepo"
-	"log"
+	"github.com/calvarado2004/go-movies-backend/internal/tracing"
+	"log/slog"
 	"net/http"
+	"net/netip"
 	"os"
+	"sync/atomic"
 	"time"
 )
 
-const port = 8080
-
 type application struct {
-	Domain       string
-	DSN          string
-	DB           repository.DatabaseRepo
-	auth         Auth
-	JWTSecret    string
-	JWTIssuer    string
-	JWTAudience  string
-	CookieDomain string
-	APIKey       string
+	Env            string
+	Port           int
+	Domain         string
+	DSN            string
+	DB             repository.DatabaseRepo
+	DBTimeout      time.Duration
+	RequestTimeout time.Duration
+	DrainDelay     time.Duration
+	draining       atomic.Bool
+	auth           Auth
+	cors           CORS
+	logins         loginThrottle
+	trustedProxies []netip.Prefix
+	JWTIssuer      string
+	JWTAudience    string
+	CookieDomain   string
+	APIKey         string
+	FrontendURL    string
+	Mailer         mailer.Sender
+	Graph          *graph.Graph
+	Posters        posters.Provider
+	TMDBImageURL   string
+	Images         images.Store
+	Metrics        *metrics.Metrics
+	Logger         *slog.Logger
 }
 
 func main() {
 
+	// load the configuration from the config file, the environment and the flags, in that order
+	cfg, args, err := config.Load(os.Args[0], os.Args[1:], os.Stderr, os.LookupEnv)
+	if errors.Is(err, flag.ErrHelp) {
+		return
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+
+	// log JSON lines, the standard logger used by libraries included
+	logger, err := newLogger(os.Stdout, cfg.LogLevel)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+
+	slog.SetDefault(logger)
+
 	// set application config
-	var app application
+	app := application{
+		Env:            cfg.Env,
+		Port:           cfg.Port,
+		Domain:         cfg.Domain,
+		DSN:            cfg.DSN(),
+		DBTimeout:      cfg.DB.Timeout,
+		RequestTimeout: cfg.HTTP.RequestTimeout,
+		DrainDelay:     cfg.HTTP.DrainDelay,
+		JWTIssuer:      cfg.Auth.JWTIssuer,
+		JWTAudience:    cfg.Auth.JWTAudience,
+		CookieDomain:   cfg.Auth.CookieDomain,
+		APIKey:         cfg.TMDB.APIKey,
+		FrontendURL:    cfg.FrontendURL,
+		TMDBImageURL:   cfg.TMDB.ImageURL,
+		Logger:         logger,
+		cors: CORS{
+			AllowedOrigins: cfg.CORS.Origins,
+			AllowedMethods: defaultCORSMethods,
+			AllowedHeaders: defaultCORSHeaders,
+			ExposedHeaders: cfg.CORS.ExposedHeaders,
+			MaxAge:         cfg.CORS.MaxAge,
+		},
+		logins: loginThrottle{
+			Account:     loginPolicy{MaxFailures: cfg.Login.MaxFailures, Lockout: cfg.Login.Lockout},
+			IP:          loginPolicy{MaxFailures: cfg.Login.MaxIPFailures, Lockout: cfg.Login.Lockout},
+			AccountRate: ratelimit.New(cfg.Login.AccountRate, time.Minute),
+			IPRate:      ratelimit.New(cfg.Login.IPRate, time.Minute),
+		},
+	}
+
+	// run a subcommand instead of the server
+	if len(args) > 0 {
+		switch args[0] {
+		case "config":
+			err = runConfig(cfg, args[1:])
+		case "migrate":
+			err = app.runMigrate(args[1:])
+		default:
+			err = fmt.Errorf("unknown command %q, want config or migrate", args[0])
+		}
+		if err != nil {
+			app.fatal(err)
+		}
+		return
+	}
 
-	dbServer := os.Getenv("DB_SERVER")
-	dbPort := os.Getenv("DB_PORT")
-	dbName := os.Getenv("DB_NAME")
-	dbUser := os.Getenv("DB_USER")
-	dbPassword := os.Getenv("DB_PASSWORD")
+	// refuse to start with an invalid or, outside development, insecure configuration
+	err = cfg.Validate()
+	if err != nil {
+		app.fatal(err)
+	}
 
-	dsnVariable := fmt.Sprintf("host=%s port=%s user=%s password=%s dbname=%s sslmode=disable timezone=UTC connect_timeout=5", dbServer, dbPort, dbUser, dbPassword, dbName)
+	err = app.cors.validate()
+	if err != nil {
+		app.fatal(err)
+	}
 
-	apiMoviesKey := os.Getenv("API_MOVIES_KEY")
+	app.trustedProxies, err = parseTrustedProxies(cfg.HTTP.TrustedProxies)
+	if err != nil {
+		app.fatal(err)
+	}
 
-	// read from command line
-	flag.StringVar(&app.DSN, "dsn", dsnVariable, "PostgreSQL DSN")
-	flag.StringVar(&app.JWTSecret, "jwt-secret", "verysecret", "JWT Secret")
-	flag.StringVar(&app.JWTIssuer, "jwt-issuer", "api-golang-movies.apps.okd.calvarado04.com", "JWT Issuer")
-	flag.StringVar(&app.JWTAudience, "jwt-audience", "node-react-movies.apps.okd.calvarado04.com", "JWT Audience")
-	flag.StringVar(&app.CookieDomain, "cookie-domain", "apps.okd.calvarado04.com", "Cookie Domain")
-	flag.StringVar(&app.Domain, "domain", "apps.okd.calvarado04.com", "Domain")
-	flag.StringVar(&app.APIKey, "api-key", apiMoviesKey, "API Key")
+	tracingConfig := tracing.Config{
+		Exporter:    cfg.Tracing.Exporter,
+		Endpoint:    cfg.Tracing.Endpoint,
+		Insecure:    cfg.Tracing.Insecure,
+		SampleRatio: cfg.Tracing.SampleRatio,
+	}
 
-	flag.Parse()
+	tracingConfig.ServiceName = "go-movies-backend"
 
-	// connect to the database
-	conn, err := app.connectToDB()
+	shutdownTracing, err := tracing.Setup(context.Background(), tracingConfig)
 	if err != nil {
-		log.Fatal(err)
+		app.fatal(err)
+	}
+
+	// flush the spans not exported yet on exit
+	defer func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+
+		err := shutdownTracing(ctx)
+		if err != nil {
+			app.Logger.Error("flushing spans failed", "error", err)
+		}
+	}()
+
+	switch cfg.Mail.Sender {
+	case "log":
+		app.Mailer = &mailer.LogSender{}
+	case "file":
+		app.Mailer = &mailer.FileSender{Dir: cfg.Mail.Dir}
+	case "smtp":
+		app.Mailer = &mailer.SMTPSender{
+			Host:     cfg.Mail.SMTP.Host,
+			Port:     cfg.Mail.SMTP.Port,
+			Username: cfg.Mail.SMTP.Username,
+			Password: cfg.Mail.SMTP.Password,
+			From:     cfg.Mail.From,
+		}
+	default:
+		app.fatal(fmt.Errorf("unknown mailer %q", cfg.Mail.Sender))
+	}
+
+	switch cfg.DB.Repo {
+	case "postgres":
+		// connect to the database
+		conn, err := app.connectToDB()
+		if err != nil {
+			app.fatal(err)
+		}
+
+		app.DB = &dbrepo.PostgresDBRepo{DB: conn, Timeout: app.DBTimeout}
+
+		if cfg.DB.Migrate {
+			err = app.migrateUp()
+			if err != nil {
+				app.fatal(err)
+			}
+		}
+
+		defer func(connection *sql.DB) {
+			err := connection.Close()
+			if err != nil {
+				return
+			}
+		}(app.DB.Connection())
+	case "memory":
+		app.Logger.Warn("using the in-memory repository, data will be lost on exit")
+		app.DB = dbrepo.NewSeededMemoryDBRepo()
+	default:
+		app.fatal(fmt.Errorf("unknown repository %q", cfg.DB.Repo))
 	}
 
-	app.DB = &dbrepo.PostgresDBRepo{DB: conn}
+	// expose the pool statistics and time every repository call
+	app.Metrics = metrics.New()
 
-	defer func(connection *sql.DB) {
-		err := connection.Close()
+	if conn := app.DB.Connection(); conn != nil {
+		err := app.Metrics.RegisterDB("movies", conn)
 		if err != nil {
-			return
+			app.fatal(err)
+		}
+	}
+
+	app.DB = tracing.Repository(app.Metrics.Repository(app.DB))
+
+	switch cfg.Images.Store {
+	case "fs":
+		app.Images = &images.FSStore{Dir: cfg.Images.Dir}
+	case "s3":
+		app.Images = &images.S3Store{
+			Endpoint:  cfg.Images.S3.Endpoint,
+			Region:    cfg.Images.S3.Region,
+			Bucket:    cfg.Images.S3.Bucket,
+			AccessKey: cfg.Images.S3.AccessKey,
+			SecretKey: cfg.Images.S3.SecretKey,
+			Client:    &http.Client{Transport: tracing.Transport(nil)},
 		}
-	}(app.DB.Connection())
+	default:
+		app.fatal(fmt.Errorf("unknown image store %q", cfg.Images.Store))
+	}
+
+	// posters are looked up on TMDB when an API key is configured
+	if app.APIKey != "" {
+		tmdb := posters.NewTMDB(posters.TMDBConfig{
+			BaseURL: cfg.TMDB.URL,
+			APIKey:  app.APIKey,
+			Retries: 3,
+			Client:  &http.Client{Timeout: 5 * time.Second, Transport: tracing.Transport(nil)},
+		})
+		app.Posters = posters.NewCache(app.Metrics.Posters(tmdb), 24*time.Hour, time.Hour, 1000)
+	} else {
+		app.Logger.Warn("no TMDB API key, posters will not be looked up")
+	}
+
+	// build the GraphQL schema once, its resolvers query the repository
+	app.Graph, err = app.newGraph()
+	if err != nil {
+		app.fatal(err)
+	}
+
+	// sign the JWTs with the keys of the manifest, or with the shared secret when there is none
+	keys, err := loadKeys(cfg.Auth)
+	if err != nil {
+		app.fatal(err)
+	}
 
 	app.auth = Auth{
 		Issuer:        app.JWTIssuer,
 		Audience:      app.JWTAudience,
-		Secret:        app.JWTSecret,
-		TokenExpiry:   15 * time.Minute,
-		RefreshExpiry: 24 * time.Hour,
+		Keys:          keys,
+		Leeway:        cfg.Auth.Leeway,
+		TokenExpiry:   cfg.Auth.TokenExpiry,
+		RefreshExpiry: cfg.Auth.RefreshExpiry,
 		CookiePath:    "/",
-		CookieName:    "jwt-refresh_token",
+		CookieName:    cfg.Auth.CookieName,
 		CookieDomain:  app.CookieDomain,
 	}
 
-	log.Println(fmt.Sprintf("Starting server on port %d", port))
+	// periodically delete expired refresh tokens and forgotten login failures
+	done := make(chan struct{})
+	defer close(done)
+
+	go app.cleanupRefreshTokens(time.Hour, done)
+	go app.cleanupLoginFailures(time.Hour, done)
+
+	// start a web server, it returns once it has been shut down
+	err = app.serve()
+	if err != nil {
+		app.fatal(err)
+	}
+
+}
+
+// loadKeys returns the keyring signing the JWTs, which must have a key signing from now on.
+func loadKeys(cfg config.Auth) (*keyring.Keyring, error) {
+
+	var keys *keyring.Keyring
+	var err error
+
+	if cfg.JWTKeysFile != "" {
+		keys, err = keyring.Load(cfg.JWTKeysFile)
+	} else {
+		keys, err = keyring.New(keyring.HMAC("hs256", []byte(cfg.JWTSecret)))
+	}
+	if err != nil {
+		return nil, err
+	}
 
-	// start a web server
-	err = http.ListenAndServe(fmt.Sprintf(":%d", port), app.routes())
+	_, err = keys.Current(time.Now())
 	if err != nil {
-		log.Fatal(err)
+		return nil, err
 	}
 
+	return keys, nil
+}
+
+// fatal logs err and exits with a non-zero status.
+func (app *application) fatal(err error) {
+	app.Logger.Error(err.Error())
+	os.Exit(1)
 }
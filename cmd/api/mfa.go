@@ -0,0 +1,622 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/base32"
+	"errors"
+	"github.com/calvarado2004/go-movies-backend/internal/models"
+	"github.com/calvarado2004/go-movies-backend/internal/repository"
+	"github.com/calvarado2004/go-movies-backend/internal/totp"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// totpIssuer labels the codes of the API in authenticator apps.
+const totpIssuer = "Go Movies"
+
+// recoveryCodeCount is the number of recovery codes users get when enabling two-factor authentication.
+const recoveryCodeCount = 10
+
+// Errors of the two-factor authentication.
+var (
+	errInvalidCode       = errors.New("invalid code")
+	errMFAEnabled        = errors.New("two-factor authentication is already enabled")
+	errNoMFAEnrollment   = errors.New("no two-factor authentication enrollment to confirm")
+	errMFANotEnabled     = errors.New("two-factor authentication is not enabled")
+	errInvalidChallenge  = errors.New("invalid or expired challenge token")
+	errNothingToConfirm  = errors.New("the enrollment was confirmed or replaced meanwhile")
+	errRecoveryCodeGiven = errors.New("a code of the authenticator app is required")
+)
+
+// recoveryCodeEncoding encodes recovery codes with letters and digits that can't be mistaken for one another.
+var recoveryCodeEncoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// newRecoveryCodes returns new recovery codes, formatted as xxxxx-xxxxx, and their hashes.
+func newRecoveryCodes() ([]string, []string, error) {
+
+	codes := make([]string, 0, recoveryCodeCount)
+	hashes := make([]string, 0, recoveryCodeCount)
+
+	for i := 0; i < recoveryCodeCount; i++ {
+		b := make([]byte, 7)
+
+		_, err := rand.Read(b)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		// 10 characters of 5 bits
+		code := strings.ToLower(recoveryCodeEncoding.EncodeToString(b)[:10])
+
+		codes = append(codes, code[:5]+"-"+code[5:])
+		hashes = append(hashes, hashToken(code))
+	}
+
+	return codes, hashes, nil
+}
+
+// isTOTPCode reports whether code looks like a code of an authenticator app rather than a recovery code.
+func isTOTPCode(code string) bool {
+
+	if len(code) != totp.Digits {
+		return false
+	}
+
+	_, err := strconv.Atoi(code)
+
+	return err == nil
+}
+
+// verifySecondFactor checks a code of the authenticator app of the user, or one of their recovery codes. Both
+// are single-use: a code of the app is refused once it, or a later one, has been used.
+func (app *application) verifySecondFactor(ctx context.Context, enrollment models.UserTOTP, code string) (bool, error) {
+
+	code = strings.TrimSpace(code)
+
+	if isTOTPCode(code) {
+		step, ok := totp.Verify(enrollment.Secret, code, time.Now())
+		if !ok {
+			return false, nil
+		}
+
+		return app.DB.UseTOTPStep(ctx, enrollment.UserID, step)
+	}
+
+	// recovery codes are accepted with or without their dash, in any case
+	code = strings.ToLower(strings.NewReplacer("-", "", " ", "").Replace(code))
+
+	used, err := app.DB.UseRecoveryCode(ctx, enrollment.UserID, hashToken(code))
+	if err != nil || !used {
+		return false, err
+	}
+
+	app.logger(ctx).Warn("recovery code used", "user_id", enrollment.UserID)
+
+	return true, nil
+}
+
+// checkSecondFactor checks a code given by the user like verifySecondFactor, under the same rate limits and
+// lockouts as passwords: wrong codes count as failed logins of the account. When the code isn't accepted, it
+// writes the error response and reports false.
+func (app *application) checkSecondFactor(w http.ResponseWriter, r *http.Request, user models.User, enrollment models.UserTOTP, code string) bool {
+
+	subjects := app.loginSubjects(r, user.Email)
+
+	retryAfter, err := app.checkLogin(r.Context(), subjects)
+	if err != nil {
+		err := app.errorJSON(w, r, err, http.StatusInternalServerError)
+		if err != nil {
+			return false
+		}
+		return false
+	}
+
+	if retryAfter > 0 {
+		app.tooManyLogins(w, r, retryAfter)
+		return false
+	}
+
+	valid, err := app.verifySecondFactor(r.Context(), enrollment, code)
+	if err != nil {
+		err := app.errorJSON(w, r, err, http.StatusInternalServerError)
+		if err != nil {
+			return false
+		}
+		return false
+	}
+
+	if !valid {
+		err := app.recordLoginFailure(r.Context(), subjects)
+		if err != nil {
+			app.logger(r.Context()).Error("recording the failed login failed", "error", err)
+		}
+
+		err = app.errorJSON(w, r, errInvalidCode, http.StatusBadRequest)
+		if err != nil {
+			return false
+		}
+		return false
+	}
+
+	return true
+}
+
+// challengeSecondFactor answers the login of a user with two-factor authentication with a challenge token,
+// to exchange along with a code at /authenticate/mfa.
+func (app *application) challengeSecondFactor(w http.ResponseWriter, r *http.Request, user models.User) {
+
+	challenge, err := app.auth.generateChallengeToken(user.ID)
+	if err != nil {
+		err := app.errorJSON(w, r, err, http.StatusInternalServerError)
+		if err != nil {
+			return
+		}
+		return
+	}
+
+	var payload = struct {
+		MFARequired    bool   `json:"mfa_required"`
+		ChallengeToken string `json:"challenge_token"`
+		ExpiresIn      int    `json:"expires_in"`
+	}{
+		MFARequired:    true,
+		ChallengeToken: challenge,
+		ExpiresIn:      int(mfaChallengeExpiry.Seconds()),
+	}
+
+	err = app.writeJSON(w, http.StatusAccepted, payload, nil)
+	if err != nil {
+		app.logger(r.Context()).Error("writing the response failed", "error", err)
+		return
+	}
+}
+
+// authenticateMFA exchanges a challenge token and a code of the authenticator app, or a recovery code, for a
+// token pair. Wrong codes count as failed logins of the account.
+func (app *application) authenticateMFA(w http.ResponseWriter, r *http.Request) {
+
+	var requestPayload struct {
+		ChallengeToken string `json:"challenge_token"`
+		Code           string `json:"code"`
+	}
+
+	err := app.readJSON(w, r, &requestPayload)
+	if err != nil {
+		err := app.errorJSON(w, r, err, http.StatusBadRequest)
+		if err != nil {
+			return
+		}
+		return
+	}
+
+	claims, err := app.auth.parseChallengeToken(requestPayload.ChallengeToken)
+	if err != nil {
+		err := app.errorJSON(w, r, errInvalidChallenge, http.StatusUnauthorized)
+		if err != nil {
+			return
+		}
+		return
+	}
+
+	userID, err := strconv.Atoi(claims.Subject)
+	if err != nil {
+		err := app.errorJSON(w, r, errInvalidChallenge, http.StatusUnauthorized)
+		if err != nil {
+			return
+		}
+		return
+	}
+
+	user, err := app.DB.GetUserByID(r.Context(), userID)
+	if err != nil {
+		err := app.errorJSON(w, r, errInvalidChallenge, http.StatusUnauthorized)
+		if err != nil {
+			return
+		}
+		return
+	}
+
+	setLogUser(r.Context(), claims.Subject)
+
+	enrollment, err := app.DB.GetUserTOTP(r.Context(), user.ID)
+	if err != nil || !enrollment.IsConfirmed() {
+		// two-factor authentication was disabled since the challenge
+		err := app.errorJSON(w, r, errInvalidChallenge, http.StatusUnauthorized)
+		if err != nil {
+			return
+		}
+		return
+	}
+
+	if !app.checkSecondFactor(w, r, user, enrollment, requestPayload.Code) {
+		return
+	}
+
+	app.completeLogin(w, r, user)
+}
+
+// mfaStatus tells whether the user has two-factor authentication enabled, and how many recovery codes they
+// have left.
+func (app *application) mfaStatus(w http.ResponseWriter, r *http.Request) {
+
+	user, _ := userFromContext(r.Context())
+
+	var payload struct {
+		Enabled           bool `json:"enabled"`
+		Enrolling         bool `json:"enrolling"`
+		RecoveryCodesLeft int  `json:"recovery_codes_left"`
+	}
+
+	enrollment, err := app.DB.GetUserTOTP(r.Context(), user.ID)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		err := app.errorJSON(w, r, err, http.StatusInternalServerError)
+		if err != nil {
+			return
+		}
+		return
+	}
+
+	if err == nil {
+		payload.Enabled = enrollment.IsConfirmed()
+		payload.Enrolling = !enrollment.IsConfirmed()
+	}
+
+	payload.RecoveryCodesLeft, err = app.DB.CountRecoveryCodes(r.Context(), user.ID)
+	if err != nil {
+		err := app.errorJSON(w, r, err, http.StatusInternalServerError)
+		if err != nil {
+			return
+		}
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, payload, nil)
+	if err != nil {
+		app.logger(r.Context()).Error("writing the response failed", "error", err)
+		return
+	}
+}
+
+// enrollTOTP starts the enrollment of the user in two-factor authentication: it generates a secret and
+// returns it, along with its otpauth:// URI for the QR code scanned by authenticator apps. Enrolling again
+// before confirming replaces the secret.
+func (app *application) enrollTOTP(w http.ResponseWriter, r *http.Request) {
+
+	authenticated, _ := userFromContext(r.Context())
+
+	enrollment, err := app.DB.GetUserTOTP(r.Context(), authenticated.ID)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		err := app.errorJSON(w, r, err, http.StatusInternalServerError)
+		if err != nil {
+			return
+		}
+		return
+	}
+
+	if err == nil && enrollment.IsConfirmed() {
+		err := app.errorJSON(w, r, errMFAEnabled, http.StatusConflict)
+		if err != nil {
+			return
+		}
+		return
+	}
+
+	user, err := app.DB.GetUserByID(r.Context(), authenticated.ID)
+	if err != nil {
+		err := app.errorJSON(w, r, err, http.StatusInternalServerError)
+		if err != nil {
+			return
+		}
+		return
+	}
+
+	secret, err := totp.NewSecret()
+	if err != nil {
+		err := app.errorJSON(w, r, err, http.StatusInternalServerError)
+		if err != nil {
+			return
+		}
+		return
+	}
+
+	// an enrollment confirmed since it was checked is kept
+	stored, err := app.DB.SetUserTOTP(r.Context(), models.UserTOTP{UserID: user.ID, Secret: secret, CreatedAt: time.Now().UTC()})
+	if err != nil {
+		err := app.errorJSON(w, r, err, http.StatusInternalServerError)
+		if err != nil {
+			return
+		}
+		return
+	}
+
+	if !stored {
+		err := app.errorJSON(w, r, errMFAEnabled, http.StatusConflict)
+		if err != nil {
+			return
+		}
+		return
+	}
+
+	var payload = struct {
+		Secret string `json:"secret"`
+		URI    string `json:"uri"`
+	}{
+		Secret: secret,
+		URI:    totp.URI(totpIssuer, user.Email, secret),
+	}
+
+	err = app.writeJSON(w, http.StatusOK, payload, nil)
+	if err != nil {
+		app.logger(r.Context()).Error("writing the response failed", "error", err)
+		return
+	}
+}
+
+// confirmTOTP enables two-factor authentication once the user proves their authenticator app has the secret
+// with a first code, and returns their recovery codes. They are only ever shown here.
+func (app *application) confirmTOTP(w http.ResponseWriter, r *http.Request) {
+
+	var requestPayload struct {
+		Code string `json:"code"`
+	}
+
+	err := app.readJSON(w, r, &requestPayload)
+	if err != nil {
+		err := app.errorJSON(w, r, err, http.StatusBadRequest)
+		if err != nil {
+			return
+		}
+		return
+	}
+
+	user, _ := userFromContext(r.Context())
+
+	enrollment, err := app.DB.GetUserTOTP(r.Context(), user.ID)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		err := app.errorJSON(w, r, err, http.StatusInternalServerError)
+		if err != nil {
+			return
+		}
+		return
+	}
+
+	if err != nil || enrollment.IsConfirmed() {
+		err := app.errorJSON(w, r, errNoMFAEnrollment, http.StatusConflict)
+		if err != nil {
+			return
+		}
+		return
+	}
+
+	step, ok := totp.Verify(enrollment.Secret, strings.TrimSpace(requestPayload.Code), time.Now())
+	if !ok {
+		err := app.errorJSON(w, r, errInvalidCode, http.StatusBadRequest)
+		if err != nil {
+			return
+		}
+		return
+	}
+
+	codes, hashes, err := newRecoveryCodes()
+	if err != nil {
+		err := app.errorJSON(w, r, err, http.StatusInternalServerError)
+		if err != nil {
+			return
+		}
+		return
+	}
+
+	err = app.DB.WithTx(r.Context(), func(repo repository.DatabaseRepo) error {
+		confirmed, err := repo.ConfirmUserTOTP(r.Context(), user.ID, step)
+		if err != nil {
+			return err
+		}
+		if !confirmed {
+			return errNothingToConfirm
+		}
+
+		return repo.ReplaceRecoveryCodes(r.Context(), user.ID, hashes)
+	})
+	if errors.Is(err, errNothingToConfirm) {
+		err := app.errorJSON(w, r, err, http.StatusConflict)
+		if err != nil {
+			return
+		}
+		return
+	}
+	if err != nil {
+		err := app.errorJSON(w, r, err, http.StatusInternalServerError)
+		if err != nil {
+			return
+		}
+		return
+	}
+
+	app.logger(r.Context()).Info("two-factor authentication enabled", "user_id", user.ID)
+
+	app.writeRecoveryCodes(w, r, codes)
+}
+
+// regenerateRecoveryCodes replaces the recovery codes of the user, who proves they still have their
+// authenticator app with a code of it.
+func (app *application) regenerateRecoveryCodes(w http.ResponseWriter, r *http.Request) {
+
+	var requestPayload struct {
+		Code string `json:"code"`
+	}
+
+	err := app.readJSON(w, r, &requestPayload)
+	if err != nil {
+		err := app.errorJSON(w, r, err, http.StatusBadRequest)
+		if err != nil {
+			return
+		}
+		return
+	}
+
+	authenticated, _ := userFromContext(r.Context())
+
+	user, enrollment, ok := app.confirmedEnrollment(w, r, authenticated.ID)
+	if !ok {
+		return
+	}
+
+	// recovery codes can't be used to get new ones
+	if !isTOTPCode(strings.TrimSpace(requestPayload.Code)) {
+		err := app.errorJSON(w, r, errRecoveryCodeGiven, http.StatusBadRequest)
+		if err != nil {
+			return
+		}
+		return
+	}
+
+	if !app.checkSecondFactor(w, r, user, enrollment, requestPayload.Code) {
+		return
+	}
+
+	codes, hashes, err := newRecoveryCodes()
+	if err != nil {
+		err := app.errorJSON(w, r, err, http.StatusInternalServerError)
+		if err != nil {
+			return
+		}
+		return
+	}
+
+	err = app.DB.ReplaceRecoveryCodes(r.Context(), user.ID, hashes)
+	if err != nil {
+		err := app.errorJSON(w, r, err, http.StatusInternalServerError)
+		if err != nil {
+			return
+		}
+		return
+	}
+
+	app.logger(r.Context()).Info("recovery codes regenerated", "user_id", user.ID)
+
+	app.writeRecoveryCodes(w, r, codes)
+}
+
+// disableTOTP disables two-factor authentication, given a code of the authenticator app or a recovery code.
+// An enrollment that wasn't confirmed yet is cancelled without one.
+func (app *application) disableTOTP(w http.ResponseWriter, r *http.Request) {
+
+	var requestPayload struct {
+		Code string `json:"code"`
+	}
+
+	err := app.readJSON(w, r, &requestPayload)
+	if err != nil {
+		err := app.errorJSON(w, r, err, http.StatusBadRequest)
+		if err != nil {
+			return
+		}
+		return
+	}
+
+	authenticated, _ := userFromContext(r.Context())
+
+	user, err := app.DB.GetUserByID(r.Context(), authenticated.ID)
+	if err != nil {
+		err := app.errorJSON(w, r, err, http.StatusInternalServerError)
+		if err != nil {
+			return
+		}
+		return
+	}
+
+	enrollment, err := app.DB.GetUserTOTP(r.Context(), user.ID)
+	if errors.Is(err, sql.ErrNoRows) {
+		err := app.errorJSON(w, r, errMFANotEnabled, http.StatusNotFound)
+		if err != nil {
+			return
+		}
+		return
+	}
+	if err != nil {
+		err := app.errorJSON(w, r, err, http.StatusInternalServerError)
+		if err != nil {
+			return
+		}
+		return
+	}
+
+	if enrollment.IsConfirmed() && !app.checkSecondFactor(w, r, user, enrollment, requestPayload.Code) {
+		return
+	}
+
+	err = app.DB.DeleteUserTOTP(r.Context(), user.ID)
+	if err != nil {
+		err := app.errorJSON(w, r, err, http.StatusInternalServerError)
+		if err != nil {
+			return
+		}
+		return
+	}
+
+	app.logger(r.Context()).Info("two-factor authentication disabled", "user_id", user.ID)
+
+	response := JSONResponse{
+		Error:   false,
+		Message: "two-factor authentication disabled",
+	}
+
+	err = app.writeJSON(w, http.StatusOK, response, nil)
+	if err != nil {
+		app.logger(r.Context()).Error("writing the response failed", "error", err)
+		return
+	}
+}
+
+// confirmedEnrollment returns a user and their confirmed TOTP enrollment. When they have none, it writes the
+// error response and reports false.
+func (app *application) confirmedEnrollment(w http.ResponseWriter, r *http.Request, userID int) (models.User, models.UserTOTP, bool) {
+
+	user, err := app.DB.GetUserByID(r.Context(), userID)
+	if err != nil {
+		err := app.errorJSON(w, r, err, http.StatusInternalServerError)
+		if err != nil {
+			return models.User{}, models.UserTOTP{}, false
+		}
+		return models.User{}, models.UserTOTP{}, false
+	}
+
+	enrollment, err := app.DB.GetUserTOTP(r.Context(), userID)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		err := app.errorJSON(w, r, err, http.StatusInternalServerError)
+		if err != nil {
+			return models.User{}, models.UserTOTP{}, false
+		}
+		return models.User{}, models.UserTOTP{}, false
+	}
+
+	if err != nil || !enrollment.IsConfirmed() {
+		err := app.errorJSON(w, r, errMFANotEnabled, http.StatusConflict)
+		if err != nil {
+			return models.User{}, models.UserTOTP{}, false
+		}
+		return models.User{}, models.UserTOTP{}, false
+	}
+
+	return user, enrollment, true
+}
+
+// writeRecoveryCodes writes the recovery codes of the user.
+func (app *application) writeRecoveryCodes(w http.ResponseWriter, r *http.Request, codes []string) {
+
+	var payload = struct {
+		RecoveryCodes []string `json:"recovery_codes"`
+	}{
+		RecoveryCodes: codes,
+	}
+
+	err := app.writeJSON(w, http.StatusOK, payload, nil)
+	if err != nil {
+		app.logger(r.Context()).Error("writing the response failed", "error", err)
+		return
+	}
+}
@@ -0,0 +1,246 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"github.com/calvarado2004/go-movies-backend/internal/models"
+	"github.com/calvarado2004/go-movies-backend/internal/repository"
+	"github.com/calvarado2004/go-movies-backend/internal/repository/dbrepo"
+	"github.com/calvarado2004/go-movies-backend/internal/totp"
+	"golang.org/x/crypto/bcrypt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestTOTPLogin(t *testing.T) {
+
+	repo := dbrepo.NewSeededMemoryDBRepo()
+
+	hash, err := bcrypt.GenerateFromPassword([]byte("correct horse"), bcrypt.MinCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	verified := time.Now().UTC()
+
+	_, err = repo.InsertUser(context.Background(), models.User{
+		FirstName:       "Grace",
+		LastName:        "Hopper",
+		Email:           "grace@example.com",
+		Password:        string(hash),
+		Role:            models.RoleAdmin,
+		EmailVerifiedAt: &verified,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	app := application{
+		DB:   repo,
+		auth: testAuth(t),
+		logins: loginThrottle{
+			Account: loginPolicy{MaxFailures: 10, Lockout: 15 * time.Minute},
+			IP:      loginPolicy{MaxFailures: 100, Lockout: 15 * time.Minute},
+		},
+	}
+
+	// serve sends a JSON request with the access token, if any, and decodes the response into out
+	serve := func(method, target, accessToken string, body string, out any) int {
+		t.Helper()
+
+		req := httptest.NewRequest(method, target, strings.NewReader(body))
+		if accessToken != "" {
+			req.Header.Set("Authorization", "Bearer "+accessToken)
+		}
+
+		rr := httptest.NewRecorder()
+		app.routes().ServeHTTP(rr, req)
+
+		if out != nil {
+			err := json.Unmarshal(rr.Body.Bytes(), out)
+			if err != nil {
+				t.Fatalf("%s %s = %d %s", method, target, rr.Code, rr.Body)
+			}
+		}
+
+		return rr.Code
+	}
+
+	type loginResponse struct {
+		AccessToken    string `json:"access_token"`
+		MFARequired    bool   `json:"mfa_required"`
+		ChallengeToken string `json:"challenge_token"`
+	}
+
+	login := func() loginResponse {
+		t.Helper()
+
+		var response loginResponse
+		serve(http.MethodPost, "/authenticate", "", `{"email":"grace@example.com","password":"correct horse"}`, &response)
+		return response
+	}
+
+	tokens := login()
+	if tokens.AccessToken == "" || tokens.MFARequired {
+		t.Fatalf("login without two-factor authentication = %+v, want tokens", tokens)
+	}
+
+	var enrollment struct {
+		Secret string `json:"secret"`
+		URI    string `json:"uri"`
+	}
+
+	if code := serve(http.MethodPost, "/account/mfa/totp", tokens.AccessToken, "", &enrollment); code != http.StatusOK {
+		t.Fatalf("enrolling = %d", code)
+	}
+
+	if !strings.HasPrefix(enrollment.URI, "otpauth://totp/") || !strings.Contains(enrollment.URI, "secret="+enrollment.Secret) {
+		t.Errorf("enrollment URI = %s, want the otpauth URI of the secret", enrollment.URI)
+	}
+
+	// the enrollment isn't effective until confirmed
+	if response := login(); response.AccessToken == "" {
+		t.Fatalf("login while enrolling = %+v, want tokens", response)
+	}
+
+	codeAt := func(step int64) string {
+		code, err := totp.Code(enrollment.Secret, step)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return code
+	}
+
+	step := totp.Step(time.Now())
+
+	if code := serve(http.MethodPost, "/account/mfa/totp/confirm", tokens.AccessToken, `{"code":"`+codeAt(step+5)+`"}`, nil); code != http.StatusBadRequest {
+		t.Errorf("confirming with a wrong code = %d, want 400", code)
+	}
+
+	var recovery struct {
+		RecoveryCodes []string `json:"recovery_codes"`
+	}
+
+	if code := serve(http.MethodPost, "/account/mfa/totp/confirm", tokens.AccessToken, `{"code":"`+codeAt(step)+`"}`, &recovery); code != http.StatusOK {
+		t.Fatalf("confirming = %d", code)
+	}
+
+	if len(recovery.RecoveryCodes) != recoveryCodeCount || len(recovery.RecoveryCodes[0]) != 11 {
+		t.Errorf("recovery codes = %v, want %d codes formatted as xxxxx-xxxxx", recovery.RecoveryCodes, recoveryCodeCount)
+	}
+
+	// the password now only gets a challenge
+	challenge := login()
+	if challenge.AccessToken != "" || !challenge.MFARequired || challenge.ChallengeToken == "" {
+		t.Fatalf("login with two-factor authentication = %+v, want a challenge", challenge)
+	}
+
+	exchange := func(challengeToken, code string) (int, loginResponse) {
+		t.Helper()
+
+		var response loginResponse
+		status := serve(http.MethodPost, "/authenticate/mfa", "", `{"challenge_token":"`+challengeToken+`","code":"`+code+`"}`, &response)
+		return status, response
+	}
+
+	// tokens of other types aren't challenges
+	if status, _ := exchange(tokens.AccessToken, codeAt(step+1)); status != http.StatusUnauthorized {
+		t.Errorf("exchanging an access token = %d, want 401", status)
+	}
+
+	// the code used to confirm can't be replayed
+	if status, _ := exchange(challenge.ChallengeToken, codeAt(step)); status != http.StatusBadRequest {
+		t.Errorf("exchanging a used code = %d, want 400", status)
+	}
+
+	status, response := exchange(challenge.ChallengeToken, codeAt(step+1))
+	if status != http.StatusAccepted || response.AccessToken == "" {
+		t.Fatalf("exchanging the next code = %d %+v, want tokens", status, response)
+	}
+
+	// recovery codes are accepted once, in any case and without their dash
+	recoveryCode := strings.ToUpper(strings.ReplaceAll(recovery.RecoveryCodes[3], "-", ""))
+
+	if status, response := exchange(login().ChallengeToken, recoveryCode); status != http.StatusAccepted || response.AccessToken == "" {
+		t.Fatalf("exchanging a recovery code = %d %+v, want tokens", status, response)
+	}
+
+	if status, _ := exchange(login().ChallengeToken, recoveryCode); status != http.StatusBadRequest {
+		t.Errorf("exchanging a used recovery code = %d, want 400", status)
+	}
+
+	var mfaStatus struct {
+		Enabled           bool `json:"enabled"`
+		RecoveryCodesLeft int  `json:"recovery_codes_left"`
+	}
+
+	serve(http.MethodGet, "/account/mfa", tokens.AccessToken, "", &mfaStatus)
+
+	if !mfaStatus.Enabled || mfaStatus.RecoveryCodesLeft != recoveryCodeCount-1 {
+		t.Errorf("status = %+v, want enabled with %d recovery codes left", mfaStatus, recoveryCodeCount-1)
+	}
+
+	if code := serve(http.MethodPost, "/account/mfa/recovery-codes", tokens.AccessToken, `{"code":"`+recovery.RecoveryCodes[4]+`"}`, nil); code != http.StatusBadRequest {
+		t.Errorf("regenerating recovery codes with a recovery code = %d, want 400", code)
+	}
+
+	if code := serve(http.MethodDelete, "/account/mfa/totp", tokens.AccessToken, `{"code":"`+recovery.RecoveryCodes[4]+`"}`, nil); code != http.StatusOK {
+		t.Fatalf("disabling = %d", code)
+	}
+
+	if response := login(); response.AccessToken == "" {
+		t.Errorf("login after disabling two-factor authentication = %+v, want tokens", response)
+	}
+}
+
+// staleTOTPRepo is a repository reading TOTP enrollments from before the user confirmed one.
+type staleTOTPRepo struct {
+	repository.DatabaseRepo
+}
+
+// GetUserTOTP reports that the user has no enrollment.
+func (r staleTOTPRepo) GetUserTOTP(ctx context.Context, userID int) (models.UserTOTP, error) {
+	return models.UserTOTP{}, sql.ErrNoRows
+}
+
+func TestEnrollTOTPConfirmedMeanwhile(t *testing.T) {
+
+	repo := dbrepo.NewSeededMemoryDBRepo()
+
+	_, err := repo.SetUserTOTP(context.Background(), models.UserTOTP{UserID: 1, Secret: "CONFIRMED", CreatedAt: time.Now().UTC()})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = repo.ConfirmUserTOTP(context.Background(), 1, totp.Step(time.Now()))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the enrollment is confirmed after enrollTOTP checked it
+	app := application{DB: staleTOTPRepo{DatabaseRepo: repo}, auth: testAuth(t)}
+
+	tokens, err := app.auth.generateTokenPair(&jwtUser{ID: 1, Role: models.RoleAdmin})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	req := httptest.NewRequest(http.MethodPost, "/account/mfa/totp", nil)
+	req.Header.Set("Authorization", "Bearer "+tokens.AccessToken)
+
+	rr := httptest.NewRecorder()
+	app.routes().ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusConflict {
+		t.Errorf("enrolling = %d %s, want %d", rr.Code, rr.Body, http.StatusConflict)
+	}
+
+	enrollment, err := repo.GetUserTOTP(context.Background(), 1)
+	if err != nil || enrollment.Secret != "CONFIRMED" || !enrollment.IsConfirmed() {
+		t.Errorf("enrollment = %+v, %v, want the confirmed one kept", enrollment, err)
+	}
+}
@@ -1,38 +1,111 @@
 package main
 
 import (
+	"context"
+	"errors"
+	"fmt"
 	"net/http"
-	"os"
+	"strconv"
 )
 
-// enableCORS is a middleware function that adds the appropriate CORS headers to the response.
-func (app *application) enableCORS(h http.Handler) http.Handler {
-
-	corsDomain := os.Getenv("FRONTEND_MOVIES")
-
+// requestDeadline is a middleware function that sets the deadline of the request context to RequestTimeout
+// from now, so that the repository calls made for a request give up together.
+func (app *application) requestDeadline(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		w.Header().Set("Access-Control-Allow-Origin", corsDomain)
-
-		w.Header().Set("Access-Control-Allow-Credentials", "true")
-
-		if r.Method == "OPTIONS" {
-			w.Header().Set("Access-Control-Allow-Methods", "GET, POST, PUT, PATCH, DELETE, OPTIONS")
-			w.Header().Set("Access-Control-Allow-Headers", "Accept, Content-Type, Content-Length, Accept-Encoding, X-CSRF-Token, Authorization")
-
+		if app.RequestTimeout <= 0 {
+			next.ServeHTTP(w, r)
 			return
 		}
-		h.ServeHTTP(w, r)
+
+		ctx, cancel := context.WithTimeout(r.Context(), app.RequestTimeout)
+		defer cancel()
+
+		next.ServeHTTP(w, r.WithContext(ctx))
 	})
 }
 
-// authRequired is a middleware function that checks that the request contains a valid JWT token.
+// authRequired is a middleware function that checks that the request contains a valid JWT token, and puts
+// the user it was issued to in the request context.
 func (app *application) authRequired(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		_, _, err := app.auth.getTokenFromHeaderAndVerify(w, r)
+		user, err := app.authenticateRequest(w, r)
 		if err != nil {
-			w.WriteHeader(http.StatusUnauthorized)
+			app.unauthorized(w, r, err)
 			return
 		}
-		next.ServeHTTP(w, r)
+
+		next.ServeHTTP(w, r.WithContext(contextWithUser(r.Context(), user)))
 	})
 }
+
+// requireRole returns a middleware function that checks that the JWT token grants at least the given role.
+// The token is only verified once when authRequired comes first.
+func (app *application) requireRole(role string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			user, ok := userFromContext(r.Context())
+			if !ok {
+				var err error
+
+				user, err = app.authenticateRequest(w, r)
+				if err != nil {
+					app.unauthorized(w, r, err)
+					return
+				}
+
+				r = r.WithContext(contextWithUser(r.Context(), user))
+			}
+
+			if !user.can(role) {
+				err := app.errorJSON(w, r, errors.New("insufficient permissions"), http.StatusForbidden)
+				if err != nil {
+					return
+				}
+				return
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// authenticateRequest verifies the access token of a request and returns the user it was issued to.
+func (app *application) authenticateRequest(w http.ResponseWriter, r *http.Request) (authUser, error) {
+
+	_, claims, err := app.auth.getTokenFromHeaderAndVerify(w, r)
+	if err != nil {
+		return authUser{}, err
+	}
+
+	id, err := strconv.Atoi(claims.Subject)
+	if err != nil {
+		return authUser{}, fmt.Errorf("%w: invalid subject %q", errInvalidToken, claims.Subject)
+	}
+
+	setLogUser(r.Context(), claims.Subject)
+
+	return authUser{ID: id, Name: claims.Name, Role: claims.Role}, nil
+}
+
+// unauthorized writes a 401 Unauthorized response, telling the client why its token was refused as RFC 6750
+// describes.
+func (app *application) unauthorized(w http.ResponseWriter, r *http.Request, err error) {
+
+	app.logError(r, http.StatusUnauthorized, err)
+
+	challenge := "Bearer"
+
+	switch {
+	case errors.Is(err, errMissingToken):
+	case errors.Is(err, errMalformedHeader):
+		challenge += ` error="invalid_request"`
+	case errors.Is(err, errTokenExpired), errors.Is(err, errTokenNotValidYet), errors.Is(err, errInvalidIssuer),
+		errors.Is(err, errInvalidAudience), errors.Is(err, errWrongTokenType):
+		challenge += fmt.Sprintf(` error="invalid_token", error_description=%q`, err.Error())
+	default:
+		challenge += ` error="invalid_token"`
+	}
+
+	w.Header().Set("WWW-Authenticate", challenge)
+	w.WriteHeader(http.StatusUnauthorized)
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"github.com/calvarado2004/go-movies-backend/internal/models"
+	"github.com/calvarado2004/go-movies-backend/internal/repository/dbrepo"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestAdminRoutesRequireRole(t *testing.T) {
+
+	app := application{DB: dbrepo.NewSeededMemoryDBRepo(), auth: testAuth(t)}
+
+	tokens := map[string]string{}
+
+	for _, role := range []string{models.RoleViewer, models.RoleEditor, models.RoleAdmin, "superuser"} {
+		pair, err := app.auth.generateTokenPair(&jwtUser{ID: 1, Role: role})
+		if err != nil {
+			t.Fatal(err)
+		}
+		tokens[role] = pair.AccessToken
+	}
+
+	routes := []struct {
+		method  string
+		target  string
+		allowed string
+	}{
+		{http.MethodGet, "/admin/movies", "viewer,editor,admin"},
+		{http.MethodGet, "/admin/movies/1", "viewer,editor,admin"},
+		{http.MethodPut, "/admin/movies/0", "editor,admin"},
+		{http.MethodPatch, "/admin/movies/1", "editor,admin"},
+		{http.MethodPost, "/admin/movies/1/image", "editor,admin"},
+		{http.MethodGet, "/admin/lockouts", "admin"},
+		{http.MethodDelete, "/admin/lockouts/account/nobody@example.com", "admin"},
+		{http.MethodDelete, "/admin/movies/1", "admin"},
+	}
+
+	serve := func(method, target, role string) int {
+		// the bodies are empty, allowed requests fail validation, if anything
+		req := httptest.NewRequest(method, target, strings.NewReader(""))
+		if role != "" {
+			req.Header.Set("Authorization", "Bearer "+tokens[role])
+		}
+
+		rr := httptest.NewRecorder()
+		app.routes().ServeHTTP(rr, req)
+		return rr.Code
+	}
+
+	for _, route := range routes {
+		if code := serve(route.method, route.target, ""); code != http.StatusUnauthorized {
+			t.Errorf("%s %s without a token = %d, want %d", route.method, route.target, code, http.StatusUnauthorized)
+		}
+
+		for _, role := range []string{models.RoleViewer, models.RoleEditor, models.RoleAdmin, "superuser"} {
+			code := serve(route.method, route.target, role)
+
+			if slices.Contains(strings.Split(route.allowed, ","), role) {
+				if code == http.StatusUnauthorized || code == http.StatusForbidden {
+					t.Errorf("%s %s as %s = %d, want it allowed", route.method, route.target, role, code)
+				}
+			} else if code != http.StatusForbidden {
+				t.Errorf("%s %s as %s = %d, want %d", route.method, route.target, role, code, http.StatusForbidden)
+			}
+		}
+	}
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/calvarado2004/go-movies-backend/internal/migrations"
+	"strconv"
+	"time"
+)
+
+// migrationTimeout is the maximum amount of time a migrate command can take.
+const migrationTimeout = 5 * time.Minute
+
+// runMigrate runs the migrate subcommand: migrate up|down [steps]|status|goto <version>.
+func (app *application) runMigrate(args []string) error {
+
+	if len(args) == 0 {
+		return errors.New("usage: migrate up|down [steps]|status|goto <version>")
+	}
+
+	conn, err := app.connectToDB()
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	migrator, err := migrations.New(conn)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), migrationTimeout)
+	defer cancel()
+
+	switch args[0] {
+	case "up":
+		err = migrator.Up(ctx)
+	case "down":
+		steps := 1
+		if len(args) > 1 {
+			steps, err = strconv.Atoi(args[1])
+			if err != nil {
+				return fmt.Errorf("invalid number of steps %q", args[1])
+			}
+		}
+		err = migrator.Down(ctx, steps)
+	case "goto":
+		if len(args) < 2 {
+			return errors.New("usage: migrate goto <version>")
+		}
+		version, err := strconv.Atoi(args[1])
+		if err != nil {
+			return fmt.Errorf("invalid version %q", args[1])
+		}
+		err = migrator.Goto(ctx, version)
+		if err != nil {
+			return err
+		}
+	case "status":
+		var statuses []migrations.Status
+		statuses, err = migrator.Status(ctx)
+		for _, status := range statuses {
+			applied := "pending"
+			if status.AppliedAt != nil {
+				applied = "applied " + status.AppliedAt.Format(time.RFC3339)
+			}
+			fmt.Printf("%04d %-30s %s\n", status.Version, status.Name, applied)
+		}
+	default:
+		return fmt.Errorf("unknown migrate command %q", args[0])
+	}
+	if err != nil {
+		return err
+	}
+
+	app.logger(ctx).Info("migrate finished", "command", args[0])
+
+	return nil
+}
+
+// migrateUp applies every pending migration, it is used to migrate the database at startup.
+func (app *application) migrateUp() error {
+
+	migrator, err := migrations.New(app.DB.Connection())
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), migrationTimeout)
+	defer cancel()
+
+	err = migrator.Up(ctx)
+	if err != nil {
+		return err
+	}
+
+	app.logger(ctx).Info("database schema migrated", "version", migrator.Latest())
+
+	return nil
+}
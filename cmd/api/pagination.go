@@ -0,0 +1,142 @@
+package main
+
+import (
+	"fmt"
+	"github.com/calvarado2004/go-movies-backend/internal/models"
+	"github.com/calvarado2004/go-movies-backend/internal/repository"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// moviesPage is the response envelope for paginated movie listings.
+type moviesPage struct {
+	Movies   []*models.Movie `json:"movies"`
+	Metadata pageMetadata    `json:"metadata"`
+}
+
+// pageMetadata describes the page returned in a moviesPage.
+type pageMetadata struct {
+	Total    int    `json:"total"`
+	Limit    int    `json:"limit"`
+	Offset   int    `json:"offset"`
+	Next     string `json:"next,omitempty"`
+	Previous string `json:"previous,omitempty"`
+}
+
+// readMovieQuery reads the pagination, sorting and filtering parameters of a movie listing from the query string.
+func (app *application) readMovieQuery(r *http.Request) (repository.MovieQuery, error) {
+
+	var query repository.MovieQuery
+	var err error
+
+	qs := r.URL.Query()
+
+	ints := map[string]*int{
+		"limit":       &query.Limit,
+		"offset":      &query.Offset,
+		"year_from":   &query.ReleaseYearFrom,
+		"year_to":     &query.ReleaseYearTo,
+		"runtime_min": &query.RuntimeMin,
+		"runtime_max": &query.RuntimeMax,
+	}
+
+	for key, dest := range ints {
+		value := qs.Get(key)
+		if value == "" {
+			continue
+		}
+
+		*dest, err = strconv.Atoi(value)
+		if err != nil {
+			return query, fmt.Errorf("%s must be an integer", key)
+		}
+	}
+
+	query.Sort = qs.Get("sort")
+
+	switch strings.ToLower(qs.Get("order")) {
+	case "", "asc":
+	case "desc":
+		query.Desc = true
+	default:
+		return query, fmt.Errorf("order must be asc or desc")
+	}
+
+	if rating := qs.Get("rating"); rating != "" {
+		query.MPAARatings = strings.Split(rating, ",")
+	}
+
+	query.Genres, err = readGenreIDs(qs)
+	if err != nil {
+		return query, err
+	}
+
+	// an explicit order without a sort field applies to the default sort field
+	if query.Sort == "" && qs.Get("order") != "" {
+		query.Sort = repository.SortTitle
+	}
+
+	err = query.Normalize()
+	if err != nil {
+		return query, err
+	}
+
+	return query, nil
+}
+
+// newMoviesPage builds the response envelope for a page of movies, including links to the adjacent pages.
+func newMoviesPage(r *http.Request, query repository.MovieQuery, movies []*models.Movie, total int) moviesPage {
+
+	page := moviesPage{
+		Movies: movies,
+		Metadata: pageMetadata{
+			Total:  total,
+			Limit:  query.Limit,
+			Offset: query.Offset,
+		},
+	}
+
+	pageLink := func(offset int) string {
+		qs := r.URL.Query()
+		qs.Set("limit", strconv.Itoa(query.Limit))
+		qs.Set("offset", strconv.Itoa(offset))
+
+		link := url.URL{Path: r.URL.Path, RawQuery: qs.Encode()}
+
+		return link.String()
+	}
+
+	if query.Offset+len(movies) < total {
+		page.Metadata.Next = pageLink(query.Offset + query.Limit)
+	}
+
+	if query.Offset > 0 {
+		previous := query.Offset - query.Limit
+		if previous < 0 {
+			previous = 0
+		}
+		page.Metadata.Previous = pageLink(previous)
+	}
+
+	return page
+}
+
+// readGenreIDs reads the comma separated list of genre ids in the genres query parameter.
+func readGenreIDs(qs url.Values) ([]int, error) {
+
+	var genreIDs []int
+
+	if genres := qs.Get("genres"); genres != "" {
+		for _, genre := range strings.Split(genres, ",") {
+			genreID, err := strconv.Atoi(genre)
+			if err != nil {
+				return nil, fmt.Errorf("genres must be a comma separated list of genre ids")
+			}
+			genreIDs = append(genreIDs, genreID)
+		}
+	}
+
+	return genreIDs, nil
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"encoding/json"
+	"github.com/calvarado2004/go-movies-backend/internal/repository/dbrepo"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestListMovies(t *testing.T) {
+
+	app := application{DB: dbrepo.NewSeededMemoryDBRepo()}
+
+	tests := []struct {
+		target   string
+		titles   string
+		metadata pageMetadata
+	}{
+		// the catalog is sorted by title, descending, unless asked otherwise
+		{"/movies", "The Godfather,Raiders of the Lost Ark,Highlander", pageMetadata{Total: 3, Limit: 20}},
+		{"/movies?limit=1", "The Godfather", pageMetadata{Total: 3, Limit: 1, Next: "/movies?limit=1&offset=1"}},
+		{"/movies?limit=1&offset=1", "Raiders of the Lost Ark", pageMetadata{Total: 3, Limit: 1, Offset: 1,
+			Next: "/movies?limit=1&offset=2", Previous: "/movies?limit=1&offset=0"}},
+		{"/movies?limit=2&offset=1", "Raiders of the Lost Ark,Highlander", pageMetadata{Total: 3, Limit: 2, Offset: 1,
+			Previous: "/movies?limit=2&offset=0"}},
+		{"/movies?offset=10", "", pageMetadata{Total: 3, Limit: 20, Offset: 10, Previous: "/movies?limit=20&offset=0"}},
+		// page sizes are capped, and zero or negative ones get the default
+		{"/movies?limit=1000", "The Godfather,Raiders of the Lost Ark,Highlander", pageMetadata{Total: 3, Limit: 100}},
+		{"/movies?limit=-5", "The Godfather,Raiders of the Lost Ark,Highlander", pageMetadata{Total: 3, Limit: 20}},
+		{"/movies?sort=release_date", "The Godfather,Raiders of the Lost Ark,Highlander", pageMetadata{Total: 3, Limit: 20}},
+		{"/movies?sort=runtime&order=desc", "The Godfather,Highlander,Raiders of the Lost Ark", pageMetadata{Total: 3, Limit: 20}},
+		{"/movies?order=asc", "Highlander,Raiders of the Lost Ark,The Godfather", pageMetadata{Total: 3, Limit: 20}},
+		// filters apply to the total, and the links keep them
+		{"/movies?year_from=1980&limit=1", "Raiders of the Lost Ark", pageMetadata{Total: 2, Limit: 1,
+			Next: "/movies?limit=1&offset=1&year_from=1980"}},
+		{"/movies?rating=R,18A", "The Godfather,Highlander", pageMetadata{Total: 2, Limit: 20}},
+		{"/movies?runtime_max=120&genres=11", "Raiders of the Lost Ark", pageMetadata{Total: 1, Limit: 20}},
+		{"/movies/genres/5?genres=9", "Raiders of the Lost Ark,Highlander", pageMetadata{Total: 2, Limit: 20}},
+	}
+
+	for _, tt := range tests {
+		rr := httptest.NewRecorder()
+		app.routes().ServeHTTP(rr, httptest.NewRequest(http.MethodGet, tt.target, nil))
+
+		var page moviesPage
+
+		err := json.Unmarshal(rr.Body.Bytes(), &page)
+		if rr.Code != http.StatusOK || err != nil {
+			t.Errorf("GET %s = %d %s", tt.target, rr.Code, rr.Body)
+			continue
+		}
+
+		var titles []string
+		for _, movie := range page.Movies {
+			titles = append(titles, movie.Title)
+		}
+
+		if got := strings.Join(titles, ","); got != tt.titles {
+			t.Errorf("GET %s returned %q, want %q", tt.target, got, tt.titles)
+		}
+
+		if page.Metadata != tt.metadata {
+			t.Errorf("GET %s metadata = %+v, want %+v", tt.target, page.Metadata, tt.metadata)
+		}
+	}
+}
+
+func TestListMoviesInvalidQuery(t *testing.T) {
+
+	app := application{DB: dbrepo.NewSeededMemoryDBRepo()}
+
+	tests := []struct {
+		target  string
+		message string
+	}{
+		{"/movies?limit=ten", "limit must be an integer"},
+		{"/movies?offset=1.5", "offset must be an integer"},
+		{"/movies?offset=-1", "offset must not be negative"},
+		{"/movies?sort=rating", `invalid sort field "rating"`},
+		{"/movies?sort=title,runtime", `invalid sort field "title,runtime"`},
+		{"/movies?order=sideways", "order must be asc or desc"},
+		{"/movies?year_from=2000&year_to=1990", "year_from must not be greater than year_to"},
+		{"/movies?runtime_min=200&runtime_max=100", "runtime_min must not be greater than runtime_max"},
+		{"/movies?genres=1,action", "genres must be a comma separated list of genre ids"},
+	}
+
+	for _, tt := range tests {
+		rr := httptest.NewRecorder()
+		app.routes().ServeHTTP(rr, httptest.NewRequest(http.MethodGet, tt.target, nil))
+
+		var response JSONResponse
+
+		err := json.Unmarshal(rr.Body.Bytes(), &response)
+		if rr.Code != http.StatusBadRequest || err != nil || !response.Error || response.Message != tt.message {
+			t.Errorf("GET %s = %d %s, want 400 %q", tt.target, rr.Code, rr.Body, tt.message)
+		}
+	}
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"github.com/calvarado2004/go-movies-backend/internal/images"
+	"github.com/calvarado2004/go-movies-backend/internal/models"
+	"github.com/calvarado2004/go-movies-backend/internal/posters"
+	"github.com/calvarado2004/go-movies-backend/internal/tracing"
+	"net/http"
+	"time"
+)
+
+// posterTimeout bounds a poster lookup, retries included.
+const posterTimeout = 30 * time.Second
+
+// imageClient downloads the posters found.
+var imageClient = &http.Client{Timeout: posterTimeout, Transport: tracing.Transport(nil)}
+
+// movieCreated starts looking up the poster of a new movie without an image in the background, the movie is
+// listed without one until it is found.
+func (app *application) movieCreated(ctx context.Context, movie models.Movie) {
+	if movie.Image == "" && app.Posters != nil {
+		go app.enrichPoster(tracing.Detach(ctx), movie.ID, movie.Title)
+	}
+}
+
+// enrichPoster looks up the poster of a movie and stores it, unless the movie got an image in the meantime.
+// It runs in the background, ctx should carry the trace of the request but not its cancellation.
+func (app *application) enrichPoster(ctx context.Context, movieID int, title string) {
+
+	ctx, cancel := context.WithTimeout(ctx, posterTimeout)
+	defer cancel()
+
+	poster, err := app.Posters.Poster(ctx, title)
+	if errors.Is(err, posters.ErrNotFound) {
+		return
+	}
+	if err != nil {
+		app.logger(ctx).Warn("poster lookup failed", "movie_id", movieID, "error", err)
+		return
+	}
+
+	filled, err := app.DB.FillMovieImage(ctx, movieID, poster)
+	if err != nil {
+		app.logger(ctx).Error("storing the poster failed", "movie_id", movieID, "error", err)
+		return
+	}
+
+	// keep a copy of the poster, unless the movie got an image in the meantime
+	if !filled || app.Images == nil {
+		return
+	}
+
+	source := app.TMDBImageURL + poster
+
+	data, err := images.Download(ctx, imageClient, source)
+	if err != nil {
+		app.logger(ctx).Warn("downloading the poster failed", "movie_id", movieID, "error", err)
+		return
+	}
+
+	_, err = app.savePoster(ctx, movieID, source, data, false)
+	if err != nil {
+		app.logger(ctx).Error("saving the poster failed", "movie_id", movieID, "error", err)
+	}
+}
@@ -0,0 +1,167 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"github.com/calvarado2004/go-movies-backend/internal/images"
+	"github.com/calvarado2004/go-movies-backend/internal/models"
+	"github.com/calvarado2004/go-movies-backend/internal/posters"
+	"github.com/calvarado2004/go-movies-backend/internal/posters/posterstest"
+	"github.com/calvarado2004/go-movies-backend/internal/repository/dbrepo"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/jpeg"
+	"image/png"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestEnrichPoster(t *testing.T) {
+
+	fake := posterstest.NewTMDB(t, "key")
+	fake.SetPoster("Die Hard", "/die-hard.jpg")
+	fake.SetImage("/die-hard.jpg", testPNG(t, 500, 750))
+
+	app := application{
+		DB:           dbrepo.NewMemoryDBRepo(),
+		Posters:      posters.NewTMDB(posters.TMDBConfig{BaseURL: fake.URL, APIKey: fake.APIKey}),
+		TMDBImageURL: fake.URL,
+		Images:       &images.FSStore{Dir: t.TempDir()},
+	}
+
+	insert := func(movie models.Movie) int {
+		movie.ReleaseDate = time.Date(1988, 7, 15, 0, 0, 0, 0, time.UTC)
+		movie.MPAARating = "R"
+		id, err := app.DB.InsertMovie(context.Background(), movie)
+		if err != nil {
+			t.Fatalf("InsertMovie: %v", err)
+		}
+		return id
+	}
+
+	tests := []struct {
+		name  string
+		movie models.Movie
+		want  string
+	}{
+		{"found", models.Movie{Title: "Die Hard"}, "/die-hard.jpg"},
+		{"not found", models.Movie{Title: "Unknown"}, ""},
+		{"image set meanwhile", models.Movie{Title: "Die Hard", Image: "/own.jpg"}, "/own.jpg"},
+	}
+
+	for _, test := range tests {
+		id := insert(test.movie)
+
+		app.enrichPoster(context.Background(), id, test.movie.Title)
+
+		movie, err := app.DB.OneMovie(context.Background(), id)
+		if err != nil {
+			t.Fatalf("%s: OneMovie: %v", test.name, err)
+		}
+
+		if movie.Image != test.want {
+			t.Errorf("%s: image = %q, want %q", test.name, movie.Image, test.want)
+		}
+
+		// only posters found on TMDB are downloaded
+		if stored := movie.Poster != nil; stored != (test.name == "found") {
+			t.Errorf("%s: stored poster = %+v", test.name, movie.Poster)
+		}
+	}
+}
+
+func TestMovieImage(t *testing.T) {
+
+	app := application{
+		DB:     dbrepo.NewMemoryDBRepo(),
+		Images: &images.FSStore{Dir: t.TempDir()},
+	}
+
+	id, err := app.DB.InsertMovie(context.Background(), models.Movie{Title: "Alien", ReleaseDate: time.Now(), MPAARating: "R"})
+	if err != nil {
+		t.Fatalf("InsertMovie: %v", err)
+	}
+
+	poster, err := app.savePoster(context.Background(), id, "upload", testPNG(t, 1000, 1500), false)
+	if err != nil {
+		t.Fatalf("savePoster: %v", err)
+	}
+
+	if poster.Width != 1000 || poster.Height != 1500 || poster.DominantColor != "#3366cc" {
+		t.Errorf("savePoster = %+v", poster)
+	}
+
+	tests := []struct {
+		path       string
+		wantStatus int
+		wantWidth  int
+	}{
+		{fmt.Sprintf("/images/%d/thumbnail", id), http.StatusOK, 92},
+		{fmt.Sprintf("/images/%d/card", id), http.StatusOK, 342},
+		{fmt.Sprintf("/images/%d/full?v=1", id), http.StatusOK, 780},
+		{fmt.Sprintf("/images/%d/huge", id), http.StatusNotFound, 0},
+		{fmt.Sprintf("/images/%d/full", id+1), http.StatusNotFound, 0},
+	}
+
+	for _, test := range tests {
+		rr := httptest.NewRecorder()
+		app.routes().ServeHTTP(rr, httptest.NewRequest(http.MethodGet, test.path, nil))
+
+		if rr.Code != test.wantStatus {
+			t.Errorf("GET %s = %d, want %d", test.path, rr.Code, test.wantStatus)
+			continue
+		}
+
+		if test.wantStatus != http.StatusOK {
+			continue
+		}
+
+		config, err := jpeg.DecodeConfig(rr.Body)
+		if err != nil {
+			t.Errorf("GET %s: %v", test.path, err)
+			continue
+		}
+
+		if config.Width != test.wantWidth || config.Height != test.wantWidth*3/2 {
+			t.Errorf("GET %s is %dx%d, want a width of %d", test.path, config.Width, config.Height, test.wantWidth)
+		}
+
+		if !strings.Contains(rr.Header().Get("Cache-Control"), "max-age") || rr.Header().Get("ETag") == "" {
+			t.Errorf("GET %s is missing cache headers: %v", test.path, rr.Header())
+		}
+	}
+
+	// a client holding the current version gets no body
+	req := httptest.NewRequest(http.MethodGet, fmt.Sprintf("/images/%d/card", id), nil)
+	req.Header.Set("If-None-Match", fmt.Sprintf(`"%d-%s-card-%d"`, id, poster.Version, poster.UpdatedAt.Unix()))
+
+	rr := httptest.NewRecorder()
+	app.routes().ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusNotModified {
+		t.Errorf("conditional GET = %d, want %d", rr.Code, http.StatusNotModified)
+	}
+}
+
+// testPNG returns a PNG of the given size, mostly blue with a red band.
+func testPNG(t *testing.T, width, height int) []byte {
+	t.Helper()
+
+	img := image.NewRGBA(image.Rect(0, 0, width, height))
+	draw.Draw(img, img.Bounds(), &image.Uniform{C: color.RGBA{R: 0x33, G: 0x66, B: 0xcc, A: 0xff}}, image.Point{}, draw.Src)
+	draw.Draw(img, image.Rect(0, 0, width, height/10), &image.Uniform{C: color.RGBA{R: 0xff, A: 0xff}}, image.Point{}, draw.Src)
+
+	var buf bytes.Buffer
+
+	err := png.Encode(&buf, img)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return buf.Bytes()
+}
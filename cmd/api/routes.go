@@ -1,6 +1,8 @@
 package main
 
 import (
+	"github.com/calvarado2004/go-movies-backend/internal/models"
+	"github.com/calvarado2004/go-movies-backend/internal/tracing"
 	"github.com/go-chi/chi/v5"
 	"github.com/go-chi/chi/v5/middleware"
 	"net/http"
@@ -12,30 +14,73 @@ func (app *application) routes() http.Handler {
 
 	mux := chi.NewRouter()
 
-	// add middleware
+	// add middleware, tracing, metrics and logging first so that they see the requests that panicked
+	mux.Use(tracing.Middleware)
+	mux.Use(app.assignRequestID)
+	mux.Use(app.logRequests)
+
+	if app.Metrics != nil {
+		mux.Use(app.Metrics.Middleware)
+	}
+
 	mux.Use(middleware.Recoverer)
 
 	// add CORS middleware
 	mux.Use(app.enableCORS)
 
+	// bound the time spent on a request
+	mux.Use(app.requestDeadline)
+
 	// add routes
 	mux.Get("/", app.Home)
+	mux.Get("/healthz", app.healthz)
+	mux.Get("/readyz", app.readyz)
+	mux.Get("/.well-known/jwks.json", app.jwks)
+
+	if app.Metrics != nil {
+		mux.Method(http.MethodGet, "/metrics", app.Metrics.Handler())
+	}
+
 	mux.Get("/movies", app.AllMovies)
+	mux.Get("/movies/search", app.searchMovies)
 	mux.Get("/movies/{id}", app.getMovie)
 	mux.Post("/authenticate", app.authenticate)
+	mux.Post("/authenticate/mfa", app.authenticateMFA)
+	mux.Post("/register", app.register)
+	mux.Post("/verify-email", app.verifyEmail)
+	mux.Post("/forgot-password", app.forgotPassword)
+	mux.Post("/reset-password", app.resetPassword)
 	mux.Get("/refresh", app.refreshToken)
 	mux.Get("/logout", app.logout)
 	mux.Get("/genres", app.allGenres)
 	mux.Get("/movies/genres/{id}", app.AllMoviesByGenre)
+	mux.Get("/images/{movieID}/{size}", app.movieImage)
+	mux.Get("/graph", app.moviesGraphQL)
 	mux.Post("/graph", app.moviesGraphQL)
 
+	if app.Env == "development" {
+		mux.Get("/graphiql", app.graphiQL)
+	}
+
+	mux.Route("/account/mfa", func(accountMux chi.Router) {
+		accountMux.Use(app.authRequired)
+		accountMux.Get("/", app.mfaStatus)
+		accountMux.Post("/totp", app.enrollTOTP)
+		accountMux.Post("/totp/confirm", app.confirmTOTP)
+		accountMux.Delete("/totp", app.disableTOTP)
+		accountMux.Post("/recovery-codes", app.regenerateRecoveryCodes)
+	})
+
 	mux.Route("/admin", func(authMux chi.Router) {
 		authMux.Use(app.authRequired)
-		authMux.Get("/movies", app.movieCatalog)
-		authMux.Get("/movies/{id}", app.movieForEdit)
-		authMux.Put("/movies/0", app.insertMovie)
-		authMux.Patch("/movies/{id}", app.updateMovie)
-		authMux.Delete("/movies/{id}", app.deleteMovie)
+		authMux.With(app.requireRole(models.RoleViewer)).Get("/movies", app.movieCatalog)
+		authMux.With(app.requireRole(models.RoleViewer)).Get("/movies/{id}", app.movieForEdit)
+		authMux.With(app.requireRole(models.RoleEditor)).Put("/movies/0", app.insertMovie)
+		authMux.With(app.requireRole(models.RoleEditor)).Patch("/movies/{id}", app.updateMovie)
+		authMux.With(app.requireRole(models.RoleEditor)).Post("/movies/{id}/image", app.uploadMovieImage)
+		authMux.With(app.requireRole(models.RoleAdmin)).Delete("/movies/{id}", app.deleteMovie)
+		authMux.With(app.requireRole(models.RoleAdmin)).Get("/lockouts", app.loginLockouts)
+		authMux.With(app.requireRole(models.RoleAdmin)).Delete("/lockouts/{kind}/{subject}", app.clearLoginLockout)
 
 	})
 
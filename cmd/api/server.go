@@ -0,0 +1,180 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// Timeouts of the HTTP server. Writes are given long enough to process an uploaded poster.
+const (
+	readHeaderTimeout = 5 * time.Second
+	readTimeout       = 30 * time.Second
+	writeTimeout      = 60 * time.Second
+	idleTimeout       = 120 * time.Second
+	shutdownTimeout   = 20 * time.Second
+	readinessTimeout  = 2 * time.Second
+)
+
+// readinessTables are the tables the API needs, the database isn't ready until they have been migrated.
+var readinessTables = []string{"movies", "genres", "movies_genres", "movie_posters", "users", "user_tokens", "refresh_tokens", "login_failures", "user_totp", "recovery_codes"}
+
+// serve runs the web server until SIGINT or SIGTERM is received. The server then reports it isn't ready,
+// waits DrainDelay for load balancers to stop sending it requests, and finishes the requests in flight.
+func (app *application) serve() error {
+
+	srv := &http.Server{
+		Addr:              fmt.Sprintf(":%d", app.Port),
+		Handler:           app.routes(),
+		ReadHeaderTimeout: readHeaderTimeout,
+		ReadTimeout:       readTimeout,
+		WriteTimeout:      writeTimeout,
+		IdleTimeout:       idleTimeout,
+	}
+
+	shutdownError := make(chan error, 1)
+
+	go func() {
+		quit := make(chan os.Signal, 1)
+		signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM)
+
+		s := <-quit
+
+		app.logger(context.Background()).Info("draining before shutdown", "signal", s.String(), "drain_delay", app.DrainDelay)
+
+		app.draining.Store(true)
+		time.Sleep(app.DrainDelay)
+
+		ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+		defer cancel()
+
+		app.logger(ctx).Info("shutting down the server")
+
+		shutdownError <- srv.Shutdown(ctx)
+	}()
+
+	app.logger(context.Background()).Info("starting the server", "port", app.Port, "env", app.Env)
+
+	err := srv.ListenAndServe()
+	if !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+
+	err = <-shutdownError
+	if err != nil {
+		return err
+	}
+
+	app.logger(context.Background()).Info("server stopped")
+
+	return nil
+}
+
+// healthz handler reports that the process is alive.
+func (app *application) healthz(w http.ResponseWriter, r *http.Request) {
+
+	var payload = struct {
+		Status string `json:"status"`
+	}{
+		Status: "ok",
+	}
+
+	err := app.writeJSON(w, http.StatusOK, payload, nil)
+	if err != nil {
+		app.logger(r.Context()).Error("writing the response failed", "error", err)
+		return
+	}
+}
+
+// readyz handler reports whether the server can take requests: it isn't shutting down, the database
+// answers and its schema has been migrated.
+func (app *application) readyz(w http.ResponseWriter, r *http.Request) {
+
+	if app.draining.Load() {
+		err := app.errorJSON(w, r, errors.New("shutting down"), http.StatusServiceUnavailable)
+		if err != nil {
+			return
+		}
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), readinessTimeout)
+	defer cancel()
+
+	err := app.checkDatabase(ctx)
+	if err != nil {
+		err := app.errorJSON(w, r, err, http.StatusServiceUnavailable)
+		if err != nil {
+			return
+		}
+		return
+	}
+
+	var payload = struct {
+		Status string `json:"status"`
+	}{
+		Status: "ready",
+	}
+
+	err = app.writeJSON(w, http.StatusOK, payload, nil)
+	if err != nil {
+		app.logger(r.Context()).Error("writing the response failed", "error", err)
+		return
+	}
+}
+
+// checkDatabase pings the connection pool and checks that every table in readinessTables exists. Repositories
+// without a connection pool are always ready.
+func (app *application) checkDatabase(ctx context.Context) error {
+
+	db := app.DB.Connection()
+	if db == nil {
+		return nil
+	}
+
+	err := db.PingContext(ctx)
+	if err != nil {
+		return fmt.Errorf("database unreachable: %w", err)
+	}
+
+	rows, err := db.QueryContext(ctx, `SELECT t FROM unnest($1::text[]) AS t WHERE to_regclass('public.' || t) IS NULL`, readinessTables)
+	if err != nil {
+		return fmt.Errorf("checking tables: %w", err)
+	}
+
+	defer func(rows *sql.Rows) {
+		err := rows.Close()
+		if err != nil {
+			return
+		}
+	}(rows)
+
+	var missing []string
+
+	for rows.Next() {
+		var table string
+		err := rows.Scan(&table)
+		if err != nil {
+			return err
+		}
+		missing = append(missing, table)
+	}
+
+	err = rows.Err()
+	if err != nil {
+		return err
+	}
+
+	if len(missing) > 0 {
+		return fmt.Errorf("missing tables %s, migrations have not been applied", strings.Join(missing, ", "))
+	}
+
+	return nil
+}
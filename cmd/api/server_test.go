@@ -0,0 +1,55 @@
+package main
+
+import (
+	"github.com/calvarado2004/go-movies-backend/internal/repository/dbrepo"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestProbes(t *testing.T) {
+
+	app := application{DB: dbrepo.NewMemoryDBRepo()}
+
+	status := func(path string) int {
+		rr := httptest.NewRecorder()
+		app.routes().ServeHTTP(rr, httptest.NewRequest(http.MethodGet, path, nil))
+		return rr.Code
+	}
+
+	if code := status("/healthz"); code != http.StatusOK {
+		t.Errorf("GET /healthz = %d, want %d", code, http.StatusOK)
+	}
+
+	if code := status("/readyz"); code != http.StatusOK {
+		t.Errorf("GET /readyz = %d, want %d", code, http.StatusOK)
+	}
+
+	// once draining, the server is alive but takes no new requests
+	app.draining.Store(true)
+
+	if code := status("/healthz"); code != http.StatusOK {
+		t.Errorf("GET /healthz while draining = %d, want %d", code, http.StatusOK)
+	}
+
+	if code := status("/readyz"); code != http.StatusServiceUnavailable {
+		t.Errorf("GET /readyz while draining = %d, want %d", code, http.StatusServiceUnavailable)
+	}
+}
+
+func TestReadinessTablesInSeedDump(t *testing.T) {
+
+	dump, err := os.ReadFile("../../sql/create_tables.sql")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// docker compose seeds the database from the dump, which must have every table the API needs to be ready
+	for _, table := range readinessTables {
+		if !strings.Contains(string(dump), "CREATE TABLE public."+table+" (") {
+			t.Errorf("the seed dump has no %s table", table)
+		}
+	}
+}
@@ -63,7 +63,9 @@ func (app *application) readJSON(w http.ResponseWriter, r *http.Request, data an
 	return nil
 }
 
-func (app *application) errorJSON(w http.ResponseWriter, err error, status ...int) error {
+// errorJSON writes the error as a JSON response, 400 Bad Request unless another status is given, and logs it
+// with the id of the request.
+func (app *application) errorJSON(w http.ResponseWriter, r *http.Request, err error, status ...int) error {
 
 	statusCode := http.StatusBadRequest
 
@@ -71,11 +73,19 @@ func (app *application) errorJSON(w http.ResponseWriter, err error, status ...in
 		statusCode = status[0]
 	}
 
+	app.logError(r, statusCode, err)
+
 	var payload JSONResponse
 
 	payload.Error = true
 	payload.Message = err.Error()
 
-	return app.writeJSON(w, statusCode, payload, nil)
+	err = app.writeJSON(w, statusCode, payload, nil)
+	if err != nil {
+		app.logger(r.Context()).Error("writing the error response failed", "error", err)
+		return err
+	}
+
+	return nil
 
 }
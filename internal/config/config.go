@@ -0,0 +1,307 @@
+// Package config loads the configuration of the API. Settings come from defaults, a YAML file, environment
+// variables and command line flags, each overriding the previous ones. Secrets can also be read from files,
+// such as Kubernetes secret mounts.
+package config
+
+import (
+	"errors"
+	"fmt"
+	"github.com/calvarado2004/go-movies-backend/internal/posters"
+	"log/slog"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// Environments the API runs in. Insecure defaults are only accepted in development.
+const (
+	Development = "development"
+	Production  = "production"
+)
+
+// minSecretLength is the minimum length of the JWT secret outside development, which rules out the default
+// one. HS256 keys should be at least 32 bytes.
+const minSecretLength = 32
+
+// Config is the configuration of the API.
+type Config struct {
+	Env         string
+	Port        int
+	Domain      string
+	LogLevel    string
+	FrontendURL string
+	DB          DB
+	HTTP        HTTP
+	Auth        Auth
+	Login       Login
+	CORS        CORS
+	TMDB        TMDB
+	Images      Images
+	Mail        Mail
+	Tracing     Tracing
+}
+
+// DB configures the repository and its database.
+type DB struct {
+	// Repo is postgres or memory.
+	Repo string
+	// DSN is the PostgreSQL DSN, built from the other settings when empty.
+	DSN      string
+	Server   string
+	Port     int
+	Name     string
+	User     string
+	Password string
+	Timeout  time.Duration
+	// Migrate applies the pending migrations at startup.
+	Migrate bool
+}
+
+// HTTP configures the web server.
+type HTTP struct {
+	RequestTimeout time.Duration
+	DrainDelay     time.Duration
+	// TrustedProxies are the addresses and CIDR ranges of the reverse proxies whose X-Forwarded-For header
+	// tells the address of the client.
+	TrustedProxies []string
+}
+
+// Auth configures the JWTs and the refresh token cookie.
+type Auth struct {
+	// JWTKeysFile is the manifest of the keys signing the JWTs, JWTSecret signs them with HS256 when empty.
+	JWTKeysFile string
+	JWTSecret   string
+	JWTIssuer   string
+	JWTAudience string
+	// Leeway tolerates clock skew when checking the times of tokens.
+	Leeway        time.Duration
+	TokenExpiry   time.Duration
+	RefreshExpiry time.Duration
+	CookieName    string
+	CookieDomain  string
+}
+
+// Login configures the protection of the logins against password guessing.
+type Login struct {
+	// MaxFailures is the number of failed logins locking an account out for Lockout, MaxIPFailures the number
+	// locking out a client IP address.
+	MaxFailures   int
+	MaxIPFailures int
+	Lockout       time.Duration
+	// AccountRate and IPRate are the login attempts allowed per minute for an account and a client IP address.
+	AccountRate int
+	IPRate      int
+}
+
+// CORS configures the cross-origin resource sharing policy.
+type CORS struct {
+	// Origins are allowed to call the API, the frontend when empty.
+	Origins        []string
+	ExposedHeaders []string
+	MaxAge         time.Duration
+}
+
+// TMDB configures the poster lookups.
+type TMDB struct {
+	APIKey   string
+	URL      string
+	ImageURL string
+}
+
+// Images configures the poster image store.
+type Images struct {
+	// Store is fs or s3.
+	Store string
+	Dir   string
+	S3    S3
+}
+
+// S3 configures the s3 image store.
+type S3 struct {
+	Endpoint  string
+	Region    string
+	Bucket    string
+	AccessKey string
+	SecretKey string
+}
+
+// Mail configures the email sender.
+type Mail struct {
+	// Sender is log, file or smtp.
+	Sender string
+	Dir    string
+	From   string
+	SMTP   SMTP
+}
+
+// SMTP configures the smtp email sender.
+type SMTP struct {
+	Host     string
+	Port     int
+	Username string
+	Password string
+}
+
+// Tracing configures the span exporter.
+type Tracing struct {
+	// Exporter is none, otlp or stdout.
+	Exporter    string
+	Endpoint    string
+	Insecure    bool
+	SampleRatio float64
+}
+
+// Default returns the default configuration. Its well-known JWT secret and localhost hostnames are only
+// accepted in development, which has to be chosen explicitly.
+func Default() *Config {
+	return &Config{
+		Env:         Production,
+		Port:        8080,
+		LogLevel:    "info",
+		FrontendURL: "http://localhost:3000",
+		DB: DB{
+			Repo:    "postgres",
+			Server:  "localhost",
+			Port:    5432,
+			Name:    "movies",
+			User:    "postgres",
+			Timeout: 5 * time.Second,
+		},
+		HTTP: HTTP{
+			RequestTimeout: 30 * time.Second,
+			DrainDelay:     5 * time.Second,
+		},
+		Auth: Auth{
+			JWTSecret:     "verysecret",
+			JWTIssuer:     "localhost",
+			JWTAudience:   "localhost",
+			Leeway:        30 * time.Second,
+			TokenExpiry:   15 * time.Minute,
+			RefreshExpiry: 24 * time.Hour,
+			CookieName:    "jwt-refresh_token",
+			CookieDomain:  "localhost",
+		},
+		Login: Login{
+			MaxFailures:   10,
+			MaxIPFailures: 100,
+			Lockout:       15 * time.Minute,
+			AccountRate:   5,
+			IPRate:        20,
+		},
+		CORS: CORS{
+			ExposedHeaders: []string{"X-Request-ID"},
+			MaxAge:         10 * time.Minute,
+		},
+		TMDB: TMDB{
+			URL:      posters.DefaultTMDBURL,
+			ImageURL: "https://image.tmdb.org/t/p/original",
+		},
+		Images: Images{
+			Store: "fs",
+			Dir:   "images",
+			S3:    S3{Region: "us-east-1"},
+		},
+		Mail: Mail{
+			Sender: "log",
+			Dir:    "mail",
+			From:   "Go Movies <no-reply@localhost>",
+			SMTP:   SMTP{Port: 587},
+		},
+		Tracing: Tracing{
+			Exporter:    "none",
+			SampleRatio: 1,
+		},
+	}
+}
+
+// DSN returns the PostgreSQL DSN.
+func (c *Config) DSN() string {
+
+	if c.DB.DSN != "" {
+		return c.DB.DSN
+	}
+
+	return fmt.Sprintf("host=%s port=%d user=%s password=%s dbname=%s sslmode=disable timezone=UTC connect_timeout=5",
+		c.DB.Server, c.DB.Port, c.DB.User, c.DB.Password, c.DB.Name)
+}
+
+// Validate reports every invalid setting. Outside development it also refuses the insecure defaults, the
+// well-known JWT secret and the localhost hostnames.
+func (c *Config) Validate() error {
+
+	var errs []error
+
+	check := func(ok bool, format string, args ...any) {
+		if !ok {
+			errs = append(errs, fmt.Errorf(format, args...))
+		}
+	}
+
+	oneOf := func(name, value string, allowed ...string) {
+		for _, a := range allowed {
+			if value == a {
+				return
+			}
+		}
+		errs = append(errs, fmt.Errorf("%s is %q, want one of %s", name, value, strings.Join(allowed, ", ")))
+	}
+
+	oneOf("env", c.Env, Development, Production)
+	oneOf("db.repo", c.DB.Repo, "postgres", "memory")
+	oneOf("images.store", c.Images.Store, "fs", "s3")
+	oneOf("mail.sender", c.Mail.Sender, "log", "file", "smtp")
+	oneOf("tracing.exporter", c.Tracing.Exporter, "none", "otlp", "stdout")
+
+	var level slog.Level
+	check(level.UnmarshalText([]byte(c.LogLevel)) == nil, "log_level is %q, want debug, info, warn or error", c.LogLevel)
+
+	check(c.Port > 0 && c.Port < 1<<16, "port %d is out of range", c.Port)
+	check(c.DB.Timeout > 0, "db.timeout must be positive")
+	check(c.HTTP.RequestTimeout >= 0, "http.request_timeout must not be negative")
+	check(c.HTTP.DrainDelay >= 0, "http.drain_delay must not be negative")
+	check(c.Auth.Leeway >= 0 && c.Auth.Leeway < c.Auth.TokenExpiry, "auth.leeway must not be negative and be shorter than auth.token_expiry")
+	check(c.Auth.TokenExpiry > 0, "auth.token_expiry must be positive")
+	check(c.Auth.RefreshExpiry > c.Auth.TokenExpiry, "auth.refresh_expiry must be longer than auth.token_expiry")
+	check(c.Auth.CookieName != "", "auth.cookie_name is required")
+	check(c.Auth.JWTKeysFile != "" || c.Auth.JWTSecret != "", "auth.jwt_keys_file or auth.jwt_secret is required")
+	check(c.Login.MaxFailures > 0, "login.max_failures must be positive")
+	check(c.Login.MaxIPFailures > 0, "login.max_ip_failures must be positive")
+	check(c.Login.Lockout > 0, "login.lockout must be positive")
+	check(c.Login.AccountRate > 0, "login.account_rate must be positive")
+	check(c.Login.IPRate > 0, "login.ip_rate must be positive")
+	check(c.CORS.MaxAge >= 0, "cors.max_age must not be negative")
+	check(c.Tracing.SampleRatio >= 0 && c.Tracing.SampleRatio <= 1, "tracing.sample_ratio must be between 0 and 1")
+
+	if c.Images.Store == "s3" {
+		check(c.Images.S3.Bucket != "", "images.s3.bucket is required by the s3 image store")
+	}
+
+	if c.Mail.Sender == "smtp" {
+		check(c.Mail.SMTP.Host != "", "mail.smtp.host is required by the smtp sender")
+	}
+
+	u, err := url.Parse(c.FrontendURL)
+	check(err == nil && (u.Scheme == "http" || u.Scheme == "https") && u.Host != "", "frontend_url %q is not an absolute URL", c.FrontendURL)
+
+	if c.Env == Development {
+		return errors.Join(errs...)
+	}
+
+	if c.Auth.JWTKeysFile == "" {
+		check(len(c.Auth.JWTSecret) >= minSecretLength, "auth.jwt_secret must be at least %d bytes outside development", minSecretLength)
+	}
+
+	check(!isLocal(c.Auth.JWTIssuer), "auth.jwt_issuer must be set outside development")
+	check(!isLocal(c.Auth.JWTAudience), "auth.jwt_audience must be set outside development")
+	check(!isLocal(c.Auth.CookieDomain), "auth.cookie_domain must be set outside development")
+	check(err != nil || !isLocal(u.Hostname()), "frontend_url must be set outside development")
+	check(c.DB.Repo != "memory", "the memory repository is only allowed in development")
+
+	return errors.Join(errs...)
+}
+
+// isLocal reports whether a hostname is unset or only reachable from the machine itself.
+func isLocal(host string) bool {
+	host = strings.ToLower(host)
+	return host == "" || host == "localhost" || host == "127.0.0.1" || host == "::1" || strings.HasSuffix(host, ".localhost")
+}
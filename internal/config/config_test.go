@@ -0,0 +1,285 @@
+package config
+
+import (
+	"bytes"
+	"gopkg.in/yaml.v3"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// env returns a lookupEnv function reading vars.
+func env(vars map[string]string) func(string) (string, bool) {
+	return func(key string) (string, bool) {
+		value, ok := vars[key]
+		return value, ok
+	}
+}
+
+// writeFile writes content to a file of the test's temporary directory and returns its path.
+func writeFile(t *testing.T, name, content string) string {
+	t.Helper()
+
+	path := filepath.Join(t.TempDir(), name)
+
+	err := os.WriteFile(path, []byte(content), 0o600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return path
+}
+
+func TestLoadPrecedence(t *testing.T) {
+
+	path := writeFile(t, "config.yaml", `
+port: 9000
+log_level: debug
+db:
+  server: db.file
+  timeout: 2s
+  name: from-file
+auth:
+  jwt_issuer: issuer.file
+cors:
+  origins:
+    - https://movies.example.com
+    - https://*.staging.example.com
+`)
+
+	vars := map[string]string{
+		"CONFIG_FILE": path,
+		"DB_SERVER":   "db.env",
+		"JWT_ISSUER":  "issuer.env",
+	}
+
+	c, args, err := Load("api", []string{"-jwt-issuer", "issuer.flag", "-migrate", "migrate", "up"}, io.Discard, env(vars))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if strings.Join(args, " ") != "migrate up" {
+		t.Errorf("args = %v, want [migrate up]", args)
+	}
+
+	tests := []struct {
+		name      string
+		got, want any
+	}{
+		{"default", c.Mail.Sender, "log"},
+		{"file over default", c.Port, 9000},
+		{"file over default", c.DB.Timeout, 2 * time.Second},
+		{"file over default", c.DB.Name, "from-file"},
+		{"env over file", c.DB.Server, "db.env"},
+		{"flag over env", c.Auth.JWTIssuer, "issuer.flag"},
+		{"bool flag", c.DB.Migrate, true},
+		{"list", strings.Join(c.CORS.Origins, ","), "https://movies.example.com,https://*.staging.example.com"},
+	}
+
+	for _, tt := range tests {
+		if tt.got != tt.want {
+			t.Errorf("%s: got %v, want %v", tt.name, tt.got, tt.want)
+		}
+	}
+
+	// the -config flag wins over CONFIG_FILE
+	other := writeFile(t, "other.yaml", "port: 9100\n")
+
+	c, _, err = Load("api", []string{"-config", other}, io.Discard, env(vars))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if c.Port != 9100 {
+		t.Errorf("port = %d, want the one of the -config file", c.Port)
+	}
+}
+
+func TestLoadDefaults(t *testing.T) {
+
+	c, _, err := Load("api", nil, io.Discard, env(map[string]string{"FRONTEND_MOVIES": "https://movies.example.com"}))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if strings.Join(c.CORS.Origins, ",") != "https://movies.example.com" {
+		t.Errorf("CORS origins = %v, want the frontend", c.CORS.Origins)
+	}
+
+	if !strings.Contains(c.DSN(), "host=localhost port=5432 user=postgres") {
+		t.Errorf("DSN() = %q, want one built from the db settings", c.DSN())
+	}
+}
+
+func TestLoadSecretFiles(t *testing.T) {
+
+	secret := strings.Repeat("s", 40)
+	secretPath := writeFile(t, "jwt-secret", secret+"\n")
+	passwordPath := writeFile(t, "db-password", "p4ss\n")
+	apiKeyPath := writeFile(t, "api-key", "key")
+
+	path := writeFile(t, "config.yaml", "db:\n  password_file: "+passwordPath+"\n")
+
+	c, _, err := Load("api", []string{"-config", path, "-api-key-file", apiKeyPath}, io.Discard,
+		env(map[string]string{"JWT_SECRET_FILE": secretPath}))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if c.Auth.JWTSecret != secret {
+		t.Errorf("JWT secret = %q, want the content of JWT_SECRET_FILE without the newline", c.Auth.JWTSecret)
+	}
+	if c.DB.Password != "p4ss" {
+		t.Errorf("DB password = %q, want the content of db.password_file", c.DB.Password)
+	}
+	if c.TMDB.APIKey != "key" {
+		t.Errorf("API key = %q, want the content of -api-key-file", c.TMDB.APIKey)
+	}
+
+	_, _, err = Load("api", nil, io.Discard, env(map[string]string{"JWT_SECRET_FILE": filepath.Join(t.TempDir(), "missing")}))
+	if err == nil {
+		t.Error("a missing secret file was ignored")
+	}
+}
+
+func TestLoadErrors(t *testing.T) {
+
+	tests := []struct {
+		name string
+		file string
+		args []string
+		vars map[string]string
+	}{
+		{name: "unknown setting", file: "db:\n  hots: localhost\n"},
+		{name: "bad duration in file", file: "db:\n  timeout: soon\n"},
+		{name: "bad YAML", file: "port: [\n"},
+		{name: "bad number in env", vars: map[string]string{"PORT": "eighty"}},
+		{name: "unknown flag", args: []string{"-nope"}},
+	}
+
+	for _, tt := range tests {
+		args := tt.args
+		if tt.file != "" {
+			args = append([]string{"-config", writeFile(t, "config.yaml", tt.file)}, args...)
+		}
+
+		_, _, err := Load("api", args, io.Discard, env(tt.vars))
+		if err == nil {
+			t.Errorf("%s: Load succeeded, want an error", tt.name)
+		}
+	}
+}
+
+func TestValidate(t *testing.T) {
+
+	development := Default()
+	development.Env = Development
+
+	err := development.Validate()
+	if err != nil {
+		t.Errorf("the defaults are refused in development: %v", err)
+	}
+
+	// outside development, the insecure defaults are refused
+	err = Default().Validate()
+	if err == nil {
+		t.Fatal("the defaults are accepted in production")
+	}
+
+	for _, want := range []string{"auth.jwt_secret", "auth.jwt_issuer", "auth.jwt_audience", "auth.cookie_domain", "frontend_url"} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("error %q does not mention %s", err, want)
+		}
+	}
+
+	production := Default()
+	production.Auth.JWTSecret = strings.Repeat("s", 32)
+	production.Auth.JWTIssuer = "api.example.com"
+	production.Auth.JWTAudience = "movies.example.com"
+	production.Auth.CookieDomain = "example.com"
+	production.FrontendURL = "https://movies.example.com"
+
+	err = production.Validate()
+	if err != nil {
+		t.Errorf("a production configuration is refused: %v", err)
+	}
+
+	invalid := *production
+	invalid.Env = "staging"
+	invalid.Images.Store = "s3"
+	invalid.Tracing.SampleRatio = 2
+
+	err = invalid.Validate()
+	if err == nil {
+		t.Fatal("an invalid configuration is accepted")
+	}
+
+	for _, want := range []string{"env", "images.s3.bucket", "tracing.sample_ratio"} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("error %q does not mention %s", err, want)
+		}
+	}
+}
+
+func TestPrint(t *testing.T) {
+
+	c := Default()
+	c.Auth.JWTSecret = "top secret"
+	c.DB.Password = ""
+	c.CORS.Origins = []string{"https://movies.example.com"}
+
+	var buf bytes.Buffer
+
+	err := c.Print(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if strings.Contains(buf.String(), "top secret") {
+		t.Errorf("the JWT secret is printed:\n%s", buf.String())
+	}
+
+	// the output is a config file giving the same configuration, secrets aside
+	var printed struct {
+		Port int
+		Auth struct {
+			JWTSecret string `yaml:"jwt_secret"`
+		}
+		DB struct {
+			Password string
+			Timeout  string
+		}
+		CORS struct {
+			Origins []string
+		}
+	}
+
+	err = yaml.Unmarshal(buf.Bytes(), &printed)
+	if err != nil {
+		t.Fatalf("the output is not YAML: %v\n%s", err, buf.String())
+	}
+
+	if printed.Auth.JWTSecret != redacted {
+		t.Errorf("jwt_secret = %q, want %q", printed.Auth.JWTSecret, redacted)
+	}
+	if printed.DB.Password != "" {
+		t.Errorf("password = %q, want it empty as it is not set", printed.DB.Password)
+	}
+	if printed.Port != 8080 || printed.DB.Timeout != "5s" || len(printed.CORS.Origins) != 1 {
+		t.Errorf("printed %+v, want the configuration", printed)
+	}
+
+	path := writeFile(t, "printed.yaml", strings.Replace(buf.String(), redacted, "", -1))
+
+	reloaded, _, err := Load("api", []string{"-config", path}, io.Discard, env(nil))
+	if err != nil {
+		t.Fatalf("the output can't be loaded back: %v", err)
+	}
+
+	if reloaded.Port != c.Port || reloaded.Auth.TokenExpiry != c.Auth.TokenExpiry || reloaded.CORS.Origins[0] != c.CORS.Origins[0] {
+		t.Errorf("reloaded %+v, want %+v", reloaded, c)
+	}
+}
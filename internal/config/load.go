@@ -0,0 +1,337 @@
+package config
+
+import (
+	"flag"
+	"fmt"
+	"gopkg.in/yaml.v3"
+	"io"
+	"os"
+	"sort"
+	"strings"
+)
+
+// fileEnv is the environment variable naming the config file when the -config flag isn't given.
+const fileEnv = "CONFIG_FILE"
+
+// redacted replaces the secrets set in the printed configuration.
+const redacted = "[redacted]"
+
+// setting binds a field of Config to its key in the config file, its environment variable and its flag.
+// Secrets can also be read from a file, named by the key with a _file suffix, the variable with a _FILE
+// suffix or the flag with a -file suffix.
+type setting struct {
+	key    string
+	env    string
+	flag   string
+	usage  string
+	secret bool
+	value  value
+}
+
+// value is a field of Config that can be set from text.
+type value interface {
+	flag.Value
+	// tag is the YAML tag of the value when printed, if any.
+	tag() string
+}
+
+// settings returns the settings of c, in the order they are printed.
+func (c *Config) settings() []setting {
+	return []setting{
+		{key: "env", env: "APP_ENV", flag: "env", usage: "Environment: development or production", value: (*stringValue)(&c.Env)},
+		{key: "port", env: "PORT", flag: "port", usage: "Port the web server listens on", value: (*intValue)(&c.Port)},
+		{key: "domain", env: "DOMAIN", flag: "domain", usage: "Domain", value: (*stringValue)(&c.Domain)},
+		{key: "log_level", env: "LOG_LEVEL", flag: "log-level", usage: "Minimum level of the logs: debug, info, warn or error", value: (*stringValue)(&c.LogLevel)},
+		{key: "frontend_url", env: "FRONTEND_MOVIES", flag: "frontend-url", usage: "Frontend URL used in email links and allowed by CORS", value: (*stringValue)(&c.FrontendURL)},
+
+		{key: "db.repo", env: "DB_REPO", flag: "repo", usage: "Repository: postgres or memory", value: (*stringValue)(&c.DB.Repo)},
+		{key: "db.dsn", env: "DB_DSN", flag: "dsn", usage: "PostgreSQL DSN, built from the other db settings when empty", secret: true, value: (*stringValue)(&c.DB.DSN)},
+		{key: "db.server", env: "DB_SERVER", flag: "db-server", usage: "PostgreSQL host", value: (*stringValue)(&c.DB.Server)},
+		{key: "db.port", env: "DB_PORT", flag: "db-port", usage: "PostgreSQL port", value: (*intValue)(&c.DB.Port)},
+		{key: "db.name", env: "DB_NAME", flag: "db-name", usage: "PostgreSQL database", value: (*stringValue)(&c.DB.Name)},
+		{key: "db.user", env: "DB_USER", flag: "db-user", usage: "PostgreSQL user", value: (*stringValue)(&c.DB.User)},
+		{key: "db.password", env: "DB_PASSWORD", flag: "db-password", usage: "PostgreSQL password", secret: true, value: (*stringValue)(&c.DB.Password)},
+		{key: "db.timeout", env: "DB_TIMEOUT", flag: "db-timeout", usage: "Maximum duration of a database operation", value: (*durationValue)(&c.DB.Timeout)},
+		{key: "db.migrate", env: "DB_MIGRATE", flag: "migrate", usage: "Apply pending database migrations at startup", value: (*boolValue)(&c.DB.Migrate)},
+
+		{key: "http.request_timeout", env: "REQUEST_TIMEOUT", flag: "request-timeout", usage: "Deadline of the database work of a request, 0 for none", value: (*durationValue)(&c.HTTP.RequestTimeout)},
+		{key: "http.drain_delay", env: "DRAIN_DELAY", flag: "drain-delay", usage: "Time between failing readiness and shutting down on SIGTERM", value: (*durationValue)(&c.HTTP.DrainDelay)},
+		{key: "http.trusted_proxies", env: "TRUSTED_PROXIES", flag: "trusted-proxies", usage: "Comma separated addresses or CIDR ranges of the proxies trusted to set X-Forwarded-For", value: (*listValue)(&c.HTTP.TrustedProxies)},
+
+		{key: "auth.jwt_keys_file", env: "JWT_KEYS_FILE", flag: "jwt-keys-file", usage: "Manifest of the RS256 or EdDSA keys signing the JWTs, jwt-secret signs them with HS256 when empty", value: (*stringValue)(&c.Auth.JWTKeysFile)},
+		{key: "auth.jwt_secret", env: "JWT_SECRET", flag: "jwt-secret", usage: "Secret signing the JWTs", secret: true, value: (*stringValue)(&c.Auth.JWTSecret)},
+		{key: "auth.jwt_issuer", env: "JWT_ISSUER", flag: "jwt-issuer", usage: "Issuer of the JWTs", value: (*stringValue)(&c.Auth.JWTIssuer)},
+		{key: "auth.jwt_audience", env: "JWT_AUDIENCE", flag: "jwt-audience", usage: "Audience of the JWTs", value: (*stringValue)(&c.Auth.JWTAudience)},
+		{key: "auth.leeway", env: "JWT_LEEWAY", flag: "jwt-leeway", usage: "Clock skew tolerated when checking the expiry and not before times of tokens", value: (*durationValue)(&c.Auth.Leeway)},
+		{key: "auth.token_expiry", env: "TOKEN_EXPIRY", flag: "token-expiry", usage: "Lifetime of access tokens", value: (*durationValue)(&c.Auth.TokenExpiry)},
+		{key: "auth.refresh_expiry", env: "REFRESH_EXPIRY", flag: "refresh-expiry", usage: "Lifetime of refresh tokens", value: (*durationValue)(&c.Auth.RefreshExpiry)},
+		{key: "auth.cookie_name", env: "COOKIE_NAME", flag: "cookie-name", usage: "Name of the refresh token cookie", value: (*stringValue)(&c.Auth.CookieName)},
+		{key: "auth.cookie_domain", env: "COOKIE_DOMAIN", flag: "cookie-domain", usage: "Domain of the refresh token cookie", value: (*stringValue)(&c.Auth.CookieDomain)},
+
+		{key: "login.max_failures", env: "LOGIN_MAX_FAILURES", flag: "login-max-failures", usage: "Failed logins locking an account out", value: (*intValue)(&c.Login.MaxFailures)},
+		{key: "login.max_ip_failures", env: "LOGIN_MAX_IP_FAILURES", flag: "login-max-ip-failures", usage: "Failed logins locking a client IP address out", value: (*intValue)(&c.Login.MaxIPFailures)},
+		{key: "login.lockout", env: "LOGIN_LOCKOUT", flag: "login-lockout", usage: "How long logins stay locked out", value: (*durationValue)(&c.Login.Lockout)},
+		{key: "login.account_rate", env: "LOGIN_ACCOUNT_RATE", flag: "login-account-rate", usage: "Login attempts allowed per minute for an account", value: (*intValue)(&c.Login.AccountRate)},
+		{key: "login.ip_rate", env: "LOGIN_IP_RATE", flag: "login-ip-rate", usage: "Login attempts allowed per minute for a client IP address", value: (*intValue)(&c.Login.IPRate)},
+
+		{key: "cors.origins", env: "CORS_ORIGINS", flag: "cors-origins", usage: "Comma separated origins allowed to call the API, such as https://*.example.com, the frontend when empty", value: (*listValue)(&c.CORS.Origins)},
+		{key: "cors.exposed_headers", env: "CORS_EXPOSED_HEADERS", flag: "cors-exposed-headers", usage: "Comma separated response headers readable from other origins", value: (*listValue)(&c.CORS.ExposedHeaders)},
+		{key: "cors.max_age", env: "CORS_MAX_AGE", flag: "cors-max-age", usage: "How long browsers may cache preflight responses", value: (*durationValue)(&c.CORS.MaxAge)},
+
+		{key: "tmdb.api_key", env: "API_MOVIES_KEY", flag: "api-key", usage: "TMDB API key, posters are not looked up without one", secret: true, value: (*stringValue)(&c.TMDB.APIKey)},
+		{key: "tmdb.url", env: "TMDB_URL", flag: "tmdb-url", usage: "TMDB API base URL used to look up posters", value: (*stringValue)(&c.TMDB.URL)},
+		{key: "tmdb.image_url", env: "TMDB_IMAGE_URL", flag: "tmdb-image-url", usage: "TMDB base URL posters are downloaded from", value: (*stringValue)(&c.TMDB.ImageURL)},
+
+		{key: "images.store", env: "IMAGE_STORE", flag: "image-store", usage: "Poster image store: fs or s3", value: (*stringValue)(&c.Images.Store)},
+		{key: "images.dir", env: "IMAGE_DIR", flag: "image-dir", usage: "Directory the fs image store writes to", value: (*stringValue)(&c.Images.Dir)},
+		{key: "images.s3.endpoint", env: "S3_ENDPOINT", flag: "s3-endpoint", usage: "S3 compatible endpoint of the s3 image store", value: (*stringValue)(&c.Images.S3.Endpoint)},
+		{key: "images.s3.region", env: "S3_REGION", flag: "s3-region", usage: "S3 region", value: (*stringValue)(&c.Images.S3.Region)},
+		{key: "images.s3.bucket", env: "S3_BUCKET", flag: "s3-bucket", usage: "S3 bucket", value: (*stringValue)(&c.Images.S3.Bucket)},
+		{key: "images.s3.access_key", env: "S3_ACCESS_KEY", flag: "s3-access-key", usage: "S3 access key", secret: true, value: (*stringValue)(&c.Images.S3.AccessKey)},
+		{key: "images.s3.secret_key", env: "S3_SECRET_KEY", flag: "s3-secret-key", usage: "S3 secret key", secret: true, value: (*stringValue)(&c.Images.S3.SecretKey)},
+
+		{key: "mail.sender", env: "MAILER", flag: "mailer", usage: "Mail sender: log, file or smtp", value: (*stringValue)(&c.Mail.Sender)},
+		{key: "mail.dir", env: "MAIL_DIR", flag: "mail-dir", usage: "Directory the file mail sender writes to", value: (*stringValue)(&c.Mail.Dir)},
+		{key: "mail.from", env: "MAIL_FROM", flag: "mail-from", usage: "Sender address of emails", value: (*stringValue)(&c.Mail.From)},
+		{key: "mail.smtp.host", env: "SMTP_HOST", flag: "smtp-host", usage: "SMTP host", value: (*stringValue)(&c.Mail.SMTP.Host)},
+		{key: "mail.smtp.port", env: "SMTP_PORT", flag: "smtp-port", usage: "SMTP port", value: (*intValue)(&c.Mail.SMTP.Port)},
+		{key: "mail.smtp.username", env: "SMTP_USERNAME", flag: "smtp-username", usage: "SMTP username", value: (*stringValue)(&c.Mail.SMTP.Username)},
+		{key: "mail.smtp.password", env: "SMTP_PASSWORD", flag: "smtp-password", usage: "SMTP password", secret: true, value: (*stringValue)(&c.Mail.SMTP.Password)},
+
+		{key: "tracing.exporter", env: "TRACING", flag: "tracing", usage: "Span exporter: none, otlp or stdout", value: (*stringValue)(&c.Tracing.Exporter)},
+		{key: "tracing.endpoint", env: "TRACING_ENDPOINT", flag: "tracing-endpoint", usage: "OTLP/HTTP collector host:port, defaults to OTEL_EXPORTER_OTLP_ENDPOINT", value: (*stringValue)(&c.Tracing.Endpoint)},
+		{key: "tracing.insecure", env: "TRACING_INSECURE", flag: "tracing-insecure", usage: "Send spans to the collector over plain HTTP", value: (*boolValue)(&c.Tracing.Insecure)},
+		{key: "tracing.sample_ratio", env: "TRACING_SAMPLE_RATIO", flag: "tracing-sample-ratio", usage: "Ratio of the traces started by the API that are sampled", value: (*floatValue)(&c.Tracing.SampleRatio)},
+	}
+}
+
+// Load returns the configuration given by the config file, the environment and args, the command line
+// arguments without the program name, along with the arguments left after the flags. The config file is
+// named by the -config flag or the CONFIG_FILE variable. Usage and flag errors are written to output, and
+// flag.ErrHelp is returned when the usage was asked for. lookupEnv is os.LookupEnv outside tests.
+func Load(name string, args []string, output io.Writer, lookupEnv func(string) (string, bool)) (*Config, []string, error) {
+
+	// a first pass over the flags finds the config file, and reports bad flags before anything is read
+	var path string
+
+	fs := Default().flagSet(name, &path)
+	fs.SetOutput(output)
+
+	err := fs.Parse(args)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if path == "" {
+		path, _ = lookupEnv(fileEnv)
+	}
+
+	c := Default()
+
+	if path != "" {
+		err = c.loadFile(path)
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+
+	err = c.loadEnv(lookupEnv)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// flags were already checked, the second pass only sets the ones given over the file and environment
+	fs = c.flagSet(name, &path)
+	fs.SetOutput(io.Discard)
+
+	err = fs.Parse(args)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if len(c.CORS.Origins) == 0 && c.FrontendURL != "" {
+		c.CORS.Origins = []string{c.FrontendURL}
+	}
+
+	return c, fs.Args(), nil
+}
+
+// flagSet returns the flags setting c, and the -config flag setting path.
+func (c *Config) flagSet(name string, path *string) *flag.FlagSet {
+
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+
+	fs.StringVar(path, "config", "", "YAML config file, defaults to "+fileEnv)
+
+	for _, s := range c.settings() {
+		fs.Var(s.value, s.flag, s.usage+" ("+s.env+")")
+		if s.secret {
+			fs.Var(&fileValue{s.value}, s.flag+"-file", "File holding the "+s.flag+" setting ("+s.env+"_FILE)")
+		}
+	}
+
+	return fs
+}
+
+// loadEnv sets the settings given by environment variables.
+func (c *Config) loadEnv(lookupEnv func(string) (string, bool)) error {
+
+	for _, s := range c.settings() {
+		if text, ok := lookupEnv(s.env); ok {
+			err := s.value.Set(text)
+			if err != nil {
+				return fmt.Errorf("%s: %w", s.env, err)
+			}
+		}
+
+		if !s.secret {
+			continue
+		}
+
+		if path, ok := lookupEnv(s.env + "_FILE"); ok {
+			err := (&fileValue{s.value}).Set(path)
+			if err != nil {
+				return fmt.Errorf("%s_FILE: %w", s.env, err)
+			}
+		}
+	}
+
+	return nil
+}
+
+// loadFile sets the settings given by a YAML file. Unknown settings are errors, they usually are typos.
+func (c *Config) loadFile(path string) error {
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+
+	var tree map[string]any
+
+	err = yaml.Unmarshal(data, &tree)
+	if err != nil {
+		return fmt.Errorf("config file %s: %w", path, err)
+	}
+
+	values := make(map[string]value)
+	for _, s := range c.settings() {
+		values[s.key] = s.value
+		if s.secret {
+			values[s.key+"_file"] = &fileValue{s.value}
+		}
+	}
+
+	leaves := make(map[string]string)
+
+	err = flatten("", tree, leaves)
+	if err != nil {
+		return fmt.Errorf("config file %s: %w", path, err)
+	}
+
+	// set the settings in a stable order, so that a bad file always reports the same error
+	keys := make([]string, 0, len(leaves))
+	for key := range leaves {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		v, ok := values[key]
+		if !ok {
+			return fmt.Errorf("config file %s: unknown setting %q", path, key)
+		}
+
+		err := v.Set(leaves[key])
+		if err != nil {
+			return fmt.Errorf("config file %s: %s: %w", path, key, err)
+		}
+	}
+
+	return nil
+}
+
+// flatten adds the scalars of a YAML mapping to leaves, keyed by their dotted path. Sequences become comma
+// separated lists.
+func flatten(prefix string, tree map[string]any, leaves map[string]string) error {
+
+	for key, node := range tree {
+		if prefix != "" {
+			key = prefix + "." + key
+		}
+
+		switch node := node.(type) {
+		case nil:
+		case map[string]any:
+			err := flatten(key, node, leaves)
+			if err != nil {
+				return err
+			}
+		case []any:
+			items := make([]string, len(node))
+			for i, item := range node {
+				items[i] = fmt.Sprint(item)
+			}
+			leaves[key] = strings.Join(items, ",")
+		default:
+			leaves[key] = fmt.Sprint(node)
+		}
+	}
+
+	return nil
+}
+
+// Print writes the configuration as YAML, in the format of the config file, with the secrets redacted.
+func (c *Config) Print(w io.Writer) error {
+
+	root := &yaml.Node{Kind: yaml.MappingNode}
+
+	for _, s := range c.settings() {
+		parent := root
+		path := strings.Split(s.key, ".")
+
+		for _, section := range path[:len(path)-1] {
+			parent = child(parent, section)
+		}
+
+		node := &yaml.Node{Kind: yaml.ScalarNode, Tag: s.value.tag(), Value: s.value.String()}
+
+		if list, ok := s.value.(*listValue); ok {
+			node = &yaml.Node{Kind: yaml.SequenceNode}
+			for _, item := range *list {
+				node.Content = append(node.Content, &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: item})
+			}
+		}
+
+		if s.secret && node.Value != "" {
+			node.Value = redacted
+		}
+
+		parent.Content = append(parent.Content, &yaml.Node{Kind: yaml.ScalarNode, Value: path[len(path)-1]}, node)
+	}
+
+	enc := yaml.NewEncoder(w)
+	enc.SetIndent(2)
+
+	err := enc.Encode(root)
+	if err != nil {
+		return err
+	}
+
+	return enc.Close()
+}
+
+// child returns the mapping under key in a mapping node, adding it when missing.
+func child(parent *yaml.Node, key string) *yaml.Node {
+
+	for i := 0; i < len(parent.Content); i += 2 {
+		if parent.Content[i].Value == key {
+			return parent.Content[i+1]
+		}
+	}
+
+	node := &yaml.Node{Kind: yaml.MappingNode}
+	parent.Content = append(parent.Content, &yaml.Node{Kind: yaml.ScalarNode, Value: key}, node)
+
+	return node
+}
@@ -0,0 +1,193 @@
+package config
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// stringValue is a string setting.
+type stringValue string
+
+// Set sets the string.
+func (v *stringValue) Set(text string) error {
+	*v = stringValue(text)
+	return nil
+}
+
+// String returns the string.
+func (v *stringValue) String() string {
+	return string(*v)
+}
+
+// tag returns the YAML tag of strings.
+func (v *stringValue) tag() string {
+	return "!!str"
+}
+
+// intValue is an int setting.
+type intValue int
+
+// Set parses a decimal number.
+func (v *intValue) Set(text string) error {
+
+	n, err := strconv.Atoi(strings.TrimSpace(text))
+	if err != nil {
+		return fmt.Errorf("invalid number %q", text)
+	}
+
+	*v = intValue(n)
+
+	return nil
+}
+
+// String formats the number.
+func (v *intValue) String() string {
+	return strconv.Itoa(int(*v))
+}
+
+// tag returns no tag, the value is printed plain.
+func (v *intValue) tag() string {
+	return ""
+}
+
+// floatValue is a float64 setting.
+type floatValue float64
+
+// Set parses a number.
+func (v *floatValue) Set(text string) error {
+
+	f, err := strconv.ParseFloat(strings.TrimSpace(text), 64)
+	if err != nil {
+		return fmt.Errorf("invalid number %q", text)
+	}
+
+	*v = floatValue(f)
+
+	return nil
+}
+
+// String formats the number.
+func (v *floatValue) String() string {
+	return strconv.FormatFloat(float64(*v), 'g', -1, 64)
+}
+
+// tag returns no tag, the value is printed plain.
+func (v *floatValue) tag() string {
+	return ""
+}
+
+// boolValue is a bool setting. As a flag, it can be given without a value.
+type boolValue bool
+
+// Set parses a boolean such as true, false, 1 or 0.
+func (v *boolValue) Set(text string) error {
+
+	b, err := strconv.ParseBool(strings.TrimSpace(text))
+	if err != nil {
+		return fmt.Errorf("invalid boolean %q", text)
+	}
+
+	*v = boolValue(b)
+
+	return nil
+}
+
+// String formats the boolean.
+func (v *boolValue) String() string {
+	return strconv.FormatBool(bool(*v))
+}
+
+// tag returns no tag, the value is printed plain.
+func (v *boolValue) tag() string {
+	return ""
+}
+
+// IsBoolFlag tells the flag package that -flag means -flag=true.
+func (v *boolValue) IsBoolFlag() bool {
+	return true
+}
+
+// durationValue is a time.Duration setting.
+type durationValue time.Duration
+
+// Set parses a duration such as 1m30s.
+func (v *durationValue) Set(text string) error {
+
+	d, err := time.ParseDuration(strings.TrimSpace(text))
+	if err != nil {
+		return fmt.Errorf("invalid duration %q", text)
+	}
+
+	*v = durationValue(d)
+
+	return nil
+}
+
+// String formats the duration.
+func (v *durationValue) String() string {
+	return time.Duration(*v).String()
+}
+
+// tag returns the YAML tag of durations, which are printed as strings.
+func (v *durationValue) tag() string {
+	return "!!str"
+}
+
+// listValue is a list setting, given as a comma separated list.
+type listValue []string
+
+// Set splits a comma separated list, dropping blank items.
+func (v *listValue) Set(text string) error {
+
+	var items []string
+
+	for _, item := range strings.Split(text, ",") {
+		item = strings.TrimSpace(item)
+		if item != "" {
+			items = append(items, item)
+		}
+	}
+
+	*v = items
+
+	return nil
+}
+
+// String joins the list with commas.
+func (v *listValue) String() string {
+	return strings.Join(*v, ",")
+}
+
+// tag returns the YAML tag of lists.
+func (v *listValue) tag() string {
+	return "!!seq"
+}
+
+// fileValue sets a secret setting to the content of a file, without its trailing newline.
+type fileValue struct {
+	value value
+}
+
+// Set reads the file at path into the setting.
+func (v *fileValue) Set(path string) error {
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+
+	return v.value.Set(strings.TrimRight(string(data), "\r\n"))
+}
+
+// String returns nothing, the path isn't kept.
+func (v *fileValue) String() string {
+	return ""
+}
+
+// tag returns the YAML tag of paths.
+func (v *fileValue) tag() string {
+	return "!!str"
+}
@@ -1,24 +1,53 @@
 package graph
 
 import (
+	"context"
+	"database/sql"
 	"errors"
 	"github.com/calvarado2004/go-movies-backend/internal/models"
+	"github.com/calvarado2004/go-movies-backend/internal/repository"
 	"github.com/graphql-go/graphql"
-	"strings"
+	"github.com/graphql-go/graphql/language/ast"
+	"github.com/graphql-go/graphql/language/parser"
 )
 
+// Graph is the GraphQL schema of the movies catalog, resolved against the repository.
 type Graph struct {
-	Movies      []*models.Movie
-	QueryString string
-	Config      graphql.SchemaConfig
-	fields      graphql.Fields
-	movieType   *graphql.Object
+	Repo repository.DatabaseRepo
+
+	// MovieCreated, if set, is called after a mutation created a movie, to start the work done for new movies
+	// outside the repository such as looking up their poster.
+	MovieCreated func(ctx context.Context, movie models.Movie)
+
+	// DeleteMovie, if set, deletes movies in place of the repository, so that whatever is stored for them
+	// outside the repository such as their poster images is deleted along with them.
+	DeleteMovie func(ctx context.Context, id int) error
+
+	schema    graphql.Schema
+	movieType *graphql.Object
+	genreType *graphql.Object
 }
 
-// NewGraph creates a new Graphql object with the given movies
-func NewGraph(movies []*models.Movie) *Graph {
+// NewGraph builds the GraphQL schema once, its resolvers query the repository on demand.
+func NewGraph(repo repository.DatabaseRepo) (*Graph, error) {
+
+	g := &Graph{Repo: repo}
+
+	g.genreType = graphql.NewObject(
+		graphql.ObjectConfig{
+			Name: "Genre",
+			Fields: graphql.Fields{
+				"id": &graphql.Field{
+					Type: graphql.Int,
+				},
+				"genre": &graphql.Field{
+					Type: graphql.String,
+				},
+			},
+		},
+	)
 
-	var movieType = graphql.NewObject(
+	g.movieType = graphql.NewObject(
 		graphql.ObjectConfig{
 			Name: "Movie",
 			Fields: graphql.Fields{
@@ -50,46 +79,151 @@ func NewGraph(movies []*models.Movie) *Graph {
 					Type: graphql.String,
 				},
 				"genres": &graphql.Field{
-					Type: graphql.NewList(graphql.String),
+					Type:    graphql.NewList(g.genreType),
+					Resolve: g.resolveGenres,
+				},
+			},
+		},
+	)
+
+	rootQuery := graphql.ObjectConfig{Name: "RootQuery", Fields: g.queryFields()}
+	rootMutation := graphql.ObjectConfig{Name: "RootMutation", Fields: g.mutationFields()}
+	schemaConfig := graphql.SchemaConfig{Query: graphql.NewObject(rootQuery), Mutation: graphql.NewObject(rootMutation)}
+
+	schema, err := graphql.NewSchema(schemaConfig)
+	if err != nil {
+		return nil, err
+	}
+
+	g.schema = schema
+
+	return g, nil
+}
+
+// queryFields returns the fields of the root query.
+func (g *Graph) queryFields() graphql.Fields {
+
+	var searchResultType = graphql.NewObject(
+		graphql.ObjectConfig{
+			Name: "MovieSearchResult",
+			Fields: graphql.Fields{
+				"movie": &graphql.Field{
+					Type: g.movieType,
+					Resolve: func(params graphql.ResolveParams) (any, error) {
+						if result, ok := params.Source.(*models.MovieSearchResult); ok {
+							return &result.Movie, nil
+						}
+						return nil, nil
+					},
+				},
+				"rank": &graphql.Field{
+					Type: graphql.Float,
+				},
+				"snippet": &graphql.Field{
+					Type: graphql.String,
 				},
 			},
 		},
 	)
 
-	var fields = graphql.Fields{
+	return graphql.Fields{
 		"list": &graphql.Field{
-			Type:        graphql.NewList(movieType),
-			Description: "Get all movies",
+			Type:        graphql.NewList(g.movieType),
+			Description: "Get movies, all of them from the offset on unless a limit is given",
+			Args: graphql.FieldConfigArgument{
+				"limit": &graphql.ArgumentConfig{
+					Type:        graphql.Int,
+					Description: "Number of movies to return, at most 100",
+				},
+				"offset": &graphql.ArgumentConfig{
+					Type: graphql.Int,
+				},
+				"sort": &graphql.ArgumentConfig{
+					Type:        graphql.String,
+					Description: "One of title, release_date, runtime or created_at",
+				},
+				"desc": &graphql.ArgumentConfig{
+					Type: graphql.Boolean,
+				},
+				"genres": &graphql.ArgumentConfig{
+					Type: graphql.NewList(graphql.Int),
+				},
+				"ratings": &graphql.ArgumentConfig{
+					Type: graphql.NewList(graphql.String),
+				},
+			},
 			Resolve: func(params graphql.ResolveParams) (any, error) {
-				return movies, nil
+				query := repository.MovieQuery{}
+				query.Limit, _ = params.Args["limit"].(int)
+				query.Offset, _ = params.Args["offset"].(int)
+				query.Sort, _ = params.Args["sort"].(string)
+				query.Desc, _ = params.Args["desc"].(bool)
+				query.Genres = intArgs(params.Args["genres"])
+				query.MPAARatings = stringArgs(params.Args["ratings"])
+
+				return g.listMovies(params.Context, query)
 			},
 		},
 
 		"search": &graphql.Field{
-			Type:        graphql.NewList(movieType),
-			Description: "Search movies by title",
+			Type:        graphql.NewList(g.movieType),
+			Description: "Search movies by title, all the matches from the offset on unless a limit is given",
 			Args: graphql.FieldConfigArgument{
 				"titleContains": &graphql.ArgumentConfig{
 					Type: graphql.String,
 				},
+				"limit": &graphql.ArgumentConfig{
+					Type:        graphql.Int,
+					Description: "Number of movies to return, at most 100",
+				},
+				"offset": &graphql.ArgumentConfig{
+					Type: graphql.Int,
+				},
 			},
 			Resolve: func(params graphql.ResolveParams) (any, error) {
-				var filteredMovies []*models.Movie
-				titleContains, ok := params.Args["titleContains"].(string)
-				if ok {
-					for _, movie := range movies {
-						if strings.Contains(strings.ToLower(movie.Title), strings.ToLower(titleContains)) {
-							filteredMovies = append(filteredMovies, movie)
-						}
-					}
+				titleContains, _ := params.Args["titleContains"].(string)
+				if titleContains == "" {
+					return []*models.Movie{}, nil
 				}
 
-				return filteredMovies, nil
+				query := repository.MovieQuery{TitleContains: titleContains}
+				query.Limit, _ = params.Args["limit"].(int)
+				query.Offset, _ = params.Args["offset"].(int)
+
+				return g.listMovies(params.Context, query)
+			},
+		},
+
+		"searchMovies": &graphql.Field{
+			Type:        graphql.NewList(searchResultType),
+			Description: "Full-text search of movie titles and descriptions",
+			Args: graphql.FieldConfigArgument{
+				"query": &graphql.ArgumentConfig{
+					Type: graphql.NewNonNull(graphql.String),
+				},
+				"genres": &graphql.ArgumentConfig{
+					Type: graphql.NewList(graphql.Int),
+				},
+				"ratings": &graphql.ArgumentConfig{
+					Type: graphql.NewList(graphql.String),
+				},
+				"limit": &graphql.ArgumentConfig{
+					Type: graphql.Int,
+				},
+			},
+			Resolve: func(params graphql.ResolveParams) (any, error) {
+				search := repository.MovieSearch{}
+				search.Query, _ = params.Args["query"].(string)
+				search.Limit, _ = params.Args["limit"].(int)
+				search.Genres = intArgs(params.Args["genres"])
+				search.MPAARatings = stringArgs(params.Args["ratings"])
+
+				return g.Repo.SearchMovies(params.Context, search)
 			},
 		},
 
 		"get": &graphql.Field{
-			Type:        movieType,
+			Type:        g.movieType,
 			Description: "Get movie by id",
 			Args: graphql.FieldConfigArgument{
 				"id": &graphql.ArgumentConfig{
@@ -98,41 +232,162 @@ func NewGraph(movies []*models.Movie) *Graph {
 			},
 			Resolve: func(params graphql.ResolveParams) (any, error) {
 				id, ok := params.Args["id"].(int)
-				if ok {
-					for _, movie := range movies {
-						if movie.ID == id {
-							return movie, nil
-						}
-					}
+				if !ok {
+					return nil, nil
+				}
+
+				movie, err := g.Repo.OneMovie(params.Context, id)
+				if errors.Is(err, sql.ErrNoRows) {
+					return nil, nil
+				}
+				if err != nil {
+					return nil, err
 				}
 
-				return nil, nil
+				return movie, nil
 			},
 		},
 	}
+}
+
+// listMovies returns the movies of a query. Without a limit it returns every movie from the offset on, as list
+// and search did before they took one, fetching them a page at a time.
+func (g *Graph) listMovies(ctx context.Context, query repository.MovieQuery) ([]*models.Movie, error) {
+
+	if query.Limit > 0 {
+		movies, _, err := g.Repo.ListMovies(ctx, query)
+		return movies, err
+	}
+
+	query.Limit = repository.MaxMovieLimit
+
+	all := []*models.Movie{}
+
+	for {
+		movies, total, err := g.Repo.ListMovies(ctx, query)
+		if err != nil {
+			return nil, err
+		}
 
-	return &Graph{
-		Movies:    movies,
-		fields:    fields,
-		movieType: movieType,
+		all = append(all, movies...)
+		query.Offset += len(movies)
+
+		if len(movies) == 0 || query.Offset >= total {
+			return all, nil
+		}
 	}
 }
 
-// Query executes the given query string against the Graphql object
-func (g *Graph) Query() (*graphql.Result, error) {
+// resolveGenres resolves the genres of a movie, batching the lookups of every movie in the response.
+func (g *Graph) resolveGenres(params graphql.ResolveParams) (any, error) {
 
-	rootQuery := graphql.ObjectConfig{Name: "RootQuery", Fields: g.fields}
-	schemaConfig := graphql.SchemaConfig{Query: graphql.NewObject(rootQuery)}
-	schema, err := graphql.NewSchema(schemaConfig)
+	var movie *models.Movie
+
+	switch source := params.Source.(type) {
+	case *models.Movie:
+		movie = source
+	case models.Movie:
+		movie = &source
+	default:
+		return nil, nil
+	}
+
+	// OneMovie already loads the genres
+	if movie.Genres != nil {
+		return movie.Genres, nil
+	}
+
+	loader, ok := params.Context.Value(genreLoaderKey{}).(*genreLoader)
+	if !ok {
+		genres, err := g.Repo.GenresForMovies(params.Context, []int{movie.ID})
+		if err != nil {
+			return nil, err
+		}
+		return genres[movie.ID], nil
+	}
+
+	return loader.load(params.Context, movie.ID), nil
+}
+
+// Request is a GraphQL request, as sent by GraphQL-over-HTTP clients.
+type Request struct {
+	Query         string         `json:"query"`
+	OperationName string         `json:"operationName,omitempty"`
+	Variables     map[string]any `json:"variables,omitempty"`
+	Extensions    map[string]any `json:"extensions,omitempty"`
+}
+
+// Operation returns the type of the operation the request selects: query, mutation or subscription. It
+// returns an empty string when the document can't be parsed or has no such operation, execution reports why.
+func (r Request) Operation() string {
+
+	doc, err := parser.Parse(parser.ParseParams{Source: r.Query})
 	if err != nil {
-		return nil, err
+		return ""
+	}
+
+	var operations []*ast.OperationDefinition
+	for _, definition := range doc.Definitions {
+		if operation, ok := definition.(*ast.OperationDefinition); ok {
+			operations = append(operations, operation)
+		}
+	}
+
+	for _, operation := range operations {
+		if r.OperationName == "" && len(operations) == 1 {
+			return operation.Operation
+		}
+		if operation.Name != nil && operation.Name.Value == r.OperationName {
+			return operation.Operation
+		}
+	}
+
+	return ""
+}
+
+// Execute runs a request against the schema. Every error, whether the document is invalid or a resolver
+// failed, is reported in the errors of the result along with any partial data.
+func (g *Graph) Execute(ctx context.Context, req Request) *graphql.Result {
+
+	ctx = context.WithValue(ctx, genreLoaderKey{}, newGenreLoader(g.Repo))
+
+	params := graphql.Params{
+		Schema:         g.schema,
+		RequestString:  req.Query,
+		VariableValues: req.Variables,
+		OperationName:  req.OperationName,
+		Context:        ctx,
 	}
-	params := graphql.Params{Schema: schema, RequestString: g.QueryString}
-	result := graphql.Do(params)
-	if len(result.Errors) > 0 {
-		return nil, errors.New("error parsing GraphQL query: " + result.Errors[0].Message + " " + g.QueryString)
+
+	return graphql.Do(params)
+}
+
+// intArgs returns the integers of a list argument.
+func intArgs(arg any) []int {
+
+	var ints []int
+
+	values, _ := arg.([]any)
+	for _, value := range values {
+		if i, ok := value.(int); ok {
+			ints = append(ints, i)
+		}
 	}
 
-	return result, nil
+	return ints
+}
+
+// stringArgs returns the strings of a list argument.
+func stringArgs(arg any) []string {
+
+	var strs []string
+
+	values, _ := arg.([]any)
+	for _, value := range values {
+		if s, ok := value.(string); ok {
+			strs = append(strs, s)
+		}
+	}
 
+	return strs
 }
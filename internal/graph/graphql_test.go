@@ -0,0 +1,56 @@
+package graph
+
+import (
+	"context"
+	"fmt"
+	"github.com/calvarado2004/go-movies-backend/internal/models"
+	"github.com/calvarado2004/go-movies-backend/internal/repository/dbrepo"
+	"testing"
+	"time"
+)
+
+func TestListMovies(t *testing.T) {
+
+	repo := dbrepo.NewSeededMemoryDBRepo()
+
+	// more movies than fit in the largest page
+	for i := 0; i < 120; i++ {
+		_, err := repo.InsertMovie(context.Background(), models.Movie{
+			Title:       fmt.Sprintf("Sequel %03d", i),
+			ReleaseDate: time.Date(2000, 1, 1, 0, 0, 0, 0, time.UTC),
+			Runtime:     90,
+			MPAARating:  "PG",
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	g := newTestGraph(t, repo)
+
+	tests := []struct {
+		query string
+		want  int
+	}{
+		// without a limit every movie is returned
+		{`{ movies: list { id } }`, 123},
+		{`{ movies: list(offset: 100) { id } }`, 23},
+		{`{ movies: search(titleContains: "sequel") { id } }`, 120},
+		{`{ movies: list(limit: 5) { id } }`, 5},
+		{`{ movies: list(limit: 500) { id } }`, 100},
+		{`{ movies: search(titleContains: "sequel", limit: 5, offset: 118) { id } }`, 2},
+		{`{ movies: search(titleContains: "no such movie") { id } }`, 0},
+	}
+
+	for _, tt := range tests {
+		result := execute(g, "", tt.query, nil)
+		if len(result.Errors) != 0 {
+			t.Fatalf("%s: errors = %v", tt.query, result.Errors)
+		}
+
+		movies, ok := field(result, "movies").([]any)
+		if !ok || len(movies) != tt.want {
+			t.Errorf("%s = %d movies, want %d", tt.query, len(movies), tt.want)
+		}
+	}
+}
@@ -0,0 +1,72 @@
+package graph
+
+import (
+	"context"
+	"github.com/calvarado2004/go-movies-backend/internal/models"
+	"github.com/calvarado2004/go-movies-backend/internal/repository"
+	"sync"
+)
+
+// genreLoaderKey is the context key of the genreLoader of a query.
+type genreLoaderKey struct{}
+
+// genreLoader batches the genre lookups of the movies in a response into a single repository call. The
+// resolvers queue the movies and return thunks, which graphql-go only runs once every sibling field has
+// been resolved, so the first thunk loads the genres of the whole batch.
+type genreLoader struct {
+	repo    repository.DatabaseRepo
+	mu      sync.Mutex
+	pending []int
+	queued  map[int]bool
+	loaded  map[int][]*models.Genre
+	failed  map[int]error
+}
+
+// newGenreLoader returns a genreLoader for a single query.
+func newGenreLoader(repo repository.DatabaseRepo) *genreLoader {
+	return &genreLoader{
+		repo:   repo,
+		queued: map[int]bool{},
+		loaded: map[int][]*models.Genre{},
+		failed: map[int]error{},
+	}
+}
+
+// load queues a movie and returns a thunk resolving to its genres. The batch is loaded with the context of
+// the first thunk run.
+func (l *genreLoader) load(ctx context.Context, movieID int) func() (any, error) {
+
+	// a movie can appear several times in a response, it is loaded once
+	l.mu.Lock()
+	if !l.queued[movieID] {
+		l.queued[movieID] = true
+		l.pending = append(l.pending, movieID)
+	}
+	l.mu.Unlock()
+
+	return func() (any, error) {
+		l.mu.Lock()
+		defer l.mu.Unlock()
+
+		if len(l.pending) > 0 {
+			batch := l.pending
+			l.pending = nil
+
+			// every movie of a batch that failed to load reports the error
+			genres, err := l.repo.GenresForMovies(ctx, batch)
+			for _, id := range batch {
+				if err != nil {
+					l.failed[id] = err
+				} else {
+					l.loaded[id] = genres[id]
+				}
+			}
+		}
+
+		if err, ok := l.failed[movieID]; ok {
+			return nil, err
+		}
+
+		return l.loaded[movieID], nil
+	}
+}
@@ -0,0 +1,110 @@
+package graph
+
+import (
+	"context"
+	"github.com/calvarado2004/go-movies-backend/internal/models"
+	"github.com/calvarado2004/go-movies-backend/internal/repository"
+	"github.com/calvarado2004/go-movies-backend/internal/repository/dbrepo"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// countingRepo is a repository counting its GenresForMovies calls.
+type countingRepo struct {
+	repository.DatabaseRepo
+	mu    sync.Mutex
+	calls [][]int
+	err   error
+}
+
+// GenresForMovies records the movies of the call, and fails if err is set.
+func (r *countingRepo) GenresForMovies(ctx context.Context, movieIDs []int) (map[int][]*models.Genre, error) {
+	r.mu.Lock()
+	r.calls = append(r.calls, movieIDs)
+	r.mu.Unlock()
+
+	if r.err != nil {
+		return nil, r.err
+	}
+
+	return r.DatabaseRepo.GenresForMovies(ctx, movieIDs)
+}
+
+func TestGenreLoader(t *testing.T) {
+
+	repo := &countingRepo{DatabaseRepo: dbrepo.NewSeededMemoryDBRepo()}
+	g := newTestGraph(t, repo)
+
+	result := execute(g, "", `{ list(sort: "title") { title genres { genre } } }`, nil)
+	if len(result.Errors) != 0 {
+		t.Fatalf("errors = %v", result.Errors)
+	}
+
+	if len(repo.calls) != 1 || len(repo.calls[0]) != 3 {
+		t.Fatalf("GenresForMovies calls = %v, want a single call for the 3 movies", repo.calls)
+	}
+
+	movies, _ := field(result, "list").([]any)
+
+	want := map[string]string{
+		"Highlander":              "Action,Fantasy",
+		"Raiders of the Lost Ark": "Action,Adventure",
+		"The Godfather":           "Crime,Drama",
+	}
+
+	for _, movie := range movies {
+		movie, _ := movie.(map[string]any)
+		genres, _ := movie["genres"].([]any)
+
+		var names []string
+		for _, genre := range genres {
+			genre, _ := genre.(map[string]any)
+			names = append(names, genre["genre"].(string))
+		}
+
+		if got := joinSorted(names); got != want[movie["title"].(string)] {
+			t.Errorf("genres of %s = %s, want %s", movie["title"], got, want[movie["title"].(string)])
+		}
+	}
+
+	// every query gets its own loader, and movies without genres selected don't load any
+	repo.calls = nil
+
+	execute(g, "", `{ list { title } }`, nil)
+	execute(g, "", `{ a: list(limit: 1) { genres { id } } b: search(titleContains: "the") { genres { id } } }`, nil)
+
+	// The Godfather is in both fields, and is only loaded once
+	if len(repo.calls) != 1 || joinInts(repo.calls[0]) != "2,3" {
+		t.Errorf("GenresForMovies calls = %v, want a single call for the movies of both fields", repo.calls)
+	}
+
+	// a failed batch is reported for every movie, along with the rest of the data
+	repo.err = context.DeadlineExceeded
+
+	result = execute(g, "", `{ list { title genres { id } } }`, nil)
+
+	movies, _ = field(result, "list").([]any)
+	if len(result.Errors) != 3 || len(movies) != 3 {
+		t.Errorf("result = %+v, want the 3 movies and an error for each of their genres", result)
+	}
+}
+
+// joinSorted returns the strings sorted and joined by commas.
+func joinSorted(strs []string) string {
+	sort.Strings(strs)
+	return strings.Join(strs, ",")
+}
+
+// joinInts returns the integers sorted and joined by commas.
+func joinInts(ints []int) string {
+
+	var strs []string
+	for _, i := range ints {
+		strs = append(strs, strconv.Itoa(i))
+	}
+
+	return joinSorted(strs)
+}
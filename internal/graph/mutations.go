@@ -0,0 +1,590 @@
+package graph
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"github.com/calvarado2004/go-movies-backend/internal/models"
+	"github.com/calvarado2004/go-movies-backend/internal/repository"
+	"github.com/graphql-go/graphql"
+	"github.com/graphql-go/graphql/gqlerrors"
+	"strings"
+	"time"
+)
+
+// Viewer is the authenticated user a GraphQL request is executed for.
+type Viewer struct {
+	UserID int
+	Role   string
+}
+
+// viewerKey is the context key of the Viewer.
+type viewerKey struct{}
+
+// WithViewer returns a copy of ctx carrying the authenticated user of the request.
+func WithViewer(ctx context.Context, viewer Viewer) context.Context {
+	return context.WithValue(ctx, viewerKey{}, viewer)
+}
+
+// Error codes reported in the extensions of GraphQL errors.
+const (
+	CodeBadUserInput    = "BAD_USER_INPUT"
+	CodeUnauthenticated = "UNAUTHENTICATED"
+	CodeForbidden       = "FORBIDDEN"
+	CodeNotFound        = "NOT_FOUND"
+	CodeInternal        = "INTERNAL_SERVER_ERROR"
+)
+
+// Error is an error reported in the GraphQL errors array with a machine readable code in its extensions.
+type Error struct {
+	Code    string
+	Message string
+	Err     error
+}
+
+// Error returns the message of the error.
+func (e *Error) Error() string {
+	return e.Message
+}
+
+// Unwrap returns the error that caused the error, if any.
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+// internalError reports an error of the repository, which the client can't do anything about. Its cause is
+// only kept for logging, the client gets a fixed message.
+func internalError(err error) error {
+	return &Error{Code: CodeInternal, Message: "internal server error", Err: err}
+}
+
+// InternalErrors returns the causes of the internal errors of a result, which clients don't see.
+func InternalErrors(result *graphql.Result) []error {
+
+	var causes []error
+
+	for _, formatted := range result.Errors {
+		located, ok := formatted.OriginalError().(*gqlerrors.Error)
+		if !ok {
+			continue
+		}
+
+		var e *Error
+		if errors.As(located.OriginalError, &e) && e.Code == CodeInternal && e.Err != nil {
+			causes = append(causes, e.Err)
+		}
+	}
+
+	return causes
+}
+
+// Extensions returns the extensions of the error in the GraphQL response.
+func (e *Error) Extensions() map[string]any {
+	return map[string]any{"code": e.Code}
+}
+
+// ValidationError is an invalid input field of a mutation.
+type ValidationError struct {
+	Field   string
+	Message string
+}
+
+// Error returns the message of the error.
+func (e *ValidationError) Error() string {
+	return fmt.Sprintf("%s: %s", e.Field, e.Message)
+}
+
+// Extensions returns the extensions of the error in the GraphQL response.
+func (e *ValidationError) Extensions() map[string]any {
+	return map[string]any{"code": CodeBadUserInput, "field": e.Field}
+}
+
+// requireRole checks that the request is authenticated with at least the given role.
+func requireRole(ctx context.Context, role string) error {
+
+	viewer, ok := ctx.Value(viewerKey{}).(Viewer)
+	if !ok {
+		return &Error{Code: CodeUnauthenticated, Message: "authentication required"}
+	}
+
+	if !models.RoleSatisfies(viewer.Role, role) {
+		return &Error{Code: CodeForbidden, Message: "insufficient permissions"}
+	}
+
+	return nil
+}
+
+// movieInput holds the validated fields of a MovieInput, and which of its optional fields were given.
+type movieInput struct {
+	models.Movie
+	genres         []int
+	hasGenres      bool
+	hasDescription bool
+	hasImage       bool
+}
+
+// mutationFields returns the fields of the root mutation.
+func (g *Graph) mutationFields() graphql.Fields {
+
+	var movieInputType = graphql.NewInputObject(
+		graphql.InputObjectConfig{
+			Name: "MovieInput",
+			Fields: graphql.InputObjectConfigFieldMap{
+				"title": &graphql.InputObjectFieldConfig{
+					Type: graphql.NewNonNull(graphql.String),
+				},
+				"description": &graphql.InputObjectFieldConfig{
+					Type: graphql.String,
+				},
+				"release_date": &graphql.InputObjectFieldConfig{
+					Type:        graphql.NewNonNull(graphql.String),
+					Description: "Release date in YYYY-MM-DD format",
+				},
+				"runtime": &graphql.InputObjectFieldConfig{
+					Type: graphql.NewNonNull(graphql.Int),
+				},
+				"mpaa_rating": &graphql.InputObjectFieldConfig{
+					Type: graphql.NewNonNull(graphql.String),
+				},
+				"image": &graphql.InputObjectFieldConfig{
+					Type: graphql.String,
+				},
+				"genres": &graphql.InputObjectFieldConfig{
+					Type: graphql.NewList(graphql.NewNonNull(graphql.Int)),
+				},
+			},
+		},
+	)
+
+	idArgs := graphql.FieldConfigArgument{
+		"id": &graphql.ArgumentConfig{
+			Type: graphql.NewNonNull(graphql.Int),
+		},
+	}
+
+	return graphql.Fields{
+		"createMovie": &graphql.Field{
+			Type:        g.movieType,
+			Description: "Create a movie, its poster is looked up in the background when it has no image",
+			Args: graphql.FieldConfigArgument{
+				"input": &graphql.ArgumentConfig{
+					Type: graphql.NewNonNull(movieInputType),
+				},
+			},
+			Resolve: func(params graphql.ResolveParams) (any, error) {
+				err := requireRole(params.Context, models.RoleEditor)
+				if err != nil {
+					return nil, err
+				}
+
+				input, err := g.readMovieInput(params.Context, params.Args["input"])
+				if err != nil {
+					return nil, err
+				}
+
+				input.CreatedAt = time.Now()
+				input.UpdatedAt = time.Now()
+
+				var id int
+
+				err = g.Repo.WithTx(params.Context, func(repo repository.DatabaseRepo) error {
+
+					id, err = repo.InsertMovie(params.Context, input.Movie)
+					if err != nil {
+						return err
+					}
+
+					return repo.UpdateMovieGenres(params.Context, id, input.genres)
+				})
+				if err != nil {
+					return nil, internalError(err)
+				}
+
+				if g.MovieCreated != nil {
+					movie := input.Movie
+					movie.ID = id
+					g.MovieCreated(params.Context, movie)
+				}
+
+				return g.oneMovie(params.Context, id)
+			},
+		},
+
+		"updateMovie": &graphql.Field{
+			Type:        g.movieType,
+			Description: "Update a movie, its description, image and genres are only replaced when the input has them",
+			Args: graphql.FieldConfigArgument{
+				"id": &graphql.ArgumentConfig{
+					Type: graphql.NewNonNull(graphql.Int),
+				},
+				"input": &graphql.ArgumentConfig{
+					Type: graphql.NewNonNull(movieInputType),
+				},
+			},
+			Resolve: func(params graphql.ResolveParams) (any, error) {
+				err := requireRole(params.Context, models.RoleEditor)
+				if err != nil {
+					return nil, err
+				}
+
+				movie, err := g.findMovie(params.Context, params.Args["id"])
+				if err != nil {
+					return nil, err
+				}
+
+				input, err := g.readMovieInput(params.Context, params.Args["input"])
+				if err != nil {
+					return nil, err
+				}
+
+				movie.Title = input.Title
+				movie.ReleaseDate = input.ReleaseDate
+				movie.Runtime = input.Runtime
+				movie.MPAARating = input.MPAARating
+				movie.UpdatedAt = time.Now()
+
+				if input.hasDescription {
+					movie.Description = input.Description
+				}
+
+				if input.hasImage {
+					movie.Image = input.Image
+				}
+
+				err = g.Repo.WithTx(params.Context, func(repo repository.DatabaseRepo) error {
+
+					err := repo.UpdateMovie(params.Context, *movie)
+					if err != nil {
+						return err
+					}
+
+					if !input.hasGenres {
+						return nil
+					}
+
+					return repo.UpdateMovieGenres(params.Context, movie.ID, input.genres)
+				})
+				if err != nil {
+					return nil, internalError(err)
+				}
+
+				return g.oneMovie(params.Context, movie.ID)
+			},
+		},
+
+		"deleteMovie": &graphql.Field{
+			Type:        graphql.Boolean,
+			Description: "Delete a movie",
+			Args:        idArgs,
+			Resolve: func(params graphql.ResolveParams) (any, error) {
+				err := requireRole(params.Context, models.RoleAdmin)
+				if err != nil {
+					return nil, err
+				}
+
+				movie, err := g.findMovie(params.Context, params.Args["id"])
+				if err != nil {
+					return nil, err
+				}
+
+				deleteMovie := g.Repo.DeleteMovie
+				if g.DeleteMovie != nil {
+					deleteMovie = g.DeleteMovie
+				}
+
+				err = deleteMovie(params.Context, movie.ID)
+				if err != nil {
+					return nil, internalError(err)
+				}
+
+				return true, nil
+			},
+		},
+
+		"setMovieGenres": &graphql.Field{
+			Type:        g.movieType,
+			Description: "Replace the genres of a movie",
+			Args: graphql.FieldConfigArgument{
+				"id": &graphql.ArgumentConfig{
+					Type: graphql.NewNonNull(graphql.Int),
+				},
+				"genres": &graphql.ArgumentConfig{
+					Type: graphql.NewNonNull(graphql.NewList(graphql.NewNonNull(graphql.Int))),
+				},
+			},
+			Resolve: func(params graphql.ResolveParams) (any, error) {
+				err := requireRole(params.Context, models.RoleEditor)
+				if err != nil {
+					return nil, err
+				}
+
+				movie, err := g.findMovie(params.Context, params.Args["id"])
+				if err != nil {
+					return nil, err
+				}
+
+				genres, err := g.readGenreIDs(params.Context, params.Args["genres"])
+				if err != nil {
+					return nil, err
+				}
+
+				err = g.Repo.UpdateMovieGenres(params.Context, movie.ID, genres)
+				if err != nil {
+					return nil, internalError(err)
+				}
+
+				return g.oneMovie(params.Context, movie.ID)
+			},
+		},
+
+		"createGenre": &graphql.Field{
+			Type:        g.genreType,
+			Description: "Create a genre",
+			Args: graphql.FieldConfigArgument{
+				"genre": &graphql.ArgumentConfig{
+					Type: graphql.NewNonNull(graphql.String),
+				},
+			},
+			Resolve: func(params graphql.ResolveParams) (any, error) {
+				err := requireRole(params.Context, models.RoleEditor)
+				if err != nil {
+					return nil, err
+				}
+
+				name, err := g.readGenreName(params.Context, params.Args["genre"], 0)
+				if err != nil {
+					return nil, err
+				}
+
+				genre := models.Genre{Genre: name, CreatedAt: time.Now(), UpdatedAt: time.Now()}
+
+				genre.ID, err = g.Repo.InsertGenre(params.Context, genre)
+				if err != nil {
+					return nil, internalError(err)
+				}
+
+				return &genre, nil
+			},
+		},
+
+		"updateGenre": &graphql.Field{
+			Type:        g.genreType,
+			Description: "Rename a genre",
+			Args: graphql.FieldConfigArgument{
+				"id": &graphql.ArgumentConfig{
+					Type: graphql.NewNonNull(graphql.Int),
+				},
+				"genre": &graphql.ArgumentConfig{
+					Type: graphql.NewNonNull(graphql.String),
+				},
+			},
+			Resolve: func(params graphql.ResolveParams) (any, error) {
+				err := requireRole(params.Context, models.RoleEditor)
+				if err != nil {
+					return nil, err
+				}
+
+				genre, err := g.findGenre(params.Context, params.Args["id"])
+				if err != nil {
+					return nil, err
+				}
+
+				genre.Genre, err = g.readGenreName(params.Context, params.Args["genre"], genre.ID)
+				if err != nil {
+					return nil, err
+				}
+
+				genre.UpdatedAt = time.Now()
+
+				err = g.Repo.UpdateGenre(params.Context, *genre)
+				if err != nil {
+					return nil, internalError(err)
+				}
+
+				return genre, nil
+			},
+		},
+
+		"deleteGenre": &graphql.Field{
+			Type:        graphql.Boolean,
+			Description: "Delete a genre, removing it from every movie",
+			Args:        idArgs,
+			Resolve: func(params graphql.ResolveParams) (any, error) {
+				err := requireRole(params.Context, models.RoleAdmin)
+				if err != nil {
+					return nil, err
+				}
+
+				genre, err := g.findGenre(params.Context, params.Args["id"])
+				if err != nil {
+					return nil, err
+				}
+
+				err = g.Repo.DeleteGenre(params.Context, genre.ID)
+				if err != nil {
+					return nil, internalError(err)
+				}
+
+				return true, nil
+			},
+		},
+	}
+}
+
+// readMovieInput validates a MovieInput argument.
+func (g *Graph) readMovieInput(ctx context.Context, arg any) (movieInput, error) {
+
+	var input movieInput
+
+	fields, _ := arg.(map[string]any)
+
+	title, _ := fields["title"].(string)
+	input.Title = strings.TrimSpace(title)
+
+	switch {
+	case input.Title == "":
+		return input, &ValidationError{Field: "title", Message: "must not be empty"}
+	case len(input.Title) > 512:
+		return input, &ValidationError{Field: "title", Message: "must be at most 512 characters long"}
+	}
+
+	input.Description, input.hasDescription = fields["description"].(string)
+	input.Image, input.hasImage = fields["image"].(string)
+
+	releaseDate, _ := fields["release_date"].(string)
+
+	var err error
+
+	input.ReleaseDate, err = time.Parse("2006-01-02", releaseDate)
+	if err != nil {
+		return input, &ValidationError{Field: "release_date", Message: "must be a date in YYYY-MM-DD format"}
+	}
+
+	input.Runtime, _ = fields["runtime"].(int)
+	if input.Runtime <= 0 {
+		return input, &ValidationError{Field: "runtime", Message: "must be a positive number of minutes"}
+	}
+
+	rating, _ := fields["mpaa_rating"].(string)
+	input.MPAARating = strings.TrimSpace(rating)
+
+	switch {
+	case input.MPAARating == "":
+		return input, &ValidationError{Field: "mpaa_rating", Message: "must not be empty"}
+	case len(input.MPAARating) > 10:
+		return input, &ValidationError{Field: "mpaa_rating", Message: "must be at most 10 characters long"}
+	}
+
+	if genres, ok := fields["genres"]; ok && genres != nil {
+		input.hasGenres = true
+
+		input.genres, err = g.readGenreIDs(ctx, genres)
+		if err != nil {
+			return input, err
+		}
+	}
+
+	return input, nil
+}
+
+// readGenreIDs validates a list of genre ids, every genre must exist.
+func (g *Graph) readGenreIDs(ctx context.Context, arg any) ([]int, error) {
+
+	values, _ := arg.([]any)
+
+	genres, err := g.Repo.AllGenresDB(ctx)
+	if err != nil {
+		return nil, internalError(err)
+	}
+
+	known := map[int]bool{}
+	for _, genre := range genres {
+		known[genre.ID] = true
+	}
+
+	var ids []int
+
+	for _, value := range values {
+		id, _ := value.(int)
+		if !known[id] {
+			return nil, &ValidationError{Field: "genres", Message: fmt.Sprintf("unknown genre %d", id)}
+		}
+		ids = append(ids, id)
+	}
+
+	return ids, nil
+}
+
+// readGenreName validates the name of a genre, which must not be used by another genre.
+func (g *Graph) readGenreName(ctx context.Context, arg any, id int) (string, error) {
+
+	name, _ := arg.(string)
+	name = strings.TrimSpace(name)
+
+	switch {
+	case name == "":
+		return "", &ValidationError{Field: "genre", Message: "must not be empty"}
+	case len(name) > 255:
+		return "", &ValidationError{Field: "genre", Message: "must be at most 255 characters long"}
+	}
+
+	genres, err := g.Repo.AllGenresDB(ctx)
+	if err != nil {
+		return "", internalError(err)
+	}
+
+	for _, genre := range genres {
+		if genre.ID != id && strings.EqualFold(genre.Genre, name) {
+			return "", &ValidationError{Field: "genre", Message: "already exists"}
+		}
+	}
+
+	return name, nil
+}
+
+// findMovie returns the movie with the id in arg, or a NOT_FOUND error.
+func (g *Graph) findMovie(ctx context.Context, arg any) (*models.Movie, error) {
+
+	id, _ := arg.(int)
+
+	movie, err := g.Repo.OneMovie(ctx, id)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, &Error{Code: CodeNotFound, Message: fmt.Sprintf("movie %d not found", id)}
+	}
+	if err != nil {
+		return nil, internalError(err)
+	}
+
+	return movie, nil
+}
+
+// oneMovie returns the movie a mutation changed.
+func (g *Graph) oneMovie(ctx context.Context, id int) (*models.Movie, error) {
+
+	movie, err := g.Repo.OneMovie(ctx, id)
+	if err != nil {
+		return nil, internalError(err)
+	}
+
+	return movie, nil
+}
+
+// findGenre returns the genre with the id in arg, or a NOT_FOUND error.
+func (g *Graph) findGenre(ctx context.Context, arg any) (*models.Genre, error) {
+
+	id, _ := arg.(int)
+
+	genres, err := g.Repo.AllGenresDB(ctx)
+	if err != nil {
+		return nil, internalError(err)
+	}
+
+	for _, genre := range genres {
+		if genre.ID == id {
+			return genre, nil
+		}
+	}
+
+	return nil, &Error{Code: CodeNotFound, Message: fmt.Sprintf("genre %d not found", id)}
+}
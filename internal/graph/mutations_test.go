@@ -0,0 +1,310 @@
+package graph
+
+import (
+	"context"
+	"errors"
+	"github.com/calvarado2004/go-movies-backend/internal/models"
+	"github.com/calvarado2004/go-movies-backend/internal/repository"
+	"github.com/calvarado2004/go-movies-backend/internal/repository/dbrepo"
+	"github.com/graphql-go/graphql"
+	"testing"
+)
+
+// newTestGraph returns a Graph of the seeded memory repository.
+func newTestGraph(t *testing.T, repo repository.DatabaseRepo) *Graph {
+	t.Helper()
+
+	if repo == nil {
+		repo = dbrepo.NewSeededMemoryDBRepo()
+	}
+
+	g, err := NewGraph(repo)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return g
+}
+
+// execute runs a request for a user of the given role, anonymously if the role is empty.
+func execute(g *Graph, role, query string, variables map[string]any) *graphql.Result {
+
+	ctx := context.Background()
+	if role != "" {
+		ctx = WithViewer(ctx, Viewer{UserID: 1, Role: role})
+	}
+
+	return g.Execute(ctx, Request{Query: query, Variables: variables})
+}
+
+// errorExtensions returns the extensions of the only error of the result, or nil.
+func errorExtensions(t *testing.T, result *graphql.Result) map[string]any {
+	t.Helper()
+
+	if len(result.Errors) != 1 {
+		t.Errorf("errors = %v, want one", result.Errors)
+		return nil
+	}
+
+	return result.Errors[0].Extensions
+}
+
+// field returns the value of a field of the data of the result.
+func field(result *graphql.Result, name string) any {
+	data, _ := result.Data.(map[string]any)
+	return data[name]
+}
+
+// failingMovieRepo is a repository failing to get movies.
+type failingMovieRepo struct {
+	repository.DatabaseRepo
+	err error
+}
+
+// OneMovie fails.
+func (r failingMovieRepo) OneMovie(ctx context.Context, id int) (*models.Movie, error) {
+	return nil, r.err
+}
+
+// AllGenresDB fails.
+func (r failingMovieRepo) AllGenresDB(ctx context.Context) ([]*models.Genre, error) {
+	return nil, r.err
+}
+
+// failingWriteRepo is a repository failing to store changes.
+type failingWriteRepo struct {
+	repository.DatabaseRepo
+	err error
+}
+
+// WithTx fails.
+func (r failingWriteRepo) WithTx(ctx context.Context, fn func(repo repository.DatabaseRepo) error) error {
+	return r.err
+}
+
+// DeleteMovie fails.
+func (r failingWriteRepo) DeleteMovie(ctx context.Context, id int) error {
+	return r.err
+}
+
+// UpdateMovieGenres fails.
+func (r failingWriteRepo) UpdateMovieGenres(ctx context.Context, id int, genreIDs []int) error {
+	return r.err
+}
+
+// InsertGenre fails.
+func (r failingWriteRepo) InsertGenre(ctx context.Context, genre models.Genre) (int, error) {
+	return 0, r.err
+}
+
+// UpdateGenre fails.
+func (r failingWriteRepo) UpdateGenre(ctx context.Context, genre models.Genre) error {
+	return r.err
+}
+
+// DeleteGenre fails.
+func (r failingWriteRepo) DeleteGenre(ctx context.Context, id int) error {
+	return r.err
+}
+
+const createMovie = `mutation($input: MovieInput!) {
+	createMovie(input: $input) { id title description image genres { id } }
+}`
+
+// validMovie returns a valid MovieInput.
+func validMovie() map[string]any {
+	return map[string]any{
+		"title":        "Alien",
+		"description":  "In space no one can hear you scream.",
+		"release_date": "1979-05-25",
+		"runtime":      117,
+		"mpaa_rating":  "R",
+		"image":        "/alien.jpg",
+		"genres":       []any{2, 3},
+	}
+}
+
+func TestMutationRoles(t *testing.T) {
+
+	g := newTestGraph(t, nil)
+
+	tests := []struct {
+		role  string
+		query string
+		code  string
+	}{
+		{"", `mutation { createGenre(genre: "Western") { id } }`, CodeUnauthenticated},
+		{models.RoleViewer, `mutation { createGenre(genre: "Western") { id } }`, CodeForbidden},
+		{models.RoleViewer, `mutation { setMovieGenres(id: 1, genres: [1]) { id } }`, CodeForbidden},
+		{models.RoleEditor, `mutation { deleteMovie(id: 1) }`, CodeForbidden},
+		{models.RoleEditor, `mutation { deleteGenre(id: 1) }`, CodeForbidden},
+		{"superuser", `mutation { updateGenre(id: 1, genre: "Comedies") { id } }`, CodeForbidden},
+	}
+
+	for _, tt := range tests {
+		result := execute(g, tt.role, tt.query, nil)
+
+		if code := errorExtensions(t, result)["code"]; code != tt.code {
+			t.Errorf("%s as %q: code = %v, want %s", tt.query, tt.role, code, tt.code)
+		}
+	}
+
+	// nothing was changed
+	genres, err := g.Repo.AllGenresDB(context.Background())
+	if err != nil || len(genres) != 13 || genres[0].Genre == "Comedies" {
+		t.Errorf("genres = %d, %v, want the 13 seeded genres", len(genres), err)
+	}
+
+	if _, err := g.Repo.OneMovie(context.Background(), 1); err != nil {
+		t.Errorf("movie 1 = %v, want it kept", err)
+	}
+
+	result := execute(g, models.RoleAdmin, `mutation { deleteMovie(id: 1) }`, nil)
+	if len(result.Errors) != 0 || field(result, "deleteMovie") != true {
+		t.Errorf("deleting as admin = %+v", result)
+	}
+}
+
+func TestMutationValidation(t *testing.T) {
+
+	g := newTestGraph(t, nil)
+
+	tests := []struct {
+		field string
+		value any
+	}{
+		{"title", "   "},
+		{"release_date", "25/05/1979"},
+		{"runtime", 0},
+		{"runtime", -10},
+		{"mpaa_rating", ""},
+		{"mpaa_rating", "NOT-A-RATING"},
+		{"genres", []any{2, 999}},
+	}
+
+	for _, tt := range tests {
+		input := validMovie()
+		input[tt.field] = tt.value
+
+		result := execute(g, models.RoleEditor, createMovie, map[string]any{"input": input})
+
+		extensions := errorExtensions(t, result)
+		if extensions["code"] != CodeBadUserInput || extensions["field"] != tt.field {
+			t.Errorf("%s = %v: extensions = %v, want %s of %s", tt.field, tt.value, extensions, CodeBadUserInput, tt.field)
+		}
+
+		if field(result, "createMovie") != nil {
+			t.Errorf("%s = %v: a movie was created", tt.field, tt.value)
+		}
+	}
+
+	result := execute(g, models.RoleEditor, `mutation { createGenre(genre: " comedy ") { id } }`, nil)
+	if extensions := errorExtensions(t, result); extensions["code"] != CodeBadUserInput || extensions["field"] != "genre" {
+		t.Errorf("creating a duplicate genre: extensions = %v", extensions)
+	}
+
+	result = execute(g, models.RoleEditor, `mutation { updateMovie(id: 999, input: {title: "x", release_date: "2000-01-01", runtime: 1, mpaa_rating: "G"}) { id } }`, nil)
+	if code := errorExtensions(t, result)["code"]; code != CodeNotFound {
+		t.Errorf("updating a missing movie: code = %v, want %s", code, CodeNotFound)
+	}
+
+	result = execute(g, models.RoleAdmin, `mutation { deleteGenre(id: 999) }`, nil)
+	if code := errorExtensions(t, result)["code"]; code != CodeNotFound {
+		t.Errorf("deleting a missing genre: code = %v, want %s", code, CodeNotFound)
+	}
+}
+
+func TestUpdateMovie(t *testing.T) {
+
+	g := newTestGraph(t, nil)
+
+	result := execute(g, models.RoleEditor, createMovie, map[string]any{"input": validMovie()})
+	if len(result.Errors) != 0 {
+		t.Fatalf("creating = %v", result.Errors)
+	}
+
+	created, _ := field(result, "createMovie").(map[string]any)
+	id := created["id"]
+
+	const updateMovie = `mutation($id: Int!, $input: MovieInput!) {
+		updateMovie(id: $id, input: $input) { title description image genres { id } }
+	}`
+
+	// the optional fields that are left out are kept
+	input := validMovie()
+	input["title"] = "Aliens"
+	delete(input, "description")
+	delete(input, "image")
+	delete(input, "genres")
+
+	result = execute(g, models.RoleEditor, updateMovie, map[string]any{"id": id, "input": input})
+	if len(result.Errors) != 0 {
+		t.Fatalf("updating = %v", result.Errors)
+	}
+
+	updated, _ := field(result, "updateMovie").(map[string]any)
+	genres, _ := updated["genres"].([]any)
+
+	if updated["title"] != "Aliens" || updated["description"] != "In space no one can hear you scream." || updated["image"] != "/alien.jpg" || len(genres) != 2 {
+		t.Errorf("movie updated without its optional fields = %v, want them kept", updated)
+	}
+
+	// the given ones are replaced, even when empty
+	input["image"] = ""
+	input["description"] = "This time it's war."
+	input["genres"] = []any{}
+
+	result = execute(g, models.RoleEditor, updateMovie, map[string]any{"id": id, "input": input})
+
+	updated, _ = field(result, "updateMovie").(map[string]any)
+	genres, _ = updated["genres"].([]any)
+
+	if updated["description"] != "This time it's war." || updated["image"] != "" || len(genres) != 0 {
+		t.Errorf("movie updated with its optional fields = %v, want them replaced", updated)
+	}
+}
+
+func TestMutationRepositoryErrors(t *testing.T) {
+
+	cause := errors.New(`pq: relation "movies" does not exist`)
+
+	tests := []struct {
+		repo    repository.DatabaseRepo
+		queries []string
+	}{
+		// a failing lookup isn't a missing movie or genre
+		{failingMovieRepo{DatabaseRepo: dbrepo.NewSeededMemoryDBRepo(), err: cause}, []string{
+			`mutation { deleteMovie(id: 1) }`,
+			`mutation { setMovieGenres(id: 1, genres: [1]) { id } }`,
+			`mutation { deleteGenre(id: 1) }`,
+			`mutation { createGenre(genre: "Western") { id } }`,
+		}},
+		{failingWriteRepo{DatabaseRepo: dbrepo.NewSeededMemoryDBRepo(), err: cause}, []string{
+			`mutation { createMovie(input: {title: "x", release_date: "2000-01-01", runtime: 1, mpaa_rating: "G"}) { id } }`,
+			`mutation { updateMovie(id: 1, input: {title: "x", release_date: "2000-01-01", runtime: 1, mpaa_rating: "G"}) { id } }`,
+			`mutation { deleteMovie(id: 1) }`,
+			`mutation { setMovieGenres(id: 1, genres: [1]) { id } }`,
+			`mutation { createGenre(genre: "Western") { id } }`,
+			`mutation { updateGenre(id: 1, genre: "Comedies") { id } }`,
+			`mutation { deleteGenre(id: 1) }`,
+		}},
+	}
+
+	for _, tt := range tests {
+		g := newTestGraph(t, tt.repo)
+
+		for _, query := range tt.queries {
+			result := execute(g, models.RoleAdmin, query, nil)
+
+			// the cause is logged, not sent to the client
+			extensions := errorExtensions(t, result)
+			if extensions["code"] != CodeInternal || result.Errors[0].Message != "internal server error" {
+				t.Errorf("%s: errors = %v, want %s", query, result.Errors, CodeInternal)
+			}
+
+			if causes := InternalErrors(result); len(causes) != 1 || causes[0] != cause {
+				t.Errorf("%s: internal errors = %v, want the cause", query, causes)
+			}
+		}
+	}
+}
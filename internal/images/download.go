@@ -0,0 +1,44 @@
+package images
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+)
+
+// Download fetches an image over HTTP, failing on responses larger than MaxBytes.
+func Download(ctx context.Context, client *http.Client, url string) ([]byte, error) {
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+
+	defer func(Body io.ReadCloser) {
+		err := Body.Close()
+		if err != nil {
+			return
+		}
+	}(resp.Body)
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("images: downloading %s returned status %d", url, resp.StatusCode)
+	}
+
+	data, err := io.ReadAll(io.LimitReader(resp.Body, MaxBytes+1))
+	if err != nil {
+		return nil, err
+	}
+
+	if len(data) > MaxBytes {
+		return nil, fmt.Errorf("%w: larger than %d bytes", ErrUnsupported, MaxBytes)
+	}
+
+	return data, nil
+}
@@ -0,0 +1,192 @@
+package images
+
+import (
+	"bytes"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"golang.org/x/image/draw"
+	_ "golang.org/x/image/webp"
+	"image"
+	"image/color"
+	_ "image/gif"
+	"image/jpeg"
+	_ "image/png"
+)
+
+// Size is a width posters are resized to, the height keeps the aspect ratio.
+type Size struct {
+	Name  string
+	Width int
+}
+
+// Sizes are the sizes every poster is stored in.
+var Sizes = []Size{
+	{Name: "thumbnail", Width: 92},
+	{Name: "card", Width: 342},
+	{Name: "full", Width: 780},
+}
+
+// Limits of the images accepted by Process.
+const (
+	MaxBytes  = 20 << 20
+	MaxPixels = 50_000_000
+)
+
+// placeholderWidth is the width of the blurry placeholder shown while a poster loads.
+const placeholderWidth = 16
+
+// ErrUnsupported is returned for data that isn't an image in a supported format, or is too large.
+var ErrUnsupported = errors.New("images: unsupported image")
+
+// Processed holds a poster in every size, along with what clients need before loading it.
+type Processed struct {
+	Images        map[string][]byte
+	Width         int
+	Height        int
+	DominantColor string
+	Placeholder   string
+}
+
+// PosterKey returns the key a size of a version of the poster of a movie is stored under. Posters stored
+// before they were versioned have an empty version.
+func PosterKey(movieID int, version, size string) string {
+	if version == "" {
+		return fmt.Sprintf("posters/%d/%s.jpg", movieID, size)
+	}
+	return fmt.Sprintf("posters/%d/%s/%s.jpg", movieID, version, size)
+}
+
+// SizeByName returns the size with the given name.
+func SizeByName(name string) (Size, bool) {
+	for _, size := range Sizes {
+		if size.Name == name {
+			return size, true
+		}
+	}
+	return Size{}, false
+}
+
+// Process decodes a JPEG, PNG, GIF or WebP image and encodes it as a JPEG in every size, computing its
+// dominant color and a tiny placeholder.
+func Process(data []byte) (*Processed, error) {
+
+	if len(data) > MaxBytes {
+		return nil, fmt.Errorf("%w: larger than %d bytes", ErrUnsupported, MaxBytes)
+	}
+
+	// check the dimensions before decoding, a small file can hold a huge image
+	config, _, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrUnsupported, err)
+	}
+
+	if config.Width <= 0 || config.Height <= 0 || config.Width*config.Height > MaxPixels {
+		return nil, fmt.Errorf("%w: %dx%d pixels", ErrUnsupported, config.Width, config.Height)
+	}
+
+	src, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrUnsupported, err)
+	}
+
+	processed := &Processed{
+		Images: map[string][]byte{},
+		Width:  src.Bounds().Dx(),
+		Height: src.Bounds().Dy(),
+	}
+
+	for _, size := range Sizes {
+		processed.Images[size.Name], err = encodeJPEG(resize(src, size.Width, draw.CatmullRom), 85)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	placeholder, err := encodeJPEG(resize(src, placeholderWidth, draw.ApproxBiLinear), 50)
+	if err != nil {
+		return nil, err
+	}
+
+	processed.Placeholder = "data:image/jpeg;base64," + base64.StdEncoding.EncodeToString(placeholder)
+	processed.DominantColor = dominantColor(src)
+
+	return processed, nil
+}
+
+// resize scales an image down to the width, keeping its aspect ratio; images are never scaled up. Transparent
+// areas are drawn over white, as JPEG has no transparency.
+func resize(src image.Image, width int, scaler draw.Scaler) *image.RGBA {
+
+	bounds := src.Bounds()
+
+	if width > bounds.Dx() {
+		width = bounds.Dx()
+	}
+
+	height := bounds.Dy() * width / bounds.Dx()
+	if height < 1 {
+		height = 1
+	}
+
+	dst := image.NewRGBA(image.Rect(0, 0, width, height))
+	draw.Draw(dst, dst.Bounds(), image.White, image.Point{}, draw.Src)
+	scaler.Scale(dst, dst.Bounds(), src, bounds, draw.Over, nil)
+
+	return dst
+}
+
+// encodeJPEG encodes an image as a JPEG of the given quality.
+func encodeJPEG(img image.Image, quality int) ([]byte, error) {
+
+	var buf bytes.Buffer
+
+	err := jpeg.Encode(&buf, img, &jpeg.Options{Quality: quality})
+	if err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
+
+// dominantColor returns the most common color of an image as #rrggbb. Colors are grouped in buckets of
+// similar colors, and the average of the fullest bucket is returned.
+func dominantColor(src image.Image) string {
+
+	small := resize(src, 64, draw.ApproxBiLinear)
+
+	type bucket struct {
+		count   int
+		r, g, b int
+	}
+
+	buckets := map[int]*bucket{}
+	var best *bucket
+
+	for y := 0; y < small.Bounds().Dy(); y++ {
+		for x := 0; x < small.Bounds().Dx(); x++ {
+			c := small.RGBAAt(x, y)
+
+			key := int(c.R>>4)<<8 | int(c.G>>4)<<4 | int(c.B>>4)
+
+			b, ok := buckets[key]
+			if !ok {
+				b = &bucket{}
+				buckets[key] = b
+			}
+
+			b.count++
+			b.r += int(c.R)
+			b.g += int(c.G)
+			b.b += int(c.B)
+
+			if best == nil || b.count > best.count {
+				best = b
+			}
+		}
+	}
+
+	c := color.RGBA{R: uint8(best.r / best.count), G: uint8(best.g / best.count), B: uint8(best.b / best.count)}
+
+	return fmt.Sprintf("#%02x%02x%02x", c.R, c.G, c.B)
+}
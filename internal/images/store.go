@@ -0,0 +1,38 @@
+package images
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// ErrNotFound is returned when a store holds no blob under a key.
+var ErrNotFound = errors.New("images: blob not found")
+
+// Store is the interface implemented by every blob store images can be kept in.
+type Store interface {
+	// Put stores data under the key, replacing any previous blob.
+	Put(ctx context.Context, key string, data []byte, contentType string) error
+	// Get returns the blob stored under the key and its content type, or ErrNotFound.
+	Get(ctx context.Context, key string) (io.ReadCloser, string, error)
+	// Delete removes the blob stored under the key, deleting a missing blob is not an error.
+	Delete(ctx context.Context, key string) error
+}
+
+// validKey checks that a key is a relative slash separated path without empty or dot segments.
+func validKey(key string) error {
+
+	if key == "" || strings.HasPrefix(key, "/") {
+		return fmt.Errorf("images: invalid key %q", key)
+	}
+
+	for _, segment := range strings.Split(key, "/") {
+		if segment == "" || segment == "." || segment == ".." || strings.ContainsAny(segment, "\\\x00") {
+			return fmt.Errorf("images: invalid key %q", key)
+		}
+	}
+
+	return nil
+}
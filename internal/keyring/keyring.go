@@ -0,0 +1,338 @@
+// Package keyring holds the keys signing and verifying the JWTs of the API. Keys are identified by the kid
+// header of the tokens and rotate on a schedule: each key signs from its not_before time until a newer key
+// takes over, and keeps verifying tokens until its not_after time. The public keys are published as a JWKS,
+// so that other services can verify the tokens without sharing a secret.
+package keyring
+
+import (
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"github.com/golang-jwt/jwt/v4"
+	"gopkg.in/yaml.v3"
+	"math/big"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+// minRSABits is the minimum size of RSA keys.
+const minRSABits = 2048
+
+// Algorithms of the keys.
+const (
+	RS256 = "RS256"
+	EdDSA = "EdDSA"
+	HS256 = "HS256"
+)
+
+// Errors returned when looking keys up.
+var (
+	ErrNoSigningKey = errors.New("keyring: no key is active")
+	ErrUnknownKey   = errors.New("keyring: unknown key")
+	ErrExpiredKey   = errors.New("keyring: key no longer verifies tokens")
+)
+
+// Key is a signing key.
+type Key struct {
+	// ID is the kid of the tokens the key signs.
+	ID        string
+	Algorithm string
+	// NotBefore is when the key starts signing tokens.
+	NotBefore time.Time
+	// NotAfter is when the key stops verifying tokens, never when zero.
+	NotAfter time.Time
+	// private is a *rsa.PrivateKey, an ed25519.PrivateKey or the []byte secret of a HS256 key.
+	private crypto.PrivateKey
+}
+
+// NewKey returns a key signing with an RSA or Ed25519 private key.
+func NewKey(id string, private crypto.PrivateKey) (*Key, error) {
+
+	key := &Key{ID: id, private: private}
+
+	switch private := private.(type) {
+	case *rsa.PrivateKey:
+		if private.N.BitLen() < minRSABits {
+			return nil, fmt.Errorf("keyring: key %s: RSA keys must be at least %d bits", id, minRSABits)
+		}
+		key.Algorithm = RS256
+	case ed25519.PrivateKey:
+		key.Algorithm = EdDSA
+	default:
+		return nil, fmt.Errorf("keyring: key %s: unsupported key type %T, want RSA or Ed25519", id, private)
+	}
+
+	return key, nil
+}
+
+// HMAC returns a key signing with a shared secret. Its tokens can only be verified with the secret, so it
+// isn't published in the JWKS.
+func HMAC(id string, secret []byte) *Key {
+	return &Key{ID: id, Algorithm: HS256, private: secret}
+}
+
+// method returns the JWT signing method of the key.
+func (k *Key) method() jwt.SigningMethod {
+	switch k.Algorithm {
+	case RS256:
+		return jwt.SigningMethodRS256
+	case EdDSA:
+		return jwt.SigningMethodEdDSA
+	default:
+		return jwt.SigningMethodHS256
+	}
+}
+
+// public returns the key verifying the signatures of the key.
+func (k *Key) public() any {
+	switch private := k.private.(type) {
+	case *rsa.PrivateKey:
+		return &private.PublicKey
+	case ed25519.PrivateKey:
+		return private.Public()
+	default:
+		return private
+	}
+}
+
+// Keyring is a set of keys.
+type Keyring struct {
+	// keys are sorted by NotBefore
+	keys []*Key
+}
+
+// New returns a keyring of the given keys, whose IDs must be unique.
+func New(keys ...*Key) (*Keyring, error) {
+
+	seen := make(map[string]bool)
+
+	for _, key := range keys {
+		if key.ID == "" {
+			return nil, errors.New("keyring: a key has no id")
+		}
+		if seen[key.ID] {
+			return nil, fmt.Errorf("keyring: duplicate key id %q", key.ID)
+		}
+		if !key.NotAfter.IsZero() && !key.NotAfter.After(key.NotBefore) {
+			return nil, fmt.Errorf("keyring: key %s expires before it starts signing", key.ID)
+		}
+		seen[key.ID] = true
+	}
+
+	sorted := append([]*Key(nil), keys...)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return sorted[i].NotBefore.Before(sorted[j].NotBefore)
+	})
+
+	return &Keyring{keys: sorted}, nil
+}
+
+// manifestEntry is a key in a keyring manifest.
+type manifestEntry struct {
+	ID        string    `yaml:"id"`
+	File      string    `yaml:"file"`
+	NotBefore time.Time `yaml:"not_before"`
+	NotAfter  time.Time `yaml:"not_after"`
+}
+
+// Load reads a keyring from a YAML manifest listing the keys, such as:
+//
+//   - id: 2026-09
+//     file: 2026-09.pem
+//     not_before: 2026-09-01T00:00:00Z
+//     not_after: 2026-11-01T00:00:00Z
+//   - id: 2026-10
+//     file: 2026-10.pem
+//     not_before: 2026-10-01T00:00:00Z
+//
+// Files are PEM encoded PKCS #8 or PKCS #1 private keys, relative to the manifest, as written by
+// openssl genpkey -algorithm ed25519 or -algorithm rsa.
+func Load(path string) (*Keyring, error) {
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var entries []manifestEntry
+
+	err = yaml.Unmarshal(data, &entries)
+	if err != nil {
+		return nil, fmt.Errorf("keyring %s: %w", path, err)
+	}
+
+	if len(entries) == 0 {
+		return nil, fmt.Errorf("keyring %s: no keys", path)
+	}
+
+	keys := make([]*Key, 0, len(entries))
+
+	for _, entry := range entries {
+		file := entry.File
+		if !filepath.IsAbs(file) {
+			file = filepath.Join(filepath.Dir(path), file)
+		}
+
+		private, err := readPrivateKey(file)
+		if err != nil {
+			return nil, fmt.Errorf("keyring %s: key %s: %w", path, entry.ID, err)
+		}
+
+		key, err := NewKey(entry.ID, private)
+		if err != nil {
+			return nil, err
+		}
+
+		key.NotBefore = entry.NotBefore
+		key.NotAfter = entry.NotAfter
+
+		keys = append(keys, key)
+	}
+
+	return New(keys...)
+}
+
+// readPrivateKey reads a PEM encoded private key.
+func readPrivateKey(path string) (crypto.PrivateKey, error) {
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, errors.New("no PEM block found")
+	}
+
+	switch block.Type {
+	case "PRIVATE KEY":
+		return x509.ParsePKCS8PrivateKey(block.Bytes)
+	case "RSA PRIVATE KEY":
+		return x509.ParsePKCS1PrivateKey(block.Bytes)
+	default:
+		return nil, fmt.Errorf("unsupported PEM block %q, want a private key", block.Type)
+	}
+}
+
+// Current returns the key signing tokens at the given time: the last one to have started signing among the
+// keys that still verify tokens.
+func (k *Keyring) Current(now time.Time) (*Key, error) {
+
+	for i := len(k.keys) - 1; i >= 0; i-- {
+		key := k.keys[i]
+		if !key.NotBefore.After(now) && (key.NotAfter.IsZero() || key.NotAfter.After(now)) {
+			return key, nil
+		}
+	}
+
+	return nil, ErrNoSigningKey
+}
+
+// Sign signs the claims with the current key, whose id is set as the kid header of the token.
+func (k *Keyring) Sign(claims jwt.Claims, now time.Time) (string, error) {
+
+	key, err := k.Current(now)
+	if err != nil {
+		return "", err
+	}
+
+	token := jwt.NewWithClaims(key.method(), claims)
+	token.Header["kid"] = key.ID
+
+	return token.SignedString(key.private)
+}
+
+// Keyfunc returns a jwt.Keyfunc verifying tokens with the key named by their kid header, at the given time.
+// Tokens must be signed with the algorithm of the key, so that a public key can't be used as a HMAC secret.
+func (k *Keyring) Keyfunc(now func() time.Time) jwt.Keyfunc {
+	return func(token *jwt.Token) (any, error) {
+
+		kid, _ := token.Header["kid"].(string)
+
+		key, err := k.lookup(kid, now())
+		if err != nil {
+			return nil, err
+		}
+
+		if token.Method.Alg() != key.Algorithm {
+			return nil, fmt.Errorf("unexpected signing method %v for key %s", token.Header["alg"], key.ID)
+		}
+
+		return key.public(), nil
+	}
+}
+
+// lookup returns the key with the given id, if it still verifies tokens at the given time.
+func (k *Keyring) lookup(id string, now time.Time) (*Key, error) {
+
+	for _, key := range k.keys {
+		if key.ID != id {
+			continue
+		}
+		if !key.NotAfter.IsZero() && !key.NotAfter.After(now) {
+			return nil, ErrExpiredKey
+		}
+		return key, nil
+	}
+
+	return nil, ErrUnknownKey
+}
+
+// JWK is a public key in the JSON Web Key format of RFC 7517.
+type JWK struct {
+	KeyType   string `json:"kty"`
+	ID        string `json:"kid"`
+	Use       string `json:"use"`
+	Algorithm string `json:"alg"`
+	// N and E are the modulus and exponent of RSA keys.
+	N string `json:"n,omitempty"`
+	E string `json:"e,omitempty"`
+	// Curve and X are the curve and public key of Ed25519 keys.
+	Curve string `json:"crv,omitempty"`
+	X     string `json:"x,omitempty"`
+}
+
+// JWKS is a JSON Web Key Set.
+type JWKS struct {
+	Keys []JWK `json:"keys"`
+}
+
+// JWKS returns the public keys verifying tokens at the given time, including the ones that haven't started
+// signing yet, so that verifiers know them before the rotation. HS256 keys are left out.
+func (k *Keyring) JWKS(now time.Time) JWKS {
+
+	set := JWKS{Keys: []JWK{}}
+
+	for _, key := range k.keys {
+		if !key.NotAfter.IsZero() && !key.NotAfter.After(now) {
+			continue
+		}
+
+		jwk := JWK{ID: key.ID, Use: "sig", Algorithm: key.Algorithm}
+
+		switch public := key.public().(type) {
+		case *rsa.PublicKey:
+			jwk.KeyType = "RSA"
+			jwk.N = base64.RawURLEncoding.EncodeToString(public.N.Bytes())
+			jwk.E = base64.RawURLEncoding.EncodeToString(big.NewInt(int64(public.E)).Bytes())
+		case ed25519.PublicKey:
+			jwk.KeyType = "OKP"
+			jwk.Curve = "Ed25519"
+			jwk.X = base64.RawURLEncoding.EncodeToString(public)
+		default:
+			continue
+		}
+
+		set.Keys = append(set.Keys, jwk)
+	}
+
+	return set
+}
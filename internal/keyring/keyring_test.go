@@ -0,0 +1,240 @@
+package keyring
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"github.com/golang-jwt/jwt/v4"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// writeKey writes a private key as PEM to dir and returns the name of the file.
+func writeKey(t *testing.T, dir, name string, private any) string {
+	t.Helper()
+
+	der, err := x509.MarshalPKCS8PrivateKey(private)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = os.WriteFile(filepath.Join(dir, name), pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}), 0o600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return name
+}
+
+// claims returns claims with a subject.
+func claims() jwt.MapClaims {
+	return jwt.MapClaims{"sub": "1"}
+}
+
+func TestLoadAndRotate(t *testing.T) {
+
+	dir := t.TempDir()
+
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, edKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	writeKey(t, dir, "old.pem", rsaKey)
+	writeKey(t, dir, "new.pem", edKey)
+
+	manifest := filepath.Join(dir, "keys.yaml")
+
+	err = os.WriteFile(manifest, []byte(`
+- id: new
+  file: new.pem
+  not_before: 2026-10-01T00:00:00Z
+- id: old
+  file: old.pem
+  not_before: 2026-09-01T00:00:00Z
+  not_after: 2026-11-01T00:00:00Z
+`), 0o600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	keys, err := Load(manifest)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	september := time.Date(2026, 9, 15, 0, 0, 0, 0, time.UTC)
+	october := time.Date(2026, 10, 15, 0, 0, 0, 0, time.UTC)
+	november := time.Date(2026, 11, 15, 0, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		now  time.Time
+		want string
+	}{
+		{september, "old"},
+		{october, "new"},
+		{november, "new"},
+	}
+
+	for _, tt := range tests {
+		key, err := keys.Current(tt.now)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if key.ID != tt.want {
+			t.Errorf("Current(%s) = %s, want %s", tt.now.Format(time.DateOnly), key.ID, tt.want)
+		}
+	}
+
+	_, err = keys.Current(time.Date(2026, 8, 1, 0, 0, 0, 0, time.UTC))
+	if !errors.Is(err, ErrNoSigningKey) {
+		t.Errorf("Current before the first key = %v, want %v", err, ErrNoSigningKey)
+	}
+
+	// a token signed by the old key verifies after the rotation, until the old key expires
+	signed, err := keys.Sign(claims(), september)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	at := func(now time.Time) func() time.Time {
+		return func() time.Time { return now }
+	}
+
+	_, err = jwt.Parse(signed, keys.Keyfunc(at(october)))
+	if err != nil {
+		t.Errorf("a token of the old key is refused after the rotation: %v", err)
+	}
+
+	_, err = jwt.Parse(signed, keys.Keyfunc(at(november)))
+	if !errors.Is(err, ErrExpiredKey) {
+		t.Errorf("a token of the expired key = %v, want %v", err, ErrExpiredKey)
+	}
+
+	signed, err = keys.Sign(claims(), october)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	token, err := jwt.Parse(signed, keys.Keyfunc(at(november)))
+	if err != nil {
+		t.Fatalf("a token of the new key is refused: %v", err)
+	}
+
+	if token.Header["kid"] != "new" || token.Method.Alg() != EdDSA {
+		t.Errorf("token header = %v, want the kid and algorithm of the new key", token.Header)
+	}
+
+	// the JWKS publishes the keys still verifying tokens
+	if set := keys.JWKS(october); len(set.Keys) != 2 {
+		t.Errorf("JWKS in October has %d keys, want 2", len(set.Keys))
+	}
+
+	set := keys.JWKS(november)
+	if len(set.Keys) != 1 || set.Keys[0].ID != "new" || set.Keys[0].KeyType != "OKP" || set.Keys[0].X == "" {
+		t.Errorf("JWKS in November = %+v, want the new key only", set)
+	}
+}
+
+func TestKeyfuncRefusesOtherAlgorithms(t *testing.T) {
+
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	key, err := NewKey("rsa", rsaKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	keys, err := New(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// a HS256 token using the public key as secret must not verify
+	public, err := x509.MarshalPKIXPublicKey(&rsaKey.PublicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	forged := jwt.NewWithClaims(jwt.SigningMethodHS256, claims())
+	forged.Header["kid"] = "rsa"
+
+	signed, err := forged.SignedString(public)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = jwt.Parse(signed, keys.Keyfunc(time.Now))
+	if err == nil {
+		t.Error("a HS256 token is accepted for an RSA key")
+	}
+
+	// tokens without a known kid are refused
+	unsigned := jwt.NewWithClaims(jwt.SigningMethodRS256, claims())
+
+	signed, err = unsigned.SignedString(rsaKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = jwt.Parse(signed, keys.Keyfunc(time.Now))
+	if !errors.Is(err, ErrUnknownKey) {
+		t.Errorf("a token without kid = %v, want %v", err, ErrUnknownKey)
+	}
+
+	set := keys.JWKS(time.Now())
+	if len(set.Keys) != 1 || set.Keys[0].KeyType != "RSA" || set.Keys[0].E != "AQAB" {
+		t.Errorf("JWKS = %+v, want the RSA key", set)
+	}
+}
+
+func TestNew(t *testing.T) {
+
+	_, err := New(HMAC("a", []byte("x")), HMAC("a", []byte("y")))
+	if err == nil {
+		t.Error("duplicate ids are accepted")
+	}
+
+	small, err := rsa.GenerateKey(rand.Reader, 1024)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = NewKey("small", small)
+	if err == nil {
+		t.Error("a 1024 bits RSA key is accepted")
+	}
+
+	// HMAC keys sign and verify but aren't published
+	keys, err := New(HMAC("hs", []byte("secret")))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	signed, err := keys.Sign(claims(), time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = jwt.Parse(signed, keys.Keyfunc(time.Now))
+	if err != nil {
+		t.Errorf("a HS256 token is refused: %v", err)
+	}
+
+	if set := keys.JWKS(time.Now()); len(set.Keys) != 0 {
+		t.Errorf("JWKS = %+v, want no keys", set)
+	}
+}
@@ -0,0 +1,105 @@
+package mailer
+
+import (
+	"fmt"
+	"log"
+	"net/smtp"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// Message is a struct that holds a plain text email message.
+type Message struct {
+	To      string
+	Subject string
+	Body    string
+}
+
+// Sender is the interface implemented by everything that can deliver email messages.
+type Sender interface {
+	Send(msg Message) error
+}
+
+// LogSender writes every message to a logger instead of delivering it. It is meant for local development.
+type LogSender struct {
+	Logger *log.Logger
+}
+
+// Send writes the message to the logger.
+func (s *LogSender) Send(msg Message) error {
+
+	logger := s.Logger
+	if logger == nil {
+		logger = log.Default()
+	}
+
+	logger.Printf("email to %s\nSubject: %s\n\n%s", msg.To, msg.Subject, msg.Body)
+
+	return nil
+}
+
+// FileSender writes every message to its own file in a directory instead of delivering it, so that messages
+// can be inspected by developers and tests.
+type FileSender struct {
+	Dir string
+	seq atomic.Int64
+}
+
+// Send writes the message to a new .eml file in the directory.
+func (s *FileSender) Send(msg Message) error {
+
+	err := os.MkdirAll(s.Dir, 0o755)
+	if err != nil {
+		return err
+	}
+
+	name := fmt.Sprintf("%d-%03d.eml", time.Now().UTC().UnixNano(), s.seq.Add(1))
+
+	return os.WriteFile(filepath.Join(s.Dir, name), formatMessage("", msg), 0o644)
+}
+
+// SMTPSender delivers messages through an SMTP server.
+type SMTPSender struct {
+	Host     string
+	Port     int
+	Username string
+	Password string
+	From     string
+}
+
+// Send delivers the message through the SMTP server.
+func (s *SMTPSender) Send(msg Message) error {
+
+	var auth smtp.Auth
+	if s.Username != "" {
+		auth = smtp.PlainAuth("", s.Username, s.Password, s.Host)
+	}
+
+	addr := fmt.Sprintf("%s:%d", s.Host, s.Port)
+
+	return smtp.SendMail(addr, auth, s.From, []string{msg.To}, formatMessage(s.From, msg))
+}
+
+// headerSanitizer strips line breaks from header values, so they cannot inject extra headers.
+var headerSanitizer = strings.NewReplacer("\r", "", "\n", "")
+
+// formatMessage renders the message in RFC 5322 format.
+func formatMessage(from string, msg Message) []byte {
+
+	var b strings.Builder
+
+	if from != "" {
+		fmt.Fprintf(&b, "From: %s\r\n", headerSanitizer.Replace(from))
+	}
+	fmt.Fprintf(&b, "To: %s\r\n", headerSanitizer.Replace(msg.To))
+	fmt.Fprintf(&b, "Subject: %s\r\n", headerSanitizer.Replace(msg.Subject))
+	fmt.Fprintf(&b, "Date: %s\r\n", time.Now().UTC().Format(time.RFC1123Z))
+	b.WriteString("MIME-Version: 1.0\r\n")
+	b.WriteString("Content-Type: text/plain; charset=UTF-8\r\n\r\n")
+	b.WriteString(strings.ReplaceAll(msg.Body, "\n", "\r\n"))
+
+	return []byte(b.String())
+}
@@ -0,0 +1,101 @@
+package mailer
+
+import (
+	"bytes"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestFormatMessage(t *testing.T) {
+
+	msg := Message{
+		To:      "ada@example.com\r\nBcc: everyone@example.com",
+		Subject: "Hello\nX-Injected: yes",
+		Body:    "first line\nsecond line\n",
+	}
+
+	got := string(formatMessage("Go Movies <noreply@example.com>", msg))
+
+	header, body, found := strings.Cut(got, "\r\n\r\n")
+	if !found {
+		t.Fatalf("message %q has no blank line between header and body", got)
+	}
+
+	for _, want := range []string{
+		"From: Go Movies <noreply@example.com>\r\n",
+		"To: ada@example.comBcc: everyone@example.com\r\n",
+		"Subject: HelloX-Injected: yes\r\n",
+		"MIME-Version: 1.0\r\n",
+		"Content-Type: text/plain; charset=UTF-8",
+	} {
+		if !strings.Contains(header+"\r\n", want) {
+			t.Errorf("header %q lacks %q", header, want)
+		}
+	}
+
+	// header values can't start a new header line
+	for _, line := range strings.Split(header, "\r\n") {
+		if strings.HasPrefix(line, "Bcc:") || strings.HasPrefix(line, "X-Injected:") {
+			t.Errorf("header line %q was injected", line)
+		}
+	}
+
+	if body != "first line\r\nsecond line\r\n" {
+		t.Errorf("body = %q, want CRLF line endings", body)
+	}
+
+	if got := string(formatMessage("", msg)); strings.Contains(got, "From:") {
+		t.Errorf("message without sender has a From header: %q", got)
+	}
+}
+
+func TestFileSender(t *testing.T) {
+
+	dir := filepath.Join(t.TempDir(), "mail")
+
+	sender := &FileSender{Dir: dir}
+
+	for _, subject := range []string{"first", "second"} {
+		err := sender.Send(Message{To: "ada@example.com", Subject: subject, Body: "hello"})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	files, err := filepath.Glob(filepath.Join(dir, "*.eml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(files) != 2 {
+		t.Fatalf("files = %v, want a file per message", files)
+	}
+
+	content, err := os.ReadFile(files[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !strings.Contains(string(content), "Subject: first\r\n") || !strings.HasSuffix(string(content), "\r\n\r\nhello") {
+		t.Errorf("first message = %q", content)
+	}
+}
+
+func TestLogSender(t *testing.T) {
+
+	var buf bytes.Buffer
+
+	sender := &LogSender{Logger: log.New(&buf, "", 0)}
+
+	err := sender.Send(Message{To: "ada@example.com", Subject: "Hello", Body: "the body"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got := buf.String(); got != "email to ada@example.com\nSubject: Hello\n\nthe body\n" {
+		t.Errorf("log = %q", got)
+	}
+}
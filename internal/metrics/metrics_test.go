@@ -0,0 +1,137 @@
+package metrics
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"github.com/calvarado2004/go-movies-backend/internal/posters"
+	"github.com/calvarado2004/go-movies-backend/internal/repository"
+	"github.com/calvarado2004/go-movies-backend/internal/repository/dbrepo"
+	"github.com/go-chi/chi/v5"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestMiddleware(t *testing.T) {
+
+	m := New()
+
+	mux := chi.NewRouter()
+	mux.Use(m.Middleware)
+	mux.Get("/movies/{id}", func(w http.ResponseWriter, r *http.Request) {
+		if chi.URLParam(r, "id") == "0" {
+			http.NotFound(w, r)
+			return
+		}
+		_, _ = w.Write([]byte("ok"))
+	})
+
+	for _, path := range []string{"/movies/1", "/movies/2", "/movies/0", "/nowhere"} {
+		mux.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, path, nil))
+	}
+
+	tests := []struct {
+		route, code string
+		want        float64
+	}{
+		{"/movies/{id}", "200", 2},
+		{"/movies/{id}", "404", 1},
+		{unmatchedRoute, "404", 1},
+	}
+
+	for _, test := range tests {
+		got := testutil.ToFloat64(m.requests.WithLabelValues(test.route, http.MethodGet, test.code))
+		if got != test.want {
+			t.Errorf("requests of %s with status %s = %v, want %v", test.route, test.code, got, test.want)
+		}
+	}
+
+	// raw paths never become label values
+	rr := httptest.NewRecorder()
+	m.Handler().ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+
+	if strings.Contains(rr.Body.String(), "/movies/1") {
+		t.Errorf("metrics expose a raw path")
+	}
+}
+
+func TestRepository(t *testing.T) {
+
+	m := New()
+	repo := m.Repository(dbrepo.NewSeededMemoryDBRepo())
+	ctx := context.Background()
+
+	_, err := repo.OneMovie(ctx, 1)
+	if err != nil {
+		t.Fatalf("OneMovie: %v", err)
+	}
+
+	// a missing row is an answer, not a failure
+	_, err = repo.OneMovie(ctx, -1)
+	if !errors.Is(err, sql.ErrNoRows) {
+		t.Fatalf("OneMovie of a missing movie returned %v", err)
+	}
+
+	err = repo.UpdateMovieGenres(ctx, 1, []int{-1})
+	if err == nil {
+		t.Fatalf("UpdateMovieGenres accepted an unknown genre")
+	}
+
+	// calls made in a transaction are recorded as well
+	err = repo.WithTx(ctx, func(tx repository.DatabaseRepo) error {
+		_, err := tx.AllGenresDB(ctx)
+		return err
+	})
+	if err != nil {
+		t.Fatalf("WithTx: %v", err)
+	}
+
+	if got := testutil.CollectAndCount(m.queryDuration); got != 4 {
+		t.Errorf("query duration series = %d, want 4", got)
+	}
+
+	if got := testutil.ToFloat64(m.queryErrors.WithLabelValues("OneMovie")); got != 0 {
+		t.Errorf("OneMovie errors = %v, want 0", got)
+	}
+
+	if got := testutil.ToFloat64(m.queryErrors.WithLabelValues("UpdateMovieGenres")); got != 1 {
+		t.Errorf("UpdateMovieGenres errors = %v, want 1", got)
+	}
+}
+
+// providerFunc is a posters.Provider calling a function.
+type providerFunc func(ctx context.Context, title string) (string, error)
+
+func (f providerFunc) Poster(ctx context.Context, title string) (string, error) {
+	return f(ctx, title)
+}
+
+func TestPosters(t *testing.T) {
+
+	m := New()
+
+	provider := m.Posters(providerFunc(func(ctx context.Context, title string) (string, error) {
+		switch title {
+		case "Alien":
+			return "/alien.jpg", nil
+		case "Unknown":
+			return "", posters.ErrNotFound
+		case "Open":
+			return "", posters.ErrCircuitOpen
+		}
+		return "", errors.New("boom")
+	}))
+
+	for _, title := range []string{"Alien", "Alien", "Unknown", "Open", "Broken"} {
+		_, _ = provider.Poster(context.Background(), title)
+	}
+
+	for outcome, want := range map[string]float64{"found": 2, "not_found": 1, "circuit_open": 1, "error": 1} {
+		if got := testutil.ToFloat64(m.posterLookups.WithLabelValues(outcome)); got != want {
+			t.Errorf("%s lookups = %v, want %v", outcome, got, want)
+		}
+	}
+}
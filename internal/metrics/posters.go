@@ -0,0 +1,39 @@
+package metrics
+
+import (
+	"context"
+	"errors"
+	"github.com/calvarado2004/go-movies-backend/internal/posters"
+)
+
+// instrumentedProvider is a posters.Provider counting the outcomes of the lookups of another provider.
+type instrumentedProvider struct {
+	provider posters.Provider
+	metrics  *Metrics
+}
+
+// Posters returns a posters.Provider recording the outcome of every lookup of provider.
+func (m *Metrics) Posters(provider posters.Provider) posters.Provider {
+	return &instrumentedProvider{provider: provider, metrics: m}
+}
+
+// Poster looks the poster up and records the outcome.
+func (p *instrumentedProvider) Poster(ctx context.Context, title string) (string, error) {
+
+	poster, err := p.provider.Poster(ctx, title)
+
+	outcome := "found"
+
+	switch {
+	case errors.Is(err, posters.ErrNotFound):
+		outcome = "not_found"
+	case errors.Is(err, posters.ErrCircuitOpen):
+		outcome = "circuit_open"
+	case err != nil:
+		outcome = "error"
+	}
+
+	p.metrics.posterLookups.WithLabelValues(outcome).Inc()
+
+	return poster, err
+}
@@ -0,0 +1,311 @@
+package metrics
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"github.com/calvarado2004/go-movies-backend/internal/models"
+	"github.com/calvarado2004/go-movies-backend/internal/repository"
+	"time"
+)
+
+// instrumentedRepo is a repository.DatabaseRepo timing every call to another repository and counting the
+// calls that fail.
+type instrumentedRepo struct {
+	repo    repository.DatabaseRepo
+	metrics *Metrics
+}
+
+// Repository returns a repository.DatabaseRepo recording the duration and errors of every call to repo.
+func (m *Metrics) Repository(repo repository.DatabaseRepo) repository.DatabaseRepo {
+	return &instrumentedRepo{repo: repo, metrics: m}
+}
+
+// observe records a call to method started at start, which failed if *err isn't nil. It is meant to be
+// deferred, with err pointing at the named error result of the caller.
+func (r *instrumentedRepo) observe(method string, start time.Time, err *error) {
+
+	r.metrics.queryDuration.WithLabelValues(method).Observe(time.Since(start).Seconds())
+
+	if *err != nil && !errors.Is(*err, sql.ErrNoRows) {
+		r.metrics.queryErrors.WithLabelValues(method).Inc()
+	}
+}
+
+// Connection returns the connection pool of the wrapped repository.
+func (r *instrumentedRepo) Connection() *sql.DB {
+	return r.repo.Connection()
+}
+
+// WithTx runs fn in a transaction of the wrapped repository, the calls made in it are recorded too.
+func (r *instrumentedRepo) WithTx(ctx context.Context, fn func(repo repository.DatabaseRepo) error) (err error) {
+	defer r.observe("WithTx", time.Now(), &err)
+
+	return r.repo.WithTx(ctx, func(tx repository.DatabaseRepo) error {
+		return fn(&instrumentedRepo{repo: tx, metrics: r.metrics})
+	})
+}
+
+// AllMovies records a call to AllMovies of the wrapped repository.
+func (r *instrumentedRepo) AllMovies(ctx context.Context, genre ...int) (_ []*models.Movie, err error) {
+	defer r.observe("AllMovies", time.Now(), &err)
+	return r.repo.AllMovies(ctx, genre...)
+}
+
+// ListMovies records a call to ListMovies of the wrapped repository.
+func (r *instrumentedRepo) ListMovies(ctx context.Context, query repository.MovieQuery) (_ []*models.Movie, _ int, err error) {
+	defer r.observe("ListMovies", time.Now(), &err)
+	return r.repo.ListMovies(ctx, query)
+}
+
+// SearchMovies records a call to SearchMovies of the wrapped repository.
+func (r *instrumentedRepo) SearchMovies(ctx context.Context, search repository.MovieSearch) (_ []*models.MovieSearchResult, err error) {
+	defer r.observe("SearchMovies", time.Now(), &err)
+	return r.repo.SearchMovies(ctx, search)
+}
+
+// OneMovie records a call to OneMovie of the wrapped repository.
+func (r *instrumentedRepo) OneMovie(ctx context.Context, id int) (_ *models.Movie, err error) {
+	defer r.observe("OneMovie", time.Now(), &err)
+	return r.repo.OneMovie(ctx, id)
+}
+
+// OneMovieForEdit records a call to OneMovieForEdit of the wrapped repository.
+func (r *instrumentedRepo) OneMovieForEdit(ctx context.Context, id int) (_ *models.Movie, _ []*models.Genre, err error) {
+	defer r.observe("OneMovieForEdit", time.Now(), &err)
+	return r.repo.OneMovieForEdit(ctx, id)
+}
+
+// GetUserByEmail records a call to GetUserByEmail of the wrapped repository.
+func (r *instrumentedRepo) GetUserByEmail(ctx context.Context, email string) (_ models.User, err error) {
+	defer r.observe("GetUserByEmail", time.Now(), &err)
+	return r.repo.GetUserByEmail(ctx, email)
+}
+
+// GetUserByID records a call to GetUserByID of the wrapped repository.
+func (r *instrumentedRepo) GetUserByID(ctx context.Context, id int) (_ models.User, err error) {
+	defer r.observe("GetUserByID", time.Now(), &err)
+	return r.repo.GetUserByID(ctx, id)
+}
+
+// InsertUser records a call to InsertUser of the wrapped repository.
+func (r *instrumentedRepo) InsertUser(ctx context.Context, user models.User) (_ int, err error) {
+	defer r.observe("InsertUser", time.Now(), &err)
+	return r.repo.InsertUser(ctx, user)
+}
+
+// UpdateUserPassword records a call to UpdateUserPassword of the wrapped repository.
+func (r *instrumentedRepo) UpdateUserPassword(ctx context.Context, id int, passwordHash string) (err error) {
+	defer r.observe("UpdateUserPassword", time.Now(), &err)
+	return r.repo.UpdateUserPassword(ctx, id, passwordHash)
+}
+
+// SetUserEmailVerified records a call to SetUserEmailVerified of the wrapped repository.
+func (r *instrumentedRepo) SetUserEmailVerified(ctx context.Context, id int) (err error) {
+	defer r.observe("SetUserEmailVerified", time.Now(), &err)
+	return r.repo.SetUserEmailVerified(ctx, id)
+}
+
+// InsertUserToken records a call to InsertUserToken of the wrapped repository.
+func (r *instrumentedRepo) InsertUserToken(ctx context.Context, token models.UserToken) (err error) {
+	defer r.observe("InsertUserToken", time.Now(), &err)
+	return r.repo.InsertUserToken(ctx, token)
+}
+
+// ConsumeUserToken records a call to ConsumeUserToken of the wrapped repository.
+func (r *instrumentedRepo) ConsumeUserToken(ctx context.Context, hash, scope string) (_ models.UserToken, err error) {
+	defer r.observe("ConsumeUserToken", time.Now(), &err)
+	return r.repo.ConsumeUserToken(ctx, hash, scope)
+}
+
+// AllGenresDB records a call to AllGenresDB of the wrapped repository.
+func (r *instrumentedRepo) AllGenresDB(ctx context.Context) (_ []*models.Genre, err error) {
+	defer r.observe("AllGenresDB", time.Now(), &err)
+	return r.repo.AllGenresDB(ctx)
+}
+
+// GenresForMovies records a call to GenresForMovies of the wrapped repository.
+func (r *instrumentedRepo) GenresForMovies(ctx context.Context, movieIDs []int) (_ map[int][]*models.Genre, err error) {
+	defer r.observe("GenresForMovies", time.Now(), &err)
+	return r.repo.GenresForMovies(ctx, movieIDs)
+}
+
+// InsertGenre records a call to InsertGenre of the wrapped repository.
+func (r *instrumentedRepo) InsertGenre(ctx context.Context, genre models.Genre) (_ int, err error) {
+	defer r.observe("InsertGenre", time.Now(), &err)
+	return r.repo.InsertGenre(ctx, genre)
+}
+
+// UpdateGenre records a call to UpdateGenre of the wrapped repository.
+func (r *instrumentedRepo) UpdateGenre(ctx context.Context, genre models.Genre) (err error) {
+	defer r.observe("UpdateGenre", time.Now(), &err)
+	return r.repo.UpdateGenre(ctx, genre)
+}
+
+// DeleteGenre records a call to DeleteGenre of the wrapped repository.
+func (r *instrumentedRepo) DeleteGenre(ctx context.Context, id int) (err error) {
+	defer r.observe("DeleteGenre", time.Now(), &err)
+	return r.repo.DeleteGenre(ctx, id)
+}
+
+// InsertMovie records a call to InsertMovie of the wrapped repository.
+func (r *instrumentedRepo) InsertMovie(ctx context.Context, movie models.Movie) (_ int, err error) {
+	defer r.observe("InsertMovie", time.Now(), &err)
+	return r.repo.InsertMovie(ctx, movie)
+}
+
+// UpdateMovieGenres records a call to UpdateMovieGenres of the wrapped repository.
+func (r *instrumentedRepo) UpdateMovieGenres(ctx context.Context, id int, genreIDs []int) (err error) {
+	defer r.observe("UpdateMovieGenres", time.Now(), &err)
+	return r.repo.UpdateMovieGenres(ctx, id, genreIDs)
+}
+
+// UpdateMovie records a call to UpdateMovie of the wrapped repository.
+func (r *instrumentedRepo) UpdateMovie(ctx context.Context, movie models.Movie) (err error) {
+	defer r.observe("UpdateMovie", time.Now(), &err)
+	return r.repo.UpdateMovie(ctx, movie)
+}
+
+// FillMovieImage records a call to FillMovieImage of the wrapped repository.
+func (r *instrumentedRepo) FillMovieImage(ctx context.Context, id int, image string) (_ bool, err error) {
+	defer r.observe("FillMovieImage", time.Now(), &err)
+	return r.repo.FillMovieImage(ctx, id, image)
+}
+
+// ReplaceMoviePoster records a call to ReplaceMoviePoster of the wrapped repository.
+func (r *instrumentedRepo) ReplaceMoviePoster(ctx context.Context, poster models.MoviePoster, image string) (_ *models.MoviePoster, err error) {
+	defer r.observe("ReplaceMoviePoster", time.Now(), &err)
+	return r.repo.ReplaceMoviePoster(ctx, poster, image)
+}
+
+// GetMoviePoster records a call to GetMoviePoster of the wrapped repository.
+func (r *instrumentedRepo) GetMoviePoster(ctx context.Context, movieID int) (_ models.MoviePoster, err error) {
+	defer r.observe("GetMoviePoster", time.Now(), &err)
+	return r.repo.GetMoviePoster(ctx, movieID)
+}
+
+// DeleteMovie records a call to DeleteMovie of the wrapped repository.
+func (r *instrumentedRepo) DeleteMovie(ctx context.Context, id int) (err error) {
+	defer r.observe("DeleteMovie", time.Now(), &err)
+	return r.repo.DeleteMovie(ctx, id)
+}
+
+// InsertRefreshToken records a call to InsertRefreshToken of the wrapped repository.
+func (r *instrumentedRepo) InsertRefreshToken(ctx context.Context, token models.RefreshToken) (err error) {
+	defer r.observe("InsertRefreshToken", time.Now(), &err)
+	return r.repo.InsertRefreshToken(ctx, token)
+}
+
+// GetRefreshTokenByHash records a call to GetRefreshTokenByHash of the wrapped repository.
+func (r *instrumentedRepo) GetRefreshTokenByHash(ctx context.Context, hash string) (_ models.RefreshToken, err error) {
+	defer r.observe("GetRefreshTokenByHash", time.Now(), &err)
+	return r.repo.GetRefreshTokenByHash(ctx, hash)
+}
+
+// RevokeRefreshToken records a call to RevokeRefreshToken of the wrapped repository.
+func (r *instrumentedRepo) RevokeRefreshToken(ctx context.Context, id int) (_ bool, err error) {
+	defer r.observe("RevokeRefreshToken", time.Now(), &err)
+	return r.repo.RevokeRefreshToken(ctx, id)
+}
+
+// RevokeRefreshTokenFamily records a call to RevokeRefreshTokenFamily of the wrapped repository.
+func (r *instrumentedRepo) RevokeRefreshTokenFamily(ctx context.Context, familyID string) (err error) {
+	defer r.observe("RevokeRefreshTokenFamily", time.Now(), &err)
+	return r.repo.RevokeRefreshTokenFamily(ctx, familyID)
+}
+
+// RevokeUserRefreshTokens records a call to RevokeUserRefreshTokens of the wrapped repository.
+func (r *instrumentedRepo) RevokeUserRefreshTokens(ctx context.Context, userID int) (err error) {
+	defer r.observe("RevokeUserRefreshTokens", time.Now(), &err)
+	return r.repo.RevokeUserRefreshTokens(ctx, userID)
+}
+
+// DeleteExpiredRefreshTokens records a call to DeleteExpiredRefreshTokens of the wrapped repository.
+func (r *instrumentedRepo) DeleteExpiredRefreshTokens(ctx context.Context) (_ int64, err error) {
+	defer r.observe("DeleteExpiredRefreshTokens", time.Now(), &err)
+	return r.repo.DeleteExpiredRefreshTokens(ctx)
+}
+
+// RecordLoginFailure records a call to RecordLoginFailure of the wrapped repository.
+func (r *instrumentedRepo) RecordLoginFailure(ctx context.Context, kind, subject string, at time.Time, window time.Duration) (_ models.LoginFailures, err error) {
+	defer r.observe("RecordLoginFailure", time.Now(), &err)
+	return r.repo.RecordLoginFailure(ctx, kind, subject, at, window)
+}
+
+// LockLogins records a call to LockLogins of the wrapped repository.
+func (r *instrumentedRepo) LockLogins(ctx context.Context, kind, subject string, until time.Time) (err error) {
+	defer r.observe("LockLogins", time.Now(), &err)
+	return r.repo.LockLogins(ctx, kind, subject, until)
+}
+
+// GetLoginFailures records a call to GetLoginFailures of the wrapped repository.
+func (r *instrumentedRepo) GetLoginFailures(ctx context.Context, kind, subject string) (_ models.LoginFailures, err error) {
+	defer r.observe("GetLoginFailures", time.Now(), &err)
+	return r.repo.GetLoginFailures(ctx, kind, subject)
+}
+
+// ListLoginLockouts records a call to ListLoginLockouts of the wrapped repository.
+func (r *instrumentedRepo) ListLoginLockouts(ctx context.Context, now time.Time) (_ []*models.LoginFailures, err error) {
+	defer r.observe("ListLoginLockouts", time.Now(), &err)
+	return r.repo.ListLoginLockouts(ctx, now)
+}
+
+// ClearLoginFailures records a call to ClearLoginFailures of the wrapped repository.
+func (r *instrumentedRepo) ClearLoginFailures(ctx context.Context, kind, subject string) (_ bool, err error) {
+	defer r.observe("ClearLoginFailures", time.Now(), &err)
+	return r.repo.ClearLoginFailures(ctx, kind, subject)
+}
+
+// DeleteStaleLoginFailures records a call to DeleteStaleLoginFailures of the wrapped repository.
+func (r *instrumentedRepo) DeleteStaleLoginFailures(ctx context.Context, before time.Time) (_ int64, err error) {
+	defer r.observe("DeleteStaleLoginFailures", time.Now(), &err)
+	return r.repo.DeleteStaleLoginFailures(ctx, before)
+}
+
+// SetUserTOTP records a call to SetUserTOTP of the wrapped repository.
+func (r *instrumentedRepo) SetUserTOTP(ctx context.Context, totp models.UserTOTP) (_ bool, err error) {
+	defer r.observe("SetUserTOTP", time.Now(), &err)
+	return r.repo.SetUserTOTP(ctx, totp)
+}
+
+// GetUserTOTP records a call to GetUserTOTP of the wrapped repository.
+func (r *instrumentedRepo) GetUserTOTP(ctx context.Context, userID int) (_ models.UserTOTP, err error) {
+	defer r.observe("GetUserTOTP", time.Now(), &err)
+	return r.repo.GetUserTOTP(ctx, userID)
+}
+
+// ConfirmUserTOTP records a call to ConfirmUserTOTP of the wrapped repository.
+func (r *instrumentedRepo) ConfirmUserTOTP(ctx context.Context, userID int, step int64) (_ bool, err error) {
+	defer r.observe("ConfirmUserTOTP", time.Now(), &err)
+	return r.repo.ConfirmUserTOTP(ctx, userID, step)
+}
+
+// UseTOTPStep records a call to UseTOTPStep of the wrapped repository.
+func (r *instrumentedRepo) UseTOTPStep(ctx context.Context, userID int, step int64) (_ bool, err error) {
+	defer r.observe("UseTOTPStep", time.Now(), &err)
+	return r.repo.UseTOTPStep(ctx, userID, step)
+}
+
+// DeleteUserTOTP records a call to DeleteUserTOTP of the wrapped repository.
+func (r *instrumentedRepo) DeleteUserTOTP(ctx context.Context, userID int) (err error) {
+	defer r.observe("DeleteUserTOTP", time.Now(), &err)
+	return r.repo.DeleteUserTOTP(ctx, userID)
+}
+
+// ReplaceRecoveryCodes records a call to ReplaceRecoveryCodes of the wrapped repository.
+func (r *instrumentedRepo) ReplaceRecoveryCodes(ctx context.Context, userID int, hashes []string) (err error) {
+	defer r.observe("ReplaceRecoveryCodes", time.Now(), &err)
+	return r.repo.ReplaceRecoveryCodes(ctx, userID, hashes)
+}
+
+// UseRecoveryCode records a call to UseRecoveryCode of the wrapped repository.
+func (r *instrumentedRepo) UseRecoveryCode(ctx context.Context, userID int, hash string) (_ bool, err error) {
+	defer r.observe("UseRecoveryCode", time.Now(), &err)
+	return r.repo.UseRecoveryCode(ctx, userID, hash)
+}
+
+// CountRecoveryCodes records a call to CountRecoveryCodes of the wrapped repository.
+func (r *instrumentedRepo) CountRecoveryCodes(ctx context.Context, userID int) (_ int, err error) {
+	defer r.observe("CountRecoveryCodes", time.Now(), &err)
+	return r.repo.CountRecoveryCodes(ctx, userID)
+}
@@ -0,0 +1,152 @@
+package migrations
+
+import (
+	"fmt"
+	"io/fs"
+	"os"
+	"strings"
+	"testing"
+	"testing/fstest"
+)
+
+// mapFS returns a file system with the given files in its sql directory, each containing its own name.
+func mapFS(names ...string) fstest.MapFS {
+
+	fsys := fstest.MapFS{}
+	for _, name := range names {
+		fsys["sql/"+name] = &fstest.MapFile{Data: []byte("-- " + name)}
+	}
+
+	return fsys
+}
+
+func TestLoad(t *testing.T) {
+
+	// directory order isn't version order, versions are compared as numbers
+	migrations, err := load(mapFS(
+		"0010_posters.down.sql", "0010_posters.up.sql",
+		"0002_refresh_tokens.up.sql", "0002_refresh_tokens.down.sql",
+		"0001_initial.up.sql", "0001_initial.down.sql",
+		"9_users.up.sql", "9_users.down.sql",
+	))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var versions []int
+	for _, migration := range migrations {
+		versions = append(versions, migration.Version)
+	}
+
+	if len(versions) != 4 || versions[0] != 1 || versions[1] != 2 || versions[2] != 9 || versions[3] != 10 {
+		t.Fatalf("versions = %v, want [1 2 9 10]", versions)
+	}
+
+	want := Migration{Version: 2, Name: "refresh_tokens", Up: "-- 0002_refresh_tokens.up.sql", Down: "-- 0002_refresh_tokens.down.sql"}
+	if migrations[1] != want {
+		t.Errorf("migration 2 = %+v, want %+v", migrations[1], want)
+	}
+
+	empty, err := load(fstest.MapFS{"sql": &fstest.MapFile{Mode: fs.ModeDir | 0o755}})
+	if err != nil || len(empty) != 0 {
+		t.Errorf("load of no migrations = %v, %v, want none", empty, err)
+	}
+}
+
+func TestLoadInvalid(t *testing.T) {
+
+	tests := []struct {
+		name  string
+		files []string
+		want  string
+	}{
+		{"no version", []string{"initial.up.sql", "initial.down.sql"}, `invalid migration file name "initial.down.sql"`},
+		{"no direction", []string{"0001_initial.sql"}, `invalid migration file name "0001_initial.sql"`},
+		{"wrong direction", []string{"0001_initial.sideways.sql"}, `invalid migration file name "0001_initial.sideways.sql"`},
+		{"wrong extension", []string{"0001_initial.up.txt"}, `invalid migration file name "0001_initial.up.txt"`},
+		{"dash in name", []string{"0001_refresh-tokens.up.sql"}, `invalid migration file name "0001_refresh-tokens.up.sql"`},
+		{"version zero", []string{"0000_initial.up.sql", "0000_initial.down.sql"}, `invalid migration version in file name "0000_initial.down.sql"`},
+		{"version overflow", []string{"99999999999999999999_initial.up.sql"}, `invalid migration version in file name "99999999999999999999_initial.up.sql"`},
+		{"missing down", []string{"0001_initial.up.sql", "0001_initial.down.sql", "0002_users.up.sql"}, "migration 2 is missing its up or down file"},
+		{"missing up", []string{"0001_initial.down.sql"}, "migration 1 is missing its up or down file"},
+		{"duplicate version", []string{"0001_initial.up.sql", "0001_initial.down.sql", "0001_users.up.sql"}, `migration 1 has conflicting names "initial" and "users"`},
+		{"duplicate padding", []string{"0001_initial.up.sql", "0001_initial.down.sql", "1_initial.up.sql"}, "migration 1 has more than one up file"},
+	}
+
+	for _, tt := range tests {
+		_, err := load(mapFS(tt.files...))
+		if err == nil || err.Error() != tt.want {
+			t.Errorf("%s: load = %v, want %q", tt.name, err, tt.want)
+		}
+	}
+
+	_, err := load(fstest.MapFS{})
+	if err == nil || !strings.Contains(err.Error(), "sql") {
+		t.Errorf("load without a sql directory = %v, want an error", err)
+	}
+}
+
+func TestEmbeddedMigrations(t *testing.T) {
+
+	migrations, err := load(files)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the embedded migrations are numbered without gaps
+	for i, migration := range migrations {
+		if migration.Version != i+1 {
+			t.Errorf("migration %d_%s is number %d", migration.Version, migration.Name, i+1)
+		}
+	}
+}
+
+func TestDownTarget(t *testing.T) {
+
+	m := &Migrator{migrations: []Migration{{Version: 1}, {Version: 2}, {Version: 3}, {Version: 5}}}
+
+	tests := []struct {
+		current int
+		steps   int
+		want    int
+	}{
+		{5, 1, 3},
+		{5, 2, 2},
+		{5, 3, 1},
+		{5, 4, 0},
+		{5, 10, 0},
+		{3, 1, 2},
+		{1, 1, 0},
+		{0, 1, 0},
+		// a version between two migrations reverts the migration before it first
+		{4, 1, 2},
+		// a database newer than the binary reverts the newest migrations the binary knows
+		{7, 1, 3},
+	}
+
+	for _, tt := range tests {
+		if got := m.downTarget(tt.current, tt.steps); got != tt.want {
+			t.Errorf("downTarget(%d, %d) = %d, want %d", tt.current, tt.steps, got, tt.want)
+		}
+	}
+}
+
+func TestSeedDump(t *testing.T) {
+
+	dump, err := os.ReadFile("../../sql/create_tables.sql")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	migrations, err := load(files)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// databases seeded from the dump have the schema of every migration, and record them as applied
+	for _, migration := range migrations {
+		if !strings.Contains(string(dump), fmt.Sprintf("(%d,\t'%s',", migration.Version, migration.Name)) {
+			t.Errorf("the seed dump doesn't record migration %d_%s", migration.Version, migration.Name)
+		}
+	}
+}
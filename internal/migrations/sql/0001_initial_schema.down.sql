@@ -0,0 +1,4 @@
+DROP TABLE IF EXISTS public.movies_genres;
+DROP TABLE IF EXISTS public.movies;
+DROP TABLE IF EXISTS public.genres;
+DROP TABLE IF EXISTS public.users;
@@ -0,0 +1,37 @@
+-- The initial schema matches sql/create_tables.sql, so databases that were created from that dump
+-- can be brought under migration control without changes.
+
+CREATE TABLE IF NOT EXISTS public.genres (
+    id integer GENERATED ALWAYS AS IDENTITY PRIMARY KEY,
+    genre character varying(255),
+    created_at timestamp without time zone,
+    updated_at timestamp without time zone
+);
+
+CREATE TABLE IF NOT EXISTS public.movies (
+    id integer GENERATED ALWAYS AS IDENTITY PRIMARY KEY,
+    title character varying(512),
+    release_date date,
+    runtime integer,
+    mpaa_rating character varying(10),
+    description text,
+    image character varying(255),
+    created_at timestamp without time zone,
+    updated_at timestamp without time zone
+);
+
+CREATE TABLE IF NOT EXISTS public.movies_genres (
+    id integer GENERATED ALWAYS AS IDENTITY PRIMARY KEY,
+    movie_id integer REFERENCES public.movies(id) ON UPDATE CASCADE ON DELETE CASCADE,
+    genre_id integer REFERENCES public.genres(id) ON UPDATE CASCADE ON DELETE CASCADE
+);
+
+CREATE TABLE IF NOT EXISTS public.users (
+    id integer GENERATED ALWAYS AS IDENTITY PRIMARY KEY,
+    first_name character varying(255),
+    last_name character varying(255),
+    email character varying(255),
+    password character varying(255),
+    created_at timestamp without time zone,
+    updated_at timestamp without time zone
+);
@@ -0,0 +1,13 @@
+CREATE TABLE IF NOT EXISTS public.refresh_tokens (
+    id integer GENERATED ALWAYS AS IDENTITY PRIMARY KEY,
+    user_id integer NOT NULL REFERENCES public.users(id) ON UPDATE CASCADE ON DELETE CASCADE,
+    token_hash character varying(64) NOT NULL,
+    family_id character varying(64) NOT NULL,
+    expires_at timestamp without time zone NOT NULL,
+    revoked_at timestamp without time zone,
+    created_at timestamp without time zone
+);
+
+CREATE UNIQUE INDEX IF NOT EXISTS refresh_tokens_token_hash_key ON public.refresh_tokens USING btree (token_hash);
+
+CREATE INDEX IF NOT EXISTS refresh_tokens_family_id_idx ON public.refresh_tokens USING btree (family_id);
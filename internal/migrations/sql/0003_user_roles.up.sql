@@ -0,0 +1,10 @@
+-- Users that existed before roles were introduced could edit and delete every movie, so they keep that access.
+DO $$
+BEGIN
+    IF NOT EXISTS (SELECT 1 FROM information_schema.columns
+                   WHERE table_schema = 'public' AND table_name = 'users' AND column_name = 'role') THEN
+        ALTER TABLE public.users ADD COLUMN role character varying(20) DEFAULT 'viewer'::character varying NOT NULL;
+        UPDATE public.users SET role = 'admin';
+    END IF;
+END
+$$;
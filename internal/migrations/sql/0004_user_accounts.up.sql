@@ -0,0 +1,24 @@
+-- Users that existed before email verification was introduced are considered verified.
+DO $$
+BEGIN
+    IF NOT EXISTS (SELECT 1 FROM information_schema.columns
+                   WHERE table_schema = 'public' AND table_name = 'users' AND column_name = 'email_verified_at') THEN
+        ALTER TABLE public.users ADD COLUMN email_verified_at timestamp without time zone;
+        UPDATE public.users SET email_verified_at = coalesce(created_at, now());
+    END IF;
+END
+$$;
+
+CREATE UNIQUE INDEX IF NOT EXISTS users_email_key ON public.users USING btree (lower((email)::text));
+
+CREATE TABLE IF NOT EXISTS public.user_tokens (
+    id integer GENERATED ALWAYS AS IDENTITY PRIMARY KEY,
+    user_id integer NOT NULL REFERENCES public.users(id) ON UPDATE CASCADE ON DELETE CASCADE,
+    token_hash character varying(64) NOT NULL,
+    scope character varying(32) NOT NULL,
+    expires_at timestamp without time zone NOT NULL,
+    used_at timestamp without time zone,
+    created_at timestamp without time zone
+);
+
+CREATE UNIQUE INDEX IF NOT EXISTS user_tokens_token_hash_key ON public.user_tokens USING btree (token_hash);
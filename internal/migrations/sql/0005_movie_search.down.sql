@@ -0,0 +1,3 @@
+DROP INDEX IF EXISTS public.movies_title_trgm_idx;
+DROP INDEX IF EXISTS public.movies_search_vector_idx;
+ALTER TABLE public.movies DROP COLUMN IF EXISTS search_vector;
@@ -0,0 +1,11 @@
+CREATE EXTENSION IF NOT EXISTS pg_trgm WITH SCHEMA public;
+
+ALTER TABLE public.movies ADD COLUMN IF NOT EXISTS search_vector tsvector
+    GENERATED ALWAYS AS (
+        setweight(to_tsvector('english'::regconfig, coalesce(title, '')), 'A') ||
+        setweight(to_tsvector('english'::regconfig, coalesce(description, '')), 'B')
+    ) STORED;
+
+CREATE INDEX IF NOT EXISTS movies_search_vector_idx ON public.movies USING gin (search_vector);
+
+CREATE INDEX IF NOT EXISTS movies_title_trgm_idx ON public.movies USING gin (title public.gin_trgm_ops);
@@ -0,0 +1,10 @@
+CREATE TABLE IF NOT EXISTS public.movie_posters (
+    movie_id integer PRIMARY KEY REFERENCES public.movies(id) ON UPDATE CASCADE ON DELETE CASCADE,
+    source character varying(512) NOT NULL,
+    dominant_color character varying(7) NOT NULL,
+    placeholder text NOT NULL,
+    width integer NOT NULL,
+    height integer NOT NULL,
+    created_at timestamp without time zone NOT NULL,
+    updated_at timestamp without time zone NOT NULL
+);
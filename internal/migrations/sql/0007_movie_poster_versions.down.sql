@@ -0,0 +1 @@
+ALTER TABLE public.movie_posters DROP COLUMN IF EXISTS version;
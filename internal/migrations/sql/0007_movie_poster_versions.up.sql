@@ -0,0 +1 @@
+ALTER TABLE public.movie_posters ADD COLUMN IF NOT EXISTS version character varying(32) NOT NULL DEFAULT '';
@@ -0,0 +1,10 @@
+CREATE TABLE IF NOT EXISTS public.login_failures (
+    kind character varying(16) NOT NULL,
+    subject character varying(255) NOT NULL,
+    failures integer NOT NULL,
+    last_failure_at timestamp without time zone NOT NULL,
+    locked_until timestamp without time zone,
+    PRIMARY KEY (kind, subject)
+);
+
+CREATE INDEX IF NOT EXISTS login_failures_locked_until_idx ON public.login_failures USING btree (locked_until);
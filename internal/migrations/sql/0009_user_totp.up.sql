@@ -0,0 +1,17 @@
+CREATE TABLE IF NOT EXISTS public.user_totp (
+    user_id integer PRIMARY KEY REFERENCES public.users(id) ON UPDATE CASCADE ON DELETE CASCADE,
+    secret character varying(64) NOT NULL,
+    last_step bigint NOT NULL DEFAULT 0,
+    confirmed_at timestamp without time zone,
+    created_at timestamp without time zone NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS public.recovery_codes (
+    id integer GENERATED ALWAYS AS IDENTITY PRIMARY KEY,
+    user_id integer NOT NULL REFERENCES public.users(id) ON UPDATE CASCADE ON DELETE CASCADE,
+    code_hash character varying(64) NOT NULL,
+    used_at timestamp without time zone,
+    created_at timestamp without time zone NOT NULL
+);
+
+CREATE UNIQUE INDEX IF NOT EXISTS recovery_codes_user_id_code_hash_key ON public.recovery_codes USING btree (user_id, code_hash);
@@ -0,0 +1,29 @@
+package models
+
+import "time"
+
+// Kinds of the subjects whose failed logins are counted.
+const (
+	LoginSubjectAccount = "account"
+	LoginSubjectIP      = "ip"
+)
+
+// LoginFailures is a struct that holds the recent failed logins of an account, identified by its email address,
+// or of a client IP address.
+type LoginFailures struct {
+	Kind          string     `json:"kind"`
+	Subject       string     `json:"subject"`
+	Failures      int        `json:"failures"`
+	LastFailureAt time.Time  `json:"last_failure_at"`
+	LockedUntil   *time.Time `json:"locked_until,omitempty"`
+}
+
+// RetryAfter returns how long logins of the subject remain locked at the given time, 0 when they aren't.
+func (f *LoginFailures) RetryAfter(now time.Time) time.Duration {
+
+	if f.LockedUntil == nil || !f.LockedUntil.After(now) {
+		return 0
+	}
+
+	return f.LockedUntil.Sub(now)
+}
@@ -3,18 +3,19 @@ package models
 import "time"
 
 type Movie struct {
-	ID          int       `json:"id"`
-	Title       string    `json:"title"`
-	ReleaseDate time.Time `json:"release_date"`
-	Runtime     int       `json:"runtime"`
-	MPAARating  string    `json:"mpaa_rating"`
-	Description string    `json:"description"`
-	Genre       string    `json:"genre,omitempty"`
-	Image       string    `json:"image,omitempty"`
-	CreatedAt   time.Time `json:"-"`
-	UpdatedAt   time.Time `json:"-"`
-	Genres      []*Genre  `json:"genres,omitempty"`
-	GenresArray []int     `json:"genres_array,omitempty"`
+	ID          int          `json:"id"`
+	Title       string       `json:"title"`
+	ReleaseDate time.Time    `json:"release_date"`
+	Runtime     int          `json:"runtime"`
+	MPAARating  string       `json:"mpaa_rating"`
+	Description string       `json:"description"`
+	Genre       string       `json:"genre,omitempty"`
+	Image       string       `json:"image,omitempty"`
+	CreatedAt   time.Time    `json:"-"`
+	UpdatedAt   time.Time    `json:"-"`
+	Genres      []*Genre     `json:"genres,omitempty"`
+	GenresArray []int        `json:"genres_array,omitempty"`
+	Poster      *MoviePoster `json:"poster,omitempty"`
 }
 
 type Genre struct {
@@ -24,3 +25,11 @@ type Genre struct {
 	CreatedAt time.Time `json:"-"`
 	UpdatedAt time.Time `json:"-"`
 }
+
+// MovieSearchResult is a movie matched by a search, along with its relevance and a highlighted excerpt. The
+// snippet is HTML: the description is escaped, and the matches are wrapped in <mark> tags.
+type MovieSearchResult struct {
+	Movie
+	Rank    float64 `json:"rank"`
+	Snippet string  `json:"snippet"`
+}
@@ -0,0 +1,18 @@
+package models
+
+import "time"
+
+// MoviePoster describes the poster images stored for a movie. The images themselves are kept in the image
+// store, in every size and under a new version whenever the poster is replaced, while the dominant color and
+// the tiny placeholder let clients render something before they are loaded.
+type MoviePoster struct {
+	MovieID       int       `json:"movie_id"`
+	Version       string    `json:"version"`
+	Source        string    `json:"-"`
+	DominantColor string    `json:"dominant_color"`
+	Placeholder   string    `json:"placeholder"`
+	Width         int       `json:"width"`
+	Height        int       `json:"height"`
+	CreatedAt     time.Time `json:"-"`
+	UpdatedAt     time.Time `json:"updated_at"`
+}
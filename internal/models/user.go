@@ -3,18 +3,55 @@ package models
 import (
 	"errors"
 	"golang.org/x/crypto/bcrypt"
+	"sync"
 	"time"
 )
 
 // User is a struct that holds the user information.
 type User struct {
-	ID        int       `json:"id"`
-	FirstName string    `json:"first_name"`
-	LastName  string    `json:"last_name"`
-	Email     string    `json:"email"`
-	Password  string    `json:"password"`
-	CreatedAt time.Time `json:"-"`
-	UpdatedAt time.Time `json:"-"`
+	ID              int        `json:"id"`
+	FirstName       string     `json:"first_name"`
+	LastName        string     `json:"last_name"`
+	Email           string     `json:"email"`
+	Password        string     `json:"password"`
+	Role            string     `json:"role"`
+	EmailVerifiedAt *time.Time `json:"-"`
+	CreatedAt       time.Time  `json:"-"`
+	UpdatedAt       time.Time  `json:"-"`
+}
+
+// passwordCost is the bcrypt cost used to hash new passwords.
+const passwordCost = 12
+
+// SetPassword hashes the plain text password and stores the hash in the user.
+func (u *User) SetPassword(plainText string) error {
+
+	hash, err := bcrypt.GenerateFromPassword([]byte(plainText), passwordCost)
+	if err != nil {
+		return err
+	}
+
+	u.Password = string(hash)
+
+	return nil
+}
+
+// PasswordNeedsRehash reports whether the password hash was made with another cost than new hashes, such as
+// the hashes stored before the cost was lowered. Logins rehash them, so that every hash takes as long to
+// compare as the hash of ComparePasswordWithoutUser.
+func (u *User) PasswordNeedsRehash() bool {
+
+	cost, err := bcrypt.Cost([]byte(u.Password))
+	if err != nil {
+		return false
+	}
+
+	return cost != passwordCost
+}
+
+// IsEmailVerified reports whether the user has confirmed their email address.
+func (u *User) IsEmailVerified() bool {
+	return u.EmailVerifiedAt != nil
 }
 
 // PasswordMatches compares the plain text password with the hashed password.
@@ -34,3 +71,47 @@ func (u *User) PasswordMatches(plainText string) (bool, error) {
 
 	return true, nil
 }
+
+// Roles a user can have, from least to most privileged.
+const (
+	RoleViewer = "viewer"
+	RoleEditor = "editor"
+	RoleAdmin  = "admin"
+)
+
+// roleRanks orders the roles by privilege, each role includes the permissions of the roles ranked below it.
+var roleRanks = map[string]int{
+	RoleViewer: 1,
+	RoleEditor: 2,
+	RoleAdmin:  3,
+}
+
+// RoleSatisfies reports whether role grants at least the permissions of the required role. Unknown roles
+// neither grant nor can be granted anything.
+func RoleSatisfies(role, required string) bool {
+	rank, ok := roleRanks[role]
+	if !ok {
+		return false
+	}
+
+	requiredRank, ok := roleRanks[required]
+	if !ok {
+		return false
+	}
+
+	return rank >= requiredRank
+}
+
+// noUserPassword is the hash compared with when there is no user, computed on first use.
+var noUserPassword = sync.OnceValue(func() []byte {
+	// the password is short enough for bcrypt and the cost valid, it can't fail
+	hash, _ := bcrypt.GenerateFromPassword([]byte("no user has this password"), passwordCost)
+	return hash
+})
+
+// ComparePasswordWithoutUser takes as long as PasswordMatches with a wrong password, for hashes of passwordCost.
+// Logins of unknown email addresses call it, so that their response time doesn't reveal which addresses are
+// registered, and logins rehash the hashes of other costs.
+func ComparePasswordWithoutUser(plainText string) {
+	_ = bcrypt.CompareHashAndPassword(noUserPassword(), []byte(plainText))
+}
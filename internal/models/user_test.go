@@ -0,0 +1,36 @@
+package models
+
+import (
+	"testing"
+)
+
+func TestRoleSatisfies(t *testing.T) {
+
+	tests := []struct {
+		role     string
+		required string
+		want     bool
+	}{
+		{RoleViewer, RoleViewer, true},
+		{RoleViewer, RoleEditor, false},
+		{RoleViewer, RoleAdmin, false},
+		{RoleEditor, RoleViewer, true},
+		{RoleEditor, RoleEditor, true},
+		{RoleEditor, RoleAdmin, false},
+		{RoleAdmin, RoleViewer, true},
+		{RoleAdmin, RoleEditor, true},
+		{RoleAdmin, RoleAdmin, true},
+		// unknown and missing roles grant nothing, and a mistyped requirement isn't met by anyone
+		{"", RoleViewer, false},
+		{"superuser", RoleViewer, false},
+		{"Admin", RoleAdmin, false},
+		{RoleAdmin, "", false},
+		{RoleAdmin, "admins", false},
+	}
+
+	for _, tt := range tests {
+		if got := RoleSatisfies(tt.role, tt.required); got != tt.want {
+			t.Errorf("RoleSatisfies(%q, %q) = %v, want %v", tt.role, tt.required, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,20 @@
+package models
+
+import "time"
+
+// Scopes of the single-use tokens sent to users by email.
+const (
+	TokenScopeEmailVerification = "email_verification"
+	TokenScopePasswordReset     = "password_reset"
+)
+
+// UserToken is a struct that holds a single-use token sent to a user. Only the hash of the token is kept.
+type UserToken struct {
+	ID        int        `json:"id"`
+	UserID    int        `json:"user_id"`
+	TokenHash string     `json:"-"`
+	Scope     string     `json:"scope"`
+	ExpiresAt time.Time  `json:"expires_at"`
+	UsedAt    *time.Time `json:"used_at,omitempty"`
+	CreatedAt time.Time  `json:"-"`
+}
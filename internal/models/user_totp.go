@@ -0,0 +1,19 @@
+package models
+
+import "time"
+
+// UserTOTP is a struct that holds the TOTP secret of a user. Until it is confirmed with a first code, the user
+// is enrolling and still logs in with their password alone.
+type UserTOTP struct {
+	UserID int    `json:"user_id"`
+	Secret string `json:"-"`
+	// LastStep is the step of the last code used, codes of earlier steps are refused.
+	LastStep    int64      `json:"-"`
+	ConfirmedAt *time.Time `json:"confirmed_at,omitempty"`
+	CreatedAt   time.Time  `json:"created_at"`
+}
+
+// IsConfirmed reports whether the user confirmed the secret, which enables two-factor authentication.
+func (t *UserTOTP) IsConfirmed() bool {
+	return t.ConfirmedAt != nil
+}
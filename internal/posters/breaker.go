@@ -0,0 +1,70 @@
+package posters
+
+import (
+	"errors"
+	"sync"
+	"time"
+)
+
+// ErrCircuitOpen is returned without calling the provider while the circuit breaker is open.
+var ErrCircuitOpen = errors.New("posters: circuit breaker is open")
+
+// Breaker is a circuit breaker. After Threshold consecutive failures it opens and rejects every call for
+// Cooldown, then lets a single trial call through, which closes it again on success.
+type Breaker struct {
+	Threshold int
+	Cooldown  time.Duration
+
+	mu       sync.Mutex
+	failures int
+	openedAt time.Time
+	trial    bool
+	now      func() time.Time
+}
+
+// Allow reports whether a call may be made. A caller that is allowed must report the outcome with Done.
+func (b *Breaker) Allow() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.openedAt.IsZero() {
+		return nil
+	}
+
+	if b.trial || b.clock().Sub(b.openedAt) < b.Cooldown {
+		return ErrCircuitOpen
+	}
+
+	// half-open, let a single call through
+	b.trial = true
+
+	return nil
+}
+
+// Done records the outcome of an allowed call.
+func (b *Breaker) Done(success bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.trial = false
+
+	if success {
+		b.failures = 0
+		b.openedAt = time.Time{}
+		return
+	}
+
+	b.failures++
+
+	if !b.openedAt.IsZero() || b.failures >= b.Threshold {
+		b.openedAt = b.clock()
+	}
+}
+
+// clock returns the current time. The caller must hold the lock.
+func (b *Breaker) clock() time.Time {
+	if b.now != nil {
+		return b.now()
+	}
+	return time.Now()
+}
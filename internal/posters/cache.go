@@ -0,0 +1,117 @@
+package posters
+
+import (
+	"container/list"
+	"context"
+	"errors"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Cache is a Provider remembering the lookups of another provider. Titles without a poster are remembered
+// too, for NegativeTTL, so that they are not looked up again on every request; errors are never cached.
+type Cache struct {
+	provider    Provider
+	ttl         time.Duration
+	negativeTTL time.Duration
+	maxEntries  int
+
+	mu      sync.Mutex
+	entries map[string]*list.Element
+	lru     *list.List
+	now     func() time.Time
+}
+
+// cacheEntry is a cached lookup.
+type cacheEntry struct {
+	key     string
+	poster  string
+	expires time.Time
+}
+
+// NewCache returns a Cache of at most maxEntries lookups, the least recently used are evicted first.
+func NewCache(provider Provider, ttl, negativeTTL time.Duration, maxEntries int) *Cache {
+	return &Cache{
+		provider:    provider,
+		ttl:         ttl,
+		negativeTTL: negativeTTL,
+		maxEntries:  maxEntries,
+		entries:     map[string]*list.Element{},
+		lru:         list.New(),
+		now:         time.Now,
+	}
+}
+
+// Poster returns the cached poster of the title, looking it up with the provider when it isn't cached.
+func (c *Cache) Poster(ctx context.Context, title string) (string, error) {
+
+	key := strings.ToLower(strings.Join(strings.Fields(title), " "))
+
+	if poster, ok := c.get(key); ok {
+		if poster == "" {
+			return "", ErrNotFound
+		}
+		return poster, nil
+	}
+
+	poster, err := c.provider.Poster(ctx, title)
+
+	switch {
+	case err == nil:
+		c.put(key, poster, c.ttl)
+	case errors.Is(err, ErrNotFound):
+		c.put(key, "", c.negativeTTL)
+	}
+
+	return poster, err
+}
+
+// get returns the cached poster of a key, an empty poster is a cached miss.
+func (c *Cache) get(key string) (string, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	element, ok := c.entries[key]
+	if !ok {
+		return "", false
+	}
+
+	entry := element.Value.(*cacheEntry)
+
+	if c.now().After(entry.expires) {
+		c.lru.Remove(element)
+		delete(c.entries, key)
+		return "", false
+	}
+
+	c.lru.MoveToFront(element)
+
+	return entry.poster, true
+}
+
+// put caches the poster of a key for ttl.
+func (c *Cache) put(key, poster string, ttl time.Duration) {
+	if ttl <= 0 || c.maxEntries <= 0 {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	entry := &cacheEntry{key: key, poster: poster, expires: c.now().Add(ttl)}
+
+	if element, ok := c.entries[key]; ok {
+		element.Value = entry
+		c.lru.MoveToFront(element)
+		return
+	}
+
+	c.entries[key] = c.lru.PushFront(entry)
+
+	for c.lru.Len() > c.maxEntries {
+		oldest := c.lru.Back()
+		c.lru.Remove(oldest)
+		delete(c.entries, oldest.Value.(*cacheEntry).key)
+	}
+}
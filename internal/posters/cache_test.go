@@ -0,0 +1,87 @@
+package posters
+
+import (
+	"context"
+	"errors"
+	"github.com/calvarado2004/go-movies-backend/internal/posters/posterstest"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestCache(t *testing.T) {
+
+	fake := posterstest.NewTMDB(t, "key")
+	fake.SetPoster("Alien", "/alien.jpg")
+
+	cache := NewCache(newTestTMDB(fake, 0), time.Hour, time.Minute, 10)
+
+	now := time.Now()
+	cache.now = func() time.Time { return now }
+
+	for _, title := range []string{"Alien", "alien", "  ALIEN "} {
+		poster, err := cache.Poster(context.Background(), title)
+		if err != nil || poster != "/alien.jpg" {
+			t.Fatalf("Poster(%q) = %q, %v, want /alien.jpg", title, poster, err)
+		}
+	}
+
+	if fake.Requests() != 1 {
+		t.Errorf("sent %d requests, want 1", fake.Requests())
+	}
+
+	// misses are cached for the negative ttl
+	for i := 0; i < 2; i++ {
+		_, err := cache.Poster(context.Background(), "Unknown")
+		if !errors.Is(err, ErrNotFound) {
+			t.Fatalf("Poster of an unknown movie returned %v, want ErrNotFound", err)
+		}
+	}
+
+	if fake.Requests() != 2 {
+		t.Errorf("sent %d requests, want 2", fake.Requests())
+	}
+
+	now = now.Add(2 * time.Minute)
+
+	_, _ = cache.Poster(context.Background(), "Unknown")
+	_, _ = cache.Poster(context.Background(), "Alien")
+
+	if fake.Requests() != 3 {
+		t.Errorf("sent %d requests after the miss expired, want 3", fake.Requests())
+	}
+}
+
+func TestCacheDoesNotCacheErrors(t *testing.T) {
+
+	fake := posterstest.NewTMDB(t, "key")
+	fake.SetPoster("Alien", "/alien.jpg")
+	fake.FailNext(1, http.StatusBadGateway)
+
+	cache := NewCache(newTestTMDB(fake, 0), time.Hour, time.Hour, 10)
+
+	_, err := cache.Poster(context.Background(), "Alien")
+	if err == nil {
+		t.Fatal("Poster succeeded while TMDB was failing")
+	}
+
+	poster, err := cache.Poster(context.Background(), "Alien")
+	if err != nil || poster != "/alien.jpg" {
+		t.Fatalf("Poster = %q, %v, want /alien.jpg", poster, err)
+	}
+}
+
+func TestCacheEviction(t *testing.T) {
+
+	fake := posterstest.NewTMDB(t, "key")
+	cache := NewCache(newTestTMDB(fake, 0), time.Hour, time.Hour, 2)
+
+	for _, title := range []string{"A", "B", "A", "C", "A", "B"} {
+		_, _ = cache.Poster(context.Background(), title)
+	}
+
+	// B is evicted by C, being less recently used than A
+	if fake.Requests() != 4 {
+		t.Errorf("sent %d requests, want 4", fake.Requests())
+	}
+}
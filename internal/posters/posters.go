@@ -0,0 +1,15 @@
+package posters
+
+import (
+	"context"
+	"errors"
+)
+
+// ErrNotFound is returned when a provider has no poster for a title.
+var ErrNotFound = errors.New("posters: no poster found")
+
+// Provider is the interface implemented by everything that can look up the poster of a movie.
+type Provider interface {
+	// Poster returns the poster path of the movie with the given title, or ErrNotFound.
+	Poster(ctx context.Context, title string) (string, error)
+}
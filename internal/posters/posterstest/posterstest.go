@@ -0,0 +1,137 @@
+// Package posterstest provides a fake TMDB server, so that poster lookups can be tested offline.
+package posterstest
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// TMDB is a fake of the TMDB search API, serving the posters it is given. It also serves poster images
+// under their path, standing in for the TMDB image server.
+type TMDB struct {
+	URL    string
+	APIKey string
+
+	mu        sync.Mutex
+	posters   map[string]string
+	images    map[string][]byte
+	failures  int
+	status    int
+	requests  int
+	lastQuery string
+}
+
+// NewTMDB starts a fake TMDB server requiring the given API key, which is closed when the test ends.
+func NewTMDB(t *testing.T, apiKey string) *TMDB {
+	t.Helper()
+
+	fake := &TMDB{
+		APIKey:  apiKey,
+		posters: map[string]string{},
+		images:  map[string][]byte{},
+	}
+
+	server := httptest.NewServer(http.HandlerFunc(fake.serve))
+	t.Cleanup(server.Close)
+
+	fake.URL = server.URL
+
+	return fake
+}
+
+// SetPoster makes searches for the title, ignoring case, return the poster path.
+func (f *TMDB) SetPoster(title, posterPath string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.posters[strings.ToLower(title)] = posterPath
+}
+
+// SetImage makes the server return the image data for the poster path.
+func (f *TMDB) SetImage(posterPath string, data []byte) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.images[posterPath] = data
+}
+
+// FailNext makes the next n searches fail with the status code.
+func (f *TMDB) FailNext(n, status int) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.failures = n
+	f.status = status
+}
+
+// Requests returns the number of search requests received, image downloads are not counted.
+func (f *TMDB) Requests() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	return f.requests
+}
+
+// LastQuery returns the query of the last search request received.
+func (f *TMDB) LastQuery() string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	return f.lastQuery
+}
+
+// serve answers a request to the search API or for a poster image.
+func (f *TMDB) serve(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if image, ok := f.images[r.URL.Path]; ok {
+		w.Header().Set("Content-Type", http.DetectContentType(image))
+		_, _ = w.Write(image)
+		return
+	}
+
+	f.requests++
+
+	if r.URL.Path != "/search/movie" {
+		http.NotFound(w, r)
+		return
+	}
+
+	if r.URL.Query().Get("api_key") != f.APIKey {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	if f.failures > 0 {
+		f.failures--
+		w.WriteHeader(f.status)
+		return
+	}
+
+	f.lastQuery = r.URL.Query().Get("query")
+
+	type result struct {
+		PosterPath string `json:"poster_path"`
+	}
+
+	response := struct {
+		Page    int      `json:"page"`
+		Results []result `json:"results"`
+	}{Page: 1, Results: []result{}}
+
+	if poster, ok := f.posters[strings.ToLower(f.lastQuery)]; ok {
+		response.Results = append(response.Results, result{PosterPath: poster})
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+
+	err := json.NewEncoder(w).Encode(response)
+	if err != nil {
+		return
+	}
+}
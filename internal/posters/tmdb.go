@@ -0,0 +1,243 @@
+package posters
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// DefaultTMDBURL is the base URL of the TMDB API.
+const DefaultTMDBURL = "https://api.themoviedb.org/3"
+
+// TMDBConfig holds the settings of a TMDB provider. Zero values use the defaults.
+type TMDBConfig struct {
+	BaseURL          string
+	APIKey           string
+	Timeout          time.Duration
+	Retries          int
+	Backoff          time.Duration
+	MaxBackoff       time.Duration
+	BreakerThreshold int
+	BreakerCooldown  time.Duration
+	Client           *http.Client
+}
+
+// TMDB looks up posters with the search API of The Movie Database. Failed requests are retried with
+// exponential backoff, and a circuit breaker stops calling the API while it keeps failing.
+type TMDB struct {
+	baseURL    string
+	apiKey     string
+	client     *http.Client
+	retries    int
+	backoff    time.Duration
+	maxBackoff time.Duration
+	breaker    *Breaker
+	sleep      func(ctx context.Context, d time.Duration) error
+}
+
+// NewTMDB returns a TMDB provider with the given settings.
+func NewTMDB(cfg TMDBConfig) *TMDB {
+
+	if cfg.BaseURL == "" {
+		cfg.BaseURL = DefaultTMDBURL
+	}
+	if cfg.Timeout <= 0 {
+		cfg.Timeout = 5 * time.Second
+	}
+	if cfg.Retries < 0 {
+		cfg.Retries = 0
+	}
+	if cfg.Backoff <= 0 {
+		cfg.Backoff = 200 * time.Millisecond
+	}
+	if cfg.MaxBackoff <= 0 {
+		cfg.MaxBackoff = 5 * time.Second
+	}
+	if cfg.BreakerThreshold <= 0 {
+		cfg.BreakerThreshold = 5
+	}
+	if cfg.BreakerCooldown <= 0 {
+		cfg.BreakerCooldown = 30 * time.Second
+	}
+	if cfg.Client == nil {
+		cfg.Client = &http.Client{Timeout: cfg.Timeout}
+	}
+
+	return &TMDB{
+		baseURL:    strings.TrimRight(cfg.BaseURL, "/"),
+		apiKey:     cfg.APIKey,
+		client:     cfg.Client,
+		retries:    cfg.Retries,
+		backoff:    cfg.Backoff,
+		maxBackoff: cfg.MaxBackoff,
+		breaker:    &Breaker{Threshold: cfg.BreakerThreshold, Cooldown: cfg.BreakerCooldown},
+		sleep:      sleep,
+	}
+}
+
+// tmdbSearch is the part of a TMDB search response used to find posters.
+type tmdbSearch struct {
+	Results []struct {
+		PosterPath string `json:"poster_path"`
+	} `json:"results"`
+}
+
+// statusError is an unexpected status code returned by TMDB.
+type statusError struct {
+	code       int
+	retryAfter time.Duration
+}
+
+// Error returns the status code of the error.
+func (e *statusError) Error() string {
+	return fmt.Sprintf("posters: tmdb returned status %d", e.code)
+}
+
+// retryable reports whether a request failing with err may succeed if it is sent again.
+func retryable(err error) bool {
+
+	var status *statusError
+	if errors.As(err, &status) {
+		return status.code == http.StatusTooManyRequests || status.code >= 500
+	}
+
+	// network errors and timeouts
+	return true
+}
+
+// Poster returns the poster path of the first search result for the title.
+func (t *TMDB) Poster(ctx context.Context, title string) (string, error) {
+
+	err := t.breaker.Allow()
+	if err != nil {
+		return "", err
+	}
+
+	poster, err := t.search(ctx, title)
+
+	// a title without a poster is a successful lookup, and a caller giving up says nothing about TMDB
+	t.breaker.Done(err == nil || errors.Is(err, ErrNotFound) || errors.Is(err, context.Canceled))
+
+	return poster, err
+}
+
+// search sends the search request, retrying it with backoff while it fails with a retryable error.
+func (t *TMDB) search(ctx context.Context, title string) (string, error) {
+
+	var err error
+
+	for attempt := 0; ; attempt++ {
+		var poster string
+
+		poster, err = t.searchOnce(ctx, title)
+		if err == nil || errors.Is(err, ErrNotFound) || !retryable(err) || attempt >= t.retries {
+			return poster, err
+		}
+
+		if ctx.Err() != nil {
+			return "", ctx.Err()
+		}
+
+		wait := t.backoffFor(attempt)
+
+		var status *statusError
+		if errors.As(err, &status) && status.retryAfter > wait {
+			wait = status.retryAfter
+		}
+
+		if wait > t.maxBackoff {
+			wait = t.maxBackoff
+		}
+
+		sleepErr := t.sleep(ctx, wait)
+		if sleepErr != nil {
+			return "", err
+		}
+	}
+}
+
+// backoffFor returns the exponential backoff before the retry following the given attempt, with jitter.
+func (t *TMDB) backoffFor(attempt int) time.Duration {
+
+	backoff := t.backoff << attempt
+	if backoff <= 0 || backoff > t.maxBackoff {
+		backoff = t.maxBackoff
+	}
+
+	// full jitter spreads the retries of concurrent lookups
+	return backoff/2 + time.Duration(rand.Int63n(int64(backoff/2)+1))
+}
+
+// searchOnce sends a single search request.
+func (t *TMDB) searchOnce(ctx context.Context, title string) (string, error) {
+
+	qs := url.Values{}
+	qs.Set("api_key", t.apiKey)
+	qs.Set("query", title)
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, t.baseURL+"/search/movie?"+qs.Encode(), nil)
+	if err != nil {
+		return "", err
+	}
+
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := t.client.Do(req)
+	if err != nil {
+		// the error holds the URL, which holds the API key
+		var urlErr *url.Error
+		if errors.As(err, &urlErr) {
+			return "", fmt.Errorf("posters: tmdb request failed: %w", urlErr.Err)
+		}
+		return "", err
+	}
+
+	defer func(Body io.ReadCloser) {
+		err := Body.Close()
+		if err != nil {
+			return
+		}
+	}(resp.Body)
+
+	if resp.StatusCode != http.StatusOK {
+		retryAfter, _ := strconv.Atoi(resp.Header.Get("Retry-After"))
+		return "", &statusError{code: resp.StatusCode, retryAfter: time.Duration(retryAfter) * time.Second}
+	}
+
+	var search tmdbSearch
+
+	err = json.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(&search)
+	if err != nil {
+		return "", fmt.Errorf("posters: decoding tmdb response: %w", err)
+	}
+
+	for _, result := range search.Results {
+		if result.PosterPath != "" {
+			return result.PosterPath, nil
+		}
+	}
+
+	return "", ErrNotFound
+}
+
+// sleep waits for d, or until the context is done.
+func sleep(ctx context.Context, d time.Duration) error {
+
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
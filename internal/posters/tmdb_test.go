@@ -0,0 +1,118 @@
+package posters
+
+import (
+	"context"
+	"errors"
+	"github.com/calvarado2004/go-movies-backend/internal/posters/posterstest"
+	"net/http"
+	"testing"
+	"time"
+)
+
+// newTestTMDB returns a TMDB provider of the fake server that retries without waiting.
+func newTestTMDB(fake *posterstest.TMDB, retries int) *TMDB {
+	provider := NewTMDB(TMDBConfig{
+		BaseURL:          fake.URL,
+		APIKey:           fake.APIKey,
+		Retries:          retries,
+		BreakerThreshold: 2,
+		BreakerCooldown:  time.Minute,
+	})
+	provider.sleep = func(ctx context.Context, d time.Duration) error { return nil }
+	return provider
+}
+
+func TestTMDBPoster(t *testing.T) {
+
+	fake := posterstest.NewTMDB(t, "key")
+	fake.SetPoster("Die Hard", "/die-hard.jpg")
+
+	provider := newTestTMDB(fake, 0)
+
+	poster, err := provider.Poster(context.Background(), "Die Hard")
+	if err != nil || poster != "/die-hard.jpg" {
+		t.Fatalf("Poster = %q, %v, want /die-hard.jpg", poster, err)
+	}
+
+	if fake.LastQuery() != "Die Hard" {
+		t.Errorf("searched for %q, want Die Hard", fake.LastQuery())
+	}
+
+	_, err = provider.Poster(context.Background(), "Unknown Movie")
+	if !errors.Is(err, ErrNotFound) {
+		t.Errorf("Poster of an unknown movie returned %v, want ErrNotFound", err)
+	}
+}
+
+func TestTMDBRetries(t *testing.T) {
+
+	fake := posterstest.NewTMDB(t, "key")
+	fake.SetPoster("Alien", "/alien.jpg")
+	fake.FailNext(2, http.StatusServiceUnavailable)
+
+	poster, err := newTestTMDB(fake, 2).Poster(context.Background(), "Alien")
+	if err != nil || poster != "/alien.jpg" {
+		t.Fatalf("Poster = %q, %v, want /alien.jpg", poster, err)
+	}
+
+	if fake.Requests() != 3 {
+		t.Errorf("sent %d requests, want 3", fake.Requests())
+	}
+}
+
+func TestTMDBDoesNotRetryClientErrors(t *testing.T) {
+
+	fake := posterstest.NewTMDB(t, "key")
+	provider := newTestTMDB(fake, 3)
+	provider.apiKey = "wrong"
+
+	_, err := provider.Poster(context.Background(), "Alien")
+	if err == nil {
+		t.Fatal("Poster succeeded with a wrong API key")
+	}
+
+	if fake.Requests() != 1 {
+		t.Errorf("sent %d requests, want 1", fake.Requests())
+	}
+}
+
+func TestTMDBCircuitBreaker(t *testing.T) {
+
+	fake := posterstest.NewTMDB(t, "key")
+	fake.SetPoster("Alien", "/alien.jpg")
+	fake.FailNext(2, http.StatusInternalServerError)
+
+	provider := newTestTMDB(fake, 0)
+
+	now := time.Now()
+	provider.breaker.now = func() time.Time { return now }
+
+	for i := 0; i < 2; i++ {
+		_, err := provider.Poster(context.Background(), "Alien")
+		if err == nil {
+			t.Fatalf("lookup %d succeeded, want a failure", i)
+		}
+	}
+
+	_, err := provider.Poster(context.Background(), "Alien")
+	if !errors.Is(err, ErrCircuitOpen) {
+		t.Fatalf("Poster after two failures returned %v, want ErrCircuitOpen", err)
+	}
+
+	if fake.Requests() != 2 {
+		t.Errorf("sent %d requests while the circuit was open, want 2", fake.Requests())
+	}
+
+	// after the cooldown a trial call goes through and closes the circuit
+	now = now.Add(2 * time.Minute)
+
+	poster, err := provider.Poster(context.Background(), "Alien")
+	if err != nil || poster != "/alien.jpg" {
+		t.Fatalf("Poster after the cooldown = %q, %v, want /alien.jpg", poster, err)
+	}
+
+	_, err = provider.Poster(context.Background(), "Alien")
+	if err != nil {
+		t.Errorf("Poster after the circuit closed returned %v", err)
+	}
+}
@@ -0,0 +1,159 @@
+// Package ratelimit limits the rate of events per key, such as the login attempts of a client IP address, with
+// a token bucket per key. Buckets are kept in memory, every replica of the API limits the events it sees.
+package ratelimit
+
+import (
+	"math"
+	"slices"
+	"sync"
+	"time"
+)
+
+// Limiter allows Events events per key in every Period, in bursts of up to Events events.
+type Limiter struct {
+	Events int
+	Period time.Duration
+
+	// now returns the current time, time.Now unless set by tests.
+	now func() time.Time
+
+	mu      sync.Mutex
+	buckets map[string]*bucket
+	// pruned is when full buckets were last dropped.
+	pruned time.Time
+}
+
+// bucket holds the tokens of a key, one is taken by every event.
+type bucket struct {
+	tokens float64
+	filled time.Time
+}
+
+// New returns a limiter allowing events events per key in every period.
+func New(events int, period time.Duration) *Limiter {
+	return &Limiter{Events: events, Period: period, now: time.Now, buckets: make(map[string]*bucket)}
+}
+
+// Allow reports whether an event of key is allowed now, taking a token from its bucket if so. When it isn't,
+// Allow returns how long to wait for the next token. A nil limiter allows every event.
+func (l *Limiter) Allow(key string) (bool, time.Duration) {
+
+	if !l.enabled() {
+		return true, 0
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	b, wait := l.fill(key)
+	if wait > 0 {
+		return false, wait
+	}
+
+	b.tokens--
+
+	return true, 0
+}
+
+// Key is a key of a limiter.
+type Key struct {
+	Limiter *Limiter
+	Key     string
+}
+
+// allMu serializes AllowAll, which locks several limiters, so that two calls can't wait for each other's locks.
+var allMu sync.Mutex
+
+// AllowAll reports whether an event is allowed now for every key, taking a token from each of their buckets if
+// so and from none of them otherwise. When it isn't, AllowAll returns how long to wait for the tokens missing.
+// The keys must be distinct, nil limiters allow every event.
+func AllowAll(keys ...Key) (bool, time.Duration) {
+
+	allMu.Lock()
+	defer allMu.Unlock()
+
+	var locked []*Limiter
+
+	defer func() {
+		for _, l := range locked {
+			l.mu.Unlock()
+		}
+	}()
+
+	var (
+		buckets []*bucket
+		wait    time.Duration
+	)
+
+	for _, k := range keys {
+		if !k.Limiter.enabled() {
+			continue
+		}
+
+		if !slices.Contains(locked, k.Limiter) {
+			k.Limiter.mu.Lock()
+			locked = append(locked, k.Limiter)
+		}
+
+		b, w := k.Limiter.fill(k.Key)
+		buckets = append(buckets, b)
+		wait = max(wait, w)
+	}
+
+	if wait > 0 {
+		return false, wait
+	}
+
+	for _, b := range buckets {
+		b.tokens--
+	}
+
+	return true, 0
+}
+
+// enabled reports whether the limiter limits events.
+func (l *Limiter) enabled() bool {
+	return l != nil && l.Events > 0 && l.Period > 0
+}
+
+// fill refills the bucket of key with the tokens earned since it was last filled, and returns it along with how
+// long to wait for its next token, 0 when it has one. The caller must hold the lock.
+func (l *Limiter) fill(key string) (*bucket, time.Duration) {
+
+	now := l.now()
+	rate := float64(l.Events) / l.Period.Seconds()
+
+	l.prune(now)
+
+	b, ok := l.buckets[key]
+	if !ok {
+		b = &bucket{tokens: float64(l.Events), filled: now}
+		l.buckets[key] = b
+	}
+
+	b.tokens = math.Min(float64(l.Events), b.tokens+now.Sub(b.filled).Seconds()*rate)
+	b.filled = now
+
+	if b.tokens >= 1 {
+		return b, 0
+	}
+
+	return b, time.Duration((1 - b.tokens) / rate * float64(time.Second))
+}
+
+// prune drops the buckets that have refilled, as if their keys had never been seen, once per period. The
+// caller must hold the lock.
+func (l *Limiter) prune(now time.Time) {
+
+	if now.Sub(l.pruned) < l.Period {
+		return
+	}
+
+	l.pruned = now
+
+	for key, b := range l.buckets {
+		if now.Sub(b.filled) >= l.Period {
+			delete(l.buckets, key)
+		}
+	}
+}
@@ -0,0 +1,125 @@
+package ratelimit
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestAllow(t *testing.T) {
+
+	now := time.Date(2026, 10, 1, 12, 0, 0, 0, time.UTC)
+
+	l := New(3, time.Minute)
+	l.now = func() time.Time { return now }
+
+	// a burst of Events events is allowed, then one every Period / Events
+	for i := 0; i < 3; i++ {
+		if ok, _ := l.Allow("a"); !ok {
+			t.Fatalf("event %d of the burst is refused", i+1)
+		}
+	}
+
+	ok, wait := l.Allow("a")
+	if ok || wait != 20*time.Second {
+		t.Errorf("Allow after the burst = %v, %v, want false, 20s", ok, wait)
+	}
+
+	// other keys have their own bucket
+	if ok, _ := l.Allow("b"); !ok {
+		t.Error("the event of another key is refused")
+	}
+
+	now = now.Add(15 * time.Second)
+
+	ok, wait = l.Allow("a")
+	if ok || wait != 5*time.Second {
+		t.Errorf("Allow before the next token = %v, %v, want false, 5s", ok, wait)
+	}
+
+	now = now.Add(5 * time.Second)
+
+	if ok, _ := l.Allow("a"); !ok {
+		t.Error("the event after the refill is refused")
+	}
+
+	// idle buckets are dropped once full again
+	now = now.Add(time.Hour)
+
+	l.Allow("c")
+
+	if len(l.buckets) != 1 {
+		t.Errorf("%d buckets kept, want the one of c", len(l.buckets))
+	}
+}
+
+func TestNilLimiter(t *testing.T) {
+
+	var l *Limiter
+
+	if ok, wait := l.Allow("a"); !ok || wait != 0 {
+		t.Errorf("nil Limiter.Allow = %v, %v, want true, 0", ok, wait)
+	}
+}
+
+func TestAllowAll(t *testing.T) {
+
+	now := time.Date(2026, 10, 1, 12, 0, 0, 0, time.UTC)
+
+	ip := New(5, time.Minute)
+	ip.now = func() time.Time { return now }
+
+	account := New(1, time.Minute)
+	account.now = func() time.Time { return now }
+
+	// concurrent events of an account only get the one token of the account, and only take that many from the
+	// address
+	var (
+		wg      sync.WaitGroup
+		mu      sync.Mutex
+		allowed int
+	)
+
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			ok, _ := AllowAll(Key{ip, "192.0.2.1"}, Key{account, "ada@example.com"})
+			if ok {
+				mu.Lock()
+				allowed++
+				mu.Unlock()
+			}
+		}()
+	}
+
+	wg.Wait()
+
+	if allowed != 1 {
+		t.Errorf("%d concurrent events allowed, want 1", allowed)
+	}
+
+	ok, wait := AllowAll(Key{ip, "192.0.2.1"}, Key{account, "ada@example.com"})
+	if ok || wait != time.Minute {
+		t.Errorf("AllowAll after the account ran out = %v, %v, want false, 1m", ok, wait)
+	}
+
+	// the refused events left the other tokens of the address
+	for i := 0; i < 4; i++ {
+		if ok, _ := AllowAll(Key{ip, "192.0.2.1"}, Key{account, fmt.Sprintf("user%d@example.com", i)}); !ok {
+			t.Fatalf("event %d of another account is refused", i+1)
+		}
+	}
+
+	ok, wait = AllowAll(Key{ip, "192.0.2.1"}, Key{account, "grace@example.com"})
+	if ok || wait != 12*time.Second {
+		t.Errorf("AllowAll after the address ran out = %v, %v, want false, 12s", ok, wait)
+	}
+
+	// nil limiters allow every event
+	if ok, _ := AllowAll(Key{nil, "192.0.2.1"}, Key{account, "linus@example.com"}); !ok {
+		t.Error("the event of a nil limiter and an account with tokens is refused")
+	}
+}
@@ -0,0 +1,1152 @@
+package dbrepo
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"github.com/calvarado2004/go-movies-backend/internal/models"
+	"github.com/calvarado2004/go-movies-backend/internal/repository"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// MemoryDBRepo is a thread-safe, in-memory implementation of repository.DatabaseRepo with the same semantics
+// as PostgresDBRepo. It is meant for tests and demos, its data is lost when the process exits.
+type MemoryDBRepo struct {
+	mu            sync.RWMutex
+	movies        map[int]models.Movie
+	genres        map[int]models.Genre
+	movieGenres   []movieGenre
+	posters       map[int]models.MoviePoster
+	users         map[int]models.User
+	userTokens    map[int]models.UserToken
+	refreshTokens map[int]models.RefreshToken
+	loginFailures map[loginSubject]models.LoginFailures
+	userTOTP      map[int]models.UserTOTP
+	recoveryCodes map[int]recoveryCode
+	lastID        map[string]int
+}
+
+// MemoryDBRepo must stay interchangeable with PostgresDBRepo.
+var _ repository.DatabaseRepo = (*MemoryDBRepo)(nil)
+
+// loginSubject is the primary key of the login_failures table.
+type loginSubject struct {
+	Kind    string
+	Subject string
+}
+
+// recoveryCode is a row of the recovery_codes table.
+type recoveryCode struct {
+	UserID   int
+	CodeHash string
+	UsedAt   *time.Time
+}
+
+// movieGenre is a row of the movies_genres join table.
+type movieGenre struct {
+	MovieID int
+	GenreID int
+}
+
+// NewMemoryDBRepo returns an empty in-memory repository.
+func NewMemoryDBRepo() *MemoryDBRepo {
+	return &MemoryDBRepo{
+		movies:        map[int]models.Movie{},
+		genres:        map[int]models.Genre{},
+		posters:       map[int]models.MoviePoster{},
+		users:         map[int]models.User{},
+		userTokens:    map[int]models.UserToken{},
+		refreshTokens: map[int]models.RefreshToken{},
+		loginFailures: map[loginSubject]models.LoginFailures{},
+		userTOTP:      map[int]models.UserTOTP{},
+		recoveryCodes: map[int]recoveryCode{},
+		lastID:        map[string]int{},
+	}
+}
+
+// NewSeededMemoryDBRepo returns an in-memory repository holding the same sample data as sql/create_tables.sql.
+func NewSeededMemoryDBRepo() *MemoryDBRepo {
+
+	m := NewMemoryDBRepo()
+
+	seeded := time.Date(2022, 9, 23, 0, 0, 0, 0, time.UTC)
+
+	for _, genre := range []string{"Comedy", "Sci-Fi", "Horror", "Romance", "Action", "Thriller", "Drama",
+		"Mystery", "Crime", "Animation", "Adventure", "Fantasy", "Superhero"} {
+		id := m.nextID("genres")
+		m.genres[id] = models.Genre{ID: id, Genre: genre, CreatedAt: seeded, UpdatedAt: seeded}
+	}
+
+	movies := []struct {
+		movie  models.Movie
+		genres []int
+	}{
+		{models.Movie{
+			Title:       "Highlander",
+			ReleaseDate: time.Date(1986, 3, 7, 0, 0, 0, 0, time.UTC),
+			Runtime:     116,
+			MPAARating:  "R",
+			Description: "He fought his first battle on the Scottish Highlands in 1536. He will fight his greatest battle on the streets of New York City in 1986. His name is Connor MacLeod. He is immortal.",
+			Image:       "/8Z8dptJEypuLoOQro1WugD855YE.jpg",
+		}, []int{5, 12}},
+		{models.Movie{
+			Title:       "Raiders of the Lost Ark",
+			ReleaseDate: time.Date(1981, 6, 12, 0, 0, 0, 0, time.UTC),
+			Runtime:     115,
+			MPAARating:  "PG-13",
+			Description: "Archaeology professor Indiana Jones ventures to seize a biblical artefact known as the Ark of the Covenant. While doing so, he puts up a fight against Renee and a troop of Nazis.",
+			Image:       "/ceG9VzoRAVGwivFU403Wc3AHRys.jpg",
+		}, []int{5, 11}},
+		{models.Movie{
+			Title:       "The Godfather",
+			ReleaseDate: time.Date(1972, 3, 24, 0, 0, 0, 0, time.UTC),
+			Runtime:     175,
+			MPAARating:  "18A",
+			Description: "The aging patriarch of an organized crime dynasty in postwar New York City transfers control of his clandestine empire to his reluctant youngest son.",
+			Image:       "/3bhkrj58Vtu7enYsRolD1fZdja1.jpg",
+		}, []int{9, 7}},
+	}
+
+	for _, seed := range movies {
+		seed.movie.ID = m.nextID("movies")
+		seed.movie.CreatedAt = seeded
+		seed.movie.UpdatedAt = seeded
+		m.movies[seed.movie.ID] = seed.movie
+
+		for _, genreID := range seed.genres {
+			m.movieGenres = append(m.movieGenres, movieGenre{MovieID: seed.movie.ID, GenreID: genreID})
+		}
+	}
+
+	id := m.nextID("users")
+	m.users[id] = models.User{
+		ID:              id,
+		FirstName:       "Admin",
+		LastName:        "User",
+		Email:           "admin@example.com",
+		Password:        "$2a$14$wVsaPvJnJJsomWArouWCtusem6S/.Gauq/GjOIEHpyh2DAMmso1wy",
+		Role:            models.RoleAdmin,
+		EmailVerifiedAt: &seeded,
+		CreatedAt:       seeded,
+		UpdatedAt:       seeded,
+	}
+
+	return m
+}
+
+// nextID returns the next identity value of a table. The caller must hold the write lock.
+func (m *MemoryDBRepo) nextID(table string) int {
+	m.lastID[table]++
+	return m.lastID[table]
+}
+
+// Connection returns nil, there is no database connection pool behind the in-memory repository.
+func (m *MemoryDBRepo) Connection() *sql.DB {
+	return nil
+}
+
+// WithTx runs fn with a copy of the repository, whose changes are kept only if fn returns nil. Transactions
+// run one at a time and block every other caller until they end, so fn must only use the repository it is given.
+func (m *MemoryDBRepo) WithTx(ctx context.Context, fn func(repo repository.DatabaseRepo) error) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	tx := m.clone()
+
+	err := fn(tx)
+	if err != nil {
+		return err
+	}
+
+	// like a transaction whose context ends, the changes are rolled back
+	err = ctx.Err()
+	if err != nil {
+		return err
+	}
+
+	m.movies = tx.movies
+	m.genres = tx.genres
+	m.movieGenres = tx.movieGenres
+	m.posters = tx.posters
+	m.users = tx.users
+	m.userTokens = tx.userTokens
+	m.refreshTokens = tx.refreshTokens
+	m.loginFailures = tx.loginFailures
+	m.userTOTP = tx.userTOTP
+	m.recoveryCodes = tx.recoveryCodes
+	m.lastID = tx.lastID
+
+	return nil
+}
+
+// clone returns a copy of the data of the repository. The caller must hold the lock.
+func (m *MemoryDBRepo) clone() *MemoryDBRepo {
+	return &MemoryDBRepo{
+		movies:        copyMap(m.movies),
+		genres:        copyMap(m.genres),
+		movieGenres:   append([]movieGenre(nil), m.movieGenres...),
+		posters:       copyMap(m.posters),
+		users:         copyMap(m.users),
+		userTokens:    copyMap(m.userTokens),
+		refreshTokens: copyMap(m.refreshTokens),
+		loginFailures: copyMap(m.loginFailures),
+		userTOTP:      copyMap(m.userTOTP),
+		recoveryCodes: copyMap(m.recoveryCodes),
+		lastID:        copyMap(m.lastID),
+	}
+}
+
+// copyMap returns a shallow copy of a map.
+func copyMap[K comparable, V any](src map[K]V) map[K]V {
+
+	dst := make(map[K]V, len(src))
+	for k, v := range src {
+		dst[k] = v
+	}
+
+	return dst
+}
+
+// AllMovies returns all movies, optionally only those of a genre, ordered by title descending.
+func (m *MemoryDBRepo) AllMovies(ctx context.Context, genre ...int) ([]*models.Movie, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	var movies []*models.Movie
+
+	for _, movie := range m.movies {
+		if len(genre) > 0 && !m.movieHasGenre(movie.ID, genre[0]) {
+			continue
+		}
+
+		movie := movie
+		movie.Genres = nil
+		movie.GenresArray = nil
+		movies = append(movies, &movie)
+	}
+
+	sort.Slice(movies, func(i, j int) bool {
+		return movies[i].Title > movies[j].Title
+	})
+
+	return movies, nil
+}
+
+// ListMovies returns one page of movies matching the query, along with the total number of matching movies.
+func (m *MemoryDBRepo) ListMovies(ctx context.Context, query repository.MovieQuery) ([]*models.Movie, int, error) {
+
+	err := query.Normalize()
+	if err != nil {
+		return nil, 0, err
+	}
+
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	var matches []*models.Movie
+
+	for _, movie := range m.movies {
+		if !m.movieMatches(movie, query) {
+			continue
+		}
+
+		movie := movie
+		movie.Genres = nil
+		movie.GenresArray = nil
+		matches = append(matches, &movie)
+	}
+
+	sort.Slice(matches, func(i, j int) bool {
+		a, b := matches[i], matches[j]
+		if query.Desc {
+			a, b = b, a
+		}
+
+		var cmp int
+		switch query.Sort {
+		case repository.SortTitle:
+			cmp = strings.Compare(a.Title, b.Title)
+		case repository.SortReleaseDate:
+			cmp = a.ReleaseDate.Compare(b.ReleaseDate)
+		case repository.SortRuntime:
+			cmp = a.Runtime - b.Runtime
+		case repository.SortCreatedAt:
+			cmp = a.CreatedAt.Compare(b.CreatedAt)
+		}
+
+		if cmp == 0 {
+			return a.ID < b.ID
+		}
+
+		return cmp < 0
+	})
+
+	movies := []*models.Movie{}
+
+	if query.Offset < len(matches) {
+		end := query.Offset + query.Limit
+		if end > len(matches) {
+			end = len(matches)
+		}
+		movies = append(movies, matches[query.Offset:end]...)
+	}
+
+	return movies, len(matches), nil
+}
+
+// movieMatches reports whether a movie passes the filters of a query. The caller must hold the lock.
+func (m *MemoryDBRepo) movieMatches(movie models.Movie, query repository.MovieQuery) bool {
+
+	if len(query.Genres) > 0 {
+		found := false
+		for _, genreID := range query.Genres {
+			if m.movieHasGenre(movie.ID, genreID) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+
+	if len(query.MPAARatings) > 0 {
+		found := false
+		for _, rating := range query.MPAARatings {
+			if movie.MPAARating == rating {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+
+	year := movie.ReleaseDate.Year()
+
+	switch {
+	case query.ReleaseYearFrom > 0 && year < query.ReleaseYearFrom:
+		return false
+	case query.ReleaseYearTo > 0 && year > query.ReleaseYearTo:
+		return false
+	case query.RuntimeMin > 0 && movie.Runtime < query.RuntimeMin:
+		return false
+	case query.RuntimeMax > 0 && movie.Runtime > query.RuntimeMax:
+		return false
+	case query.TitleContains != "" && !strings.Contains(strings.ToLower(movie.Title), strings.ToLower(query.TitleContains)):
+		return false
+	}
+
+	return true
+}
+
+// movieHasGenre reports whether a movie belongs to a genre. The caller must hold the lock.
+func (m *MemoryDBRepo) movieHasGenre(movieID, genreID int) bool {
+	for _, mg := range m.movieGenres {
+		if mg.MovieID == movieID && mg.GenreID == genreID {
+			return true
+		}
+	}
+
+	return false
+}
+
+// genresOfMovie returns the genres of a movie, with only their ID and name set. The caller must hold the lock.
+func (m *MemoryDBRepo) genresOfMovie(movieID int) []*models.Genre {
+
+	var genres []*models.Genre
+
+	for _, mg := range m.movieGenres {
+		if mg.MovieID != movieID {
+			continue
+		}
+
+		genre := m.genres[mg.GenreID]
+		genres = append(genres, &models.Genre{ID: genre.ID, Genre: genre.Genre})
+	}
+
+	return genres
+}
+
+// OneMovie returns one movie along with its genres.
+func (m *MemoryDBRepo) OneMovie(ctx context.Context, id int) (*models.Movie, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	movie, ok := m.movies[id]
+	if !ok {
+		return nil, sql.ErrNoRows
+	}
+
+	movie.Genres = m.genresOfMovie(id)
+	movie.GenresArray = nil
+
+	if poster, ok := m.posters[id]; ok {
+		movie.Poster = &poster
+	}
+
+	return &movie, nil
+}
+
+// OneMovieForEdit returns one movie along with its genres, and every genre ordered by name.
+func (m *MemoryDBRepo) OneMovieForEdit(ctx context.Context, id int) (*models.Movie, []*models.Genre, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	movie, ok := m.movies[id]
+	if !ok {
+		return nil, nil, sql.ErrNoRows
+	}
+
+	movie.Genres = m.genresOfMovie(id)
+	movie.GenresArray = nil
+
+	for _, genre := range movie.Genres {
+		movie.GenresArray = append(movie.GenresArray, genre.ID)
+	}
+
+	var allGenres []*models.Genre
+
+	for _, genre := range m.sortedGenres() {
+		allGenres = append(allGenres, &models.Genre{ID: genre.ID, Genre: genre.Genre})
+	}
+
+	return &movie, allGenres, nil
+}
+
+// sortedGenres returns every genre ordered by name. The caller must hold the lock.
+func (m *MemoryDBRepo) sortedGenres() []models.Genre {
+
+	genres := make([]models.Genre, 0, len(m.genres))
+	for _, genre := range m.genres {
+		genres = append(genres, genre)
+	}
+
+	sort.Slice(genres, func(i, j int) bool {
+		return genres[i].Genre < genres[j].Genre
+	})
+
+	return genres
+}
+
+// GetUserByEmail returns a user by email, ignoring case.
+func (m *MemoryDBRepo) GetUserByEmail(ctx context.Context, email string) (models.User, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	for _, user := range m.users {
+		if strings.EqualFold(user.Email, email) {
+			return user, nil
+		}
+	}
+
+	return models.User{}, sql.ErrNoRows
+}
+
+// GetUserByID returns a user by id.
+func (m *MemoryDBRepo) GetUserByID(ctx context.Context, id int) (models.User, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	user, ok := m.users[id]
+	if !ok {
+		return models.User{}, sql.ErrNoRows
+	}
+
+	return user, nil
+}
+
+// InsertUser inserts a user. Email addresses must be unique, ignoring case.
+func (m *MemoryDBRepo) InsertUser(ctx context.Context, user models.User) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, existing := range m.users {
+		if strings.EqualFold(existing.Email, user.Email) {
+			return 0, fmt.Errorf("email %q is already registered", user.Email)
+		}
+	}
+
+	if user.Role == "" {
+		user.Role = models.RoleViewer
+	}
+
+	user.ID = m.nextID("users")
+	m.users[user.ID] = user
+
+	return user.ID, nil
+}
+
+// UpdateUserPassword replaces the password hash of a user.
+func (m *MemoryDBRepo) UpdateUserPassword(ctx context.Context, id int, passwordHash string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	user, ok := m.users[id]
+	if !ok {
+		return nil
+	}
+
+	user.Password = passwordHash
+	user.UpdatedAt = time.Now().UTC()
+	m.users[id] = user
+
+	return nil
+}
+
+// SetUserEmailVerified marks the email address of a user as verified.
+func (m *MemoryDBRepo) SetUserEmailVerified(ctx context.Context, id int) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	user, ok := m.users[id]
+	if !ok || user.EmailVerifiedAt != nil {
+		return nil
+	}
+
+	now := time.Now().UTC()
+	user.EmailVerifiedAt = &now
+	user.UpdatedAt = now
+	m.users[id] = user
+
+	return nil
+}
+
+// InsertUserToken stores the hash of a single-use token sent to a user.
+func (m *MemoryDBRepo) InsertUserToken(ctx context.Context, token models.UserToken) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.users[token.UserID]; !ok {
+		return fmt.Errorf("user %d does not exist", token.UserID)
+	}
+
+	for _, existing := range m.userTokens {
+		if existing.TokenHash == token.TokenHash {
+			return errors.New("duplicate user token")
+		}
+	}
+
+	token.ID = m.nextID("user_tokens")
+	m.userTokens[token.ID] = token
+
+	return nil
+}
+
+// ConsumeUserToken marks an unused, unexpired token with the given hash and scope as used and returns it.
+// It returns sql.ErrNoRows if there is no such token.
+func (m *MemoryDBRepo) ConsumeUserToken(ctx context.Context, hash, scope string) (models.UserToken, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := time.Now().UTC()
+
+	for id, token := range m.userTokens {
+		if token.TokenHash != hash || token.Scope != scope || token.UsedAt != nil || !token.ExpiresAt.After(now) {
+			continue
+		}
+
+		token.UsedAt = &now
+		m.userTokens[id] = token
+
+		return token, nil
+	}
+
+	return models.UserToken{}, sql.ErrNoRows
+}
+
+// AllGenresDB returns all genres ordered by name.
+func (m *MemoryDBRepo) AllGenresDB(ctx context.Context) ([]*models.Genre, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	var genres []*models.Genre
+
+	for _, genre := range m.sortedGenres() {
+		genre := genre
+		genres = append(genres, &genre)
+	}
+
+	return genres, nil
+}
+
+// GenresForMovies returns the genres of each of the given movies ordered by name, keyed by movie id.
+func (m *MemoryDBRepo) GenresForMovies(ctx context.Context, movieIDs []int) (map[int][]*models.Genre, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	genres := map[int][]*models.Genre{}
+
+	for _, id := range movieIDs {
+		movieGenres := m.genresOfMovie(id)
+		if len(movieGenres) == 0 {
+			continue
+		}
+
+		sort.Slice(movieGenres, func(i, j int) bool {
+			return movieGenres[i].Genre < movieGenres[j].Genre
+		})
+
+		genres[id] = movieGenres
+	}
+
+	return genres, nil
+}
+
+// InsertGenre inserts a genre.
+func (m *MemoryDBRepo) InsertGenre(ctx context.Context, genre models.Genre) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	genre.ID = m.nextID("genres")
+	genre.Checked = false
+	m.genres[genre.ID] = genre
+
+	return genre.ID, nil
+}
+
+// UpdateGenre renames a genre.
+func (m *MemoryDBRepo) UpdateGenre(ctx context.Context, genre models.Genre) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	existing, ok := m.genres[genre.ID]
+	if !ok {
+		return nil
+	}
+
+	existing.Genre = genre.Genre
+	existing.UpdatedAt = genre.UpdatedAt
+	m.genres[genre.ID] = existing
+
+	return nil
+}
+
+// DeleteGenre deletes a genre and, like the foreign key in Postgres, its movie associations.
+func (m *MemoryDBRepo) DeleteGenre(ctx context.Context, id int) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	delete(m.genres, id)
+
+	kept := m.movieGenres[:0]
+
+	for _, mg := range m.movieGenres {
+		if mg.GenreID != id {
+			kept = append(kept, mg)
+		}
+	}
+
+	m.movieGenres = kept
+
+	return nil
+}
+
+// InsertMovie inserts a movie. Its genres are set with UpdateMovieGenres.
+func (m *MemoryDBRepo) InsertMovie(ctx context.Context, movie models.Movie) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	movie.ID = m.nextID("movies")
+	movie.Genre = ""
+	movie.Genres = nil
+	movie.GenresArray = nil
+	m.movies[movie.ID] = movie
+
+	return movie.ID, nil
+}
+
+// UpdateMovie updates a movie.
+func (m *MemoryDBRepo) UpdateMovie(ctx context.Context, movie models.Movie) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	existing, ok := m.movies[movie.ID]
+	if !ok {
+		return nil
+	}
+
+	existing.Title = movie.Title
+	existing.Description = movie.Description
+	existing.ReleaseDate = movie.ReleaseDate
+	existing.Runtime = movie.Runtime
+	existing.MPAARating = movie.MPAARating
+	existing.UpdatedAt = movie.UpdatedAt
+	existing.Image = movie.Image
+	m.movies[movie.ID] = existing
+
+	return nil
+}
+
+// FillMovieImage sets the image of a movie that has none, and reports whether it did.
+func (m *MemoryDBRepo) FillMovieImage(ctx context.Context, id int, image string) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	movie, ok := m.movies[id]
+	if !ok || movie.Image != "" {
+		return false, nil
+	}
+
+	movie.Image = image
+	movie.UpdatedAt = time.Now()
+	m.movies[id] = movie
+
+	return true, nil
+}
+
+// ReplaceMoviePoster stores the description of the poster images of a movie and, unless image is empty,
+// points the image of the movie to it. It returns the poster it replaced, if any.
+func (m *MemoryDBRepo) ReplaceMoviePoster(ctx context.Context, poster models.MoviePoster, image string) (*models.MoviePoster, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	movie, ok := m.movies[poster.MovieID]
+	if !ok {
+		return nil, sql.ErrNoRows
+	}
+
+	var replaced *models.MoviePoster
+
+	if previous, ok := m.posters[poster.MovieID]; ok {
+		replaced = &previous
+		poster.CreatedAt = previous.CreatedAt
+	}
+
+	m.posters[poster.MovieID] = poster
+
+	if image != "" {
+		movie.Image = image
+		movie.UpdatedAt = poster.UpdatedAt
+		m.movies[poster.MovieID] = movie
+	}
+
+	return replaced, nil
+}
+
+// GetMoviePoster returns the description of the poster images of a movie.
+func (m *MemoryDBRepo) GetMoviePoster(ctx context.Context, movieID int) (models.MoviePoster, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	poster, ok := m.posters[movieID]
+	if !ok {
+		return models.MoviePoster{}, sql.ErrNoRows
+	}
+
+	return poster, nil
+}
+
+// UpdateMovieGenres replaces the genres of a movie.
+func (m *MemoryDBRepo) UpdateMovieGenres(ctx context.Context, id int, genreIDs []int) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	// enforce the foreign keys of movies_genres
+	if _, ok := m.movies[id]; !ok && len(genreIDs) > 0 {
+		return fmt.Errorf("movie %d does not exist", id)
+	}
+
+	for _, genreID := range genreIDs {
+		if _, ok := m.genres[genreID]; !ok {
+			return fmt.Errorf("genre %d does not exist", genreID)
+		}
+	}
+
+	m.deleteMovieGenres(id)
+
+	for _, genreID := range genreIDs {
+		m.movieGenres = append(m.movieGenres, movieGenre{MovieID: id, GenreID: genreID})
+	}
+
+	return nil
+}
+
+// deleteMovieGenres removes every genre of a movie. The caller must hold the write lock.
+func (m *MemoryDBRepo) deleteMovieGenres(id int) {
+
+	kept := m.movieGenres[:0]
+
+	for _, mg := range m.movieGenres {
+		if mg.MovieID != id {
+			kept = append(kept, mg)
+		}
+	}
+
+	m.movieGenres = kept
+}
+
+// DeleteMovie deletes a movie and, like the foreign key in Postgres, its genre associations.
+func (m *MemoryDBRepo) DeleteMovie(ctx context.Context, id int) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	delete(m.movies, id)
+	delete(m.posters, id)
+	m.deleteMovieGenres(id)
+
+	return nil
+}
+
+// InsertRefreshToken stores the hash of a newly issued refresh token.
+func (m *MemoryDBRepo) InsertRefreshToken(ctx context.Context, token models.RefreshToken) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.users[token.UserID]; !ok {
+		return fmt.Errorf("user %d does not exist", token.UserID)
+	}
+
+	for _, existing := range m.refreshTokens {
+		if existing.TokenHash == token.TokenHash {
+			return errors.New("duplicate refresh token")
+		}
+	}
+
+	token.ID = m.nextID("refresh_tokens")
+	token.RevokedAt = nil
+	m.refreshTokens[token.ID] = token
+
+	return nil
+}
+
+// GetRefreshTokenByHash returns a stored refresh token by the hash of its value.
+func (m *MemoryDBRepo) GetRefreshTokenByHash(ctx context.Context, hash string) (models.RefreshToken, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	for _, token := range m.refreshTokens {
+		if token.TokenHash == hash {
+			return token, nil
+		}
+	}
+
+	return models.RefreshToken{}, sql.ErrNoRows
+}
+
+// RevokeRefreshToken revokes a refresh token. It reports false if the token was already revoked.
+func (m *MemoryDBRepo) RevokeRefreshToken(ctx context.Context, id int) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	token, ok := m.refreshTokens[id]
+	if !ok || token.RevokedAt != nil {
+		return false, nil
+	}
+
+	now := time.Now().UTC()
+	token.RevokedAt = &now
+	m.refreshTokens[id] = token
+
+	return true, nil
+}
+
+// RevokeRefreshTokenFamily revokes every refresh token issued for the same login session.
+func (m *MemoryDBRepo) RevokeRefreshTokenFamily(ctx context.Context, familyID string) error {
+	m.revokeRefreshTokensWhere(func(token models.RefreshToken) bool {
+		return token.FamilyID == familyID
+	})
+
+	return nil
+}
+
+// RevokeUserRefreshTokens revokes every refresh token of a user, ending all of their sessions.
+func (m *MemoryDBRepo) RevokeUserRefreshTokens(ctx context.Context, userID int) error {
+	m.revokeRefreshTokensWhere(func(token models.RefreshToken) bool {
+		return token.UserID == userID
+	})
+
+	return nil
+}
+
+// revokeRefreshTokensWhere revokes every active refresh token matching the predicate.
+func (m *MemoryDBRepo) revokeRefreshTokensWhere(match func(token models.RefreshToken) bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := time.Now().UTC()
+
+	for id, token := range m.refreshTokens {
+		if token.RevokedAt == nil && match(token) {
+			token.RevokedAt = &now
+			m.refreshTokens[id] = token
+		}
+	}
+}
+
+// DeleteExpiredRefreshTokens deletes the refresh tokens that have expired and returns how many were deleted.
+func (m *MemoryDBRepo) DeleteExpiredRefreshTokens(ctx context.Context) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := time.Now().UTC()
+
+	var deleted int64
+
+	for id, token := range m.refreshTokens {
+		if token.ExpiresAt.Before(now) {
+			delete(m.refreshTokens, id)
+			deleted++
+		}
+	}
+
+	return deleted, nil
+}
+
+// RecordLoginFailure counts a failed login of the subject at the given time and returns its failures. Failures
+// are forgotten once window has passed without a new one, along with the lockout they caused.
+func (m *MemoryDBRepo) RecordLoginFailure(ctx context.Context, kind, subject string, at time.Time, window time.Duration) (models.LoginFailures, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	key := loginSubject{Kind: kind, Subject: subject}
+
+	failures, ok := m.loginFailures[key]
+	if !ok || failures.LastFailureAt.Before(at.Add(-window)) {
+		failures = models.LoginFailures{Kind: kind, Subject: subject}
+	}
+
+	failures.Failures++
+	failures.LastFailureAt = at
+	m.loginFailures[key] = failures
+
+	return failures, nil
+}
+
+// LockLogins refuses the logins of the subject until the given time.
+func (m *MemoryDBRepo) LockLogins(ctx context.Context, kind, subject string, until time.Time) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	key := loginSubject{Kind: kind, Subject: subject}
+
+	failures, ok := m.loginFailures[key]
+	if !ok {
+		return nil
+	}
+
+	failures.LockedUntil = &until
+	m.loginFailures[key] = failures
+
+	return nil
+}
+
+// GetLoginFailures returns the failed logins of the subject, sql.ErrNoRows when it has none.
+func (m *MemoryDBRepo) GetLoginFailures(ctx context.Context, kind, subject string) (models.LoginFailures, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	failures, ok := m.loginFailures[loginSubject{Kind: kind, Subject: subject}]
+	if !ok {
+		return models.LoginFailures{}, sql.ErrNoRows
+	}
+
+	return failures, nil
+}
+
+// ListLoginLockouts returns the subjects whose logins are locked at the given time, the longest locked first.
+func (m *MemoryDBRepo) ListLoginLockouts(ctx context.Context, now time.Time) ([]*models.LoginFailures, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	var lockouts []*models.LoginFailures
+
+	for _, failures := range m.loginFailures {
+		if failures.LockedUntil != nil && failures.LockedUntil.After(now) {
+			failures := failures
+			lockouts = append(lockouts, &failures)
+		}
+	}
+
+	sort.Slice(lockouts, func(i, j int) bool {
+		a, b := lockouts[i], lockouts[j]
+		if !a.LockedUntil.Equal(*b.LockedUntil) {
+			return a.LockedUntil.After(*b.LockedUntil)
+		}
+		if a.Kind != b.Kind {
+			return a.Kind < b.Kind
+		}
+		return a.Subject < b.Subject
+	})
+
+	return lockouts, nil
+}
+
+// ClearLoginFailures forgets the failed logins of the subject, lifting its lockout. It reports false if the
+// subject had none.
+func (m *MemoryDBRepo) ClearLoginFailures(ctx context.Context, kind, subject string) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	key := loginSubject{Kind: kind, Subject: subject}
+
+	_, ok := m.loginFailures[key]
+	delete(m.loginFailures, key)
+
+	return ok, nil
+}
+
+// DeleteStaleLoginFailures deletes the failed logins of the subjects that haven't failed nor been locked since
+// the given time, and returns how many subjects were deleted.
+func (m *MemoryDBRepo) DeleteStaleLoginFailures(ctx context.Context, before time.Time) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var deleted int64
+
+	for key, failures := range m.loginFailures {
+		if failures.LastFailureAt.Before(before) && (failures.LockedUntil == nil || failures.LockedUntil.Before(before)) {
+			delete(m.loginFailures, key)
+			deleted++
+		}
+	}
+
+	return deleted, nil
+}
+
+// SetUserTOTP stores the TOTP secret a user is enrolling with, replacing any previous enrollment that isn't
+// confirmed yet. It reports false, storing nothing, when the user already confirmed an enrollment.
+func (m *MemoryDBRepo) SetUserTOTP(ctx context.Context, totp models.UserTOTP) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.users[totp.UserID]; !ok {
+		return false, fmt.Errorf("user %d does not exist", totp.UserID)
+	}
+
+	if current, ok := m.userTOTP[totp.UserID]; ok && current.IsConfirmed() {
+		return false, nil
+	}
+
+	totp.LastStep = 0
+	totp.ConfirmedAt = nil
+	m.userTOTP[totp.UserID] = totp
+
+	return true, nil
+}
+
+// GetUserTOTP returns the TOTP secret of a user, sql.ErrNoRows when the user has none.
+func (m *MemoryDBRepo) GetUserTOTP(ctx context.Context, userID int) (models.UserTOTP, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	totp, ok := m.userTOTP[userID]
+	if !ok {
+		return models.UserTOTP{}, sql.ErrNoRows
+	}
+
+	return totp, nil
+}
+
+// ConfirmUserTOTP confirms the TOTP secret of a user with the code of the given step, enabling two-factor
+// authentication. It reports false if there is no secret to confirm.
+func (m *MemoryDBRepo) ConfirmUserTOTP(ctx context.Context, userID int, step int64) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	totp, ok := m.userTOTP[userID]
+	if !ok || totp.ConfirmedAt != nil {
+		return false, nil
+	}
+
+	now := time.Now().UTC()
+	totp.ConfirmedAt = &now
+	totp.LastStep = step
+	m.userTOTP[userID] = totp
+
+	return true, nil
+}
+
+// UseTOTPStep records that the code of a step was used by a user. It reports false if a code of that step or
+// a later one was already used, which means the code is replayed.
+func (m *MemoryDBRepo) UseTOTPStep(ctx context.Context, userID int, step int64) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	totp, ok := m.userTOTP[userID]
+	if !ok || totp.ConfirmedAt == nil || totp.LastStep >= step {
+		return false, nil
+	}
+
+	totp.LastStep = step
+	m.userTOTP[userID] = totp
+
+	return true, nil
+}
+
+// DeleteUserTOTP deletes the TOTP secret and the recovery codes of a user, disabling two-factor authentication.
+func (m *MemoryDBRepo) DeleteUserTOTP(ctx context.Context, userID int) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	delete(m.userTOTP, userID)
+	m.deleteRecoveryCodes(userID)
+
+	return nil
+}
+
+// deleteRecoveryCodes deletes the recovery codes of a user. The caller must hold the lock.
+func (m *MemoryDBRepo) deleteRecoveryCodes(userID int) {
+	for id, code := range m.recoveryCodes {
+		if code.UserID == userID {
+			delete(m.recoveryCodes, id)
+		}
+	}
+}
+
+// ReplaceRecoveryCodes replaces the recovery codes of a user by the ones with the given hashes.
+func (m *MemoryDBRepo) ReplaceRecoveryCodes(ctx context.Context, userID int, hashes []string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.users[userID]; !ok {
+		return fmt.Errorf("user %d does not exist", userID)
+	}
+
+	m.deleteRecoveryCodes(userID)
+
+	for _, hash := range hashes {
+		m.recoveryCodes[m.nextID("recovery_codes")] = recoveryCode{UserID: userID, CodeHash: hash}
+	}
+
+	return nil
+}
+
+// UseRecoveryCode marks the recovery code of a user with the given hash as used. It reports false if the user
+// has no such unused code.
+func (m *MemoryDBRepo) UseRecoveryCode(ctx context.Context, userID int, hash string) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for id, code := range m.recoveryCodes {
+		if code.UserID == userID && code.CodeHash == hash && code.UsedAt == nil {
+			now := time.Now().UTC()
+			code.UsedAt = &now
+			m.recoveryCodes[id] = code
+			return true, nil
+		}
+	}
+
+	return false, nil
+}
+
+// CountRecoveryCodes returns how many unused recovery codes a user has left.
+func (m *MemoryDBRepo) CountRecoveryCodes(ctx context.Context, userID int) (int, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	var count int
+
+	for _, code := range m.recoveryCodes {
+		if code.UserID == userID && code.UsedAt == nil {
+			count++
+		}
+	}
+
+	return count, nil
+}
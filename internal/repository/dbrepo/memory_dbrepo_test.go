@@ -0,0 +1,13 @@
+package dbrepo
+
+import (
+	"github.com/calvarado2004/go-movies-backend/internal/repository"
+	"github.com/calvarado2004/go-movies-backend/internal/repository/repotest"
+	"testing"
+)
+
+func TestMemoryDBRepo(t *testing.T) {
+	repotest.Run(t, func(t *testing.T) repository.DatabaseRepo {
+		return NewSeededMemoryDBRepo()
+	})
+}
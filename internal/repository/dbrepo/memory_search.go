@@ -0,0 +1,225 @@
+package dbrepo
+
+import (
+	"context"
+	"github.com/calvarado2004/go-movies-backend/internal/models"
+	"github.com/calvarado2004/go-movies-backend/internal/repository"
+	"html"
+	"sort"
+	"strings"
+	"unicode"
+)
+
+// Thresholds of the pg_trgm similarity (%) and word similarity (<%) operators.
+const (
+	similarityThreshold     = 0.3
+	wordSimilarityThreshold = 0.6
+)
+
+// snippetWords is the maximum number of words in a search snippet, like MaxWords of ts_headline.
+const snippetWords = 30
+
+// SearchMovies searches the title and description of movies, tolerating typos in titles, and returns the best
+// matches first along with a highlighted excerpt of their description. It approximates the Postgres full-text
+// and trigram search: every query word must prefix a word of the title or description, or the query must be
+// similar enough to the title.
+func (m *MemoryDBRepo) SearchMovies(ctx context.Context, search repository.MovieSearch) ([]*models.MovieSearchResult, error) {
+
+	err := search.Normalize()
+	if err != nil {
+		return nil, err
+	}
+
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	queryWords := words(search.Query)
+
+	results := []*models.MovieSearchResult{}
+
+	for _, movie := range m.movies {
+		if !m.movieMatches(movie, repository.MovieQuery{Genres: search.Genres, MPAARatings: search.MPAARatings}) {
+			continue
+		}
+
+		titleWords := words(movie.Title)
+		descriptionWords := words(movie.Description)
+
+		var rank float64
+		textMatch := len(queryWords) > 0
+
+		for _, word := range queryWords {
+			inTitle := hasPrefixedWord(titleWords, word)
+			inDescription := hasPrefixedWord(descriptionWords, word)
+
+			switch {
+			case inTitle:
+				rank += 0.6
+			case inDescription:
+				rank += 0.2
+			default:
+				textMatch = false
+			}
+		}
+
+		if !textMatch {
+			rank = 0
+		}
+
+		titleSimilarity := wordSimilarity(search.Query, movie.Title)
+
+		if !textMatch && similarity(search.Query, movie.Title) < similarityThreshold && titleSimilarity < wordSimilarityThreshold {
+			continue
+		}
+
+		movie.Genres = nil
+		movie.GenresArray = nil
+
+		results = append(results, &models.MovieSearchResult{
+			Movie:   movie,
+			Rank:    rank + titleSimilarity,
+			Snippet: snippet(movie.Description, queryWords),
+		})
+	}
+
+	sort.Slice(results, func(i, j int) bool {
+		if results[i].Rank != results[j].Rank {
+			return results[i].Rank > results[j].Rank
+		}
+		return results[i].Title < results[j].Title
+	})
+
+	if len(results) > search.Limit {
+		results = results[:search.Limit]
+	}
+
+	return results, nil
+}
+
+// words splits text into lower case words of letters and digits.
+func words(text string) []string {
+	return strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+}
+
+// hasPrefixedWord reports whether any of the words starts with prefix.
+func hasPrefixedWord(words []string, prefix string) bool {
+	for _, word := range words {
+		if strings.HasPrefix(word, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// trigrams returns the trigrams of text the way pg_trgm extracts them: every word is padded with two spaces
+// in front and one behind.
+func trigrams(text string) map[string]bool {
+
+	set := map[string]bool{}
+
+	for _, word := range words(text) {
+		padded := []rune("  " + word + " ")
+		for i := 0; i+3 <= len(padded); i++ {
+			set[string(padded[i:i+3])] = true
+		}
+	}
+
+	return set
+}
+
+// similarity returns the pg_trgm similarity of two strings, the share of trigrams they have in common.
+func similarity(a, b string) float64 {
+
+	ta, tb := trigrams(a), trigrams(b)
+	if len(ta) == 0 || len(tb) == 0 {
+		return 0
+	}
+
+	shared := 0
+	for trigram := range ta {
+		if tb[trigram] {
+			shared++
+		}
+	}
+
+	return float64(shared) / float64(len(ta)+len(tb)-shared)
+}
+
+// wordSimilarity approximates the pg_trgm word_similarity of query and text: the best similarity between the
+// query and any run of consecutive words of the text.
+func wordSimilarity(query, text string) float64 {
+
+	textWords := words(text)
+
+	var best float64
+
+	for start := range textWords {
+		for end := start + 1; end <= len(textWords); end++ {
+			s := similarity(query, strings.Join(textWords[start:end], " "))
+			if s > best {
+				best = s
+			}
+		}
+	}
+
+	return best
+}
+
+// snippet returns an excerpt of the description around the first query word it contains, HTML-escaped, with
+// every query word wrapped in <mark> tags like ts_headline does.
+func snippet(description string, queryWords []string) string {
+
+	fields := strings.Fields(description)
+
+	matches := func(field string) bool {
+		for _, word := range words(field) {
+			if hasAnyPrefix(word, queryWords) {
+				return true
+			}
+		}
+		return false
+	}
+
+	start := 0
+	for i, field := range fields {
+		if matches(field) {
+			start = i - snippetWords/3
+			break
+		}
+	}
+
+	if start < 0 {
+		start = 0
+	}
+
+	end := start + snippetWords
+	if end > len(fields) {
+		end = len(fields)
+	}
+
+	excerpt := make([]string, 0, end-start)
+
+	for _, field := range fields[start:end] {
+		highlight := matches(field)
+
+		field = html.EscapeString(field)
+		if highlight {
+			field = "<mark>" + field + "</mark>"
+		}
+		excerpt = append(excerpt, field)
+	}
+
+	return strings.Join(excerpt, " ")
+}
+
+// hasAnyPrefix reports whether word starts with any of the prefixes.
+func hasAnyPrefix(word string, prefixes []string) bool {
+	for _, prefix := range prefixes {
+		if strings.HasPrefix(word, prefix) {
+			return true
+		}
+	}
+	return false
+}
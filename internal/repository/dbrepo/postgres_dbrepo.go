@@ -3,28 +3,99 @@ package dbrepo
 import (
 	"context"
 	"database/sql"
+	"errors"
 	"fmt"
 	"github.com/calvarado2004/go-movies-backend/internal/models"
+	"github.com/calvarado2004/go-movies-backend/internal/repository"
+	"strings"
 	"time"
 )
 
 // PostgresDBRepo is a wrapper around the database connection pool.
 type PostgresDBRepo struct {
 	DB *sql.DB
+
+	// Timeout bounds every operation, on top of the deadline of its context. DefaultTimeout is used when zero.
+	Timeout time.Duration
+
+	// tx is the transaction statements run in, set on the repository handed to the function of WithTx.
+	tx *sql.Tx
+}
+
+// querier runs statements, either on the connection pool or in a transaction.
+type querier interface {
+	ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error)
+	QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error)
+	QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row
 }
 
-// dbTimeout is the maximum amount of time a database operation can take.
-const dbTimeout = time.Second * 5
+// DefaultTimeout is the maximum amount of time a database operation can take, unless configured otherwise.
+const DefaultTimeout = time.Second * 5
 
 // Connection returns the database connection pool.
 func (m *PostgresDBRepo) Connection() *sql.DB {
 	return m.DB
 }
 
+// withTimeout returns a context for an operation, ending at the timeout of the repository at the latest.
+func (m *PostgresDBRepo) withTimeout(ctx context.Context) (context.Context, context.CancelFunc) {
+
+	timeout := m.Timeout
+	if timeout <= 0 {
+		timeout = DefaultTimeout
+	}
+
+	return context.WithTimeout(ctx, timeout)
+}
+
+// db returns where statements run: the transaction of the repository if it has one, the pool otherwise.
+// Every statement is traced.
+func (m *PostgresDBRepo) db() querier {
+	if m.tx != nil {
+		return tracedQuerier{q: m.tx}
+	}
+	return tracedQuerier{q: m.DB}
+}
+
+// WithTx runs fn with a repository whose statements all run in one transaction, committed if fn returns nil
+// and rolled back otherwise. Called on a repository already in a transaction, fn joins that transaction.
+func (m *PostgresDBRepo) WithTx(ctx context.Context, fn func(repo repository.DatabaseRepo) error) error {
+	return m.transact(ctx, func(tx *PostgresDBRepo) error {
+		return fn(tx)
+	})
+}
+
+// transact runs fn with a repository in the transaction of m, or in a new one committed when fn succeeds.
+func (m *PostgresDBRepo) transact(ctx context.Context, fn func(tx *PostgresDBRepo) error) error {
+
+	if m.tx != nil {
+		return fn(m)
+	}
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+
+	defer func(tx *sql.Tx) {
+		err := tx.Rollback()
+		if err != nil {
+			return
+		}
+	}(tx)
+
+	err = fn(&PostgresDBRepo{DB: m.DB, Timeout: m.Timeout, tx: tx})
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
 // AllMovies returns all movies from the database.
-func (m *PostgresDBRepo) AllMovies(genre ...int) ([]*models.Movie, error) {
+func (m *PostgresDBRepo) AllMovies(ctx context.Context, genre ...int) ([]*models.Movie, error) {
 
-	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	ctx, cancel := m.withTimeout(ctx)
 	defer cancel()
 
 	// if a genre ID was provided, only return movies for that genre
@@ -42,7 +113,7 @@ func (m *PostgresDBRepo) AllMovies(genre ...int) ([]*models.Movie, error) {
 	ORDER BY 
 	    title DESC`, where)
 
-	rows, err := m.DB.QueryContext(ctx, query)
+	rows, err := m.db().QueryContext(ctx, query)
 	if err != nil {
 		return nil, err
 	}
@@ -77,15 +148,225 @@ func (m *PostgresDBRepo) AllMovies(genre ...int) ([]*models.Movie, error) {
 	return movies, nil
 }
 
+// movieSortColumns maps the sort fields of a MovieQuery to their columns.
+var movieSortColumns = map[string]string{
+	repository.SortTitle:       "title",
+	repository.SortReleaseDate: "release_date",
+	repository.SortRuntime:     "runtime",
+	repository.SortCreatedAt:   "created_at",
+}
+
+// ListMovies returns one page of movies matching the query, along with the total number of matching movies.
+func (m *PostgresDBRepo) ListMovies(ctx context.Context, query repository.MovieQuery) ([]*models.Movie, int, error) {
+
+	ctx, cancel := m.withTimeout(ctx)
+	defer cancel()
+
+	err := query.Normalize()
+	if err != nil {
+		return nil, 0, err
+	}
+
+	var conditions []string
+	var args []any
+
+	addCondition := func(condition string, arg any) {
+		args = append(args, arg)
+		conditions = append(conditions, fmt.Sprintf(condition, len(args)))
+	}
+
+	if len(query.Genres) > 0 {
+		addCondition("id IN (SELECT movie_id FROM movies_genres WHERE genre_id = ANY($%d))", query.Genres)
+	}
+	if len(query.MPAARatings) > 0 {
+		addCondition("mpaa_rating = ANY($%d)", query.MPAARatings)
+	}
+	if query.ReleaseYearFrom > 0 {
+		addCondition("EXTRACT(YEAR FROM release_date) >= $%d", query.ReleaseYearFrom)
+	}
+	if query.ReleaseYearTo > 0 {
+		addCondition("EXTRACT(YEAR FROM release_date) <= $%d", query.ReleaseYearTo)
+	}
+	if query.RuntimeMin > 0 {
+		addCondition("runtime >= $%d", query.RuntimeMin)
+	}
+	if query.RuntimeMax > 0 {
+		addCondition("runtime <= $%d", query.RuntimeMax)
+	}
+	if query.TitleContains != "" {
+		addCondition("strpos(lower(title), lower($%d)) > 0", query.TitleContains)
+	}
+
+	where := ""
+	if len(conditions) > 0 {
+		where = "WHERE " + strings.Join(conditions, " AND ")
+	}
+
+	var total int
+
+	err = m.db().QueryRowContext(ctx, fmt.Sprintf(`SELECT count(*) FROM movies %s`, where), args...).Scan(&total)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	direction := "ASC"
+	if query.Desc {
+		direction = "DESC"
+	}
+
+	args = append(args, query.Limit, query.Offset)
+
+	stmt := fmt.Sprintf(`SELECT
+    	id, title, release_date, runtime, mpaa_rating, description, coalesce(image, ''), created_at, updated_at
+	FROM
+	    movies %s
+	ORDER BY
+	    %s %s, id %s
+	LIMIT $%d OFFSET $%d`, where, movieSortColumns[query.Sort], direction, direction, len(args)-1, len(args))
+
+	rows, err := m.db().QueryContext(ctx, stmt, args...)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	defer func(rows *sql.Rows) {
+		err := rows.Close()
+		if err != nil {
+			return
+		}
+	}(rows)
+
+	movies := []*models.Movie{}
+
+	for rows.Next() {
+		movie := models.Movie{}
+		err := rows.Scan(
+			&movie.ID,
+			&movie.Title,
+			&movie.ReleaseDate,
+			&movie.Runtime,
+			&movie.MPAARating,
+			&movie.Description,
+			&movie.Image,
+			&movie.CreatedAt,
+			&movie.UpdatedAt,
+		)
+		if err != nil {
+			return nil, 0, err
+		}
+		movies = append(movies, &movie)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, 0, err
+	}
+
+	return movies, total, nil
+}
+
+// SearchMovies searches the title and description of movies, tolerating typos in titles, and returns the best
+// matches first along with a highlighted excerpt of their description.
+func (m *PostgresDBRepo) SearchMovies(ctx context.Context, search repository.MovieSearch) ([]*models.MovieSearchResult, error) {
+
+	ctx, cancel := m.withTimeout(ctx)
+	defer cancel()
+
+	err := search.Normalize()
+	if err != nil {
+		return nil, err
+	}
+
+	args := []any{search.Query}
+	filters := ""
+
+	if len(search.Genres) > 0 {
+		args = append(args, search.Genres)
+		filters += fmt.Sprintf(" AND m.id IN (SELECT movie_id FROM movies_genres WHERE genre_id = ANY($%d))", len(args))
+	}
+	if len(search.MPAARatings) > 0 {
+		args = append(args, search.MPAARatings)
+		filters += fmt.Sprintf(" AND m.mpaa_rating = ANY($%d)", len(args))
+	}
+
+	args = append(args, search.Limit)
+
+	// full-text matches are ranked by ts_rank, typos in titles are caught by trigram word similarity, and
+	// the description is escaped before ts_headline marks the matches, so snippets are safe HTML
+	query := fmt.Sprintf(`SELECT
+		m.id, m.title, m.release_date, m.runtime, m.mpaa_rating, m.description, coalesce(m.image, ''), m.created_at, m.updated_at,
+		ts_rank(m.search_vector, q.query) + word_similarity($1, m.title) AS rank,
+		ts_headline('english', %s, q.query, 'StartSel=<mark>, StopSel=</mark>, MaxFragments=2, MaxWords=30, MinWords=10') AS snippet
+	FROM
+		movies m, websearch_to_tsquery('english', $1) AS q(query)
+	WHERE
+		(m.search_vector @@ q.query OR m.title %% $1 OR $1 <%% m.title)%s
+	ORDER BY
+		rank DESC, m.title
+	LIMIT $%d`, escapeHTML("coalesce(m.description, '')"), filters, len(args))
+
+	rows, err := m.db().QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+
+	defer func(rows *sql.Rows) {
+		err := rows.Close()
+		if err != nil {
+			return
+		}
+	}(rows)
+
+	results := []*models.MovieSearchResult{}
+
+	for rows.Next() {
+		var result models.MovieSearchResult
+		err := rows.Scan(
+			&result.ID,
+			&result.Title,
+			&result.ReleaseDate,
+			&result.Runtime,
+			&result.MPAARating,
+			&result.Description,
+			&result.Image,
+			&result.CreatedAt,
+			&result.UpdatedAt,
+			&result.Rank,
+			&result.Snippet,
+		)
+		if err != nil {
+			return nil, err
+		}
+		results = append(results, &result)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return results, nil
+}
+
+// escapeHTML returns a SQL expression escaping the HTML special characters of the text expression, the same
+// way as html.EscapeString.
+func escapeHTML(expr string) string {
+
+	// ampersands first, so that the entities of the other characters aren't escaped again
+	for _, escape := range [][2]string{{"&", "&amp;"}, {"''", "&#39;"}, {"<", "&lt;"}, {">", "&gt;"}, {`"`, "&#34;"}} {
+		expr = fmt.Sprintf("replace(%s, '%s', '%s')", expr, escape[0], escape[1])
+	}
+
+	return expr
+}
+
 // OneMovie returns one movie from the database.
-func (m *PostgresDBRepo) OneMovie(id int) (*models.Movie, error) {
+func (m *PostgresDBRepo) OneMovie(ctx context.Context, id int) (*models.Movie, error) {
 
-	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	ctx, cancel := m.withTimeout(ctx)
 	defer cancel()
 
 	query := `SELECT id, title, release_date, runtime, mpaa_rating, description, coalesce(image, ''), created_at, updated_at FROM movies WHERE id = $1`
 
-	row := m.DB.QueryRowContext(ctx, query, id)
+	row := m.db().QueryRowContext(ctx, query, id)
 
 	var movie models.Movie
 
@@ -108,7 +389,7 @@ func (m *PostgresDBRepo) OneMovie(id int) (*models.Movie, error) {
 
 	query = `SELECT g.id, g.genre from movies_genres mg, genres g where movie_id = $1 and g.id = mg.genre_id`
 
-	rows, err := m.DB.QueryContext(ctx, query, id)
+	rows, err := m.db().QueryContext(ctx, query, id)
 	if err != nil && err != sql.ErrNoRows {
 		return nil, err
 	}
@@ -136,19 +417,27 @@ func (m *PostgresDBRepo) OneMovie(id int) (*models.Movie, error) {
 
 	movie.Genres = genres
 
+	poster, err := m.GetMoviePoster(ctx, id)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return nil, err
+	}
+	if err == nil {
+		movie.Poster = &poster
+	}
+
 	return &movie, nil
 
 }
 
 // OneMovieForEdit returns one movie from the database and edit it.
-func (m *PostgresDBRepo) OneMovieForEdit(id int) (*models.Movie, []*models.Genre, error) {
+func (m *PostgresDBRepo) OneMovieForEdit(ctx context.Context, id int) (*models.Movie, []*models.Genre, error) {
 
-	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	ctx, cancel := m.withTimeout(ctx)
 	defer cancel()
 
 	query := `SELECT id, title, release_date, runtime, mpaa_rating, description, coalesce(image, ''), created_at, updated_at FROM movies WHERE id = $1`
 
-	row := m.DB.QueryRowContext(ctx, query, id)
+	row := m.db().QueryRowContext(ctx, query, id)
 
 	var movie models.Movie
 
@@ -171,7 +460,7 @@ func (m *PostgresDBRepo) OneMovieForEdit(id int) (*models.Movie, []*models.Genre
 
 	query = `SELECT g.id, g.genre from movies_genres mg, genres g where movie_id = $1 and g.id = mg.genre_id`
 
-	rows, err := m.DB.QueryContext(ctx, query, id)
+	rows, err := m.db().QueryContext(ctx, query, id)
 	if err != nil && err != sql.ErrNoRows {
 		return nil, nil, err
 	}
@@ -207,7 +496,7 @@ func (m *PostgresDBRepo) OneMovieForEdit(id int) (*models.Movie, []*models.Genre
 
 	query = `SELECT id, genre FROM genres ORDER BY genre`
 
-	rows, err = m.DB.QueryContext(ctx, query)
+	rows, err = m.db().QueryContext(ctx, query)
 	if err != nil {
 		return nil, nil, err
 	}
@@ -236,16 +525,16 @@ func (m *PostgresDBRepo) OneMovieForEdit(id int) (*models.Movie, []*models.Genre
 }
 
 // GetUserByEmail returns a user from the database by email.
-func (m *PostgresDBRepo) GetUserByEmail(email string) (models.User, error) {
+func (m *PostgresDBRepo) GetUserByEmail(ctx context.Context, email string) (models.User, error) {
 
-	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	ctx, cancel := m.withTimeout(ctx)
 	defer cancel()
 
-	query := `SELECT id, email, first_name, last_name, password, created_at, updated_at FROM users WHERE email = $1`
+	query := `SELECT id, email, first_name, last_name, password, role, email_verified_at, created_at, updated_at FROM users WHERE lower(email) = lower($1)`
 
 	var user models.User
 
-	row := m.DB.QueryRowContext(ctx, query, email)
+	row := m.db().QueryRowContext(ctx, query, email)
 
 	err := row.Scan(
 		&user.ID,
@@ -253,6 +542,8 @@ func (m *PostgresDBRepo) GetUserByEmail(email string) (models.User, error) {
 		&user.FirstName,
 		&user.LastName,
 		&user.Password,
+		&user.Role,
+		&user.EmailVerifiedAt,
 		&user.CreatedAt,
 		&user.UpdatedAt,
 	)
@@ -264,16 +555,16 @@ func (m *PostgresDBRepo) GetUserByEmail(email string) (models.User, error) {
 }
 
 // GetUserByID returns a user from the database by id.
-func (m *PostgresDBRepo) GetUserByID(id int) (models.User, error) {
+func (m *PostgresDBRepo) GetUserByID(ctx context.Context, id int) (models.User, error) {
 
-	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	ctx, cancel := m.withTimeout(ctx)
 	defer cancel()
 
-	query := `SELECT id, email, first_name, last_name, password, created_at, updated_at FROM users WHERE id = $1`
+	query := `SELECT id, email, first_name, last_name, password, role, email_verified_at, created_at, updated_at FROM users WHERE id = $1`
 
 	var user models.User
 
-	row := m.DB.QueryRowContext(ctx, query, id)
+	row := m.db().QueryRowContext(ctx, query, id)
 
 	err := row.Scan(
 		&user.ID,
@@ -281,6 +572,8 @@ func (m *PostgresDBRepo) GetUserByID(id int) (models.User, error) {
 		&user.FirstName,
 		&user.LastName,
 		&user.Password,
+		&user.Role,
+		&user.EmailVerifiedAt,
 		&user.CreatedAt,
 		&user.UpdatedAt,
 	)
@@ -291,14 +584,123 @@ func (m *PostgresDBRepo) GetUserByID(id int) (models.User, error) {
 	return user, nil
 }
 
+// InsertUser inserts a user into the database.
+func (m *PostgresDBRepo) InsertUser(ctx context.Context, user models.User) (int, error) {
+	ctx, cancel := m.withTimeout(ctx)
+	defer cancel()
+
+	stmt := `INSERT INTO users (first_name, last_name, email, password, role, email_verified_at, created_at, updated_at) VALUES ($1, $2, $3, $4, $5, $6, $7, $8) RETURNING id`
+
+	var newID int
+
+	err := m.db().QueryRowContext(
+		ctx,
+		stmt,
+		user.FirstName,
+		user.LastName,
+		user.Email,
+		user.Password,
+		user.Role,
+		user.EmailVerifiedAt,
+		user.CreatedAt,
+		user.UpdatedAt).Scan(&newID)
+	if err != nil {
+		return 0, err
+	}
+
+	return newID, nil
+}
+
+// UpdateUserPassword replaces the password hash of a user.
+func (m *PostgresDBRepo) UpdateUserPassword(ctx context.Context, id int, passwordHash string) error {
+	ctx, cancel := m.withTimeout(ctx)
+	defer cancel()
+
+	stmt := `UPDATE users SET password = $1, updated_at = $2 WHERE id = $3`
+
+	_, err := m.db().ExecContext(ctx, stmt, passwordHash, time.Now().UTC(), id)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// SetUserEmailVerified marks the email address of a user as verified.
+func (m *PostgresDBRepo) SetUserEmailVerified(ctx context.Context, id int) error {
+	ctx, cancel := m.withTimeout(ctx)
+	defer cancel()
+
+	stmt := `UPDATE users SET email_verified_at = $1, updated_at = $1 WHERE id = $2 AND email_verified_at IS NULL`
+
+	_, err := m.db().ExecContext(ctx, stmt, time.Now().UTC(), id)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// InsertUserToken stores the hash of a single-use token sent to a user.
+func (m *PostgresDBRepo) InsertUserToken(ctx context.Context, token models.UserToken) error {
+	ctx, cancel := m.withTimeout(ctx)
+	defer cancel()
+
+	stmt := `INSERT INTO user_tokens (user_id, token_hash, scope, expires_at, created_at) VALUES ($1, $2, $3, $4, $5)`
+
+	_, err := m.db().ExecContext(
+		ctx,
+		stmt,
+		token.UserID,
+		token.TokenHash,
+		token.Scope,
+		token.ExpiresAt,
+		token.CreatedAt)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// ConsumeUserToken marks an unused, unexpired token with the given hash and scope as used and returns it.
+// It returns sql.ErrNoRows if there is no such token.
+func (m *PostgresDBRepo) ConsumeUserToken(ctx context.Context, hash, scope string) (models.UserToken, error) {
+	ctx, cancel := m.withTimeout(ctx)
+	defer cancel()
+
+	stmt := `UPDATE user_tokens SET used_at = $1
+	WHERE token_hash = $2 AND scope = $3 AND used_at IS NULL AND expires_at > $1
+	RETURNING id, user_id, token_hash, scope, expires_at, used_at, created_at`
+
+	var token models.UserToken
+
+	row := m.db().QueryRowContext(ctx, stmt, time.Now().UTC(), hash, scope)
+
+	err := row.Scan(
+		&token.ID,
+		&token.UserID,
+		&token.TokenHash,
+		&token.Scope,
+		&token.ExpiresAt,
+		&token.UsedAt,
+		&token.CreatedAt,
+	)
+	if err != nil {
+		return models.UserToken{}, err
+	}
+
+	return token, nil
+}
+
 // AllGenresDB returns all genres from the database.
-func (m *PostgresDBRepo) AllGenresDB() ([]*models.Genre, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+func (m *PostgresDBRepo) AllGenresDB(ctx context.Context) ([]*models.Genre, error) {
+	ctx, cancel := m.withTimeout(ctx)
 	defer cancel()
 
 	query := `SELECT id, genre, created_at, updated_at FROM genres order by genre`
 
-	rows, err := m.DB.QueryContext(ctx, query)
+	rows, err := m.db().QueryContext(ctx, query)
 	if err != nil {
 		return nil, err
 	}
@@ -329,26 +731,59 @@ func (m *PostgresDBRepo) AllGenresDB() ([]*models.Genre, error) {
 	return genres, nil
 }
 
-// InsertMovie inserts a movie into the database.
-func (m *PostgresDBRepo) InsertMovie(movie models.Movie) (int, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+// GenresForMovies returns the genres of each of the given movies ordered by name, keyed by movie id.
+func (m *PostgresDBRepo) GenresForMovies(ctx context.Context, movieIDs []int) (map[int][]*models.Genre, error) {
+	ctx, cancel := m.withTimeout(ctx)
 	defer cancel()
 
-	stmt := `INSERT INTO movies (title, description, release_date, runtime,  mpaa_rating, created_at, updated_at, image) VALUES ($1, $2, $3, $4, $5, $6, $7, $8) RETURNING id`
+	query := `SELECT mg.movie_id, g.id, g.genre FROM movies_genres mg JOIN genres g ON g.id = mg.genre_id
+		WHERE mg.movie_id = ANY($1) ORDER BY g.genre`
+
+	rows, err := m.db().QueryContext(ctx, query, movieIDs)
+	if err != nil {
+		return nil, err
+	}
+
+	defer func(rows *sql.Rows) {
+		err := rows.Close()
+		if err != nil {
+			return
+		}
+	}(rows)
+
+	genres := map[int][]*models.Genre{}
+
+	for rows.Next() {
+		var movieID int
+		var genre models.Genre
+		err := rows.Scan(
+			&movieID,
+			&genre.ID,
+			&genre.Genre,
+		)
+		if err != nil {
+			return nil, err
+		}
+		genres[movieID] = append(genres[movieID], &genre)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return genres, nil
+}
+
+// InsertGenre inserts a genre into the database.
+func (m *PostgresDBRepo) InsertGenre(ctx context.Context, genre models.Genre) (int, error) {
+	ctx, cancel := m.withTimeout(ctx)
+	defer cancel()
+
+	stmt := `INSERT INTO genres (genre, created_at, updated_at) VALUES ($1, $2, $3) RETURNING id`
 
 	var newID int
 
-	err := m.DB.QueryRowContext(
-		ctx,
-		stmt,
-		movie.Title,
-		movie.Description,
-		movie.ReleaseDate,
-		movie.Runtime,
-		movie.MPAARating,
-		movie.CreatedAt,
-		movie.UpdatedAt,
-		movie.Image).Scan(&newID)
+	err := m.db().QueryRowContext(ctx, stmt, genre.Genre, genre.CreatedAt, genre.UpdatedAt).Scan(&newID)
 	if err != nil {
 		return 0, err
 	}
@@ -356,24 +791,14 @@ func (m *PostgresDBRepo) InsertMovie(movie models.Movie) (int, error) {
 	return newID, nil
 }
 
-// UpdateMovie updates a movie in the database.
-func (m *PostgresDBRepo) UpdateMovie(movie models.Movie) error {
-	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+// UpdateGenre renames a genre in the database.
+func (m *PostgresDBRepo) UpdateGenre(ctx context.Context, genre models.Genre) error {
+	ctx, cancel := m.withTimeout(ctx)
 	defer cancel()
 
-	stmt := `UPDATE movies SET title = $1, description = $2, release_date = $3, runtime = $4, mpaa_rating = $5, updated_at = $6, image = $7 WHERE id = $8`
+	stmt := `UPDATE genres SET genre = $1, updated_at = $2 WHERE id = $3`
 
-	_, err := m.DB.ExecContext(
-		ctx,
-		stmt,
-		movie.Title,
-		movie.Description,
-		movie.ReleaseDate,
-		movie.Runtime,
-		movie.MPAARating,
-		movie.UpdatedAt,
-		movie.Image,
-		movie.ID)
+	_, err := m.db().ExecContext(ctx, stmt, genre.Genre, genre.UpdatedAt, genre.ID)
 	if err != nil {
 		return err
 	}
@@ -381,40 +806,634 @@ func (m *PostgresDBRepo) UpdateMovie(movie models.Movie) error {
 	return nil
 }
 
-// UpdateMovieGenres updates the genres for a movie.
-func (m *PostgresDBRepo) UpdateMovieGenres(id int, genreIDs []int) error {
-	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+// DeleteGenre deletes a genre from the database. Its movie associations are deleted by the foreign key.
+func (m *PostgresDBRepo) DeleteGenre(ctx context.Context, id int) error {
+	ctx, cancel := m.withTimeout(ctx)
 	defer cancel()
 
-	stmt := `DELETE FROM movies_genres WHERE movie_id = $1`
+	stmt := `DELETE FROM genres WHERE id = $1`
 
-	_, err := m.DB.ExecContext(ctx, stmt, id)
+	_, err := m.db().ExecContext(ctx, stmt, id)
 	if err != nil {
 		return err
 	}
 
-	stmt = `INSERT INTO movies_genres (movie_id, genre_id) VALUES ($1, $2)`
-	for _, genreID := range genreIDs {
-		_, err = m.DB.ExecContext(ctx, stmt, id, genreID)
-		if err != nil {
-			return err
-		}
+	return nil
+}
+
+// InsertMovie inserts a movie into the database.
+func (m *PostgresDBRepo) InsertMovie(ctx context.Context, movie models.Movie) (int, error) {
+	ctx, cancel := m.withTimeout(ctx)
+	defer cancel()
+
+	stmt := `INSERT INTO movies (title, description, release_date, runtime,  mpaa_rating, created_at, updated_at, image) VALUES ($1, $2, $3, $4, $5, $6, $7, $8) RETURNING id`
+
+	var newID int
+
+	err := m.db().QueryRowContext(
+		ctx,
+		stmt,
+		movie.Title,
+		movie.Description,
+		movie.ReleaseDate,
+		movie.Runtime,
+		movie.MPAARating,
+		movie.CreatedAt,
+		movie.UpdatedAt,
+		movie.Image).Scan(&newID)
+	if err != nil {
+		return 0, err
+	}
+
+	return newID, nil
+}
+
+// UpdateMovie updates a movie in the database.
+func (m *PostgresDBRepo) UpdateMovie(ctx context.Context, movie models.Movie) error {
+	ctx, cancel := m.withTimeout(ctx)
+	defer cancel()
+
+	stmt := `UPDATE movies SET title = $1, description = $2, release_date = $3, runtime = $4, mpaa_rating = $5, updated_at = $6, image = $7 WHERE id = $8`
+
+	_, err := m.db().ExecContext(
+		ctx,
+		stmt,
+		movie.Title,
+		movie.Description,
+		movie.ReleaseDate,
+		movie.Runtime,
+		movie.MPAARating,
+		movie.UpdatedAt,
+		movie.Image,
+		movie.ID)
+	if err != nil {
+		return err
 	}
 
 	return nil
 }
 
+// FillMovieImage sets the image of a movie that has none, and reports whether it did.
+func (m *PostgresDBRepo) FillMovieImage(ctx context.Context, id int, image string) (bool, error) {
+	ctx, cancel := m.withTimeout(ctx)
+	defer cancel()
+
+	stmt := `UPDATE movies SET image = $1, updated_at = $2 WHERE id = $3 AND coalesce(image, '') = ''`
+
+	result, err := m.db().ExecContext(ctx, stmt, image, time.Now(), id)
+	if err != nil {
+		return false, err
+	}
+
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+
+	return affected == 1, nil
+}
+
+// ReplaceMoviePoster stores the description of the poster images of a movie and, unless image is empty,
+// points the image of the movie to it, in a single transaction. It returns the poster it replaced, if any.
+func (m *PostgresDBRepo) ReplaceMoviePoster(ctx context.Context, poster models.MoviePoster, image string) (*models.MoviePoster, error) {
+	ctx, cancel := m.withTimeout(ctx)
+	defer cancel()
+
+	var replaced *models.MoviePoster
+
+	err := m.transact(ctx, func(tx *PostgresDBRepo) error {
+
+		// lock the movie, so that concurrent replacements of its poster are serialized
+		var movieID int
+
+		err := tx.db().QueryRowContext(ctx, `SELECT id FROM movies WHERE id = $1 FOR UPDATE`, poster.MovieID).Scan(&movieID)
+		if err != nil {
+			return err
+		}
+
+		previous, err := tx.GetMoviePoster(ctx, poster.MovieID)
+		if err != nil && !errors.Is(err, sql.ErrNoRows) {
+			return err
+		}
+
+		if err == nil {
+			replaced = &previous
+		}
+
+		stmt := `INSERT INTO movie_posters (movie_id, version, source, dominant_color, placeholder, width, height, created_at, updated_at)
+			VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)
+			ON CONFLICT (movie_id) DO UPDATE SET version = excluded.version, source = excluded.source,
+				dominant_color = excluded.dominant_color, placeholder = excluded.placeholder, width = excluded.width,
+				height = excluded.height, updated_at = excluded.updated_at`
+
+		_, err = tx.db().ExecContext(ctx, stmt,
+			poster.MovieID,
+			poster.Version,
+			poster.Source,
+			poster.DominantColor,
+			poster.Placeholder,
+			poster.Width,
+			poster.Height,
+			poster.CreatedAt,
+			poster.UpdatedAt,
+		)
+		if err != nil {
+			return err
+		}
+
+		if image != "" {
+			_, err = tx.db().ExecContext(ctx, `UPDATE movies SET image = $1, updated_at = $2 WHERE id = $3`, image, poster.UpdatedAt, poster.MovieID)
+			if err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return replaced, nil
+}
+
+// GetMoviePoster returns the description of the poster images of a movie.
+func (m *PostgresDBRepo) GetMoviePoster(ctx context.Context, movieID int) (models.MoviePoster, error) {
+	ctx, cancel := m.withTimeout(ctx)
+	defer cancel()
+
+	query := `SELECT movie_id, version, source, dominant_color, placeholder, width, height, created_at, updated_at
+		FROM movie_posters WHERE movie_id = $1`
+
+	var poster models.MoviePoster
+
+	err := m.db().QueryRowContext(ctx, query, movieID).Scan(
+		&poster.MovieID,
+		&poster.Version,
+		&poster.Source,
+		&poster.DominantColor,
+		&poster.Placeholder,
+		&poster.Width,
+		&poster.Height,
+		&poster.CreatedAt,
+		&poster.UpdatedAt,
+	)
+	if err != nil {
+		return poster, err
+	}
+
+	return poster, nil
+}
+
+// UpdateMovieGenres replaces the genres of a movie, all of them inserted in a single statement. The old
+// genres are kept if the new ones can't be stored.
+func (m *PostgresDBRepo) UpdateMovieGenres(ctx context.Context, id int, genreIDs []int) error {
+	ctx, cancel := m.withTimeout(ctx)
+	defer cancel()
+
+	return m.transact(ctx, func(tx *PostgresDBRepo) error {
+
+		stmt := `DELETE FROM movies_genres WHERE movie_id = $1`
+
+		_, err := tx.db().ExecContext(ctx, stmt, id)
+		if err != nil {
+			return err
+		}
+
+		if len(genreIDs) == 0 {
+			return nil
+		}
+
+		stmt = `INSERT INTO movies_genres (movie_id, genre_id) SELECT $1, genre_id FROM unnest($2::integer[]) AS genre_id`
+
+		_, err = tx.db().ExecContext(ctx, stmt, id, genreIDs)
+
+		return err
+	})
+}
+
 // DeleteMovie deletes a movie from the database. Genres are not needed to be deleted.
-func (m *PostgresDBRepo) DeleteMovie(id int) error {
-	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+func (m *PostgresDBRepo) DeleteMovie(ctx context.Context, id int) error {
+	ctx, cancel := m.withTimeout(ctx)
 	defer cancel()
 
 	stmt := `DELETE FROM movies WHERE id = $1`
 
-	_, err := m.DB.ExecContext(ctx, stmt, id)
+	_, err := m.db().ExecContext(ctx, stmt, id)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// InsertRefreshToken stores the hash of a newly issued refresh token.
+func (m *PostgresDBRepo) InsertRefreshToken(ctx context.Context, token models.RefreshToken) error {
+	ctx, cancel := m.withTimeout(ctx)
+	defer cancel()
+
+	stmt := `INSERT INTO refresh_tokens (user_id, token_hash, family_id, expires_at, created_at) VALUES ($1, $2, $3, $4, $5)`
+
+	_, err := m.db().ExecContext(
+		ctx,
+		stmt,
+		token.UserID,
+		token.TokenHash,
+		token.FamilyID,
+		token.ExpiresAt,
+		token.CreatedAt)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// GetRefreshTokenByHash returns a stored refresh token by the hash of its value.
+func (m *PostgresDBRepo) GetRefreshTokenByHash(ctx context.Context, hash string) (models.RefreshToken, error) {
+	ctx, cancel := m.withTimeout(ctx)
+	defer cancel()
+
+	query := `SELECT id, user_id, token_hash, family_id, expires_at, revoked_at, created_at FROM refresh_tokens WHERE token_hash = $1`
+
+	var token models.RefreshToken
+
+	row := m.db().QueryRowContext(ctx, query, hash)
+
+	err := row.Scan(
+		&token.ID,
+		&token.UserID,
+		&token.TokenHash,
+		&token.FamilyID,
+		&token.ExpiresAt,
+		&token.RevokedAt,
+		&token.CreatedAt,
+	)
+	if err != nil {
+		return models.RefreshToken{}, err
+	}
+
+	return token, nil
+}
+
+// RevokeRefreshToken revokes a refresh token. It reports false if the token was already revoked, which means
+// the caller lost a race to rotate it.
+func (m *PostgresDBRepo) RevokeRefreshToken(ctx context.Context, id int) (bool, error) {
+	ctx, cancel := m.withTimeout(ctx)
+	defer cancel()
+
+	stmt := `UPDATE refresh_tokens SET revoked_at = $1 WHERE id = $2 AND revoked_at IS NULL`
+
+	result, err := m.db().ExecContext(ctx, stmt, time.Now().UTC(), id)
+	if err != nil {
+		return false, err
+	}
+
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+
+	return affected == 1, nil
+}
+
+// RevokeRefreshTokenFamily revokes every refresh token issued for the same login session.
+func (m *PostgresDBRepo) RevokeRefreshTokenFamily(ctx context.Context, familyID string) error {
+	ctx, cancel := m.withTimeout(ctx)
+	defer cancel()
+
+	stmt := `UPDATE refresh_tokens SET revoked_at = $1 WHERE family_id = $2 AND revoked_at IS NULL`
+
+	_, err := m.db().ExecContext(ctx, stmt, time.Now().UTC(), familyID)
 	if err != nil {
 		return err
 	}
 
 	return nil
 }
+
+// DeleteExpiredRefreshTokens deletes the refresh tokens that have expired and returns how many were deleted.
+func (m *PostgresDBRepo) DeleteExpiredRefreshTokens(ctx context.Context) (int64, error) {
+	ctx, cancel := m.withTimeout(ctx)
+	defer cancel()
+
+	stmt := `DELETE FROM refresh_tokens WHERE expires_at < $1`
+
+	result, err := m.db().ExecContext(ctx, stmt, time.Now().UTC())
+	if err != nil {
+		return 0, err
+	}
+
+	return result.RowsAffected()
+}
+
+// RevokeUserRefreshTokens revokes every refresh token of a user, ending all of their sessions.
+func (m *PostgresDBRepo) RevokeUserRefreshTokens(ctx context.Context, userID int) error {
+	ctx, cancel := m.withTimeout(ctx)
+	defer cancel()
+
+	stmt := `UPDATE refresh_tokens SET revoked_at = $1 WHERE user_id = $2 AND revoked_at IS NULL`
+
+	_, err := m.db().ExecContext(ctx, stmt, time.Now().UTC(), userID)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// loginFailuresColumns are the columns of login_failures, in the order scanLoginFailures reads them.
+const loginFailuresColumns = `kind, subject, failures, last_failure_at, locked_until`
+
+// scanLoginFailures reads a row of login_failures.
+func scanLoginFailures(row interface{ Scan(dest ...any) error }) (models.LoginFailures, error) {
+
+	var failures models.LoginFailures
+
+	err := row.Scan(
+		&failures.Kind,
+		&failures.Subject,
+		&failures.Failures,
+		&failures.LastFailureAt,
+		&failures.LockedUntil,
+	)
+	if err != nil {
+		return models.LoginFailures{}, err
+	}
+
+	return failures, nil
+}
+
+// RecordLoginFailure counts a failed login of the subject at the given time and returns its failures. Failures
+// are forgotten once window has passed without a new one, along with the lockout they caused.
+func (m *PostgresDBRepo) RecordLoginFailure(ctx context.Context, kind, subject string, at time.Time, window time.Duration) (models.LoginFailures, error) {
+	ctx, cancel := m.withTimeout(ctx)
+	defer cancel()
+
+	stmt := `
+	INSERT INTO login_failures (kind, subject, failures, last_failure_at) VALUES ($1, $2, 1, $3)
+	ON CONFLICT (kind, subject) DO UPDATE SET
+		failures = CASE WHEN login_failures.last_failure_at < $4 THEN 1 ELSE login_failures.failures + 1 END,
+		locked_until = CASE WHEN login_failures.last_failure_at < $4 THEN NULL ELSE login_failures.locked_until END,
+		last_failure_at = $3
+	RETURNING ` + loginFailuresColumns
+
+	return scanLoginFailures(m.db().QueryRowContext(ctx, stmt, kind, subject, at, at.Add(-window)))
+}
+
+// LockLogins refuses the logins of the subject until the given time.
+func (m *PostgresDBRepo) LockLogins(ctx context.Context, kind, subject string, until time.Time) error {
+	ctx, cancel := m.withTimeout(ctx)
+	defer cancel()
+
+	stmt := `UPDATE login_failures SET locked_until = $1 WHERE kind = $2 AND subject = $3`
+
+	_, err := m.db().ExecContext(ctx, stmt, until, kind, subject)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// GetLoginFailures returns the failed logins of the subject, sql.ErrNoRows when it has none.
+func (m *PostgresDBRepo) GetLoginFailures(ctx context.Context, kind, subject string) (models.LoginFailures, error) {
+	ctx, cancel := m.withTimeout(ctx)
+	defer cancel()
+
+	query := `SELECT ` + loginFailuresColumns + ` FROM login_failures WHERE kind = $1 AND subject = $2`
+
+	return scanLoginFailures(m.db().QueryRowContext(ctx, query, kind, subject))
+}
+
+// ListLoginLockouts returns the subjects whose logins are locked at the given time, the longest locked first.
+func (m *PostgresDBRepo) ListLoginLockouts(ctx context.Context, now time.Time) ([]*models.LoginFailures, error) {
+	ctx, cancel := m.withTimeout(ctx)
+	defer cancel()
+
+	query := `SELECT ` + loginFailuresColumns + ` FROM login_failures WHERE locked_until > $1 ORDER BY locked_until DESC, kind, subject`
+
+	rows, err := m.db().QueryContext(ctx, query, now)
+	if err != nil {
+		return nil, err
+	}
+
+	defer func(rows *sql.Rows) {
+		err := rows.Close()
+		if err != nil {
+			return
+		}
+	}(rows)
+
+	var lockouts []*models.LoginFailures
+
+	for rows.Next() {
+		failures, err := scanLoginFailures(rows)
+		if err != nil {
+			return nil, err
+		}
+		lockouts = append(lockouts, &failures)
+	}
+
+	return lockouts, rows.Err()
+}
+
+// ClearLoginFailures forgets the failed logins of the subject, lifting its lockout. It reports false if the
+// subject had none.
+func (m *PostgresDBRepo) ClearLoginFailures(ctx context.Context, kind, subject string) (bool, error) {
+	ctx, cancel := m.withTimeout(ctx)
+	defer cancel()
+
+	stmt := `DELETE FROM login_failures WHERE kind = $1 AND subject = $2`
+
+	result, err := m.db().ExecContext(ctx, stmt, kind, subject)
+	if err != nil {
+		return false, err
+	}
+
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+
+	return affected == 1, nil
+}
+
+// DeleteStaleLoginFailures deletes the failed logins of the subjects that haven't failed nor been locked since
+// the given time, and returns how many subjects were deleted.
+func (m *PostgresDBRepo) DeleteStaleLoginFailures(ctx context.Context, before time.Time) (int64, error) {
+	ctx, cancel := m.withTimeout(ctx)
+	defer cancel()
+
+	stmt := `DELETE FROM login_failures WHERE last_failure_at < $1 AND (locked_until IS NULL OR locked_until < $1)`
+
+	result, err := m.db().ExecContext(ctx, stmt, before)
+	if err != nil {
+		return 0, err
+	}
+
+	return result.RowsAffected()
+}
+
+// SetUserTOTP stores the TOTP secret a user is enrolling with, replacing any previous enrollment that isn't
+// confirmed yet. It reports false, storing nothing, when the user already confirmed an enrollment.
+func (m *PostgresDBRepo) SetUserTOTP(ctx context.Context, totp models.UserTOTP) (bool, error) {
+	ctx, cancel := m.withTimeout(ctx)
+	defer cancel()
+
+	stmt := `
+	INSERT INTO user_totp (user_id, secret, last_step, confirmed_at, created_at) VALUES ($1, $2, 0, NULL, $3)
+	ON CONFLICT (user_id) DO UPDATE SET secret = $2, last_step = 0, confirmed_at = NULL, created_at = $3
+	WHERE user_totp.confirmed_at IS NULL`
+
+	result, err := m.db().ExecContext(ctx, stmt, totp.UserID, totp.Secret, totp.CreatedAt)
+	if err != nil {
+		return false, err
+	}
+
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+
+	return affected == 1, nil
+}
+
+// GetUserTOTP returns the TOTP secret of a user, sql.ErrNoRows when the user has none.
+func (m *PostgresDBRepo) GetUserTOTP(ctx context.Context, userID int) (models.UserTOTP, error) {
+	ctx, cancel := m.withTimeout(ctx)
+	defer cancel()
+
+	query := `SELECT user_id, secret, last_step, confirmed_at, created_at FROM user_totp WHERE user_id = $1`
+
+	var totp models.UserTOTP
+
+	row := m.db().QueryRowContext(ctx, query, userID)
+
+	err := row.Scan(
+		&totp.UserID,
+		&totp.Secret,
+		&totp.LastStep,
+		&totp.ConfirmedAt,
+		&totp.CreatedAt,
+	)
+	if err != nil {
+		return models.UserTOTP{}, err
+	}
+
+	return totp, nil
+}
+
+// ConfirmUserTOTP confirms the TOTP secret of a user with the code of the given step, enabling two-factor
+// authentication. It reports false if there is no secret to confirm.
+func (m *PostgresDBRepo) ConfirmUserTOTP(ctx context.Context, userID int, step int64) (bool, error) {
+	ctx, cancel := m.withTimeout(ctx)
+	defer cancel()
+
+	stmt := `UPDATE user_totp SET confirmed_at = $1, last_step = $2 WHERE user_id = $3 AND confirmed_at IS NULL`
+
+	result, err := m.db().ExecContext(ctx, stmt, time.Now().UTC(), step, userID)
+	if err != nil {
+		return false, err
+	}
+
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+
+	return affected == 1, nil
+}
+
+// UseTOTPStep records that the code of a step was used by a user. It reports false if a code of that step or
+// a later one was already used, which means the code is replayed.
+func (m *PostgresDBRepo) UseTOTPStep(ctx context.Context, userID int, step int64) (bool, error) {
+	ctx, cancel := m.withTimeout(ctx)
+	defer cancel()
+
+	stmt := `UPDATE user_totp SET last_step = $1 WHERE user_id = $2 AND last_step < $1 AND confirmed_at IS NOT NULL`
+
+	result, err := m.db().ExecContext(ctx, stmt, step, userID)
+	if err != nil {
+		return false, err
+	}
+
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+
+	return affected == 1, nil
+}
+
+// DeleteUserTOTP deletes the TOTP secret and the recovery codes of a user, disabling two-factor authentication.
+func (m *PostgresDBRepo) DeleteUserTOTP(ctx context.Context, userID int) error {
+	ctx, cancel := m.withTimeout(ctx)
+	defer cancel()
+
+	stmt := `
+	WITH codes AS (DELETE FROM recovery_codes WHERE user_id = $1)
+	DELETE FROM user_totp WHERE user_id = $1`
+
+	_, err := m.db().ExecContext(ctx, stmt, userID)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// ReplaceRecoveryCodes replaces the recovery codes of a user by the ones with the given hashes.
+func (m *PostgresDBRepo) ReplaceRecoveryCodes(ctx context.Context, userID int, hashes []string) error {
+	ctx, cancel := m.withTimeout(ctx)
+	defer cancel()
+
+	stmt := `
+	WITH deleted AS (DELETE FROM recovery_codes WHERE user_id = $1)
+	INSERT INTO recovery_codes (user_id, code_hash, created_at) SELECT $1, hash, $3 FROM unnest($2::text[]) AS hash`
+
+	_, err := m.db().ExecContext(ctx, stmt, userID, hashes, time.Now().UTC())
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// UseRecoveryCode marks the recovery code of a user with the given hash as used. It reports false if the user
+// has no such unused code.
+func (m *PostgresDBRepo) UseRecoveryCode(ctx context.Context, userID int, hash string) (bool, error) {
+	ctx, cancel := m.withTimeout(ctx)
+	defer cancel()
+
+	stmt := `UPDATE recovery_codes SET used_at = $1 WHERE user_id = $2 AND code_hash = $3 AND used_at IS NULL`
+
+	result, err := m.db().ExecContext(ctx, stmt, time.Now().UTC(), userID, hash)
+	if err != nil {
+		return false, err
+	}
+
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+
+	return affected == 1, nil
+}
+
+// CountRecoveryCodes returns how many unused recovery codes a user has left.
+func (m *PostgresDBRepo) CountRecoveryCodes(ctx context.Context, userID int) (int, error) {
+	ctx, cancel := m.withTimeout(ctx)
+	defer cancel()
+
+	query := `SELECT count(*) FROM recovery_codes WHERE user_id = $1 AND used_at IS NULL`
+
+	var count int
+
+	err := m.db().QueryRowContext(ctx, query, userID).Scan(&count)
+	if err != nil {
+		return 0, err
+	}
+
+	return count, nil
+}
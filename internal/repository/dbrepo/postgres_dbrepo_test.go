@@ -0,0 +1,54 @@
+package dbrepo
+
+import (
+	"context"
+	"database/sql"
+	"github.com/calvarado2004/go-movies-backend/internal/migrations"
+	"github.com/calvarado2004/go-movies-backend/internal/repository"
+	"github.com/calvarado2004/go-movies-backend/internal/repository/repotest"
+	_ "github.com/jackc/pgx/v4/stdlib"
+	"os"
+	"testing"
+	"time"
+)
+
+// TestPostgresDBRepo runs the conformance suite against the database in TEST_DATABASE_DSN. The database is
+// migrated to the latest version first; it is skipped when the variable is not set.
+func TestPostgresDBRepo(t *testing.T) {
+
+	dsn := os.Getenv("TEST_DATABASE_DSN")
+	if dsn == "" {
+		t.Skip("TEST_DATABASE_DSN is not set")
+	}
+
+	db, err := sql.Open("pgx", dsn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+
+	migrator, err := migrations.New(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = migrator.Up(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the suite needs a couple of genres to work with
+	_, err = db.ExecContext(ctx, `INSERT INTO genres (genre, created_at, updated_at)
+		SELECT name, now(), now() FROM (VALUES ('Conformance A'), ('Conformance B')) AS g(name)
+		WHERE (SELECT count(*) FROM genres) < 2`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	repotest.Run(t, func(t *testing.T) repository.DatabaseRepo {
+		return &PostgresDBRepo{DB: db}
+	})
+}
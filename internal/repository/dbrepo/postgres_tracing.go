@@ -0,0 +1,79 @@
+package dbrepo
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	semconv "go.opentelemetry.io/otel/semconv/v1.21.0"
+	"go.opentelemetry.io/otel/trace"
+	"strings"
+)
+
+// instrumentationName names the tracer of the statements run by PostgresDBRepo.
+const instrumentationName = "github.com/calvarado2004/go-movies-backend/internal/repository/dbrepo"
+
+// tracedQuerier is a querier starting a span for every statement it runs, carrying the SQL of the statement.
+// Spans end when the statement returns, the time spent reading rows is not included.
+type tracedQuerier struct {
+	q querier
+}
+
+// ExecContext runs a statement returning no rows.
+func (t tracedQuerier) ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error) {
+	ctx, span := startStatement(ctx, query)
+	defer span.End()
+
+	result, err := t.q.ExecContext(ctx, query, args...)
+	recordError(span, err)
+
+	return result, err
+}
+
+// QueryContext runs a query returning rows.
+func (t tracedQuerier) QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error) {
+	ctx, span := startStatement(ctx, query)
+	defer span.End()
+
+	rows, err := t.q.QueryContext(ctx, query, args...)
+	recordError(span, err)
+
+	return rows, err
+}
+
+// QueryRowContext runs a query returning at most one row. Its errors are only known once the row is
+// scanned, so they aren't recorded on the span.
+func (t tracedQuerier) QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row {
+	ctx, span := startStatement(ctx, query)
+	defer span.End()
+
+	return t.q.QueryRowContext(ctx, query, args...)
+}
+
+// startStatement starts the span of a statement, named after its operation, such as SELECT.
+func startStatement(ctx context.Context, query string) (context.Context, trace.Span) {
+
+	query = strings.TrimSpace(query)
+
+	operation := ""
+	if fields := strings.Fields(query); len(fields) > 0 {
+		operation = strings.ToUpper(fields[0])
+	}
+
+	return otel.Tracer(instrumentationName).Start(ctx, "postgres "+operation,
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(
+			semconv.DBSystemPostgreSQL,
+			semconv.DBOperation(operation),
+			semconv.DBStatement(query),
+		))
+}
+
+// recordError marks the span of a statement as failed.
+func recordError(span trace.Span, err error) {
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+}
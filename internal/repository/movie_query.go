@@ -0,0 +1,99 @@
+package repository
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Sort fields accepted by MovieQuery.
+const (
+	SortTitle       = "title"
+	SortReleaseDate = "release_date"
+	SortRuntime     = "runtime"
+	SortCreatedAt   = "created_at"
+)
+
+// Page size limits applied by MovieQuery.Normalize.
+const (
+	DefaultMovieLimit = 20
+	MaxMovieLimit     = 100
+)
+
+// MovieQuery holds the pagination, sorting and filtering options used to list movies.
+// Zero values mean "no filter".
+type MovieQuery struct {
+	Limit           int
+	Offset          int
+	Sort            string
+	Desc            bool
+	MPAARatings     []string
+	ReleaseYearFrom int
+	ReleaseYearTo   int
+	RuntimeMin      int
+	RuntimeMax      int
+	Genres          []int
+	TitleContains   string
+}
+
+// Normalize applies the default page size and sort order, and validates the query.
+func (q *MovieQuery) Normalize() error {
+
+	if q.Limit <= 0 {
+		q.Limit = DefaultMovieLimit
+	}
+
+	if q.Limit > MaxMovieLimit {
+		q.Limit = MaxMovieLimit
+	}
+
+	if q.Offset < 0 {
+		return fmt.Errorf("offset must not be negative")
+	}
+
+	switch q.Sort {
+	case "":
+		// keep the historical ordering of the catalog
+		q.Sort = SortTitle
+		q.Desc = true
+	case SortTitle, SortReleaseDate, SortRuntime, SortCreatedAt:
+	default:
+		return fmt.Errorf("invalid sort field %q", q.Sort)
+	}
+
+	if q.ReleaseYearFrom > 0 && q.ReleaseYearTo > 0 && q.ReleaseYearFrom > q.ReleaseYearTo {
+		return fmt.Errorf("year_from must not be greater than year_to")
+	}
+
+	if q.RuntimeMin > 0 && q.RuntimeMax > 0 && q.RuntimeMin > q.RuntimeMax {
+		return fmt.Errorf("runtime_min must not be greater than runtime_max")
+	}
+
+	return nil
+}
+
+// MovieSearch holds the options of a full-text movie search.
+type MovieSearch struct {
+	Query       string
+	Genres      []int
+	MPAARatings []string
+	Limit       int
+}
+
+// Normalize applies the default number of results and validates the search.
+func (s *MovieSearch) Normalize() error {
+
+	s.Query = strings.TrimSpace(s.Query)
+	if s.Query == "" {
+		return fmt.Errorf("search query must not be empty")
+	}
+
+	if s.Limit <= 0 {
+		s.Limit = DefaultMovieLimit
+	}
+
+	if s.Limit > MaxMovieLimit {
+		s.Limit = MaxMovieLimit
+	}
+
+	return nil
+}
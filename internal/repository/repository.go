@@ -1,21 +1,58 @@
 package repository
 
 import (
+	"context"
 	"database/sql"
 	"github.com/calvarado2004/go-movies-backend/internal/models"
+	"time"
 )
 
 // DatabaseRepo is a wrapper around the database connection pool.
 type DatabaseRepo interface {
-	AllMovies(genre ...int) ([]*models.Movie, error)
-	OneMovie(id int) (*models.Movie, error)
-	OneMovieForEdit(id int) (*models.Movie, []*models.Genre, error)
+	AllMovies(ctx context.Context, genre ...int) ([]*models.Movie, error)
+	ListMovies(ctx context.Context, query MovieQuery) ([]*models.Movie, int, error)
+	SearchMovies(ctx context.Context, search MovieSearch) ([]*models.MovieSearchResult, error)
+	OneMovie(ctx context.Context, id int) (*models.Movie, error)
+	OneMovieForEdit(ctx context.Context, id int) (*models.Movie, []*models.Genre, error)
 	Connection() *sql.DB
-	GetUserByEmail(email string) (models.User, error)
-	GetUserByID(id int) (models.User, error)
-	AllGenresDB() ([]*models.Genre, error)
-	InsertMovie(movie models.Movie) (int, error)
-	UpdateMovieGenres(id int, genreIDs []int) error
-	UpdateMovie(movie models.Movie) error
-	DeleteMovie(id int) error
+	WithTx(ctx context.Context, fn func(repo DatabaseRepo) error) error
+	GetUserByEmail(ctx context.Context, email string) (models.User, error)
+	GetUserByID(ctx context.Context, id int) (models.User, error)
+	InsertUser(ctx context.Context, user models.User) (int, error)
+	UpdateUserPassword(ctx context.Context, id int, passwordHash string) error
+	SetUserEmailVerified(ctx context.Context, id int) error
+	InsertUserToken(ctx context.Context, token models.UserToken) error
+	ConsumeUserToken(ctx context.Context, hash, scope string) (models.UserToken, error)
+	AllGenresDB(ctx context.Context) ([]*models.Genre, error)
+	GenresForMovies(ctx context.Context, movieIDs []int) (map[int][]*models.Genre, error)
+	InsertGenre(ctx context.Context, genre models.Genre) (int, error)
+	UpdateGenre(ctx context.Context, genre models.Genre) error
+	DeleteGenre(ctx context.Context, id int) error
+	InsertMovie(ctx context.Context, movie models.Movie) (int, error)
+	UpdateMovieGenres(ctx context.Context, id int, genreIDs []int) error
+	UpdateMovie(ctx context.Context, movie models.Movie) error
+	FillMovieImage(ctx context.Context, id int, image string) (bool, error)
+	ReplaceMoviePoster(ctx context.Context, poster models.MoviePoster, image string) (*models.MoviePoster, error)
+	GetMoviePoster(ctx context.Context, movieID int) (models.MoviePoster, error)
+	DeleteMovie(ctx context.Context, id int) error
+	InsertRefreshToken(ctx context.Context, token models.RefreshToken) error
+	GetRefreshTokenByHash(ctx context.Context, hash string) (models.RefreshToken, error)
+	RevokeRefreshToken(ctx context.Context, id int) (bool, error)
+	RevokeRefreshTokenFamily(ctx context.Context, familyID string) error
+	RevokeUserRefreshTokens(ctx context.Context, userID int) error
+	DeleteExpiredRefreshTokens(ctx context.Context) (int64, error)
+	RecordLoginFailure(ctx context.Context, kind, subject string, at time.Time, window time.Duration) (models.LoginFailures, error)
+	LockLogins(ctx context.Context, kind, subject string, until time.Time) error
+	GetLoginFailures(ctx context.Context, kind, subject string) (models.LoginFailures, error)
+	ListLoginLockouts(ctx context.Context, now time.Time) ([]*models.LoginFailures, error)
+	ClearLoginFailures(ctx context.Context, kind, subject string) (bool, error)
+	DeleteStaleLoginFailures(ctx context.Context, before time.Time) (int64, error)
+	SetUserTOTP(ctx context.Context, totp models.UserTOTP) (bool, error)
+	GetUserTOTP(ctx context.Context, userID int) (models.UserTOTP, error)
+	ConfirmUserTOTP(ctx context.Context, userID int, step int64) (bool, error)
+	UseTOTPStep(ctx context.Context, userID int, step int64) (bool, error)
+	DeleteUserTOTP(ctx context.Context, userID int) error
+	ReplaceRecoveryCodes(ctx context.Context, userID int, hashes []string) error
+	UseRecoveryCode(ctx context.Context, userID int, hash string) (bool, error)
+	CountRecoveryCodes(ctx context.Context, userID int) (int, error)
 }
@@ -0,0 +1,1256 @@
+// Package repotest holds the conformance test suite shared by every implementation of repository.DatabaseRepo.
+package repotest
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"github.com/calvarado2004/go-movies-backend/internal/models"
+	"github.com/calvarado2004/go-movies-backend/internal/repository"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// Run runs the conformance suite. newRepo must return a repository holding at least two genres; it is called
+// once per subtest. The suite does not assume the repository is otherwise empty, so it can run against a
+// shared database.
+func Run(t *testing.T, newRepo func(t *testing.T) repository.DatabaseRepo) {
+
+	tests := []struct {
+		name string
+		fn   func(t *testing.T, repo repository.DatabaseRepo)
+	}{
+		{"Genres", testGenres},
+		{"GenreLifecycle", testGenreLifecycle},
+		{"MovieLifecycle", testMovieLifecycle},
+		{"Transactions", testTransactions},
+		{"FillMovieImage", testFillMovieImage},
+		{"MoviePosters", testMoviePosters},
+		{"AllMoviesByGenre", testAllMoviesByGenre},
+		{"ListMovies", testListMovies},
+		{"GenresForMovies", testGenresForMovies},
+		{"SearchMovies", testSearchMovies},
+		{"Users", testUsers},
+		{"UserTokens", testUserTokens},
+		{"RefreshTokens", testRefreshTokens},
+		{"LoginFailures", testLoginFailures},
+		{"UserTOTP", testUserTOTP},
+	}
+
+	for _, test := range tests {
+		test := test
+		t.Run(test.name, func(t *testing.T) {
+			test.fn(t, newRepo(t))
+		})
+	}
+}
+
+// counter makes the values returned by unique distinct within a run.
+var counter atomic.Int64
+
+// unique returns a string that is unique across test runs, to keep fixtures of different runs apart.
+func unique(prefix string) string {
+	return fmt.Sprintf("%s-%d-%d", prefix, time.Now().UnixNano(), counter.Add(1))
+}
+
+// now returns the current time rounded to what every implementation can store.
+func now() time.Time {
+	return time.Now().UTC().Truncate(time.Second)
+}
+
+// date returns midnight UTC of the given day.
+func date(year int, month time.Month, day int) time.Time {
+	return time.Date(year, month, day, 0, 0, 0, 0, time.UTC)
+}
+
+// twoGenres returns two distinct genres of the repository.
+func twoGenres(t *testing.T, repo repository.DatabaseRepo) (*models.Genre, *models.Genre) {
+	t.Helper()
+
+	ctx := context.Background()
+
+	genres, err := repo.AllGenresDB(ctx)
+	if err != nil {
+		t.Fatalf("AllGenresDB: %v", err)
+	}
+
+	if len(genres) < 2 {
+		t.Fatalf("the suite needs at least two genres, got %d", len(genres))
+	}
+
+	return genres[0], genres[1]
+}
+
+// insertMovie inserts a movie with the given genres and returns its id.
+func insertMovie(t *testing.T, repo repository.DatabaseRepo, movie models.Movie, genreIDs ...int) int {
+	t.Helper()
+
+	ctx := context.Background()
+
+	if movie.Title == "" {
+		movie.Title = unique("Movie")
+	}
+	if movie.ReleaseDate.IsZero() {
+		movie.ReleaseDate = date(2000, 1, 1)
+	}
+	if movie.MPAARating == "" {
+		movie.MPAARating = "PG"
+	}
+	movie.CreatedAt = now()
+	movie.UpdatedAt = now()
+
+	id, err := repo.InsertMovie(ctx, movie)
+	if err != nil {
+		t.Fatalf("InsertMovie: %v", err)
+	}
+
+	err = repo.UpdateMovieGenres(ctx, id, genreIDs)
+	if err != nil {
+		t.Fatalf("UpdateMovieGenres: %v", err)
+	}
+
+	return id
+}
+
+// insertUser inserts a verified viewer with a unique email address.
+func insertUser(t *testing.T, repo repository.DatabaseRepo) models.User {
+	t.Helper()
+
+	ctx := context.Background()
+
+	verified := now()
+
+	user := models.User{
+		FirstName:       "Test",
+		LastName:        "User",
+		Email:           unique("user") + "@example.com",
+		Password:        "hash",
+		Role:            models.RoleViewer,
+		EmailVerifiedAt: &verified,
+		CreatedAt:       now(),
+		UpdatedAt:       now(),
+	}
+
+	id, err := repo.InsertUser(ctx, user)
+	if err != nil {
+		t.Fatalf("InsertUser: %v", err)
+	}
+
+	user.ID = id
+
+	return user
+}
+
+// movieIDs returns the ids of the movies, in order.
+func movieIDs(movies []*models.Movie) []int {
+	var ids []int
+	for _, movie := range movies {
+		ids = append(ids, movie.ID)
+	}
+	return ids
+}
+
+// contains reports whether the movies include the one with the given id.
+func contains(movies []*models.Movie, id int) bool {
+	for _, movie := range movies {
+		if movie.ID == id {
+			return true
+		}
+	}
+	return false
+}
+
+func testGenres(t *testing.T, repo repository.DatabaseRepo) {
+
+	ctx := context.Background()
+
+	genres, err := repo.AllGenresDB(ctx)
+	if err != nil {
+		t.Fatalf("AllGenresDB: %v", err)
+	}
+
+	for i := 1; i < len(genres); i++ {
+		if genres[i-1].Genre > genres[i].Genre {
+			t.Errorf("genres are not ordered by name: %q before %q", genres[i-1].Genre, genres[i].Genre)
+		}
+	}
+}
+
+func testGenreLifecycle(t *testing.T, repo repository.DatabaseRepo) {
+
+	ctx := context.Background()
+
+	name := unique("Genre")
+
+	id, err := repo.InsertGenre(ctx, models.Genre{Genre: name, CreatedAt: now(), UpdatedAt: now()})
+	if err != nil {
+		t.Fatalf("InsertGenre: %v", err)
+	}
+
+	movie := insertMovie(t, repo, models.Movie{}, id)
+
+	defer func() {
+		_ = repo.DeleteMovie(ctx, movie)
+	}()
+
+	renamed := unique("Renamed")
+
+	err = repo.UpdateGenre(ctx, models.Genre{ID: id, Genre: renamed, UpdatedAt: now()})
+	if err != nil {
+		t.Fatalf("UpdateGenre: %v", err)
+	}
+
+	stored, err := repo.OneMovie(ctx, movie)
+	if err != nil {
+		t.Fatalf("OneMovie: %v", err)
+	}
+
+	if len(stored.Genres) != 1 || stored.Genres[0].Genre != renamed {
+		t.Errorf("movie genres = %+v, want only %q", stored.Genres, renamed)
+	}
+
+	// deleting the genre removes it from its movies
+	err = repo.DeleteGenre(ctx, id)
+	if err != nil {
+		t.Fatalf("DeleteGenre: %v", err)
+	}
+
+	genres, err := repo.AllGenresDB(ctx)
+	if err != nil {
+		t.Fatalf("AllGenresDB: %v", err)
+	}
+
+	for _, genre := range genres {
+		if genre.ID == id {
+			t.Errorf("deleted genre is still listed")
+		}
+	}
+
+	stored, err = repo.OneMovie(ctx, movie)
+	if err != nil {
+		t.Fatalf("OneMovie: %v", err)
+	}
+
+	if len(stored.Genres) != 0 {
+		t.Errorf("movie still has the deleted genre: %+v", stored.Genres)
+	}
+}
+
+func testMovieLifecycle(t *testing.T, repo repository.DatabaseRepo) {
+
+	ctx := context.Background()
+
+	first, second := twoGenres(t, repo)
+
+	id := insertMovie(t, repo, models.Movie{
+		ReleaseDate: date(1999, 3, 31),
+		Runtime:     136,
+		MPAARating:  "R",
+		Description: "A hacker learns the truth about reality.",
+		Image:       "/poster.jpg",
+	}, first.ID)
+
+	movie, err := repo.OneMovie(ctx, id)
+	if err != nil {
+		t.Fatalf("OneMovie: %v", err)
+	}
+
+	if movie.ID != id || movie.Runtime != 136 || movie.MPAARating != "R" || movie.Image != "/poster.jpg" ||
+		movie.Description != "A hacker learns the truth about reality." || !movie.ReleaseDate.Equal(date(1999, 3, 31)) {
+		t.Errorf("OneMovie returned %+v", movie)
+	}
+
+	if len(movie.Genres) != 1 || movie.Genres[0].ID != first.ID || movie.Genres[0].Genre != first.Genre {
+		t.Errorf("OneMovie genres = %+v, want only %q", movie.Genres, first.Genre)
+	}
+
+	// update the movie and replace its genres
+	movie.Title = unique("Updated")
+	movie.Runtime = 140
+	movie.UpdatedAt = now()
+
+	err = repo.UpdateMovie(ctx, *movie)
+	if err != nil {
+		t.Fatalf("UpdateMovie: %v", err)
+	}
+
+	err = repo.UpdateMovieGenres(ctx, id, []int{second.ID})
+	if err != nil {
+		t.Fatalf("UpdateMovieGenres: %v", err)
+	}
+
+	edited, allGenres, err := repo.OneMovieForEdit(ctx, id)
+	if err != nil {
+		t.Fatalf("OneMovieForEdit: %v", err)
+	}
+
+	if edited.Title != movie.Title || edited.Runtime != 140 {
+		t.Errorf("update was not stored, got %+v", edited)
+	}
+
+	if len(edited.GenresArray) != 1 || edited.GenresArray[0] != second.ID {
+		t.Errorf("GenresArray = %v, want [%d]", edited.GenresArray, second.ID)
+	}
+
+	if len(allGenres) < 2 {
+		t.Errorf("OneMovieForEdit returned %d genres, want every genre", len(allGenres))
+	}
+
+	// unknown genres are rejected, and the genres of the movie are left alone
+	err = repo.UpdateMovieGenres(ctx, id, []int{first.ID, -1})
+	if err == nil {
+		t.Errorf("UpdateMovieGenres accepted an unknown genre")
+	}
+
+	edited, _, err = repo.OneMovieForEdit(ctx, id)
+	if err != nil {
+		t.Fatalf("OneMovieForEdit: %v", err)
+	}
+
+	if len(edited.GenresArray) != 1 || edited.GenresArray[0] != second.ID {
+		t.Errorf("GenresArray after a failed update = %v, want [%d]", edited.GenresArray, second.ID)
+	}
+
+	// deleting the movie removes it and its genre associations
+	err = repo.DeleteMovie(ctx, id)
+	if err != nil {
+		t.Fatalf("DeleteMovie: %v", err)
+	}
+
+	_, err = repo.OneMovie(ctx, id)
+	if !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("OneMovie after delete returned %v, want sql.ErrNoRows", err)
+	}
+
+	_, _, err = repo.OneMovieForEdit(ctx, id)
+	if !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("OneMovieForEdit after delete returned %v, want sql.ErrNoRows", err)
+	}
+
+	movies, err := repo.AllMovies(ctx, second.ID)
+	if err != nil {
+		t.Fatalf("AllMovies: %v", err)
+	}
+
+	if contains(movies, id) {
+		t.Errorf("deleted movie is still listed in its genre")
+	}
+}
+
+func testTransactions(t *testing.T, repo repository.DatabaseRepo) {
+
+	ctx := context.Background()
+
+	first, second := twoGenres(t, repo)
+
+	insert := func(repo repository.DatabaseRepo, title string, genreIDs []int) (int, error) {
+		id, err := repo.InsertMovie(ctx, models.Movie{
+			Title:       title,
+			ReleaseDate: date(2010, 7, 16),
+			MPAARating:  "PG-13",
+			CreatedAt:   now(),
+			UpdatedAt:   now(),
+		})
+		if err != nil {
+			return 0, err
+		}
+
+		return id, repo.UpdateMovieGenres(ctx, id, genreIDs)
+	}
+
+	// a movie inserted with its genres is committed
+	var id int
+
+	err := repo.WithTx(ctx, func(tx repository.DatabaseRepo) error {
+		var err error
+		id, err = insert(tx, unique("Inception"), []int{first.ID, second.ID})
+		return err
+	})
+	if err != nil {
+		t.Fatalf("WithTx: %v", err)
+	}
+
+	movie, err := repo.OneMovie(ctx, id)
+	if err != nil {
+		t.Fatalf("OneMovie after commit: %v", err)
+	}
+
+	if len(movie.Genres) != 2 {
+		t.Errorf("committed movie has genres %+v, want 2", movie.Genres)
+	}
+
+	// a failure half way rolls back what was already written
+	var failedID int
+	title := unique("Rolled back")
+
+	err = repo.WithTx(ctx, func(tx repository.DatabaseRepo) error {
+		var err error
+		failedID, err = insert(tx, title, []int{first.ID, -1})
+		return err
+	})
+	if err == nil {
+		t.Fatalf("WithTx accepted an unknown genre")
+	}
+
+	_, err = repo.OneMovie(ctx, failedID)
+	if !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("OneMovie of a rolled back movie returned %v, want sql.ErrNoRows", err)
+	}
+
+	movies, _, err := repo.ListMovies(ctx, repository.MovieQuery{TitleContains: title})
+	if err != nil {
+		t.Fatalf("ListMovies: %v", err)
+	}
+
+	if len(movies) != 0 {
+		t.Errorf("rolled back movie is listed: %+v", movies[0])
+	}
+
+	// an error returned by the function rolls back too, and is returned as is
+	errAbort := errors.New("abort")
+
+	err = repo.WithTx(ctx, func(tx repository.DatabaseRepo) error {
+		err := tx.UpdateMovieGenres(ctx, id, nil)
+		if err != nil {
+			return err
+		}
+
+		return errAbort
+	})
+	if !errors.Is(err, errAbort) {
+		t.Errorf("WithTx returned %v, want %v", err, errAbort)
+	}
+
+	movie, err = repo.OneMovie(ctx, id)
+	if err != nil {
+		t.Fatalf("OneMovie after rollback: %v", err)
+	}
+
+	if len(movie.Genres) != 2 {
+		t.Errorf("genres after rollback = %+v, want 2", movie.Genres)
+	}
+
+	err = repo.DeleteMovie(ctx, id)
+	if err != nil {
+		t.Fatalf("DeleteMovie: %v", err)
+	}
+}
+
+func testFillMovieImage(t *testing.T, repo repository.DatabaseRepo) {
+
+	ctx := context.Background()
+
+	id := insertMovie(t, repo, models.Movie{})
+
+	filled, err := repo.FillMovieImage(ctx, id, "/found.jpg")
+	if err != nil || !filled {
+		t.Fatalf("FillMovieImage = %v, %v, want true", filled, err)
+	}
+
+	// an existing image is never replaced
+	filled, err = repo.FillMovieImage(ctx, id, "/other.jpg")
+	if err != nil || filled {
+		t.Errorf("FillMovieImage of a movie with an image = %v, %v, want false", filled, err)
+	}
+
+	movie, err := repo.OneMovie(ctx, id)
+	if err != nil {
+		t.Fatalf("OneMovie: %v", err)
+	}
+
+	if movie.Image != "/found.jpg" {
+		t.Errorf("image = %q, want /found.jpg", movie.Image)
+	}
+
+	filled, err = repo.FillMovieImage(ctx, -1, "/found.jpg")
+	if err != nil || filled {
+		t.Errorf("FillMovieImage of a missing movie = %v, %v, want false", filled, err)
+	}
+}
+
+func testMoviePosters(t *testing.T, repo repository.DatabaseRepo) {
+
+	ctx := context.Background()
+
+	id := insertMovie(t, repo, models.Movie{})
+
+	_, err := repo.GetMoviePoster(ctx, id)
+	if !errors.Is(err, sql.ErrNoRows) {
+		t.Fatalf("GetMoviePoster of a movie without a poster returned %v, want sql.ErrNoRows", err)
+	}
+
+	poster := models.MoviePoster{
+		MovieID:       id,
+		Version:       "v1",
+		Source:        "https://image.example.com/poster.jpg",
+		DominantColor: "#336699",
+		Placeholder:   "data:image/jpeg;base64,AAAA",
+		Width:         780,
+		Height:        1170,
+		CreatedAt:     now(),
+		UpdatedAt:     now(),
+	}
+
+	replaced, err := repo.ReplaceMoviePoster(ctx, poster, "")
+	if err != nil {
+		t.Fatalf("ReplaceMoviePoster: %v", err)
+	}
+
+	if replaced != nil {
+		t.Errorf("the first poster replaced %+v", replaced)
+	}
+
+	// a new poster replaces the previous one, and can point the image of the movie to it
+	poster.Version = "v2"
+	poster.DominantColor = "#000000"
+	poster.UpdatedAt = now().Add(time.Minute)
+
+	replaced, err = repo.ReplaceMoviePoster(ctx, poster, "/images/poster.jpg")
+	if err != nil {
+		t.Fatalf("ReplaceMoviePoster: %v", err)
+	}
+
+	if replaced == nil || replaced.Version != "v1" || replaced.DominantColor != "#336699" {
+		t.Errorf("ReplaceMoviePoster replaced %+v, want the first poster", replaced)
+	}
+
+	stored, err := repo.GetMoviePoster(ctx, id)
+	if err != nil {
+		t.Fatalf("GetMoviePoster: %v", err)
+	}
+
+	if stored.Version != "v2" || stored.DominantColor != "#000000" || stored.Source != poster.Source || stored.Width != 780 ||
+		stored.Height != 1170 || !stored.UpdatedAt.Equal(poster.UpdatedAt) {
+		t.Errorf("GetMoviePoster = %+v, want %+v", stored, poster)
+	}
+
+	movie, err := repo.OneMovie(ctx, id)
+	if err != nil {
+		t.Fatalf("OneMovie: %v", err)
+	}
+
+	if movie.Poster == nil || movie.Poster.DominantColor != "#000000" {
+		t.Errorf("OneMovie poster = %+v, want the stored poster", movie.Poster)
+	}
+
+	if movie.Image != "/images/poster.jpg" {
+		t.Errorf("image = %q, want /images/poster.jpg", movie.Image)
+	}
+
+	_, err = repo.ReplaceMoviePoster(ctx, models.MoviePoster{MovieID: -1, CreatedAt: now(), UpdatedAt: now()}, "")
+	if err == nil {
+		t.Errorf("ReplaceMoviePoster accepted a missing movie")
+	}
+
+	// deleting the movie deletes its poster
+	err = repo.DeleteMovie(ctx, id)
+	if err != nil {
+		t.Fatalf("DeleteMovie: %v", err)
+	}
+
+	_, err = repo.GetMoviePoster(ctx, id)
+	if !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("GetMoviePoster after delete returned %v, want sql.ErrNoRows", err)
+	}
+}
+
+func testAllMoviesByGenre(t *testing.T, repo repository.DatabaseRepo) {
+
+	ctx := context.Background()
+
+	first, second := twoGenres(t, repo)
+
+	a := insertMovie(t, repo, models.Movie{Title: unique("A")}, first.ID)
+	b := insertMovie(t, repo, models.Movie{Title: unique("B")}, first.ID, second.ID)
+
+	movies, err := repo.AllMovies(ctx, second.ID)
+	if err != nil {
+		t.Fatalf("AllMovies: %v", err)
+	}
+
+	if contains(movies, a) || !contains(movies, b) {
+		t.Errorf("AllMovies(%d) = %v, want %d and not %d", second.ID, movieIDs(movies), b, a)
+	}
+
+	movies, err = repo.AllMovies(ctx)
+	if err != nil {
+		t.Fatalf("AllMovies: %v", err)
+	}
+
+	if !contains(movies, a) || !contains(movies, b) {
+		t.Errorf("AllMovies() = %v, want both %d and %d", movieIDs(movies), a, b)
+	}
+
+	// titles are ordered descending; only the fixtures are compared, collations may differ on other titles
+	var order []int
+	for _, movie := range movies {
+		if movie.ID == a || movie.ID == b {
+			order = append(order, movie.ID)
+		}
+	}
+
+	if fmt.Sprint(order) != fmt.Sprint([]int{b, a}) {
+		t.Errorf("AllMovies() listed the fixtures as %v, want %v", order, []int{b, a})
+	}
+}
+
+func testListMovies(t *testing.T, repo repository.DatabaseRepo) {
+
+	ctx := context.Background()
+
+	first, second := twoGenres(t, repo)
+
+	// the runtimes are unusual enough to isolate the fixtures from any other movie
+	short := insertMovie(t, repo, models.Movie{Runtime: 9001, MPAARating: "G", ReleaseDate: date(1990, 5, 1)}, first.ID)
+	medium := insertMovie(t, repo, models.Movie{Title: unique("Haystack Needle"), Runtime: 9002, MPAARating: "R", ReleaseDate: date(2005, 5, 1)}, second.ID)
+	long := insertMovie(t, repo, models.Movie{Runtime: 9003, MPAARating: "R", ReleaseDate: date(2020, 5, 1)}, first.ID, second.ID)
+
+	tests := []struct {
+		name      string
+		query     repository.MovieQuery
+		wantIDs   []int
+		wantTotal int
+	}{
+		{"first page", repository.MovieQuery{RuntimeMin: 9001, RuntimeMax: 9003, Sort: repository.SortRuntime, Limit: 2}, []int{short, medium}, 3},
+		{"second page", repository.MovieQuery{RuntimeMin: 9001, RuntimeMax: 9003, Sort: repository.SortRuntime, Limit: 2, Offset: 2}, []int{long}, 3},
+		{"past the end", repository.MovieQuery{RuntimeMin: 9001, RuntimeMax: 9003, Sort: repository.SortRuntime, Offset: 10}, nil, 3},
+		{"descending", repository.MovieQuery{RuntimeMin: 9001, RuntimeMax: 9003, Sort: repository.SortReleaseDate, Desc: true}, []int{long, medium, short}, 3},
+		{"rating", repository.MovieQuery{RuntimeMin: 9001, RuntimeMax: 9003, Sort: repository.SortRuntime, MPAARatings: []string{"R"}}, []int{medium, long}, 2},
+		{"years", repository.MovieQuery{RuntimeMin: 9001, RuntimeMax: 9003, Sort: repository.SortRuntime, ReleaseYearFrom: 2000, ReleaseYearTo: 2010}, []int{medium}, 1},
+		{"genre", repository.MovieQuery{RuntimeMin: 9001, RuntimeMax: 9003, Sort: repository.SortRuntime, Genres: []int{first.ID}}, []int{short, long}, 2},
+		{"any genre", repository.MovieQuery{RuntimeMin: 9001, RuntimeMax: 9003, Sort: repository.SortRuntime, Genres: []int{first.ID, second.ID}}, []int{short, medium, long}, 3},
+		{"runtime", repository.MovieQuery{RuntimeMin: 9002, RuntimeMax: 9002}, []int{medium}, 1},
+		{"title", repository.MovieQuery{RuntimeMin: 9001, RuntimeMax: 9003, TitleContains: "stack NEEDLE"}, []int{medium}, 1},
+		{"title wildcards", repository.MovieQuery{RuntimeMin: 9001, RuntimeMax: 9003, TitleContains: "%"}, nil, 0},
+	}
+
+	for _, test := range tests {
+		movies, total, err := repo.ListMovies(ctx, test.query)
+		if err != nil {
+			t.Errorf("%s: ListMovies: %v", test.name, err)
+			continue
+		}
+
+		if total != test.wantTotal {
+			t.Errorf("%s: total = %d, want %d", test.name, total, test.wantTotal)
+		}
+
+		if fmt.Sprint(movieIDs(movies)) != fmt.Sprint(test.wantIDs) {
+			t.Errorf("%s: movies = %v, want %v", test.name, movieIDs(movies), test.wantIDs)
+		}
+	}
+
+	_, _, err := repo.ListMovies(ctx, repository.MovieQuery{Sort: "id; DROP TABLE movies"})
+	if err == nil {
+		t.Errorf("ListMovies accepted an invalid sort field")
+	}
+}
+
+func testGenresForMovies(t *testing.T, repo repository.DatabaseRepo) {
+
+	ctx := context.Background()
+
+	first, second := twoGenres(t, repo)
+
+	both := insertMovie(t, repo, models.Movie{}, second.ID, first.ID)
+	one := insertMovie(t, repo, models.Movie{}, second.ID)
+	none := insertMovie(t, repo, models.Movie{})
+
+	genres, err := repo.GenresForMovies(ctx, []int{both, one, none})
+	if err != nil {
+		t.Fatalf("GenresForMovies: %v", err)
+	}
+
+	names := func(genres []*models.Genre) []string {
+		var names []string
+		for _, genre := range genres {
+			names = append(names, genre.Genre)
+		}
+		return names
+	}
+
+	// AllGenresDB orders by name, so first sorts before second
+	tests := []struct {
+		id   int
+		want []string
+	}{
+		{both, []string{first.Genre, second.Genre}},
+		{one, []string{second.Genre}},
+		{none, nil},
+	}
+
+	for _, test := range tests {
+		if fmt.Sprint(names(genres[test.id])) != fmt.Sprint(test.want) {
+			t.Errorf("genres of movie %d = %v, want %v", test.id, names(genres[test.id]), test.want)
+		}
+	}
+
+	if len(genres) != 2 {
+		t.Errorf("GenresForMovies returned %d movies, want 2", len(genres))
+	}
+}
+
+func testSearchMovies(t *testing.T, repo repository.DatabaseRepo) {
+
+	ctx := context.Background()
+
+	first, second := twoGenres(t, repo)
+
+	id := insertMovie(t, repo, models.Movie{
+		Title:       "Quasarblade Chronicles",
+		MPAARating:  "PG-13",
+		Description: "A smuggler crosses the nebula to deliver a stolen starship.",
+	}, first.ID)
+
+	defer func() {
+		_ = repo.DeleteMovie(ctx, id)
+	}()
+
+	find := func(search repository.MovieSearch) *models.MovieSearchResult {
+		t.Helper()
+
+		search.Limit = repository.MaxMovieLimit
+
+		results, err := repo.SearchMovies(ctx, search)
+		if err != nil {
+			t.Fatalf("SearchMovies(%q): %v", search.Query, err)
+		}
+
+		for _, result := range results {
+			if result.ID == id {
+				return result
+			}
+		}
+
+		return nil
+	}
+
+	result := find(repository.MovieSearch{Query: "nebula"})
+	if result == nil {
+		t.Fatalf("a word of the description was not found")
+	}
+
+	if !strings.Contains(result.Snippet, "<mark>") {
+		t.Errorf("snippet %q does not highlight the match", result.Snippet)
+	}
+
+	// the description is escaped, only the highlighting is markup
+	script := insertMovie(t, repo, models.Movie{
+		Title:       "Quasarblade Returns",
+		MPAARating:  "PG-13",
+		Description: `The <script>alert("nebula")</script> & 'nebula' strike back.`,
+	}, first.ID)
+
+	defer func() {
+		_ = repo.DeleteMovie(ctx, script)
+	}()
+
+	results, err := repo.SearchMovies(ctx, repository.MovieSearch{Query: "strike", Limit: repository.MaxMovieLimit})
+	if err != nil {
+		t.Fatalf("SearchMovies: %v", err)
+	}
+
+	var escaped *models.MovieSearchResult
+	for _, result := range results {
+		if result.ID == script {
+			escaped = result
+		}
+	}
+
+	if escaped == nil {
+		t.Fatalf("a description with markup was not found")
+	}
+
+	snippet := strings.NewReplacer("<mark>", "", "</mark>", "").Replace(escaped.Snippet)
+	if strings.ContainsAny(snippet, "<>\"'") || !strings.Contains(snippet, "&lt;script&gt;") || !strings.Contains(escaped.Snippet, "<mark>strike</mark>") {
+		t.Errorf("snippet %q is not escaped HTML highlighting the match", escaped.Snippet)
+	}
+
+	if find(repository.MovieSearch{Query: "quasarblade"}) == nil {
+		t.Errorf("a word of the title was not found")
+	}
+
+	if find(repository.MovieSearch{Query: "Quasarblad Chronicle"}) == nil {
+		t.Errorf("a misspelled title was not found")
+	}
+
+	if find(repository.MovieSearch{Query: "nebula", Genres: []int{second.ID}}) != nil {
+		t.Errorf("the genre filter was ignored")
+	}
+
+	if find(repository.MovieSearch{Query: "nebula", MPAARatings: []string{"G"}}) != nil {
+		t.Errorf("the rating filter was ignored")
+	}
+
+	if find(repository.MovieSearch{Query: "volcano"}) != nil {
+		t.Errorf("an unrelated query matched")
+	}
+
+	_, err = repo.SearchMovies(ctx, repository.MovieSearch{Query: "  "})
+	if err == nil {
+		t.Errorf("SearchMovies accepted an empty query")
+	}
+}
+
+func testUsers(t *testing.T, repo repository.DatabaseRepo) {
+
+	ctx := context.Background()
+
+	user := insertUser(t, repo)
+
+	byEmail, err := repo.GetUserByEmail(ctx, user.Email)
+	if err != nil {
+		t.Fatalf("GetUserByEmail: %v", err)
+	}
+
+	if byEmail.ID != user.ID || byEmail.Role != models.RoleViewer || !byEmail.IsEmailVerified() {
+		t.Errorf("GetUserByEmail returned %+v", byEmail)
+	}
+
+	_, err = repo.GetUserByEmail(ctx, fmt.Sprintf("  %s", user.Email))
+	if err == nil {
+		t.Errorf("GetUserByEmail matched an address with leading spaces")
+	}
+
+	upper := user
+	upper.Email = "UPPER-" + user.Email
+	upper.ID, err = repo.InsertUser(ctx, upper)
+	if err != nil {
+		t.Fatalf("InsertUser: %v", err)
+	}
+
+	found, err := repo.GetUserByEmail(ctx, "upper-"+user.Email)
+	if err != nil || found.ID != upper.ID {
+		t.Errorf("GetUserByEmail is not case-insensitive: %+v, %v", found, err)
+	}
+
+	_, err = repo.InsertUser(ctx, user)
+	if err == nil {
+		t.Errorf("InsertUser accepted a duplicate email address")
+	}
+
+	_, err = repo.GetUserByEmail(ctx, unique("missing")+"@example.com")
+	if !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("GetUserByEmail for a missing user returned %v, want sql.ErrNoRows", err)
+	}
+
+	err = repo.UpdateUserPassword(ctx, user.ID, "new-hash")
+	if err != nil {
+		t.Fatalf("UpdateUserPassword: %v", err)
+	}
+
+	unverified := models.User{
+		FirstName: "New",
+		LastName:  "User",
+		Email:     unique("new") + "@example.com",
+		Password:  "hash",
+		Role:      models.RoleViewer,
+		CreatedAt: now(),
+		UpdatedAt: now(),
+	}
+
+	unverified.ID, err = repo.InsertUser(ctx, unverified)
+	if err != nil {
+		t.Fatalf("InsertUser: %v", err)
+	}
+
+	err = repo.SetUserEmailVerified(ctx, unverified.ID)
+	if err != nil {
+		t.Fatalf("SetUserEmailVerified: %v", err)
+	}
+
+	byID, err := repo.GetUserByID(ctx, user.ID)
+	if err != nil {
+		t.Fatalf("GetUserByID: %v", err)
+	}
+
+	if byID.Password != "new-hash" {
+		t.Errorf("password was not updated, got %q", byID.Password)
+	}
+
+	verified, err := repo.GetUserByID(ctx, unverified.ID)
+	if err != nil {
+		t.Fatalf("GetUserByID: %v", err)
+	}
+
+	if !verified.IsEmailVerified() {
+		t.Errorf("email address was not marked as verified")
+	}
+
+	_, err = repo.GetUserByID(ctx, -1)
+	if !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("GetUserByID for a missing user returned %v, want sql.ErrNoRows", err)
+	}
+}
+
+func testUserTokens(t *testing.T, repo repository.DatabaseRepo) {
+
+	ctx := context.Background()
+
+	user := insertUser(t, repo)
+
+	valid := unique("valid")
+	expired := unique("expired")
+
+	for hash, expiresAt := range map[string]time.Time{valid: now().Add(time.Hour), expired: now().Add(-time.Hour)} {
+		err := repo.InsertUserToken(ctx, models.UserToken{
+			UserID:    user.ID,
+			TokenHash: hash,
+			Scope:     models.TokenScopePasswordReset,
+			ExpiresAt: expiresAt,
+			CreatedAt: now(),
+		})
+		if err != nil {
+			t.Fatalf("InsertUserToken: %v", err)
+		}
+	}
+
+	_, err := repo.ConsumeUserToken(ctx, valid, models.TokenScopeEmailVerification)
+	if !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("ConsumeUserToken with the wrong scope returned %v, want sql.ErrNoRows", err)
+	}
+
+	token, err := repo.ConsumeUserToken(ctx, valid, models.TokenScopePasswordReset)
+	if err != nil {
+		t.Fatalf("ConsumeUserToken: %v", err)
+	}
+
+	if token.UserID != user.ID || token.UsedAt == nil {
+		t.Errorf("ConsumeUserToken returned %+v", token)
+	}
+
+	_, err = repo.ConsumeUserToken(ctx, valid, models.TokenScopePasswordReset)
+	if !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("a token could be consumed twice, got %v", err)
+	}
+
+	_, err = repo.ConsumeUserToken(ctx, expired, models.TokenScopePasswordReset)
+	if !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("an expired token could be consumed, got %v", err)
+	}
+}
+
+func testRefreshTokens(t *testing.T, repo repository.DatabaseRepo) {
+
+	ctx := context.Background()
+
+	user := insertUser(t, repo)
+
+	family := unique("family")
+	first := unique("first")
+	second := unique("second")
+	other := unique("other")
+	expired := unique("expired")
+
+	tokens := []models.RefreshToken{
+		{UserID: user.ID, TokenHash: first, FamilyID: family, ExpiresAt: now().Add(time.Hour)},
+		{UserID: user.ID, TokenHash: second, FamilyID: family, ExpiresAt: now().Add(time.Hour)},
+		{UserID: user.ID, TokenHash: other, FamilyID: unique("family"), ExpiresAt: now().Add(time.Hour)},
+		{UserID: user.ID, TokenHash: expired, FamilyID: unique("family"), ExpiresAt: now().Add(-time.Hour)},
+	}
+
+	for _, token := range tokens {
+		token.CreatedAt = now()
+		err := repo.InsertRefreshToken(ctx, token)
+		if err != nil {
+			t.Fatalf("InsertRefreshToken: %v", err)
+		}
+	}
+
+	stored, err := repo.GetRefreshTokenByHash(ctx, first)
+	if err != nil {
+		t.Fatalf("GetRefreshTokenByHash: %v", err)
+	}
+
+	if stored.UserID != user.ID || stored.FamilyID != family || stored.IsRevoked() || stored.IsExpired() {
+		t.Errorf("GetRefreshTokenByHash returned %+v", stored)
+	}
+
+	revoked, err := repo.RevokeRefreshToken(ctx, stored.ID)
+	if err != nil || !revoked {
+		t.Errorf("RevokeRefreshToken = %v, %v, want true", revoked, err)
+	}
+
+	revoked, err = repo.RevokeRefreshToken(ctx, stored.ID)
+	if err != nil || revoked {
+		t.Errorf("revoking a token twice = %v, %v, want false", revoked, err)
+	}
+
+	err = repo.RevokeRefreshTokenFamily(ctx, family)
+	if err != nil {
+		t.Fatalf("RevokeRefreshTokenFamily: %v", err)
+	}
+
+	assertRevoked := func(hash string, want bool) {
+		t.Helper()
+
+		token, err := repo.GetRefreshTokenByHash(ctx, hash)
+		if err != nil {
+			t.Fatalf("GetRefreshTokenByHash: %v", err)
+		}
+
+		if token.IsRevoked() != want {
+			t.Errorf("token %s revoked = %v, want %v", hash, token.IsRevoked(), want)
+		}
+	}
+
+	assertRevoked(second, true)
+	assertRevoked(other, false)
+
+	err = repo.RevokeUserRefreshTokens(ctx, user.ID)
+	if err != nil {
+		t.Fatalf("RevokeUserRefreshTokens: %v", err)
+	}
+
+	assertRevoked(other, true)
+
+	deleted, err := repo.DeleteExpiredRefreshTokens(ctx)
+	if err != nil {
+		t.Fatalf("DeleteExpiredRefreshTokens: %v", err)
+	}
+
+	if deleted < 1 {
+		t.Errorf("DeleteExpiredRefreshTokens deleted %d tokens, want at least 1", deleted)
+	}
+
+	_, err = repo.GetRefreshTokenByHash(ctx, expired)
+	if !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("expired token was not deleted, got %v", err)
+	}
+
+	assertRevoked(first, true)
+}
+
+func testLoginFailures(t *testing.T, repo repository.DatabaseRepo) {
+
+	ctx := context.Background()
+
+	account := unique("user") + "@example.com"
+	start := now()
+
+	_, err := repo.GetLoginFailures(ctx, models.LoginSubjectAccount, account)
+	if !errors.Is(err, sql.ErrNoRows) {
+		t.Fatalf("GetLoginFailures of a subject without failures = %v, want sql.ErrNoRows", err)
+	}
+
+	for i := 1; i <= 3; i++ {
+		failures, err := repo.RecordLoginFailure(ctx, models.LoginSubjectAccount, account, start.Add(time.Duration(i)*time.Minute), time.Hour)
+		if err != nil {
+			t.Fatalf("RecordLoginFailure: %v", err)
+		}
+		if failures.Failures != i || failures.Kind != models.LoginSubjectAccount || failures.Subject != account {
+			t.Errorf("failure %d recorded as %+v", i, failures)
+		}
+	}
+
+	// the same subject of another kind is counted apart
+	failures, err := repo.RecordLoginFailure(ctx, models.LoginSubjectIP, account, start, time.Hour)
+	if err != nil || failures.Failures != 1 {
+		t.Errorf("RecordLoginFailure of another kind = %+v, %v, want 1 failure", failures, err)
+	}
+
+	until := start.Add(time.Hour)
+
+	err = repo.LockLogins(ctx, models.LoginSubjectAccount, account, until)
+	if err != nil {
+		t.Fatalf("LockLogins: %v", err)
+	}
+
+	failures, err = repo.GetLoginFailures(ctx, models.LoginSubjectAccount, account)
+	if err != nil {
+		t.Fatalf("GetLoginFailures: %v", err)
+	}
+
+	if failures.Failures != 3 || !failures.LastFailureAt.Equal(start.Add(3*time.Minute)) || failures.RetryAfter(start) != time.Hour {
+		t.Errorf("GetLoginFailures = %+v, want 3 failures locked for an hour", failures)
+	}
+
+	lockouts, err := repo.ListLoginLockouts(ctx, start)
+	if err != nil {
+		t.Fatalf("ListLoginLockouts: %v", err)
+	}
+
+	var listed bool
+	for _, lockout := range lockouts {
+		if lockout.Kind == models.LoginSubjectIP && lockout.Subject == account {
+			t.Error("ListLoginLockouts lists a subject that isn't locked")
+		}
+		if lockout.Kind == models.LoginSubjectAccount && lockout.Subject == account {
+			listed = true
+		}
+	}
+
+	if !listed {
+		t.Error("ListLoginLockouts doesn't list the locked subject")
+	}
+
+	lockouts, err = repo.ListLoginLockouts(ctx, until)
+	if err != nil {
+		t.Fatalf("ListLoginLockouts: %v", err)
+	}
+
+	for _, lockout := range lockouts {
+		if lockout.Subject == account {
+			t.Error("ListLoginLockouts lists a lockout that has ended")
+		}
+	}
+
+	// a failure after the window starts the count over and lifts the lockout
+	later := start.Add(3 * time.Hour)
+
+	failures, err = repo.RecordLoginFailure(ctx, models.LoginSubjectAccount, account, later, time.Hour)
+	if err != nil {
+		t.Fatalf("RecordLoginFailure: %v", err)
+	}
+
+	if failures.Failures != 1 || failures.LockedUntil != nil {
+		t.Errorf("failure after the window recorded as %+v, want a first failure", failures)
+	}
+
+	cleared, err := repo.ClearLoginFailures(ctx, models.LoginSubjectAccount, account)
+	if err != nil || !cleared {
+		t.Errorf("ClearLoginFailures = %v, %v, want true", cleared, err)
+	}
+
+	cleared, err = repo.ClearLoginFailures(ctx, models.LoginSubjectAccount, account)
+	if err != nil || cleared {
+		t.Errorf("clearing failures twice = %v, %v, want false", cleared, err)
+	}
+
+	deleted, err := repo.DeleteStaleLoginFailures(ctx, start.Add(time.Minute))
+	if err != nil {
+		t.Fatalf("DeleteStaleLoginFailures: %v", err)
+	}
+
+	if deleted < 1 {
+		t.Errorf("DeleteStaleLoginFailures deleted %d subjects, want at least 1", deleted)
+	}
+
+	_, err = repo.GetLoginFailures(ctx, models.LoginSubjectIP, account)
+	if !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("GetLoginFailures of a stale subject = %v, want sql.ErrNoRows", err)
+	}
+}
+
+func testUserTOTP(t *testing.T, repo repository.DatabaseRepo) {
+
+	ctx := context.Background()
+
+	user := insertUser(t, repo)
+
+	_, err := repo.GetUserTOTP(ctx, user.ID)
+	if !errors.Is(err, sql.ErrNoRows) {
+		t.Fatalf("GetUserTOTP of a user without TOTP = %v, want sql.ErrNoRows", err)
+	}
+
+	stored, err := repo.SetUserTOTP(ctx, models.UserTOTP{UserID: user.ID, Secret: "ABANDONED", CreatedAt: now()})
+	if err != nil || !stored {
+		t.Fatalf("SetUserTOTP = %v, %v, want true", stored, err)
+	}
+
+	// enrolling again before confirming replaces the secret
+	stored, err = repo.SetUserTOTP(ctx, models.UserTOTP{UserID: user.ID, Secret: "FIRST", CreatedAt: now()})
+	if err != nil || !stored {
+		t.Fatalf("SetUserTOTP = %v, %v, want true", stored, err)
+	}
+
+	// codes of an unconfirmed secret aren't accepted
+	used, err := repo.UseTOTPStep(ctx, user.ID, 100)
+	if err != nil || used {
+		t.Errorf("UseTOTPStep before the confirmation = %v, %v, want false", used, err)
+	}
+
+	confirmed, err := repo.ConfirmUserTOTP(ctx, user.ID, 100)
+	if err != nil || !confirmed {
+		t.Fatalf("ConfirmUserTOTP = %v, %v, want true", confirmed, err)
+	}
+
+	confirmed, err = repo.ConfirmUserTOTP(ctx, user.ID, 101)
+	if err != nil || confirmed {
+		t.Errorf("confirming twice = %v, %v, want false", confirmed, err)
+	}
+
+	totp, err := repo.GetUserTOTP(ctx, user.ID)
+	if err != nil {
+		t.Fatalf("GetUserTOTP: %v", err)
+	}
+
+	if totp.Secret != "FIRST" || !totp.IsConfirmed() || totp.LastStep != 100 {
+		t.Errorf("GetUserTOTP = %+v, want the confirmed secret at step 100", totp)
+	}
+
+	// a step is used once, and never one before it
+	for _, tt := range []struct {
+		step int64
+		want bool
+	}{{100, false}, {101, true}, {101, false}, {99, false}, {103, true}} {
+		used, err := repo.UseTOTPStep(ctx, user.ID, tt.step)
+		if err != nil || used != tt.want {
+			t.Errorf("UseTOTPStep(%d) = %v, %v, want %v", tt.step, used, err, tt.want)
+		}
+	}
+
+	first, second := unique("code"), unique("code")
+
+	err = repo.ReplaceRecoveryCodes(ctx, user.ID, []string{unique("code"), unique("code")})
+	if err != nil {
+		t.Fatalf("ReplaceRecoveryCodes: %v", err)
+	}
+
+	err = repo.ReplaceRecoveryCodes(ctx, user.ID, []string{first, second})
+	if err != nil {
+		t.Fatalf("ReplaceRecoveryCodes: %v", err)
+	}
+
+	count, err := repo.CountRecoveryCodes(ctx, user.ID)
+	if err != nil || count != 2 {
+		t.Errorf("CountRecoveryCodes = %d, %v, want the 2 codes replacing the previous ones", count, err)
+	}
+
+	other := insertUser(t, repo)
+
+	for _, tt := range []struct {
+		userID int
+		hash   string
+		want   bool
+	}{{other.ID, first, false}, {user.ID, first, true}, {user.ID, first, false}} {
+		used, err := repo.UseRecoveryCode(ctx, tt.userID, tt.hash)
+		if err != nil || used != tt.want {
+			t.Errorf("UseRecoveryCode(%d) = %v, %v, want %v", tt.userID, used, err, tt.want)
+		}
+	}
+
+	count, err = repo.CountRecoveryCodes(ctx, user.ID)
+	if err != nil || count != 1 {
+		t.Errorf("CountRecoveryCodes after using a code = %d, %v, want 1", count, err)
+	}
+
+	// a confirmed enrollment isn't replaced
+	stored, err = repo.SetUserTOTP(ctx, models.UserTOTP{UserID: user.ID, Secret: "SECOND", CreatedAt: now()})
+	if err != nil || stored {
+		t.Errorf("SetUserTOTP of a confirmed enrollment = %v, %v, want false", stored, err)
+	}
+
+	totp, err = repo.GetUserTOTP(ctx, user.ID)
+	if err != nil || totp.Secret != "FIRST" || !totp.IsConfirmed() {
+		t.Errorf("GetUserTOTP after enrolling again = %+v, %v, want the confirmed secret kept", totp, err)
+	}
+
+	err = repo.DeleteUserTOTP(ctx, user.ID)
+	if err != nil {
+		t.Fatalf("DeleteUserTOTP: %v", err)
+	}
+
+	_, err = repo.GetUserTOTP(ctx, user.ID)
+	if !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("GetUserTOTP after DeleteUserTOTP = %v, want sql.ErrNoRows", err)
+	}
+
+	count, err = repo.CountRecoveryCodes(ctx, user.ID)
+	if err != nil || count != 0 {
+		t.Errorf("CountRecoveryCodes after DeleteUserTOTP = %d, %v, want 0", count, err)
+	}
+}
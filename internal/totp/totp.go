@@ -0,0 +1,124 @@
+// Package totp implements the time-based one-time passwords of RFC 6238 with the parameters every authenticator
+// app supports: HMAC-SHA1, 6 digits and a new code every 30 seconds. Secrets are base32 encoded, as they appear
+// in otpauth:// URIs.
+package totp
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/subtle"
+	"encoding/base32"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// Parameters of the codes.
+const (
+	Digits = 6
+	Period = 30 * time.Second
+)
+
+// secretSize is the size of new secrets, the size of a SHA-1 HMAC key recommended by RFC 4226.
+const secretSize = 20
+
+// ErrInvalidSecret is returned for secrets that aren't base32 encoded.
+var ErrInvalidSecret = errors.New("totp: invalid secret")
+
+// encoding encodes secrets, without the padding authenticator apps don't expect.
+var encoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// NewSecret returns a new random secret, base32 encoded.
+func NewSecret() (string, error) {
+
+	b := make([]byte, secretSize)
+
+	_, err := rand.Read(b)
+	if err != nil {
+		return "", err
+	}
+
+	return encoding.EncodeToString(b), nil
+}
+
+// URI returns the otpauth:// URI of a secret, which authenticator apps read from a QR code. The account, such
+// as an email address, and the issuer label the codes in the app.
+func URI(issuer, account, secret string) string {
+
+	query := url.Values{}
+	query.Set("secret", secret)
+	query.Set("issuer", issuer)
+	query.Set("algorithm", "SHA1")
+	query.Set("digits", fmt.Sprint(Digits))
+	query.Set("period", fmt.Sprint(int(Period.Seconds())))
+
+	u := url.URL{
+		Scheme:   "otpauth",
+		Host:     "totp",
+		Path:     "/" + issuer + ":" + account,
+		RawQuery: query.Encode(),
+	}
+
+	return u.String()
+}
+
+// Step returns the number of the period containing t, which the code of t is computed from.
+func Step(t time.Time) int64 {
+	return t.Unix() / int64(Period.Seconds())
+}
+
+// Code returns the code of the secret for a step.
+func Code(secret string, step int64) (string, error) {
+
+	key, err := encoding.DecodeString(strings.ToUpper(strings.ReplaceAll(secret, " ", "")))
+	if err != nil || len(key) == 0 {
+		return "", ErrInvalidSecret
+	}
+
+	var counter [8]byte
+	binary.BigEndian.PutUint64(counter[:], uint64(step))
+
+	mac := hmac.New(sha1.New, key)
+	mac.Write(counter[:])
+	sum := mac.Sum(nil)
+
+	// dynamic truncation of RFC 4226
+	offset := sum[len(sum)-1] & 0x0f
+	value := binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7fffffff
+
+	modulo := uint32(1)
+	for i := 0; i < Digits; i++ {
+		modulo *= 10
+	}
+
+	return fmt.Sprintf("%0*d", Digits, value%modulo), nil
+}
+
+// Verify reports whether code is the code of the secret at t, or of the step before or after it to tolerate
+// the clock of the phone being off, and returns the step it matched. Callers must refuse steps at or before
+// the last one used, so that a code can't be replayed.
+func Verify(secret, code string, t time.Time) (int64, bool) {
+
+	if len(code) != Digits {
+		return 0, false
+	}
+
+	step := Step(t)
+
+	for _, s := range []int64{step, step - 1, step + 1} {
+		want, err := Code(secret, s)
+		if err != nil {
+			return 0, false
+		}
+
+		if subtle.ConstantTimeCompare([]byte(want), []byte(code)) == 1 {
+			return s, true
+		}
+	}
+
+	return 0, false
+}
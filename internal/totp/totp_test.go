@@ -0,0 +1,107 @@
+package totp
+
+import (
+	"encoding/base32"
+	"net/url"
+	"testing"
+	"time"
+)
+
+// rfcSecret is the SHA-1 secret of the test vectors of RFC 6238, base32 encoded.
+var rfcSecret = base32.StdEncoding.EncodeToString([]byte("12345678901234567890"))
+
+func TestCode(t *testing.T) {
+
+	// the 8 digit codes of RFC 6238, appendix B, truncated to 6 digits
+	tests := []struct {
+		unix int64
+		want string
+	}{
+		{59, "287082"},
+		{1111111109, "081804"},
+		{1111111111, "050471"},
+		{1234567890, "005924"},
+		{2000000000, "279037"},
+		{20000000000, "353130"},
+	}
+
+	for _, tt := range tests {
+		got, err := Code(rfcSecret, Step(time.Unix(tt.unix, 0)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != tt.want {
+			t.Errorf("Code at %d = %s, want %s", tt.unix, got, tt.want)
+		}
+	}
+
+	_, err := Code("not base32!", 1)
+	if err != ErrInvalidSecret {
+		t.Errorf("Code of an invalid secret = %v, want %v", err, ErrInvalidSecret)
+	}
+}
+
+func TestVerify(t *testing.T) {
+
+	secret, err := NewSecret()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(secret) != 32 {
+		t.Errorf("secret %q has %d characters, want 32", secret, len(secret))
+	}
+
+	now := time.Date(2026, 10, 1, 12, 0, 10, 0, time.UTC)
+
+	code := func(t time.Time) string {
+		c, err := Code(secret, Step(t))
+		if err != nil {
+			panic(err)
+		}
+		return c
+	}
+
+	// the codes of the previous and next periods are accepted, not older ones
+	tests := []struct {
+		at   time.Time
+		want bool
+	}{
+		{now, true},
+		{now.Add(-Period), true},
+		{now.Add(Period), true},
+		{now.Add(-2 * Period), false},
+		{now.Add(2 * Period), false},
+	}
+
+	for _, tt := range tests {
+		step, ok := Verify(secret, code(tt.at), now)
+		if ok != tt.want {
+			t.Errorf("Verify of the code at %s = %v, want %v", tt.at.Format(time.TimeOnly), ok, tt.want)
+		}
+		if ok && step != Step(tt.at) {
+			t.Errorf("Verify of the code at %s matched step %d, want %d", tt.at.Format(time.TimeOnly), step, Step(tt.at))
+		}
+	}
+
+	if _, ok := Verify(secret, "12345", now); ok {
+		t.Error("a 5 digit code is accepted")
+	}
+}
+
+func TestURI(t *testing.T) {
+
+	u, err := url.Parse(URI("Go Movies", "admin@example.com", "ABCDEF"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if u.Scheme != "otpauth" || u.Host != "totp" || u.Path != "/Go Movies:admin@example.com" {
+		t.Errorf("URI = %s, want an otpauth://totp URI labelled with the issuer and account", u)
+	}
+
+	query := u.Query()
+	if query.Get("secret") != "ABCDEF" || query.Get("issuer") != "Go Movies" || query.Get("digits") != "6" || query.Get("period") != "30" {
+		t.Errorf("URI query = %v", query)
+	}
+}
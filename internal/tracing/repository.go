@@ -0,0 +1,416 @@
+package tracing
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"github.com/calvarado2004/go-movies-backend/internal/models"
+	"github.com/calvarado2004/go-movies-backend/internal/repository"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+	"time"
+)
+
+// tracedRepo is a repository.DatabaseRepo starting a span for every call to another repository. The
+// statements the call runs are traced as children of that span by the repository itself.
+type tracedRepo struct {
+	repo repository.DatabaseRepo
+
+	// tx is the span of the transaction the repository runs in, the parent of the spans of its calls.
+	tx trace.Span
+}
+
+// Repository returns a repository.DatabaseRepo tracing every call to repo.
+func Repository(repo repository.DatabaseRepo) repository.DatabaseRepo {
+	return &tracedRepo{repo: repo}
+}
+
+// start starts the span of a call to method.
+func (r *tracedRepo) start(ctx context.Context, method string) (context.Context, trace.Span) {
+
+	// the functions run by WithTx call the repository with their own context
+	if r.tx != nil {
+		ctx = trace.ContextWithSpan(ctx, r.tx)
+	}
+
+	return tracer().Start(ctx, "DatabaseRepo."+method, trace.WithSpanKind(trace.SpanKindInternal))
+}
+
+// end ends the span of a call, which failed if *err isn't nil. It is meant to be deferred, with err pointing
+// at the named error result of the caller. Lookups finding no rows are not failures.
+func end(span trace.Span, err *error) {
+
+	if *err != nil && !errors.Is(*err, sql.ErrNoRows) {
+		span.RecordError(*err)
+		span.SetStatus(codes.Error, (*err).Error())
+	}
+
+	span.End()
+}
+
+// Connection returns the connection pool of the wrapped repository.
+func (r *tracedRepo) Connection() *sql.DB {
+	return r.repo.Connection()
+}
+
+// WithTx runs fn in a transaction of the wrapped repository, the calls made in it are traced too.
+func (r *tracedRepo) WithTx(ctx context.Context, fn func(repo repository.DatabaseRepo) error) (err error) {
+	ctx, span := r.start(ctx, "WithTx")
+	defer end(span, &err)
+
+	return r.repo.WithTx(ctx, func(tx repository.DatabaseRepo) error {
+		return fn(&tracedRepo{repo: tx, tx: span})
+	})
+}
+
+// AllMovies traces a call to AllMovies of the wrapped repository.
+func (r *tracedRepo) AllMovies(ctx context.Context, genre ...int) (_ []*models.Movie, err error) {
+	ctx, span := r.start(ctx, "AllMovies")
+	defer end(span, &err)
+
+	return r.repo.AllMovies(ctx, genre...)
+}
+
+// ListMovies traces a call to ListMovies of the wrapped repository.
+func (r *tracedRepo) ListMovies(ctx context.Context, query repository.MovieQuery) (_ []*models.Movie, _ int, err error) {
+	ctx, span := r.start(ctx, "ListMovies")
+	defer end(span, &err)
+
+	return r.repo.ListMovies(ctx, query)
+}
+
+// SearchMovies traces a call to SearchMovies of the wrapped repository.
+func (r *tracedRepo) SearchMovies(ctx context.Context, search repository.MovieSearch) (_ []*models.MovieSearchResult, err error) {
+	ctx, span := r.start(ctx, "SearchMovies")
+	defer end(span, &err)
+
+	return r.repo.SearchMovies(ctx, search)
+}
+
+// OneMovie traces a call to OneMovie of the wrapped repository.
+func (r *tracedRepo) OneMovie(ctx context.Context, id int) (_ *models.Movie, err error) {
+	ctx, span := r.start(ctx, "OneMovie")
+	defer end(span, &err)
+
+	return r.repo.OneMovie(ctx, id)
+}
+
+// OneMovieForEdit traces a call to OneMovieForEdit of the wrapped repository.
+func (r *tracedRepo) OneMovieForEdit(ctx context.Context, id int) (_ *models.Movie, _ []*models.Genre, err error) {
+	ctx, span := r.start(ctx, "OneMovieForEdit")
+	defer end(span, &err)
+
+	return r.repo.OneMovieForEdit(ctx, id)
+}
+
+// GetUserByEmail traces a call to GetUserByEmail of the wrapped repository.
+func (r *tracedRepo) GetUserByEmail(ctx context.Context, email string) (_ models.User, err error) {
+	ctx, span := r.start(ctx, "GetUserByEmail")
+	defer end(span, &err)
+
+	return r.repo.GetUserByEmail(ctx, email)
+}
+
+// GetUserByID traces a call to GetUserByID of the wrapped repository.
+func (r *tracedRepo) GetUserByID(ctx context.Context, id int) (_ models.User, err error) {
+	ctx, span := r.start(ctx, "GetUserByID")
+	defer end(span, &err)
+
+	return r.repo.GetUserByID(ctx, id)
+}
+
+// InsertUser traces a call to InsertUser of the wrapped repository.
+func (r *tracedRepo) InsertUser(ctx context.Context, user models.User) (_ int, err error) {
+	ctx, span := r.start(ctx, "InsertUser")
+	defer end(span, &err)
+
+	return r.repo.InsertUser(ctx, user)
+}
+
+// UpdateUserPassword traces a call to UpdateUserPassword of the wrapped repository.
+func (r *tracedRepo) UpdateUserPassword(ctx context.Context, id int, passwordHash string) (err error) {
+	ctx, span := r.start(ctx, "UpdateUserPassword")
+	defer end(span, &err)
+
+	return r.repo.UpdateUserPassword(ctx, id, passwordHash)
+}
+
+// SetUserEmailVerified traces a call to SetUserEmailVerified of the wrapped repository.
+func (r *tracedRepo) SetUserEmailVerified(ctx context.Context, id int) (err error) {
+	ctx, span := r.start(ctx, "SetUserEmailVerified")
+	defer end(span, &err)
+
+	return r.repo.SetUserEmailVerified(ctx, id)
+}
+
+// InsertUserToken traces a call to InsertUserToken of the wrapped repository.
+func (r *tracedRepo) InsertUserToken(ctx context.Context, token models.UserToken) (err error) {
+	ctx, span := r.start(ctx, "InsertUserToken")
+	defer end(span, &err)
+
+	return r.repo.InsertUserToken(ctx, token)
+}
+
+// ConsumeUserToken traces a call to ConsumeUserToken of the wrapped repository.
+func (r *tracedRepo) ConsumeUserToken(ctx context.Context, hash, scope string) (_ models.UserToken, err error) {
+	ctx, span := r.start(ctx, "ConsumeUserToken")
+	defer end(span, &err)
+
+	return r.repo.ConsumeUserToken(ctx, hash, scope)
+}
+
+// AllGenresDB traces a call to AllGenresDB of the wrapped repository.
+func (r *tracedRepo) AllGenresDB(ctx context.Context) (_ []*models.Genre, err error) {
+	ctx, span := r.start(ctx, "AllGenresDB")
+	defer end(span, &err)
+
+	return r.repo.AllGenresDB(ctx)
+}
+
+// GenresForMovies traces a call to GenresForMovies of the wrapped repository.
+func (r *tracedRepo) GenresForMovies(ctx context.Context, movieIDs []int) (_ map[int][]*models.Genre, err error) {
+	ctx, span := r.start(ctx, "GenresForMovies")
+	defer end(span, &err)
+
+	return r.repo.GenresForMovies(ctx, movieIDs)
+}
+
+// InsertGenre traces a call to InsertGenre of the wrapped repository.
+func (r *tracedRepo) InsertGenre(ctx context.Context, genre models.Genre) (_ int, err error) {
+	ctx, span := r.start(ctx, "InsertGenre")
+	defer end(span, &err)
+
+	return r.repo.InsertGenre(ctx, genre)
+}
+
+// UpdateGenre traces a call to UpdateGenre of the wrapped repository.
+func (r *tracedRepo) UpdateGenre(ctx context.Context, genre models.Genre) (err error) {
+	ctx, span := r.start(ctx, "UpdateGenre")
+	defer end(span, &err)
+
+	return r.repo.UpdateGenre(ctx, genre)
+}
+
+// DeleteGenre traces a call to DeleteGenre of the wrapped repository.
+func (r *tracedRepo) DeleteGenre(ctx context.Context, id int) (err error) {
+	ctx, span := r.start(ctx, "DeleteGenre")
+	defer end(span, &err)
+
+	return r.repo.DeleteGenre(ctx, id)
+}
+
+// InsertMovie traces a call to InsertMovie of the wrapped repository.
+func (r *tracedRepo) InsertMovie(ctx context.Context, movie models.Movie) (_ int, err error) {
+	ctx, span := r.start(ctx, "InsertMovie")
+	defer end(span, &err)
+
+	return r.repo.InsertMovie(ctx, movie)
+}
+
+// UpdateMovieGenres traces a call to UpdateMovieGenres of the wrapped repository.
+func (r *tracedRepo) UpdateMovieGenres(ctx context.Context, id int, genreIDs []int) (err error) {
+	ctx, span := r.start(ctx, "UpdateMovieGenres")
+	defer end(span, &err)
+
+	return r.repo.UpdateMovieGenres(ctx, id, genreIDs)
+}
+
+// UpdateMovie traces a call to UpdateMovie of the wrapped repository.
+func (r *tracedRepo) UpdateMovie(ctx context.Context, movie models.Movie) (err error) {
+	ctx, span := r.start(ctx, "UpdateMovie")
+	defer end(span, &err)
+
+	return r.repo.UpdateMovie(ctx, movie)
+}
+
+// FillMovieImage traces a call to FillMovieImage of the wrapped repository.
+func (r *tracedRepo) FillMovieImage(ctx context.Context, id int, image string) (_ bool, err error) {
+	ctx, span := r.start(ctx, "FillMovieImage")
+	defer end(span, &err)
+
+	return r.repo.FillMovieImage(ctx, id, image)
+}
+
+// ReplaceMoviePoster traces a call to ReplaceMoviePoster of the wrapped repository.
+func (r *tracedRepo) ReplaceMoviePoster(ctx context.Context, poster models.MoviePoster, image string) (_ *models.MoviePoster, err error) {
+	ctx, span := r.start(ctx, "ReplaceMoviePoster")
+	defer end(span, &err)
+
+	return r.repo.ReplaceMoviePoster(ctx, poster, image)
+}
+
+// GetMoviePoster traces a call to GetMoviePoster of the wrapped repository.
+func (r *tracedRepo) GetMoviePoster(ctx context.Context, movieID int) (_ models.MoviePoster, err error) {
+	ctx, span := r.start(ctx, "GetMoviePoster")
+	defer end(span, &err)
+
+	return r.repo.GetMoviePoster(ctx, movieID)
+}
+
+// DeleteMovie traces a call to DeleteMovie of the wrapped repository.
+func (r *tracedRepo) DeleteMovie(ctx context.Context, id int) (err error) {
+	ctx, span := r.start(ctx, "DeleteMovie")
+	defer end(span, &err)
+
+	return r.repo.DeleteMovie(ctx, id)
+}
+
+// InsertRefreshToken traces a call to InsertRefreshToken of the wrapped repository.
+func (r *tracedRepo) InsertRefreshToken(ctx context.Context, token models.RefreshToken) (err error) {
+	ctx, span := r.start(ctx, "InsertRefreshToken")
+	defer end(span, &err)
+
+	return r.repo.InsertRefreshToken(ctx, token)
+}
+
+// GetRefreshTokenByHash traces a call to GetRefreshTokenByHash of the wrapped repository.
+func (r *tracedRepo) GetRefreshTokenByHash(ctx context.Context, hash string) (_ models.RefreshToken, err error) {
+	ctx, span := r.start(ctx, "GetRefreshTokenByHash")
+	defer end(span, &err)
+
+	return r.repo.GetRefreshTokenByHash(ctx, hash)
+}
+
+// RevokeRefreshToken traces a call to RevokeRefreshToken of the wrapped repository.
+func (r *tracedRepo) RevokeRefreshToken(ctx context.Context, id int) (_ bool, err error) {
+	ctx, span := r.start(ctx, "RevokeRefreshToken")
+	defer end(span, &err)
+
+	return r.repo.RevokeRefreshToken(ctx, id)
+}
+
+// RevokeRefreshTokenFamily traces a call to RevokeRefreshTokenFamily of the wrapped repository.
+func (r *tracedRepo) RevokeRefreshTokenFamily(ctx context.Context, familyID string) (err error) {
+	ctx, span := r.start(ctx, "RevokeRefreshTokenFamily")
+	defer end(span, &err)
+
+	return r.repo.RevokeRefreshTokenFamily(ctx, familyID)
+}
+
+// RevokeUserRefreshTokens traces a call to RevokeUserRefreshTokens of the wrapped repository.
+func (r *tracedRepo) RevokeUserRefreshTokens(ctx context.Context, userID int) (err error) {
+	ctx, span := r.start(ctx, "RevokeUserRefreshTokens")
+	defer end(span, &err)
+
+	return r.repo.RevokeUserRefreshTokens(ctx, userID)
+}
+
+// DeleteExpiredRefreshTokens traces a call to DeleteExpiredRefreshTokens of the wrapped repository.
+func (r *tracedRepo) DeleteExpiredRefreshTokens(ctx context.Context) (_ int64, err error) {
+	ctx, span := r.start(ctx, "DeleteExpiredRefreshTokens")
+	defer end(span, &err)
+
+	return r.repo.DeleteExpiredRefreshTokens(ctx)
+}
+
+// RecordLoginFailure traces a call to RecordLoginFailure of the wrapped repository.
+func (r *tracedRepo) RecordLoginFailure(ctx context.Context, kind, subject string, at time.Time, window time.Duration) (_ models.LoginFailures, err error) {
+	ctx, span := r.start(ctx, "RecordLoginFailure")
+	defer end(span, &err)
+
+	return r.repo.RecordLoginFailure(ctx, kind, subject, at, window)
+}
+
+// LockLogins traces a call to LockLogins of the wrapped repository.
+func (r *tracedRepo) LockLogins(ctx context.Context, kind, subject string, until time.Time) (err error) {
+	ctx, span := r.start(ctx, "LockLogins")
+	defer end(span, &err)
+
+	return r.repo.LockLogins(ctx, kind, subject, until)
+}
+
+// GetLoginFailures traces a call to GetLoginFailures of the wrapped repository.
+func (r *tracedRepo) GetLoginFailures(ctx context.Context, kind, subject string) (_ models.LoginFailures, err error) {
+	ctx, span := r.start(ctx, "GetLoginFailures")
+	defer end(span, &err)
+
+	return r.repo.GetLoginFailures(ctx, kind, subject)
+}
+
+// ListLoginLockouts traces a call to ListLoginLockouts of the wrapped repository.
+func (r *tracedRepo) ListLoginLockouts(ctx context.Context, now time.Time) (_ []*models.LoginFailures, err error) {
+	ctx, span := r.start(ctx, "ListLoginLockouts")
+	defer end(span, &err)
+
+	return r.repo.ListLoginLockouts(ctx, now)
+}
+
+// ClearLoginFailures traces a call to ClearLoginFailures of the wrapped repository.
+func (r *tracedRepo) ClearLoginFailures(ctx context.Context, kind, subject string) (_ bool, err error) {
+	ctx, span := r.start(ctx, "ClearLoginFailures")
+	defer end(span, &err)
+
+	return r.repo.ClearLoginFailures(ctx, kind, subject)
+}
+
+// DeleteStaleLoginFailures traces a call to DeleteStaleLoginFailures of the wrapped repository.
+func (r *tracedRepo) DeleteStaleLoginFailures(ctx context.Context, before time.Time) (_ int64, err error) {
+	ctx, span := r.start(ctx, "DeleteStaleLoginFailures")
+	defer end(span, &err)
+
+	return r.repo.DeleteStaleLoginFailures(ctx, before)
+}
+
+// SetUserTOTP traces a call to SetUserTOTP of the wrapped repository.
+func (r *tracedRepo) SetUserTOTP(ctx context.Context, totp models.UserTOTP) (_ bool, err error) {
+	ctx, span := r.start(ctx, "SetUserTOTP")
+	defer end(span, &err)
+
+	return r.repo.SetUserTOTP(ctx, totp)
+}
+
+// GetUserTOTP traces a call to GetUserTOTP of the wrapped repository.
+func (r *tracedRepo) GetUserTOTP(ctx context.Context, userID int) (_ models.UserTOTP, err error) {
+	ctx, span := r.start(ctx, "GetUserTOTP")
+	defer end(span, &err)
+
+	return r.repo.GetUserTOTP(ctx, userID)
+}
+
+// ConfirmUserTOTP traces a call to ConfirmUserTOTP of the wrapped repository.
+func (r *tracedRepo) ConfirmUserTOTP(ctx context.Context, userID int, step int64) (_ bool, err error) {
+	ctx, span := r.start(ctx, "ConfirmUserTOTP")
+	defer end(span, &err)
+
+	return r.repo.ConfirmUserTOTP(ctx, userID, step)
+}
+
+// UseTOTPStep traces a call to UseTOTPStep of the wrapped repository.
+func (r *tracedRepo) UseTOTPStep(ctx context.Context, userID int, step int64) (_ bool, err error) {
+	ctx, span := r.start(ctx, "UseTOTPStep")
+	defer end(span, &err)
+
+	return r.repo.UseTOTPStep(ctx, userID, step)
+}
+
+// DeleteUserTOTP traces a call to DeleteUserTOTP of the wrapped repository.
+func (r *tracedRepo) DeleteUserTOTP(ctx context.Context, userID int) (err error) {
+	ctx, span := r.start(ctx, "DeleteUserTOTP")
+	defer end(span, &err)
+
+	return r.repo.DeleteUserTOTP(ctx, userID)
+}
+
+// ReplaceRecoveryCodes traces a call to ReplaceRecoveryCodes of the wrapped repository.
+func (r *tracedRepo) ReplaceRecoveryCodes(ctx context.Context, userID int, hashes []string) (err error) {
+	ctx, span := r.start(ctx, "ReplaceRecoveryCodes")
+	defer end(span, &err)
+
+	return r.repo.ReplaceRecoveryCodes(ctx, userID, hashes)
+}
+
+// UseRecoveryCode traces a call to UseRecoveryCode of the wrapped repository.
+func (r *tracedRepo) UseRecoveryCode(ctx context.Context, userID int, hash string) (_ bool, err error) {
+	ctx, span := r.start(ctx, "UseRecoveryCode")
+	defer end(span, &err)
+
+	return r.repo.UseRecoveryCode(ctx, userID, hash)
+}
+
+// CountRecoveryCodes traces a call to CountRecoveryCodes of the wrapped repository.
+func (r *tracedRepo) CountRecoveryCodes(ctx context.Context, userID int) (_ int, err error) {
+	ctx, span := r.start(ctx, "CountRecoveryCodes")
+	defer end(span, &err)
+
+	return r.repo.CountRecoveryCodes(ctx, userID)
+}
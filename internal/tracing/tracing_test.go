@@ -0,0 +1,194 @@
+package tracing
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"errors"
+	"github.com/calvarado2004/go-movies-backend/internal/repository"
+	"github.com/calvarado2004/go-movies-backend/internal/repository/dbrepo"
+	"github.com/go-chi/chi/v5"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// record installs a global tracer provider recording the spans ended during the test.
+func record(t *testing.T) *tracetest.SpanRecorder {
+	t.Helper()
+
+	recorder := tracetest.NewSpanRecorder()
+	provider := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder))
+
+	previous := otel.GetTracerProvider()
+	otel.SetTracerProvider(provider)
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+
+	t.Cleanup(func() {
+		otel.SetTracerProvider(previous)
+	})
+
+	return recorder
+}
+
+func TestMiddleware(t *testing.T) {
+
+	recorder := record(t)
+
+	mux := chi.NewRouter()
+	mux.Use(Middleware)
+	mux.Get("/movies/{id}", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusTeapot)
+	})
+
+	req := httptest.NewRequest(http.MethodGet, "/movies/42", nil)
+	req.Header.Set("traceparent", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+
+	mux.ServeHTTP(httptest.NewRecorder(), req)
+
+	spans := recorder.Ended()
+	if len(spans) != 1 {
+		t.Fatalf("recorded %d spans, want 1", len(spans))
+	}
+
+	span := spans[0]
+
+	if span.Name() != "GET /movies/{id}" {
+		t.Errorf("span name = %q, want the route pattern", span.Name())
+	}
+
+	if span.SpanContext().TraceID().String() != "4bf92f3577b34da6a3ce929d0e0e4736" {
+		t.Errorf("trace id = %s, the trace of the caller was not continued", span.SpanContext().TraceID())
+	}
+
+	if span.Parent().SpanID().String() != "00f067aa0ba902b7" {
+		t.Errorf("parent span id = %s", span.Parent().SpanID())
+	}
+}
+
+func TestTransport(t *testing.T) {
+
+	recorder := record(t)
+
+	var traceparent string
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		traceparent = r.Header.Get("traceparent")
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer server.Close()
+
+	client := &http.Client{Transport: Transport(nil)}
+
+	req, err := http.NewRequest(http.MethodGet, server.URL+"/search/movie?api_key=secret", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = resp.Body.Close()
+
+	if req.Header.Get("traceparent") != "" {
+		t.Errorf("the request of the caller was modified")
+	}
+
+	spans := recorder.Ended()
+	if len(spans) != 1 {
+		t.Fatalf("recorded %d spans, want 1", len(spans))
+	}
+
+	if !strings.Contains(traceparent, spans[0].SpanContext().TraceID().String()) {
+		t.Errorf("traceparent %q does not carry the trace of the span", traceparent)
+	}
+
+	if spans[0].Status().Code != codes.Error {
+		t.Errorf("span status = %v, want an error for a 500", spans[0].Status())
+	}
+
+	for _, attr := range spans[0].Attributes() {
+		if strings.Contains(attr.Value.Emit(), "secret") {
+			t.Errorf("attribute %s leaks the query string", attr.Key)
+		}
+	}
+}
+
+func TestRepository(t *testing.T) {
+
+	recorder := record(t)
+
+	repo := Repository(dbrepo.NewSeededMemoryDBRepo())
+	ctx := context.Background()
+
+	_, err := repo.OneMovie(ctx, -1)
+	if !errors.Is(err, sql.ErrNoRows) {
+		t.Fatalf("OneMovie of a missing movie returned %v", err)
+	}
+
+	err = repo.WithTx(ctx, func(tx repository.DatabaseRepo) error {
+		return tx.UpdateMovieGenres(ctx, 1, []int{-1})
+	})
+	if err == nil {
+		t.Fatalf("UpdateMovieGenres accepted an unknown genre")
+	}
+
+	spans := recorder.Ended()
+	if len(spans) != 3 {
+		t.Fatalf("recorded %d spans, want 3", len(spans))
+	}
+
+	// spans end in reverse order of nesting
+	oneMovie, update, tx := spans[0], spans[1], spans[2]
+
+	if oneMovie.Name() != "DatabaseRepo.OneMovie" || oneMovie.Status().Code == codes.Error {
+		t.Errorf("a missing row is not a failure, got %s with %v", oneMovie.Name(), oneMovie.Status())
+	}
+
+	if update.Name() != "DatabaseRepo.UpdateMovieGenres" || update.Status().Code != codes.Error {
+		t.Errorf("got %s with %v, want a failed UpdateMovieGenres", update.Name(), update.Status())
+	}
+
+	if tx.Name() != "DatabaseRepo.WithTx" || update.Parent().SpanID() != tx.SpanContext().SpanID() {
+		t.Errorf("calls made in a transaction are not children of its span")
+	}
+}
+
+func TestSetupStdout(t *testing.T) {
+
+	var buf bytes.Buffer
+
+	shutdown, err := Setup(context.Background(), Config{Exporter: "stdout", SampleRatio: 1, ServiceName: "test", Writer: &buf})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	previous := otel.GetTracerProvider()
+	t.Cleanup(func() {
+		otel.SetTracerProvider(previous)
+	})
+
+	_, span := tracer().Start(context.Background(), "exported")
+	span.End()
+
+	err = shutdown(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !strings.Contains(buf.String(), `"Name":"exported"`) {
+		t.Errorf("the span was not exported: %s", buf.String())
+	}
+
+	_, err = Setup(context.Background(), Config{Exporter: "zipkin"})
+	if err == nil {
+		t.Errorf("Setup accepted an unknown exporter")
+	}
+}
@@ -16,6 +16,12 @@ SET xmloption = content;
 SET client_min_messages = warning;
 SET row_security = off;
 
+--
+-- Name: pg_trgm; Type: EXTENSION; Schema: -; Owner: -
+--
+
+CREATE EXTENSION IF NOT EXISTS pg_trgm WITH SCHEMA public;
+
 SET default_tablespace = '';
 
 SET default_table_access_method = heap;
@@ -59,7 +65,8 @@ CREATE TABLE public.movies (
                                description text,
                                image character varying(255),
                                created_at timestamp without time zone,
-                               updated_at timestamp without time zone
+                               updated_at timestamp without time zone,
+                               search_vector tsvector GENERATED ALWAYS AS ((setweight(to_tsvector('english'::regconfig, (COALESCE(title, ''::character varying))::text), 'A'::"char") || setweight(to_tsvector('english'::regconfig, COALESCE(description, ''::text)), 'B'::"char"))) STORED
 );
 
 
@@ -112,6 +119,8 @@ CREATE TABLE public.users (
                               last_name character varying(255),
                               email character varying(255),
                               password character varying(255),
+                              role character varying(20) DEFAULT 'viewer'::character varying NOT NULL,
+                              email_verified_at timestamp without time zone,
                               created_at timestamp without time zone,
                               updated_at timestamp without time zone
 );
@@ -131,6 +140,145 @@ ALTER TABLE public.users ALTER COLUMN id ADD GENERATED ALWAYS AS IDENTITY (
     );
 
 
+--
+-- Name: refresh_tokens; Type: TABLE; Schema: public; Owner: -
+--
+
+CREATE TABLE public.refresh_tokens (
+                                       id integer NOT NULL,
+                                       user_id integer NOT NULL,
+                                       token_hash character varying(64) NOT NULL,
+                                       family_id character varying(64) NOT NULL,
+                                       expires_at timestamp without time zone NOT NULL,
+                                       revoked_at timestamp without time zone,
+                                       created_at timestamp without time zone
+);
+
+
+--
+-- Name: refresh_tokens_id_seq; Type: SEQUENCE; Schema: public; Owner: -
+--
+
+ALTER TABLE public.refresh_tokens ALTER COLUMN id ADD GENERATED ALWAYS AS IDENTITY (
+    SEQUENCE NAME public.refresh_tokens_id_seq
+    START WITH 1
+    INCREMENT BY 1
+    NO MINVALUE
+    NO MAXVALUE
+    CACHE 1
+    );
+
+
+--
+-- Name: user_tokens; Type: TABLE; Schema: public; Owner: -
+--
+
+CREATE TABLE public.user_tokens (
+                                    id integer NOT NULL,
+                                    user_id integer NOT NULL,
+                                    token_hash character varying(64) NOT NULL,
+                                    scope character varying(32) NOT NULL,
+                                    expires_at timestamp without time zone NOT NULL,
+                                    used_at timestamp without time zone,
+                                    created_at timestamp without time zone
+);
+
+
+--
+-- Name: user_tokens_id_seq; Type: SEQUENCE; Schema: public; Owner: -
+--
+
+ALTER TABLE public.user_tokens ALTER COLUMN id ADD GENERATED ALWAYS AS IDENTITY (
+    SEQUENCE NAME public.user_tokens_id_seq
+    START WITH 1
+    INCREMENT BY 1
+    NO MINVALUE
+    NO MAXVALUE
+    CACHE 1
+    );
+
+
+--
+-- Name: movie_posters; Type: TABLE; Schema: public; Owner: -
+--
+
+CREATE TABLE public.movie_posters (
+                                      movie_id integer NOT NULL,
+                                      source character varying(512) NOT NULL,
+                                      dominant_color character varying(7) NOT NULL,
+                                      placeholder text NOT NULL,
+                                      width integer NOT NULL,
+                                      height integer NOT NULL,
+                                      created_at timestamp without time zone NOT NULL,
+                                      updated_at timestamp without time zone NOT NULL,
+                                      version character varying(32) DEFAULT ''::character varying NOT NULL
+);
+
+
+--
+-- Name: login_failures; Type: TABLE; Schema: public; Owner: -
+--
+
+CREATE TABLE public.login_failures (
+                                       kind character varying(16) NOT NULL,
+                                       subject character varying(255) NOT NULL,
+                                       failures integer NOT NULL,
+                                       last_failure_at timestamp without time zone NOT NULL,
+                                       locked_until timestamp without time zone
+);
+
+
+--
+-- Name: user_totp; Type: TABLE; Schema: public; Owner: -
+--
+
+CREATE TABLE public.user_totp (
+                                  user_id integer NOT NULL,
+                                  secret character varying(64) NOT NULL,
+                                  last_step bigint DEFAULT 0 NOT NULL,
+                                  confirmed_at timestamp without time zone,
+                                  created_at timestamp without time zone NOT NULL
+);
+
+
+--
+-- Name: recovery_codes; Type: TABLE; Schema: public; Owner: -
+--
+
+CREATE TABLE public.recovery_codes (
+                                       id integer NOT NULL,
+                                       user_id integer NOT NULL,
+                                       code_hash character varying(64) NOT NULL,
+                                       used_at timestamp without time zone,
+                                       created_at timestamp without time zone NOT NULL
+);
+
+
+--
+-- Name: recovery_codes_id_seq; Type: SEQUENCE; Schema: public; Owner: -
+--
+
+ALTER TABLE public.recovery_codes ALTER COLUMN id ADD GENERATED ALWAYS AS IDENTITY (
+    SEQUENCE NAME public.recovery_codes_id_seq
+    START WITH 1
+    INCREMENT BY 1
+    NO MINVALUE
+    NO MAXVALUE
+    CACHE 1
+    );
+
+
+--
+-- Name: schema_migrations; Type: TABLE; Schema: public; Owner: -
+--
+
+CREATE TABLE public.schema_migrations (
+                                          version bigint NOT NULL,
+                                          name character varying(255) NOT NULL,
+                                          applied_at timestamp without time zone NOT NULL
+);
+
+
 --
 -- Data for Name: genres; Type: TABLE DATA; Schema: public; Owner: -
 --
@@ -183,9 +331,26 @@ VALUES
 -- Data for Name: users; Type: TABLE DATA; Schema: public; Owner: -
 --
 
-INSERT INTO public.users (first_name, last_name, email, password, created_at, updated_at)
+INSERT INTO public.users (first_name, last_name, email, password, role, email_verified_at, created_at, updated_at)
 VALUES
-    ('Admin',	'User',	'admin@example.com',	'$2a$14$wVsaPvJnJJsomWArouWCtusem6S/.Gauq/GjOIEHpyh2DAMmso1wy',	'2022-09-23 00:00:00',	'2022-09-23 00:00:00');
+    ('Admin',	'User',	'admin@example.com',	'$2a$14$wVsaPvJnJJsomWArouWCtusem6S/.Gauq/GjOIEHpyh2DAMmso1wy',	'admin',	'2022-09-23 00:00:00',	'2022-09-23 00:00:00',	'2022-09-23 00:00:00');
+
+
+--
+-- Data for Name: schema_migrations; Type: TABLE DATA; Schema: public; Owner: -
+--
+
+INSERT INTO public.schema_migrations (version, name, applied_at)
+VALUES
+    (1,	'initial_schema',	'2022-09-23 00:00:00'),
+    (2,	'refresh_tokens',	'2022-09-23 00:00:00'),
+    (3,	'user_roles',	'2022-09-23 00:00:00'),
+    (4,	'user_accounts',	'2022-09-23 00:00:00'),
+    (5,	'movie_search',	'2022-09-23 00:00:00'),
+    (6,	'movie_posters',	'2022-09-23 00:00:00'),
+    (7,	'movie_poster_versions',	'2022-09-23 00:00:00'),
+    (8,	'login_failures',	'2022-09-23 00:00:00'),
+    (9,	'user_totp',	'2022-09-23 00:00:00');
 
 
 
@@ -265,6 +430,158 @@ ALTER TABLE ONLY public.movies_genres
     ADD CONSTRAINT movies_genres_movie_id_fkey FOREIGN KEY (movie_id) REFERENCES public.movies(id) ON UPDATE CASCADE ON DELETE CASCADE;
 
 
+--
+-- Name: refresh_tokens refresh_tokens_pkey; Type: CONSTRAINT; Schema: public; Owner: -
+--
+
+ALTER TABLE ONLY public.refresh_tokens
+    ADD CONSTRAINT refresh_tokens_pkey PRIMARY KEY (id);
+
+
+--
+-- Name: refresh_tokens_token_hash_key; Type: INDEX; Schema: public; Owner: -
+--
+
+CREATE UNIQUE INDEX refresh_tokens_token_hash_key ON public.refresh_tokens USING btree (token_hash);
+
+
+--
+-- Name: refresh_tokens_family_id_idx; Type: INDEX; Schema: public; Owner: -
+--
+
+CREATE INDEX refresh_tokens_family_id_idx ON public.refresh_tokens USING btree (family_id);
+
+
+--
+-- Name: refresh_tokens refresh_tokens_user_id_fkey; Type: FK CONSTRAINT; Schema: public; Owner: -
+--
+
+ALTER TABLE ONLY public.refresh_tokens
+    ADD CONSTRAINT refresh_tokens_user_id_fkey FOREIGN KEY (user_id) REFERENCES public.users(id) ON UPDATE CASCADE ON DELETE CASCADE;
+
+
+--
+-- Name: users_email_key; Type: INDEX; Schema: public; Owner: -
+--
+
+CREATE UNIQUE INDEX users_email_key ON public.users USING btree (lower((email)::text));
+
+
+--
+-- Name: user_tokens user_tokens_pkey; Type: CONSTRAINT; Schema: public; Owner: -
+--
+
+ALTER TABLE ONLY public.user_tokens
+    ADD CONSTRAINT user_tokens_pkey PRIMARY KEY (id);
+
+
+--
+-- Name: user_tokens_token_hash_key; Type: INDEX; Schema: public; Owner: -
+--
+
+CREATE UNIQUE INDEX user_tokens_token_hash_key ON public.user_tokens USING btree (token_hash);
+
+
+--
+-- Name: user_tokens user_tokens_user_id_fkey; Type: FK CONSTRAINT; Schema: public; Owner: -
+--
+
+ALTER TABLE ONLY public.user_tokens
+    ADD CONSTRAINT user_tokens_user_id_fkey FOREIGN KEY (user_id) REFERENCES public.users(id) ON UPDATE CASCADE ON DELETE CASCADE;
+
+
+--
+-- Name: movies_search_vector_idx; Type: INDEX; Schema: public; Owner: -
+--
+
+CREATE INDEX movies_search_vector_idx ON public.movies USING gin (search_vector);
+
+
+--
+-- Name: movies_title_trgm_idx; Type: INDEX; Schema: public; Owner: -
+--
+
+CREATE INDEX movies_title_trgm_idx ON public.movies USING gin (title public.gin_trgm_ops);
+
+
+--
+-- Name: movie_posters movie_posters_pkey; Type: CONSTRAINT; Schema: public; Owner: -
+--
+
+ALTER TABLE ONLY public.movie_posters
+    ADD CONSTRAINT movie_posters_pkey PRIMARY KEY (movie_id);
+
+
+--
+-- Name: movie_posters movie_posters_movie_id_fkey; Type: FK CONSTRAINT; Schema: public; Owner: -
+--
+
+ALTER TABLE ONLY public.movie_posters
+    ADD CONSTRAINT movie_posters_movie_id_fkey FOREIGN KEY (movie_id) REFERENCES public.movies(id) ON UPDATE CASCADE ON DELETE CASCADE;
+
+
+--
+-- Name: login_failures login_failures_pkey; Type: CONSTRAINT; Schema: public; Owner: -
+--
+
+ALTER TABLE ONLY public.login_failures
+    ADD CONSTRAINT login_failures_pkey PRIMARY KEY (kind, subject);
+
+
+--
+-- Name: login_failures_locked_until_idx; Type: INDEX; Schema: public; Owner: -
+--
+
+CREATE INDEX login_failures_locked_until_idx ON public.login_failures USING btree (locked_until);
+
+
+--
+-- Name: user_totp user_totp_pkey; Type: CONSTRAINT; Schema: public; Owner: -
+--
+
+ALTER TABLE ONLY public.user_totp
+    ADD CONSTRAINT user_totp_pkey PRIMARY KEY (user_id);
+
+
+--
+-- Name: user_totp user_totp_user_id_fkey; Type: FK CONSTRAINT; Schema: public; Owner: -
+--
+
+ALTER TABLE ONLY public.user_totp
+    ADD CONSTRAINT user_totp_user_id_fkey FOREIGN KEY (user_id) REFERENCES public.users(id) ON UPDATE CASCADE ON DELETE CASCADE;
+
+
+--
+-- Name: recovery_codes recovery_codes_pkey; Type: CONSTRAINT; Schema: public; Owner: -
+--
+
+ALTER TABLE ONLY public.recovery_codes
+    ADD CONSTRAINT recovery_codes_pkey PRIMARY KEY (id);
+
+
+--
+-- Name: recovery_codes_user_id_code_hash_key; Type: INDEX; Schema: public; Owner: -
+--
+
+CREATE UNIQUE INDEX recovery_codes_user_id_code_hash_key ON public.recovery_codes USING btree (user_id, code_hash);
+
+
+--
+-- Name: recovery_codes recovery_codes_user_id_fkey; Type: FK CONSTRAINT; Schema: public; Owner: -
+--
+
+ALTER TABLE ONLY public.recovery_codes
+    ADD CONSTRAINT recovery_codes_user_id_fkey FOREIGN KEY (user_id) REFERENCES public.users(id) ON UPDATE CASCADE ON DELETE CASCADE;
+
+
+--
+-- Name: schema_migrations schema_migrations_pkey; Type: CONSTRAINT; Schema: public; Owner: -
+--
+
+ALTER TABLE ONLY public.schema_migrations
+    ADD CONSTRAINT schema_migrations_pkey PRIMARY KEY (version);
+
+
 --
 -- PostgreSQL database dump complete
 --